@@ -2,7 +2,10 @@ package config
 
 import (
 	"log"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
 )
 
 type Config struct {
@@ -13,6 +16,12 @@ type Config struct {
 		ClientID     string
 		ClientSecret string
 		RedirectURL  string
+		Sites        []string // Site IDs, names or hosts to search; empty means all
+	}
+	
+	Jira struct {
+		Enabled  bool
+		Projects []string // Project keys to search; empty means all
 	}
 	
 	Slack struct {
@@ -27,6 +36,127 @@ type Config struct {
 		RedirectURL  string
 	}
 	
+	GitHub struct {
+		ClientID     string
+		ClientSecret string
+		RedirectURL  string
+		Token        string   // Personal access token used when the user hasn't connected GitHub
+		Orgs         []string // Organizations to search
+		Repos        []string // Repositories ("owner/name") to search
+	}
+	
+	Notion struct {
+		ClientID     string
+		ClientSecret string
+		RedirectURL  string
+	}
+	
+	Microsoft struct {
+		ClientID     string
+		ClientSecret string
+		RedirectURL  string
+		Tenant       string // "common", "organizations" or a tenant ID
+	}
+	
+	IMAP struct {
+		Host       string
+		Port       int
+		Username   string
+		Password   string   // Password, or an OAuth access token with XOAUTH2
+		AuthMethod string   // "login" or "xoauth2"
+		Security   string   // "tls", "starttls" or "none"
+		Mailboxes  []string
+	}
+	
+	Files struct {
+		Roots        []string // Directories to index, as "path" or "path=https://web/base"
+		PollInterval int      // Seconds between checks for changed files
+	}
+	
+	Crawler struct {
+		Seeds           []string // Start URLs; only their hosts are crawled
+		Sitemaps        []string // Extra sitemap URLs
+		PathPrefixes    []string // Only crawl URLs under these paths
+		MaxDepth        int
+		MaxPages        int
+		RequestInterval int // Milliseconds between requests to the same host
+		RecrawlInterval int // Minutes between crawls
+	}
+	
+	REST struct {
+		Connectors []string          // Built-in connector names ("linear", "zendesk") or JSON definition files
+		Variables  map[string]string // REST_* variables, lowercased without the prefix (REST_LINEAR_API_KEY -> linear_api_key)
+	}
+	
+	Vault struct {
+		Key  string // Base64-encoded 32-byte AES key for stored OAuth tokens
+		Path string
+	}
+	
+	Index struct {
+		Path string // File the local index is persisted to; empty keeps it in memory
+	}
+	
+	Imports struct {
+		Dir          string // Exports are kept here and imported again when they change; empty keeps none
+		PollInterval int    // Seconds between checks for changed exports
+	}
+	
+	Auth struct {
+		Mode          string // "none" (anonymous, single user), "local" (accounts with passwords) or "oidc" (single sign-on)
+		UsersPath     string
+		SessionTTL    int    // Hours a login lasts
+		AdminEmail    string // Admin created on first start when there are no users
+		AdminPassword string
+		APIKeysPath   string
+	}
+	
+	OIDC struct {
+		Issuer         string
+		ClientID       string
+		ClientSecret   string
+		RedirectURL    string
+		Scopes         []string
+		GroupsClaim    string            // ID token or userinfo claim listing the user's groups
+		AllowedGroups  []string          // Groups allowed to sign in; empty allows everyone
+		AdminGroups    []string          // Groups whose members are admins
+		TenantGroups   map[string]string // Group -> tenant its members belong to
+		UnmappedTenant string            // Tenant of users in no mapped group; empty refuses them
+	}
+	
+	History struct {
+		Path string // File conversations are persisted to; empty keeps them in memory
+	}
+	
+	CORS struct {
+		AllowedOrigins   []string // Origins that may call the API from a browser; "*" allows any, without credentials
+		AllowedMethods   []string
+		AllowCredentials bool // Lets the allowed origins send the session cookie
+	}
+	
+	Audit struct {
+		Dir       string // Directory of the audit log; empty turns it off
+		MaxSizeMB int    // Size at which the log file is rotated
+		MaxFiles  int    // Rotated files to keep; 0 keeps all
+	}
+	
+	RateLimit struct {
+		RequestsPerMinute float64 // Chat and search requests per user, API key or IP; 0 turns limiting off
+		Burst             int     // Requests that may be made at once
+		DailyTokens       int     // OpenAI tokens per user, API key or IP and UTC day; 0 means no quota
+		TrustProxy        bool    // Identify anonymous clients by X-Forwarded-For
+	}
+	
+	Redaction struct {
+		Detectors       []string            // Detectors run on every source's results before they go to OpenAI; "none" for none
+		SourceDetectors map[string][]string // Source -> its own detectors, from REDACTION_DETECTORS_<SOURCE>
+		Restore         bool                // Put the redacted values back into answers
+	}
+	
+	PromptInjection struct {
+		Action string // What to do with documents that look like prompt injection: "flag", "downrank" or "drop"
+	}
+	
 	OpenAI struct {
 		APIKey string
 		Model  string
@@ -49,6 +179,11 @@ func Load() *Config {
 	config.Confluence.ClientID = getEnv("CONFLUENCE_CLIENT_ID", "")
 	config.Confluence.ClientSecret = getEnv("CONFLUENCE_CLIENT_SECRET", "")
 	config.Confluence.RedirectURL = protocol + "://localhost:" + config.Port + "/api/auth/confluence/callback"
+	config.Confluence.Sites = getEnvList("CONFLUENCE_SITES")
+
+	// Jira reuses the Atlassian OAuth token from Confluence
+	config.Jira.Enabled = getEnv("JIRA_ENABLED", "true") == "true"
+	config.Jira.Projects = getEnvList("JIRA_PROJECTS")
 
 	// Slack OAuth
 	config.Slack.ClientID = getEnv("SLACK_CLIENT_ID", "")
@@ -60,6 +195,124 @@ func Load() *Config {
 	config.Google.ClientSecret = getEnv("GOOGLE_CLIENT_SECRET", "")
 	config.Google.RedirectURL = protocol + "://localhost:" + config.Port + "/api/auth/google/callback"
 
+	// GitHub OAuth or personal access token
+	config.GitHub.ClientID = getEnv("GITHUB_CLIENT_ID", "")
+	config.GitHub.ClientSecret = getEnv("GITHUB_CLIENT_SECRET", "")
+	config.GitHub.RedirectURL = protocol + "://localhost:" + config.Port + "/api/auth/github/callback"
+	config.GitHub.Token = getEnv("GITHUB_TOKEN", "")
+	config.GitHub.Orgs = getEnvList("GITHUB_ORGS")
+	config.GitHub.Repos = getEnvList("GITHUB_REPOS")
+
+	// Notion OAuth
+	config.Notion.ClientID = getEnv("NOTION_CLIENT_ID", "")
+	config.Notion.ClientSecret = getEnv("NOTION_CLIENT_SECRET", "")
+	config.Notion.RedirectURL = protocol + "://localhost:" + config.Port + "/api/auth/notion/callback"
+
+	// Microsoft 365 (Azure AD) OAuth for Outlook, Teams and SharePoint/OneDrive
+	config.Microsoft.ClientID = getEnv("MICROSOFT_CLIENT_ID", "")
+	config.Microsoft.ClientSecret = getEnv("MICROSOFT_CLIENT_SECRET", "")
+	config.Microsoft.RedirectURL = protocol + "://localhost:" + config.Port + "/api/auth/microsoft/callback"
+	config.Microsoft.Tenant = getEnv("MICROSOFT_TENANT", "common")
+
+	// Shared IMAP mailboxes
+	config.IMAP.Host = getEnv("IMAP_HOST", "")
+	config.IMAP.Port, _ = strconv.Atoi(getEnv("IMAP_PORT", "0"))
+	config.IMAP.Username = getEnv("IMAP_USERNAME", "")
+	config.IMAP.Password = getEnv("IMAP_PASSWORD", "")
+	config.IMAP.AuthMethod = getEnv("IMAP_AUTH", "login")
+	config.IMAP.Security = getEnv("IMAP_SECURITY", "tls")
+	config.IMAP.Mailboxes = getEnvList("IMAP_MAILBOXES")
+
+	// Local files and the index they are stored in
+	config.Files.Roots = getEnvList("FILES_ROOTS")
+	config.Files.PollInterval, _ = strconv.Atoi(getEnv("FILES_POLL_INTERVAL", "30"))
+	config.Index.Path = getEnv("INDEX_PATH", "data/index.json")
+	config.Imports.Dir = getEnv("IMPORTS_DIR", "data/imports")
+	config.Imports.PollInterval, _ = strconv.Atoi(getEnv("IMPORTS_POLL_INTERVAL", "300"))
+
+	// Web crawler for internal documentation sites
+	config.Crawler.Seeds = getEnvList("CRAWLER_SEEDS")
+	config.Crawler.Sitemaps = getEnvList("CRAWLER_SITEMAPS")
+	config.Crawler.PathPrefixes = getEnvList("CRAWLER_PATH_PREFIXES")
+	config.Crawler.MaxDepth, _ = strconv.Atoi(getEnv("CRAWLER_MAX_DEPTH", "3"))
+	config.Crawler.MaxPages, _ = strconv.Atoi(getEnv("CRAWLER_MAX_PAGES", "500"))
+	config.Crawler.RequestInterval, _ = strconv.Atoi(getEnv("CRAWLER_REQUEST_INTERVAL", "1000"))
+	config.Crawler.RecrawlInterval, _ = strconv.Atoi(getEnv("CRAWLER_RECRAWL_INTERVAL", "60"))
+
+	// Server-side OAuth token vault
+	config.Vault.Key = getEnv("VAULT_KEY", "")
+	config.Vault.Path = getEnv("VAULT_PATH", "data/vault.json")
+
+	// User accounts and conversation history
+	config.Auth.Mode = getEnv("AUTH_MODE", "none")
+	config.Auth.UsersPath = getEnv("USERS_PATH", "data/users.json")
+	config.Auth.SessionTTL, _ = strconv.Atoi(getEnv("AUTH_SESSION_TTL", "168"))
+	config.Auth.AdminEmail = getEnv("AUTH_ADMIN_EMAIL", "")
+	config.Auth.AdminPassword = getEnv("AUTH_ADMIN_PASSWORD", "")
+	config.Auth.APIKeysPath = getEnv("API_KEYS_PATH", "data/api_keys.json")
+	config.History.Path = getEnv("HISTORY_PATH", "data/history.json")
+
+	// OIDC single sign-on for AUTH_MODE=oidc
+	config.OIDC.Issuer = strings.TrimSuffix(getEnv("OIDC_ISSUER", ""), "/")
+	config.OIDC.ClientID = getEnv("OIDC_CLIENT_ID", "")
+	config.OIDC.ClientSecret = getEnv("OIDC_CLIENT_SECRET", "")
+	config.OIDC.RedirectURL = getEnv("OIDC_REDIRECT_URL", protocol+"://localhost:"+config.Port+"/api/auth/sso/callback")
+	config.OIDC.Scopes = getEnvList("OIDC_SCOPES")
+	if len(config.OIDC.Scopes) == 0 {
+		config.OIDC.Scopes = []string{"openid", "email", "profile"}
+	}
+	config.OIDC.GroupsClaim = getEnv("OIDC_GROUPS_CLAIM", "groups")
+	config.OIDC.AllowedGroups = getEnvList("OIDC_ALLOWED_GROUPS")
+	config.OIDC.AdminGroups = getEnvList("OIDC_ADMIN_GROUPS")
+	config.OIDC.TenantGroups = map[string]string{}
+	for _, mapping := range getEnvList("OIDC_TENANT_GROUPS") {
+		if group, tenant, ok := strings.Cut(mapping, "="); ok {
+			config.OIDC.TenantGroups[strings.TrimSpace(group)] = strings.TrimSpace(tenant)
+		}
+	}
+	config.OIDC.UnmappedTenant = getEnv("OIDC_UNMAPPED_TENANT", "")
+
+	// Cross-origin requests; by default only the frontend may make them
+	for _, origin := range getEnvList("CORS_ALLOWED_ORIGINS") {
+		config.CORS.AllowedOrigins = append(config.CORS.AllowedOrigins, strings.TrimSuffix(origin, "/"))
+	}
+	if len(config.CORS.AllowedOrigins) == 0 {
+		config.CORS.AllowedOrigins = []string{config.FrontendOrigin()}
+	}
+	config.CORS.AllowedMethods = getEnvList("CORS_ALLOWED_METHODS")
+	if len(config.CORS.AllowedMethods) == 0 {
+		config.CORS.AllowedMethods = []string{"GET", "POST", "PUT", "DELETE"}
+	}
+	config.CORS.AllowCredentials = getEnv("CORS_ALLOW_CREDENTIALS", "true") == "true"
+
+	// Audit log of chats and searches
+	config.Audit.Dir = getEnv("AUDIT_DIR", "data/audit")
+	config.Audit.MaxSizeMB, _ = strconv.Atoi(getEnv("AUDIT_MAX_SIZE_MB", "100"))
+	config.Audit.MaxFiles, _ = strconv.Atoi(getEnv("AUDIT_MAX_FILES", "0"))
+
+	// Rate limits and LLM quotas
+	config.RateLimit.RequestsPerMinute, _ = strconv.ParseFloat(getEnv("RATE_LIMIT_PER_MINUTE", "10"), 64)
+	config.RateLimit.Burst, _ = strconv.Atoi(getEnv("RATE_LIMIT_BURST", "5"))
+	config.RateLimit.DailyTokens, _ = strconv.Atoi(getEnv("LLM_DAILY_TOKEN_QUOTA", "200000"))
+	config.RateLimit.TrustProxy = getEnv("RATE_LIMIT_TRUST_PROXY", "false") == "true"
+
+	// Declarative REST connectors
+	config.REST.Connectors = getEnvList("REST_CONNECTORS")
+	config.REST.Variables = getEnvPrefixed("REST_")
+	delete(config.REST.Variables, "connectors")
+
+	// Redaction of search results before they are sent to OpenAI
+	config.Redaction.Detectors = getEnvList("REDACTION_DETECTORS")
+	if len(config.Redaction.Detectors) == 0 {
+		config.Redaction.Detectors = []string{"secrets", "high_entropy", "emails", "phones", "credit_cards", "ibans"}
+	}
+	config.Redaction.SourceDetectors = map[string][]string{}
+	for source := range getEnvPrefixed("REDACTION_DETECTORS_") {
+		config.Redaction.SourceDetectors[source] = getEnvList("REDACTION_DETECTORS_" + strings.ToUpper(source))
+	}
+	config.Redaction.Restore = getEnv("REDACTION_RESTORE", "true") == "true"
+	config.PromptInjection.Action = getEnv("PROMPT_INJECTION_ACTION", "downrank")
+
 	// OpenAI
 	config.OpenAI.APIKey = getEnv("OPENAI_API_KEY", "")
 	config.OpenAI.Model = getEnv("OPENAI_MODEL", "gpt-4")
@@ -75,9 +328,43 @@ func Load() *Config {
 	return config
 }
 
+// FrontendOrigin returns the scheme and host of FrontendURL, e.g.
+// "http://localhost:3000", or "" when it isn't an absolute URL
+func (c *Config) FrontendOrigin() string {
+	parsed, err := url.Parse(c.FrontendURL)
+	if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+		return ""
+	}
+	return parsed.Scheme + "://" + parsed.Host
+}
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
 	}
 	return defaultValue
-}
\ No newline at end of file
+}
+
+// getEnvList reads a comma-separated environment variable into a slice
+func getEnvList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// getEnvPrefixed collects the environment variables starting with prefix,
+// keyed by the rest of their name in lower case
+func getEnvPrefixed(prefix string) map[string]string {
+	values := map[string]string{}
+	for _, entry := range os.Environ() {
+		key, value, _ := strings.Cut(entry, "=")
+		if strings.HasPrefix(key, prefix) && value != "" {
+			values[strings.ToLower(strings.TrimPrefix(key, prefix))] = value
+		}
+	}
+	return values
+}
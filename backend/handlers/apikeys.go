@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"rag-chatbot/services"
+)
+
+const (
+	defaultAPIKeyDays = 90
+	maxAPIKeyDays     = 365
+)
+
+type APIKeyResponse struct {
+	ID        string     `json:"id"`
+	AccountID string     `json:"account_id"`
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	CreatedBy string     `json:"created_by"`
+	Created   time.Time  `json:"created"`
+	Expires   time.Time  `json:"expires"`
+	LastUsed  *time.Time `json:"last_used,omitempty"`
+	Revoked   *time.Time `json:"revoked,omitempty"`
+	Active    bool       `json:"active"`
+}
+
+type APIKeyListResponse struct {
+	Keys []*APIKeyResponse `json:"keys"`
+}
+
+type CreateAPIKeyRequest struct {
+	AccountID     string   `json:"account_id"` // Service account the key acts as
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`          // "chat", "search" and/or "admin"
+	ExpiresInDays int      `json:"expires_in_days"` // Defaults to 90, at most 365
+}
+
+// CreateAPIKeyResponse carries the key itself, which is never shown again
+type CreateAPIKeyResponse struct {
+	APIKeyResponse
+	Key string `json:"key"`
+}
+
+func apiKeyResponse(key *services.APIKey) *APIKeyResponse {
+	response := &APIKeyResponse{
+		ID:        key.ID,
+		AccountID: key.UserID,
+		Name:      key.Name,
+		Scopes:    key.Scopes,
+		CreatedBy: key.CreatedBy,
+		Created:   key.Created,
+		Expires:   key.Expires,
+		Active:    key.Active(time.Now()),
+	}
+	if !key.LastUsed.IsZero() {
+		response.LastUsed = &key.LastUsed
+	}
+	if !key.Revoked.IsZero() {
+		response.Revoked = &key.Revoked
+	}
+	return response
+}
+
+// APIKeysHandler lets admins list (GET), issue (POST) and revoke (DELETE ?id=)
+// the API keys of their tenant's service accounts
+func APIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	admin, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+	if !admin.IsAdmin() {
+		http.Error(w, "Admin role required", http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		response := APIKeyListResponse{Keys: []*APIKeyResponse{}}
+		for _, key := range apiKeyStore.List(admin.Tenant) {
+			response.Keys = append(response.Keys, apiKeyResponse(key))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+
+	case http.MethodPost:
+		var req CreateAPIKeyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		account, ok := userStore.Get(req.AccountID)
+		if !ok || account.Tenant != admin.Tenant {
+			http.Error(w, "Service account not found", http.StatusNotFound)
+			return
+		}
+		if !account.Service {
+			http.Error(w, "API keys can only be issued for service accounts", http.StatusBadRequest)
+			return
+		}
+		if req.ExpiresInDays == 0 {
+			req.ExpiresInDays = defaultAPIKeyDays
+		}
+		if req.ExpiresInDays < 0 || req.ExpiresInDays > maxAPIKeyDays {
+			http.Error(w, "expires_in_days must be between 1 and 365", http.StatusBadRequest)
+			return
+		}
+
+		expires := time.Now().AddDate(0, 0, req.ExpiresInDays)
+		plaintext, key, err := apiKeyStore.Create(account.ID, account.Tenant, req.Name, req.Scopes, expires, userName(admin))
+		if err != nil {
+			http.Error(w, "Failed to create API key: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("%s issued API key %s for %s", userName(admin), key.ID, userName(account))
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(CreateAPIKeyResponse{APIKeyResponse: *apiKeyResponse(key), Key: plaintext})
+
+	case http.MethodDelete:
+		key, err := apiKeyStore.Revoke(admin.Tenant, r.URL.Query().Get("id"))
+		if errors.Is(err, services.ErrAPIKeyNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to revoke API key: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("%s revoked API key %s", userName(admin), key.ID)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(apiKeyResponse(key))
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"rag-chatbot/services"
+)
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// recordAudit appends a chat or search to the audit log: who asked, what they
+// asked, which sources were searched and which documents they were shown.
+// usage is nil when no answer was generated.
+func recordAudit(r *http.Request, action string, req *ChatRequest, conversationID string, searched []string, references []Reference, answer string, usage *services.OpenAIUsage) {
+	if auditLog == nil {
+		return
+	}
+
+	entry := services.AuditEntry{
+		Action:         action,
+		Tenant:         tenantFor(r),
+		ClientIP:       clientIP(r),
+		ConversationID: conversationID,
+		Query:          req.Query,
+		Sources:        searched,
+		Documents:      []services.AuditDocument{},
+		Answer:         answer,
+		Usage:          usage,
+	}
+	if entry.Sources == nil {
+		entry.Sources = []string{}
+	}
+	if user, ok := currentUser(r); ok {
+		entry.UserID = user.ID
+		entry.User = userName(user)
+	}
+	if key, ok := currentAPIKey(r); ok {
+		entry.APIKeyID = key.ID
+	}
+	if usage != nil {
+		entry.Model = openaiService.Model
+	}
+	for _, reference := range references {
+		entry.Documents = append(entry.Documents, services.AuditDocument{
+			ID:     reference.URL,
+			Title:  reference.Title,
+			Source: reference.Source,
+		})
+	}
+
+	if err := auditLog.Append(entry); err != nil {
+		log.Printf("Failed to record audit entry: %v", err)
+	}
+}
+
+type AuditResponse struct {
+	Entries []*services.AuditEntry `json:"entries"`
+}
+
+// AuditHandler lets admins search the audit log of their tenant (GET), newest
+// first. Query parameters: user (ID), action ("chat" or "search"), q (text in
+// the question), since and until (RFC 3339) and limit (default 100).
+func AuditHandler(w http.ResponseWriter, r *http.Request) {
+	admin, ok := requireAuditAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	filter := services.AuditFilter{
+		Tenant:   admin.Tenant,
+		UserID:   query.Get("user"),
+		Action:   query.Get("action"),
+		Contains: query.Get("q"),
+		Limit:    defaultAuditLimit,
+	}
+	for name, field := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if value := query.Get(name); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				http.Error(w, name+" must be an RFC 3339 time, e.g. 2024-01-31T00:00:00Z", http.StatusBadRequest)
+				return
+			}
+			*field = parsed
+		}
+	}
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxAuditLimit {
+			http.Error(w, "limit must be between 1 and 1000", http.StatusBadRequest)
+			return
+		}
+		filter.Limit = limit
+	}
+
+	entries, err := auditLog.Query(filter)
+	if err != nil {
+		http.Error(w, "Failed to read audit log: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(AuditResponse{Entries: entries})
+}
+
+// AuditVerifyHandler checks the audit log's hash chain (GET). The log holds
+// every tenant's entries, so only admins of the default tenant may check it.
+func AuditVerifyHandler(w http.ResponseWriter, r *http.Request) {
+	admin, ok := requireAuditAdmin(w, r)
+	if !ok {
+		return
+	}
+	if admin.Tenant != services.DefaultTenant {
+		http.Error(w, "Only admins of the default tenant can verify the audit log", http.StatusForbidden)
+		return
+	}
+
+	verification, err := auditLog.Verify()
+	if err != nil {
+		http.Error(w, "Failed to read audit log: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !verification.Valid {
+		log.Printf("Audit log verification failed: %s", verification.Problem)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(verification)
+}
+
+// requireAuditAdmin returns the signed-in admin for a GET of the audit log,
+// writing an error response when the request can't read it
+func requireAuditAdmin(w http.ResponseWriter, r *http.Request) (*services.User, bool) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return nil, false
+	}
+	admin, ok := requireUser(w, r)
+	if !ok {
+		return nil, false
+	}
+	if !admin.IsAdmin() {
+		http.Error(w, "Admin role required", http.StatusForbidden)
+		return nil, false
+	}
+	if auditLog == nil {
+		http.Error(w, "The audit log is turned off (AUDIT_DIR is empty)", http.StatusNotFound)
+		return nil, false
+	}
+	return admin, true
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"rag-chatbot/services"
+)
+
+// withAuditLog gives the handlers an audit log holding a chat from each of
+// the default tenant, acme and globex
+func withAuditLog(t *testing.T) {
+	saved := auditLog
+	t.Cleanup(func() { auditLog = saved })
+
+	var err error
+	auditLog, err = services.NewAuditLog(t.TempDir(), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tenant := range []string{services.DefaultTenant, "acme", "globex"} {
+		entry := services.AuditEntry{Action: services.AuditActionChat, Tenant: tenant, UserID: tenant + "-user", Query: "question from " + tenant}
+		if err := auditLog.Append(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func auditRequest(handler http.HandlerFunc, method, target string, user *services.User) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, nil)
+	if user != nil {
+		r = asUser(r, user)
+	}
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+func TestAuditHandlerIsPerTenant(t *testing.T) {
+	withAuditLog(t)
+	admin := &services.User{ID: "a1", Tenant: "acme", Role: services.RoleAdmin}
+
+	// Parameters can narrow the search but never name another tenant
+	for _, target := range []string{"/api/audit", "/api/audit?tenant=globex", "/api/audit?user=globex-user"} {
+		w := auditRequest(AuditHandler, http.MethodGet, target, admin)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d: %s", target, w.Code, w.Body)
+		}
+		var response AuditResponse
+		if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+			t.Fatal(err)
+		}
+		for _, entry := range response.Entries {
+			if entry.Tenant != "acme" {
+				t.Errorf("%s: acme's admin was shown %s's entry %q", target, entry.Tenant, entry.Query)
+			}
+		}
+		if target == "/api/audit" && len(response.Entries) != 1 {
+			t.Errorf("%s: entries = %+v, want acme's chat", target, response.Entries)
+		}
+	}
+}
+
+func TestAuditHandlerRefusals(t *testing.T) {
+	withAuditLog(t)
+	admin := &services.User{ID: "a1", Tenant: "acme", Role: services.RoleAdmin}
+	member := &services.User{ID: "m1", Tenant: "acme", Role: services.RoleMember}
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		user   *services.User
+		status int
+	}{
+		{"signed out", http.MethodGet, "/api/audit", nil, http.StatusUnauthorized},
+		{"member", http.MethodGet, "/api/audit", member, http.StatusForbidden},
+		{"POST", http.MethodPost, "/api/audit", admin, http.StatusMethodNotAllowed},
+		{"bad since", http.MethodGet, "/api/audit?since=yesterday", admin, http.StatusBadRequest},
+		{"limit too high", http.MethodGet, "/api/audit?limit=5000", admin, http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		if w := auditRequest(AuditHandler, test.method, test.target, test.user); w.Code != test.status {
+			t.Errorf("%s: status = %d, want %d", test.name, w.Code, test.status)
+		}
+	}
+
+	auditLog = nil
+	if w := auditRequest(AuditHandler, http.MethodGet, "/api/audit", admin); w.Code != http.StatusNotFound {
+		t.Errorf("status = %d with the audit log turned off, want 404", w.Code)
+	}
+}
+
+// The log holds every tenant's entries, so only the default tenant's admins
+// may verify it
+func TestAuditVerifyHandler(t *testing.T) {
+	withAuditLog(t)
+
+	tenantAdmin := &services.User{ID: "a1", Tenant: "acme", Role: services.RoleAdmin}
+	if w := auditRequest(AuditVerifyHandler, http.MethodGet, "/api/audit/verify", tenantAdmin); w.Code != http.StatusForbidden {
+		t.Errorf("acme admin: status = %d, want 403", w.Code)
+	}
+	member := &services.User{ID: "m1", Tenant: services.DefaultTenant, Role: services.RoleMember}
+	if w := auditRequest(AuditVerifyHandler, http.MethodGet, "/api/audit/verify", member); w.Code != http.StatusForbidden {
+		t.Errorf("member: status = %d, want 403", w.Code)
+	}
+
+	admin := &services.User{ID: "a0", Tenant: services.DefaultTenant, Role: services.RoleAdmin}
+	w := auditRequest(AuditVerifyHandler, http.MethodGet, "/api/audit/verify", admin)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	var verification services.AuditVerification
+	if err := json.NewDecoder(w.Body).Decode(&verification); err != nil {
+		t.Fatal(err)
+	}
+	if !verification.Valid || verification.Entries != 3 || verification.LastSeq != 3 {
+		t.Errorf("verification = %+v, want every tenant's entries checked", verification)
+	}
+}
@@ -0,0 +1,348 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"rag-chatbot/services"
+)
+
+const (
+	authModeNone  = "none"
+	authModeLocal = "local"
+	authModeOIDC  = "oidc"
+)
+
+type contextKey string
+
+const (
+	userContextKey   contextKey = "user"
+	apiKeyContextKey contextKey = "apiKey"
+)
+
+// publicPaths are API endpoints that work without signing in. /api/me tells
+// the frontend how to sign in.
+var publicPaths = map[string]bool{
+	"/api/health":      true,
+	"/api/me":          true,
+	"/api/auth/login":  true,
+	"/api/auth/logout": true,
+	"/api/auth/sso":    true,
+}
+
+// apiKeyScopes lists the endpoints API keys may call and the scope each
+// needs; an empty scope allows any key. Everything else, such as connecting
+// accounts through OAuth, needs a browser and refuses API keys.
+var apiKeyScopes = map[string]string{
+	"/api/health":            "",
+	"/api/me":                "",
+	"/api/auth/connections":  "",
+	"/api/chat":              services.ScopeChat,
+	"/api/chat/stream":       services.ScopeChat,
+	"/api/conversations":     services.ScopeChat,
+	"/api/search":            services.ScopeSearch,
+	"/api/users":             services.ScopeAdmin,
+	"/api/users/credentials": services.ScopeAdmin,
+	"/api/imports":           services.ScopeAdmin,
+	"/api/api-keys":          services.ScopeAdmin,
+	"/api/audit":             services.ScopeAdmin,
+	"/api/audit/verify":      services.ScopeAdmin,
+}
+
+// isPublicPath reports whether path works without signing in. OAuth
+// callbacks are public because their state, bound to the browser session,
+// already protects them.
+func isPublicPath(path string) bool {
+	return publicPaths[path] || (strings.HasPrefix(path, "/api/auth/") && strings.HasSuffix(path, "/callback"))
+}
+
+// RequireAuth rejects API requests without a signed-in user and makes the
+// user available to handlers. It sits in front of every route; pages outside
+// /api/ and the public API paths are served without a user. Requests with an
+// API key act as its service account instead. Without accounts
+// (AUTH_MODE=none) every request is let through anonymously.
+func RequireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if authMode == authModeNone || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if token, ok := bearerToken(r); ok {
+			user, key, ok := apiKeyUser(w, r, token)
+			if !ok {
+				return
+			}
+			ctx := context.WithValue(r.Context(), userContextKey, user)
+			next.ServeHTTP(w, r.WithContext(context.WithValue(ctx, apiKeyContextKey, key)))
+			return
+		}
+
+		user, ok := userStore.SessionUser(sessionFromRequest(r))
+		if ok {
+			r = r.WithContext(context.WithValue(r.Context(), userContextKey, user))
+		} else if strings.HasPrefix(r.URL.Path, "/api/") && !isPublicPath(r.URL.Path) {
+			http.Error(w, "Authentication required", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// bearerToken returns the API key sent as "Authorization: Bearer <key>"
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+// apiKeyUser checks an API key against the endpoint it is used for and
+// returns it with its service account, writing an error response when it can't
+func apiKeyUser(w http.ResponseWriter, r *http.Request, token string) (*services.User, *services.APIKey, bool) {
+	key, err := apiKeyStore.Authenticate(token)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return nil, nil, false
+	}
+	user, ok := userStore.Get(key.UserID)
+	if !ok || !user.Service {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		http.Error(w, services.ErrInvalidAPIKey.Error(), http.StatusUnauthorized)
+		return nil, nil, false
+	}
+
+	scope, allowed := apiKeyScopes[r.URL.Path]
+	if !allowed {
+		http.Error(w, "API keys can't be used for this endpoint", http.StatusForbidden)
+		return nil, nil, false
+	}
+	if scope != "" && !key.HasScope(scope) {
+		w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="`+scope+`"`)
+		http.Error(w, "API key lacks the "+scope+" scope", http.StatusForbidden)
+		return nil, nil, false
+	}
+	return user, key, true
+}
+
+// currentUser returns the signed-in user, if any
+func currentUser(r *http.Request) (*services.User, bool) {
+	user, ok := r.Context().Value(userContextKey).(*services.User)
+	return user, ok
+}
+
+// currentAPIKey returns the API key the request was made with, if any
+func currentAPIKey(r *http.Request) (*services.APIKey, bool) {
+	key, ok := r.Context().Value(apiKeyContextKey).(*services.APIKey)
+	return key, ok
+}
+
+// requireUser returns the signed-in user, writing an error response when
+// there is none
+func requireUser(w http.ResponseWriter, r *http.Request) (*services.User, bool) {
+	user, ok := currentUser(r)
+	if !ok {
+		http.Error(w, "Sign in to use this endpoint", http.StatusUnauthorized)
+	}
+	return user, ok
+}
+
+// tenantFor returns the tenant whose data a request may see. Anonymous
+// requests only exist without accounts and use the default tenant.
+func tenantFor(r *http.Request) string {
+	if user, ok := currentUser(r); ok {
+		return user.Tenant
+	}
+	return services.DefaultTenant
+}
+
+// readerFor returns who the request searches the local index as: the
+// signed-in user, or an anonymous reader of the default tenant
+func readerFor(r *http.Request) services.Reader {
+	if user, ok := currentUser(r); ok {
+		return services.Reader{Tenant: user.Tenant, Email: user.Email, Groups: user.Groups}
+	}
+	return services.Reader{Tenant: services.DefaultTenant}
+}
+
+// sharedSourcesAllowed reports whether a request may search the connectors
+// configured for the whole deployment (local files, crawled sites, IMAP,
+// REST connectors and the GitHub token). They belong to the default tenant.
+func sharedSourcesAllowed(r *http.Request) bool {
+	return tenantFor(r) == services.DefaultTenant
+}
+
+// credentialOwner returns the key connected accounts are stored under in the
+// vault: the user when signed in, so they follow them across browsers, and
+// otherwise the browser session
+func credentialOwner(r *http.Request) string {
+	if user, ok := currentUser(r); ok {
+		return userCredentialOwner(user.ID)
+	}
+	return sessionFromRequest(r)
+}
+
+func userCredentialOwner(userID string) string {
+	return services.UserOwner(userID)
+}
+
+type LoginRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+type UserResponse struct {
+	ID      string   `json:"id"`
+	Tenant  string   `json:"tenant"`
+	Email   string   `json:"email,omitempty"` // Service accounts have none
+	Name    string   `json:"name"`
+	Role    string   `json:"role"`
+	Service bool     `json:"service,omitempty"`
+	Groups  []string `json:"groups,omitempty"`
+}
+
+type MeResponse struct {
+	AuthMode string        `json:"auth_mode"`
+	User     *UserResponse `json:"user,omitempty"` // Omitted without accounts
+}
+
+func userResponse(user *services.User) *UserResponse {
+	return &UserResponse{
+		ID:      user.ID,
+		Tenant:  user.Tenant,
+		Email:   user.Email,
+		Name:    user.Name,
+		Role:    user.Role,
+		Service: user.Service,
+		Groups:  user.Groups,
+	}
+}
+
+// LoginHandler signs a local user in with their email and password
+func LoginHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if authMode != authModeLocal {
+		http.Error(w, "Password login is not enabled", http.StatusNotFound)
+		return
+	}
+
+	var req LoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	user, err := userStore.Authenticate(req.Email, req.Password)
+	if err != nil {
+		log.Printf("Failed login for %s", req.Email)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	// A new session ID on login stops a planted cookie from being used to
+	// ride on the user's session
+	if !signIn(w, r, user) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(MeResponse{AuthMode: authMode, User: userResponse(user)})
+}
+
+// signIn starts a new browser session for user, writing an error response
+// when it can't
+func signIn(w http.ResponseWriter, r *http.Request, user *services.User) bool {
+	session, err := newBrowserSession(w, r)
+	if err != nil {
+		http.Error(w, "Failed to start session", http.StatusInternalServerError)
+		return false
+	}
+	if err := userStore.StartSession(session, user.ID); err != nil {
+		http.Error(w, "Failed to start session: "+err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// LogoutHandler signs the browser session out
+func LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if session := sessionFromRequest(r); session != "" {
+		if err := userStore.EndSession(session); err != nil {
+			log.Printf("Failed to end session: %v", err)
+		}
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// MeHandler returns the signed-in user, or only the auth mode without accounts
+func MeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	response := MeResponse{AuthMode: authMode}
+	if user, ok := currentUser(r); ok {
+		response.User = userResponse(user)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+type SettingsResponse struct {
+	Settings map[string]string `json:"settings"`
+}
+
+// SettingsHandler reads (GET) and updates (PUT) the signed-in user's
+// settings. PUT merges the given keys; an empty value removes a key.
+func SettingsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	settings := user.Settings
+	switch r.Method {
+	case http.MethodGet:
+		// Handled below
+	case http.MethodPut:
+		var req SettingsResponse
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		var err error
+		settings, err = userStore.UpdateSettings(user.ID, req.Settings)
+		if err != nil {
+			http.Error(w, "Failed to save settings: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(SettingsResponse{Settings: settings})
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"rag-chatbot/services"
+)
+
+// withConfluenceSites points confluenceService at a stub serving the named
+// sites, each with one page about deploys, and returns the sites searched
+func withConfluenceSites(t *testing.T, names ...string) func() []string {
+	var mu sync.Mutex
+	var searched []string
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/oauth/token/accessible-resources" {
+			var sites []string
+			for _, name := range names {
+				sites = append(sites, fmt.Sprintf(`{"id": "%[1]s", "name": "%[1]s", "url": "%[2]s/%[1]s", "scopes": ["read:confluence-content.all"]}`, name, server.URL))
+			}
+			fmt.Fprintf(w, "[%s]", strings.Join(sites, ","))
+			return
+		}
+		if !strings.HasSuffix(r.URL.Path, "/rest/api/content/search") {
+			http.NotFound(w, r) // Page expansion falls back to the search hit
+			return
+		}
+		site := strings.Split(strings.TrimPrefix(r.URL.Path, "/ex/confluence/"), "/")[0]
+		mu.Lock()
+		searched = append(searched, site)
+		mu.Unlock()
+		fmt.Fprintf(w, `{"results": [{"id": "1", "title": "Deploy guide", "space": {"name": "Ops"},
+			"body": {"storage": {"value": "<p>How to deploy on %[1]s</p>"}}, "_links": {"webui": "/wiki/pages/1"}}]}`, site)
+	}))
+	t.Cleanup(server.Close)
+
+	saved := confluenceService
+	t.Cleanup(func() { confluenceService = saved })
+	confluenceService = services.NewConfluenceService("id", "secret", "")
+	confluenceService.APIBaseURL = server.URL
+
+	return func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		sort.Strings(searched)
+		return append([]string(nil), searched...)
+	}
+}
+
+// Results from several sites are merged, each titled and linked with its site
+func TestSearchConfluenceMergesSites(t *testing.T) {
+	searched := withConfluenceSites(t, "acme", "globex")
+
+	results, err := searchConfluence("token", "deploy", nil)
+	if err != nil {
+		t.Fatalf("searchConfluence: %v", err)
+	}
+	if got := strings.Join(searched(), ","); got != "acme,globex" {
+		t.Errorf("searched %s, want every site", got)
+	}
+
+	titles := map[string]services.SearchResult{}
+	for _, result := range results.SearchResults {
+		titles[result.Title] = result
+	}
+	for _, site := range []string{"acme", "globex"} {
+		result, ok := titles["Deploy guide ("+site+")"]
+		if !ok {
+			t.Errorf("no result titled with %s in %+v", site, results.SearchResults)
+			continue
+		}
+		if !strings.HasSuffix(result.URL, "/"+site+"/wiki/pages/1") || !strings.Contains(result.Content, "Site: "+site) {
+			t.Errorf("%s result = %+v, want its own site's link and name", site, result)
+		}
+	}
+	if len(results.References) != 2 {
+		t.Errorf("references = %+v, want one per site", results.References)
+	}
+}
+
+// Selecting a subset of sites searches only those, and a single site keeps
+// the plain page title
+func TestSearchConfluenceSelectedSites(t *testing.T) {
+	searched := withConfluenceSites(t, "acme", "globex", "initech")
+
+	results, err := searchConfluence("token", "deploy", []string{"globex"})
+	if err != nil {
+		t.Fatalf("searchConfluence: %v", err)
+	}
+	if got := strings.Join(searched(), ","); got != "globex" {
+		t.Errorf("searched %s, want only the selected site", got)
+	}
+	if len(results.SearchResults) != 1 || results.SearchResults[0].Title != "Deploy guide" {
+		t.Errorf("results = %+v, want the page without a site suffix", results.SearchResults)
+	}
+
+	if _, err := searchConfluence("token", "deploy", []string{"umbrella"}); err == nil {
+		t.Errorf("selecting an inaccessible site didn't fail")
+	}
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"rag-chatbot/services"
+)
+
+// oauthProviders are the sources connected through OAuth, as named in the vault
+var oauthProviders = []string{"confluence", "slack", "gmail", "github", "drive", "notion", "microsoft"}
+
+type ConnectionsResponse struct {
+	Connected map[string]bool `json:"connected"`
+}
+
+// ConnectionsHandler reports which accounts the user or browser session has
+// connected (GET) and disconnects one (DELETE ?provider=slack)
+func ConnectionsHandler(w http.ResponseWriter, r *http.Request) {
+	owner := credentialOwner(r)
+
+	switch r.Method {
+	case http.MethodGet:
+		// Handled below
+	case http.MethodDelete:
+		provider := r.URL.Query().Get("provider")
+		if provider == "" {
+			http.Error(w, "Missing provider", http.StatusBadRequest)
+			return
+		}
+		if owner != "" {
+			if err := tokenVault.Delete(owner, provider); err != nil {
+				http.Error(w, "Failed to disconnect: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	response := ConnectionsResponse{Connected: make(map[string]bool)}
+	for _, provider := range oauthProviders {
+		response.Connected[provider] = false
+	}
+	if owner != "" {
+		for _, provider := range tokenVault.Providers(owner) {
+			response.Connected[provider] = true
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// sessionFromRequest returns the browser session ID, or "" without a cookie
+func sessionFromRequest(r *http.Request) string {
+	if cookie, err := r.Cookie(sessionCookieName); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+// storeToken saves a callback's token in the vault under the signed-in user
+// or the browser session, writing an error response when it can't
+func storeToken(w http.ResponseWriter, r *http.Request, provider string, token services.StoredToken) bool {
+	owner := credentialOwner(r)
+	if owner == "" {
+		var err error
+		if owner, err = browserSession(w, r); err != nil {
+			http.Error(w, "Failed to start session", http.StatusInternalServerError)
+			return false
+		}
+	}
+	if err := tokenVault.Put(owner, provider, token); err != nil {
+		http.Error(w, "Failed to store token: "+err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// resolveCredentials fills a chat request's tokens from the vault. Tokens
+// sent in the request body are still honored for older API clients.
+func resolveCredentials(r *http.Request, req *ChatRequest) {
+	fields := map[string]*string{
+		"confluence": &req.ConfluenceToken,
+		"slack":      &req.SlackToken,
+		"gmail":      &req.GmailToken,
+		"github":     &req.GitHubToken,
+		"drive":      &req.DriveToken,
+		"notion":     &req.NotionToken,
+		"microsoft":  &req.MicrosoftToken,
+	}
+
+	owner := credentialOwner(r)
+	for provider, field := range fields {
+		if *field != "" {
+			log.Printf("Warning: %s_token in the chat request is deprecated; connect the account so the token is kept server-side", provider)
+			continue
+		}
+		if accessToken, ok := tokenRefresher.AccessToken(owner, provider); ok {
+			*field = accessToken
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"rag-chatbot/services"
+)
+
+type ConversationListResponse struct {
+	Conversations []services.Conversation `json:"conversations"`
+}
+
+// ConversationsHandler lists the signed-in user's conversations (GET), returns
+// one with its messages (GET ?id=) and deletes one (DELETE ?id=)
+func ConversationsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+	id := r.URL.Query().Get("id")
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		if id == "" {
+			json.NewEncoder(w).Encode(ConversationListResponse{Conversations: conversationStore.List(user.Tenant, user.ID)})
+			return
+		}
+		conversation, ok := conversationStore.Get(user.Tenant, user.ID, id)
+		if !ok {
+			http.Error(w, services.ErrConversationNotFound.Error(), http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(conversation)
+
+	case http.MethodDelete:
+		if id == "" {
+			http.Error(w, "Missing conversation id", http.StatusBadRequest)
+			return
+		}
+		if err := conversationStore.Delete(user.Tenant, user.ID, id); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// recordQuestion adds a signed-in user's question to their conversation,
+// starting a new one when id is empty or unknown, and returns the
+// conversation's ID. Anonymous questions aren't recorded and return "".
+func recordQuestion(r *http.Request, id, query string) string {
+	user, ok := currentUser(r)
+	if !ok {
+		return ""
+	}
+
+	message := services.ConversationMessage{Role: "user", Content: query}
+	conversationID, err := conversationStore.Append(user.Tenant, user.ID, id, message)
+	if errors.Is(err, services.ErrConversationNotFound) {
+		conversationID, err = conversationStore.Append(user.Tenant, user.ID, "", message)
+	}
+	if err != nil {
+		log.Printf("Failed to record question: %v", err)
+		return ""
+	}
+	return conversationID
+}
+
+// recordAnswer adds the answer to a question recorded with recordQuestion
+func recordAnswer(r *http.Request, conversationID, answer string, references []Reference) {
+	user, ok := currentUser(r)
+	if !ok || conversationID == "" {
+		return
+	}
+
+	message := services.ConversationMessage{Role: "assistant", Content: answer}
+	for _, reference := range references {
+		message.References = append(message.References, services.ConversationReference{
+			Title:  reference.Title,
+			URL:    reference.URL,
+			Source: reference.Source,
+		})
+	}
+	if _, err := conversationStore.Append(user.Tenant, user.ID, conversationID, message); err != nil {
+		log.Printf("Failed to record answer: %v", err)
+	}
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+)
+
+// corsMaxAge is how long, in seconds, browsers may cache a preflight answer
+const corsMaxAge = "600"
+
+// CORS lets the origins in CORS_ALLOWED_ORIGINS call the API from a browser.
+// Requests from other origins get no CORS headers, so browsers keep their
+// pages from reading the responses, and their preflight requests are refused.
+func CORS(next http.Handler) http.Handler {
+	allowedMethods := strings.Join(appConfig.CORS.AllowedMethods, ", ")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin != "" {
+			// Responses differ per origin, so caches must keep them apart
+			w.Header().Add("Vary", "Origin")
+		}
+
+		allowed := false
+		switch {
+		case origin == "":
+		case containsFold(appConfig.CORS.AllowedOrigins, origin):
+			allowed = true
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			if appConfig.CORS.AllowCredentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+		case containsFold(appConfig.CORS.AllowedOrigins, "*"):
+			// Any origin, but never with the user's cookies
+			allowed = true
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		}
+
+		if r.Method != http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if requestMethod := r.Header.Get("Access-Control-Request-Method"); requestMethod != "" {
+			if !allowed || !containsFold(appConfig.CORS.AllowedMethods, requestMethod) {
+				http.Error(w, "Cross-origin request not allowed", http.StatusForbidden)
+				return
+			}
+			w.Header().Set("Access-Control-Allow-Methods", allowedMethods)
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+			w.Header().Set("Access-Control-Max-Age", corsMaxAge)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
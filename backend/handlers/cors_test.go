@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// withConfig gives the test its own copy of appConfig to change
+func withConfig(t *testing.T) {
+	saved := appConfig
+	t.Cleanup(func() { appConfig = saved })
+	copied := *appConfig
+	appConfig = &copied
+}
+
+func corsRequest(method, origin string, header map[string]string) *httptest.ResponseRecorder {
+	handler := CORS(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+
+	r := httptest.NewRequest(method, "/api/chat", nil)
+	if origin != "" {
+		r.Header.Set("Origin", origin)
+	}
+	for name, value := range header {
+		r.Header.Set(name, value)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestCORSAllowedOrigins(t *testing.T) {
+	withConfig(t)
+	appConfig.CORS.AllowedOrigins = []string{"https://chat.example.com"}
+	appConfig.CORS.AllowedMethods = []string{"GET", "POST"}
+	appConfig.CORS.AllowCredentials = true
+
+	tests := []struct {
+		name        string
+		origin      string
+		allow       string
+		credentials string
+		vary        string
+	}{
+		{"allowed origin", "https://chat.example.com", "https://chat.example.com", "true", "Origin"},
+		{"case-insensitive match", "https://CHAT.example.com", "https://CHAT.example.com", "true", "Origin"},
+		{"other origin", "https://evil.example.com", "", "", "Origin"},
+		{"lookalike origin", "https://chat.example.com.evil.com", "", "", "Origin"},
+		{"same-origin request", "", "", "", ""},
+	}
+	for _, test := range tests {
+		w := corsRequest(http.MethodPost, test.origin, nil)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: status = %d, want the request passed on", test.name, w.Code)
+		}
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != test.allow {
+			t.Errorf("%s: Access-Control-Allow-Origin = %q, want %q", test.name, got, test.allow)
+		}
+		if got := w.Header().Get("Access-Control-Allow-Credentials"); got != test.credentials {
+			t.Errorf("%s: Access-Control-Allow-Credentials = %q, want %q", test.name, got, test.credentials)
+		}
+		if got := w.Header().Get("Vary"); got != test.vary {
+			t.Errorf("%s: Vary = %q, want %q", test.name, got, test.vary)
+		}
+	}
+}
+
+// A wildcard allows any origin but never with credentials
+func TestCORSWildcard(t *testing.T) {
+	withConfig(t)
+	appConfig.CORS.AllowedOrigins = []string{"*"}
+	appConfig.CORS.AllowCredentials = true
+
+	w := corsRequest(http.MethodGet, "https://any.example.com", nil)
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("Access-Control-Allow-Origin = %q, want *", got)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Credentials"); got != "" {
+		t.Errorf("Access-Control-Allow-Credentials = %q with a wildcard origin", got)
+	}
+}
+
+func TestCORSPreflight(t *testing.T) {
+	withConfig(t)
+	appConfig.CORS.AllowedOrigins = []string{"https://chat.example.com"}
+	appConfig.CORS.AllowedMethods = []string{"GET", "POST", "DELETE"}
+	appConfig.CORS.AllowCredentials = false
+
+	tests := []struct {
+		name    string
+		origin  string
+		method  string
+		status  int
+		methods string
+	}{
+		{"allowed", "https://chat.example.com", "DELETE", http.StatusNoContent, "GET, POST, DELETE"},
+		{"method not allowed", "https://chat.example.com", "PATCH", http.StatusForbidden, ""},
+		{"origin not allowed", "https://evil.example.com", "POST", http.StatusForbidden, ""},
+		// A bare OPTIONS request isn't a preflight
+		{"plain OPTIONS", "https://chat.example.com", "", http.StatusNoContent, ""},
+	}
+	for _, test := range tests {
+		header := map[string]string{}
+		if test.method != "" {
+			header["Access-Control-Request-Method"] = test.method
+		}
+		w := corsRequest(http.MethodOptions, test.origin, header)
+		if w.Code != test.status {
+			t.Errorf("%s: status = %d, want %d", test.name, w.Code, test.status)
+		}
+		if got := w.Header().Get("Access-Control-Allow-Methods"); got != test.methods {
+			t.Errorf("%s: Access-Control-Allow-Methods = %q, want %q", test.name, got, test.methods)
+		}
+		if test.methods != "" {
+			if w.Header().Get("Access-Control-Allow-Headers") != "Content-Type" || w.Header().Get("Access-Control-Max-Age") != corsMaxAge {
+				t.Errorf("%s: preflight headers = %v", test.name, w.Header())
+			}
+			if w.Header().Get("Access-Control-Allow-Credentials") != "" {
+				t.Errorf("%s: credentials allowed when the config doesn't", test.name)
+			}
+		}
+		if w.Body.String() == "ok" {
+			t.Errorf("%s: preflight reached the handler", test.name)
+		}
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"log"
 	"net/http"
 	"strings"
+	"sync"
+	"time"
 	"rag-chatbot/config"
 	"rag-chatbot/services"
 )
@@ -13,6 +15,31 @@ import (
 var (
 	openaiService  *services.OpenAIService
 	rankingService *services.RankingService
+	jiraService    *services.JiraService
+
+	// localIndex holds documents from connectors without a live search API
+	localIndex        *services.LocalIndex
+	filesystemService *services.FilesystemService
+	importService     *services.ImportService
+	crawlerService    *services.CrawlerService
+	restConnectors    []*services.RESTConnector
+	imapService       *services.IMAPService
+
+	// User accounts and their conversations
+	authMode          string
+	userStore         *services.UserStore
+	apiKeyStore       *services.APIKeyStore
+	conversationStore *services.ConversationStore
+	oidcService       *services.OIDCService
+	oidcGroups        services.OIDCGroupMapping
+	oidcGroupsClaim   string
+
+	// auditLog records chats and searches; nil when AUDIT_DIR is empty
+	auditLog *services.AuditLog
+
+	// Request rate limits and LLM token quotas
+	rateLimiter *services.RateLimiter
+	trustProxy  bool
 )
 
 func init() {
@@ -21,7 +48,145 @@ func init() {
 		cfg.OpenAI.APIKey,
 		cfg.OpenAI.Model,
 	)
+	redactor, err := services.NewRedactor(cfg.Redaction.Detectors, cfg.Redaction.SourceDetectors, cfg.Redaction.Restore)
+	if err != nil {
+		log.Fatalf("Invalid redaction settings: %v", err)
+	}
+	openaiService.Redactor = redactor
+	openaiService.InjectionDetector, err = services.NewInjectionDetector(cfg.PromptInjection.Action)
+	if err != nil {
+		log.Fatalf("Invalid PROMPT_INJECTION_ACTION: %v", err)
+	}
 	rankingService = services.NewRankingService()
+	jiraService = services.NewJiraService()
+	rateLimiter = services.NewRateLimiter(
+		services.NewMemoryRateLimitStore(),
+		services.RateLimit{Rate: cfg.RateLimit.RequestsPerMinute / 60, Burst: cfg.RateLimit.Burst},
+		cfg.RateLimit.DailyTokens,
+	)
+	trustProxy = cfg.RateLimit.TrustProxy
+
+	localIndex, err = services.NewLocalIndex(cfg.Index.Path)
+	if err != nil {
+		log.Printf("Warning: failed to load local index, starting empty: %v", err)
+		localIndex, _ = services.NewLocalIndex(cfg.Index.Path)
+	}
+
+	importService = services.NewImportService(localIndex, cfg.Imports.Dir, time.Duration(cfg.Imports.PollInterval)*time.Second)
+	filesystemService = services.NewFilesystemService(
+		services.ParseFilesystemRoots(cfg.Files.Roots),
+		localIndex,
+		time.Duration(cfg.Files.PollInterval)*time.Second,
+	)
+
+	if len(cfg.Crawler.Seeds) > 0 {
+		crawlerService = services.NewCrawlerService(
+			cfg.Crawler.Seeds,
+			cfg.Crawler.Sitemaps,
+			cfg.Crawler.PathPrefixes,
+			cfg.Crawler.MaxDepth,
+			cfg.Crawler.MaxPages,
+			time.Duration(cfg.Crawler.RequestInterval)*time.Millisecond,
+			time.Duration(cfg.Crawler.RecrawlInterval)*time.Minute,
+			localIndex,
+		)
+	}
+
+	for _, name := range cfg.REST.Connectors {
+		definition, err := services.LoadRESTConnectorDefinition(name)
+		if err != nil {
+			log.Printf("Warning: skipping REST connector: %v", err)
+			continue
+		}
+		connector, err := services.NewRESTConnector(definition, cfg.REST.Variables)
+		if err != nil {
+			log.Printf("Warning: skipping REST connector: %v", err)
+			continue
+		}
+		restConnectors = append(restConnectors, connector)
+	}
+
+	authMode = cfg.Auth.Mode
+	switch authMode {
+	case authModeLocal, authModeNone:
+		// Nothing to set up
+	case authModeOIDC:
+		if cfg.OIDC.Issuer == "" || cfg.OIDC.ClientID == "" {
+			log.Fatal("AUTH_MODE=oidc needs OIDC_ISSUER and OIDC_CLIENT_ID")
+		}
+		oidcService = services.NewOIDCService(
+			cfg.OIDC.Issuer,
+			cfg.OIDC.ClientID,
+			cfg.OIDC.ClientSecret,
+			cfg.OIDC.RedirectURL,
+			cfg.OIDC.Scopes,
+		)
+		oidcGroups = services.OIDCGroupMapping{
+			AllowedGroups:  cfg.OIDC.AllowedGroups,
+			AdminGroups:    cfg.OIDC.AdminGroups,
+			TenantGroups:   cfg.OIDC.TenantGroups,
+			UnmappedTenant: cfg.OIDC.UnmappedTenant,
+		}
+		oidcGroupsClaim = cfg.OIDC.GroupsClaim
+	default:
+		log.Fatalf("Unknown AUTH_MODE %q: use none, local or oidc", authMode)
+	}
+	userStore, err = services.NewUserStore(cfg.Auth.UsersPath, time.Duration(cfg.Auth.SessionTTL)*time.Hour)
+	if err != nil {
+		log.Fatalf("Failed to load users: %v", err)
+	}
+	if authMode == authModeLocal && userStore.Count() == 0 {
+		if cfg.Auth.AdminEmail == "" || cfg.Auth.AdminPassword == "" {
+			log.Println("Warning: AUTH_MODE=local but there are no users; set AUTH_ADMIN_EMAIL and AUTH_ADMIN_PASSWORD to create an admin")
+		} else if _, err := userStore.CreateLocalUser(services.DefaultTenant, cfg.Auth.AdminEmail, "Admin", cfg.Auth.AdminPassword, services.RoleAdmin); err != nil {
+			log.Printf("Warning: failed to create admin user: %v", err)
+		} else {
+			log.Printf("Created admin user %s", cfg.Auth.AdminEmail)
+		}
+	}
+	apiKeyStore, err = services.NewAPIKeyStore(cfg.Auth.APIKeysPath)
+	if err != nil {
+		log.Fatalf("Failed to load API keys: %v", err)
+	}
+	conversationStore, err = services.NewConversationStore(cfg.History.Path)
+	if err != nil {
+		log.Printf("Warning: failed to load conversation history, starting empty: %v", err)
+		conversationStore, _ = services.NewConversationStore(cfg.History.Path)
+	}
+	if cfg.Audit.Dir != "" {
+		// Running without the audit log the deployment asked for would leave gaps in it
+		auditLog, err = services.NewAuditLog(cfg.Audit.Dir, int64(cfg.Audit.MaxSizeMB)<<20, cfg.Audit.MaxFiles)
+		if err != nil {
+			log.Fatalf("Failed to open audit log: %v", err)
+		}
+	}
+
+	if cfg.IMAP.Host != "" {
+		imapService = services.NewIMAPService(
+			cfg.IMAP.Host,
+			cfg.IMAP.Port,
+			cfg.IMAP.Username,
+			cfg.IMAP.Password,
+			cfg.IMAP.AuthMethod,
+			cfg.IMAP.Security,
+			cfg.IMAP.Mailboxes,
+		)
+	}
+}
+
+// StartWatchers starts keeping the local index up to date: kept imports,
+// configured file roots and crawled sites. It is called once by the server,
+// so loading the package, as tests do, doesn't start background syncs.
+func StartWatchers() {
+	if importService.Dir != "" {
+		go importService.Watch(nil)
+	}
+	if len(filesystemService.Roots) > 0 {
+		go filesystemService.Watch(nil)
+	}
+	if crawlerService != nil {
+		go crawlerService.Watch(nil)
+	}
 }
 
 
@@ -35,16 +200,26 @@ func HealthHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 type ChatRequest struct {
-	Query           string            `json:"query"`
-	ConfluenceToken string            `json:"confluence_token,omitempty"`
-	SlackToken      string            `json:"slack_token,omitempty"`
-	GmailToken      string            `json:"gmail_token,omitempty"`
-	Sources         map[string]string `json:"sources"`
+	Query          string            `json:"query"`
+	Sources        map[string]string `json:"sources"`
+	ConversationID string            `json:"conversation_id,omitempty"` // Continues a signed-in user's conversation
+
+	// Deprecated: tokens of connected accounts are looked up server-side for the
+	// signed-in user or session cookie. Tokens sent in these fields are still
+	// used when present.
+	ConfluenceToken string `json:"confluence_token,omitempty"`
+	SlackToken      string `json:"slack_token,omitempty"`
+	GmailToken      string `json:"gmail_token,omitempty"`
+	GitHubToken     string `json:"github_token,omitempty"`
+	DriveToken      string `json:"drive_token,omitempty"`
+	NotionToken     string `json:"notion_token,omitempty"`
+	MicrosoftToken  string `json:"microsoft_token,omitempty"`
 }
 
 type ChatResponse struct {
-	Response   string      `json:"response"`
-	References []Reference `json:"references"`
+	Response       string      `json:"response"`
+	References     []Reference `json:"references"`
+	ConversationID string      `json:"conversation_id,omitempty"` // Set when the exchange was recorded
 }
 
 type Reference struct {
@@ -53,24 +228,21 @@ type Reference struct {
 	Source string `json:"source"`
 }
 
-func ChatHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	var req ChatRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
-		return
-	}
-
+// searchSources searches every source the request may use: connected accounts,
+// the deployment's shared connectors and the local index. Connector errors are
+// logged and skipped, so one failing source doesn't fail the whole search. It
+// also returns the names of the sources it searched, for the audit log.
+func searchSources(r *http.Request, req *ChatRequest) ([]Reference, []services.SearchResult, []string) {
 	var allReferences []Reference
 	var allSearchResults []services.SearchResult
+	var searched []string
 
 	// Search Confluence if token is provided
 	if req.ConfluenceToken != "" {
-		confluenceResults, err := searchConfluence(req.ConfluenceToken, req.Query)
+		searched = append(searched, "confluence")
+		confluenceResults, err := withTokenRefresh(r, "confluence", &req.ConfluenceToken, func(accessToken string) (*ConfluenceSearchResults, error) {
+			return searchConfluence(accessToken, req.Query, confluenceSiteSelectors(*req))
+		})
 		if err != nil {
 			log.Printf("Confluence search error: %v", err)
 		} else {
@@ -80,10 +252,27 @@ func ChatHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// Search Jira with the same Atlassian token
+	if req.ConfluenceToken != "" && jiraEnabled(*req) {
+		searched = append(searched, "jira")
+		jiraResults, err := withTokenRefresh(r, "confluence", &req.ConfluenceToken, func(accessToken string) (*JiraSearchResults, error) {
+			return searchJira(accessToken, req.Query, confluenceSiteSelectors(*req))
+		})
+		if err != nil {
+			log.Printf("Jira search error: %v", err)
+		} else {
+			allReferences = append(allReferences, jiraResults.References...)
+			allSearchResults = append(allSearchResults, jiraResults.SearchResults...)
+		}
+	}
+
 	// Search Gmail if token is provided
 	if req.GmailToken != "" {
+		searched = append(searched, "gmail")
 		log.Printf("Gmail token provided, searching for: %s", req.Query)
-		gmailResults, err := searchGmail(req.GmailToken, req.Query)
+		gmailResults, err := withTokenRefresh(r, "gmail", &req.GmailToken, func(accessToken string) (*GmailSearchResults, error) {
+			return searchGmail(accessToken, req.Query)
+		})
 		if err != nil {
 			log.Printf("Gmail search error: %v", err)
 		} else {
@@ -93,8 +282,115 @@ func ChatHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// Search Google Drive if token is provided
+	if req.DriveToken != "" {
+		searched = append(searched, "drive")
+		driveResults, err := withTokenRefresh(r, "drive", &req.DriveToken, func(accessToken string) (*DriveSearchResults, error) {
+			return searchDrive(accessToken, req.Query)
+		})
+		if err != nil {
+			log.Printf("Drive search error: %v", err)
+		} else {
+			allReferences = append(allReferences, driveResults.References...)
+			allSearchResults = append(allSearchResults, driveResults.SearchResults...)
+		}
+	}
+
+	// Search Notion if token is provided
+	if req.NotionToken != "" {
+		searched = append(searched, "notion")
+		notionResults, err := searchNotion(req.NotionToken, req.Query)
+		if err != nil {
+			log.Printf("Notion search error: %v", err)
+		} else {
+			allReferences = append(allReferences, notionResults.References...)
+			allSearchResults = append(allSearchResults, notionResults.SearchResults...)
+		}
+	}
+
+	// Search Microsoft 365 if token is provided; each part can be turned off separately
+	if req.MicrosoftToken != "" {
+		microsoftSearches := []struct {
+			source string
+			name   string
+			search func(accessToken, query string) (*MicrosoftSearchResults, error)
+		}{
+			{"outlook", "Outlook", searchOutlook},
+			{"teams", "Teams", searchTeams},
+			{"sharepoint", "SharePoint", searchSharePoint},
+		}
+		for _, microsoftSearch := range microsoftSearches {
+			if req.Sources[microsoftSearch.source] == "disabled" {
+				continue
+			}
+			searched = append(searched, microsoftSearch.source)
+			microsoftResults, err := microsoftSearch.search(req.MicrosoftToken, req.Query)
+			if err != nil {
+				log.Printf("%s search error: %v", microsoftSearch.name, err)
+			} else {
+				allReferences = append(allReferences, microsoftResults.References...)
+				allSearchResults = append(allSearchResults, microsoftResults.SearchResults...)
+			}
+		}
+	}
+
+	// Search the configured IMAP mailboxes unless disabled
+	if imapService != nil && sharedSourcesAllowed(r) && req.Sources["imap"] != "disabled" {
+		searched = append(searched, "imap")
+		imapResults, err := searchIMAP(req.Query)
+		if err != nil {
+			log.Printf("IMAP search error: %v", err)
+		} else {
+			allReferences = append(allReferences, imapResults.References...)
+			allSearchResults = append(allSearchResults, sharedResults(imapResults.SearchResults)...)
+		}
+	}
+
+	// Search indexed local files unless disabled
+	if sharedSourcesAllowed(r) && req.Sources["files"] != "disabled" {
+		searched = append(searched, "files")
+		fileResults := searchIndex(readerFor(r), req.Query, "files")
+		allReferences = append(allReferences, fileResults.References...)
+		allSearchResults = append(allSearchResults, sharedResults(fileResults.SearchResults)...)
+	}
+
+	// Search declarative REST connectors (Linear, Zendesk, internal tools) unless disabled
+	for _, connector := range restConnectors {
+		name := connector.Definition.Name
+		if !sharedSourcesAllowed(r) || req.Sources[name] == "disabled" {
+			continue
+		}
+		searched = append(searched, name)
+		log.Printf("Searching %s for: %s", connector.Definition.DisplayName, req.Query)
+		restResults, err := searchRESTConnector(connector, req.Query)
+		if err != nil {
+			log.Printf("Error searching %s: %v", connector.Definition.DisplayName, err)
+		} else {
+			log.Printf("%s search returned %d references", connector.Definition.DisplayName, len(restResults.References))
+			allReferences = append(allReferences, restResults.References...)
+			allSearchResults = append(allSearchResults, sharedResults(restResults.SearchResults)...)
+		}
+	}
+
+	// Search crawled documentation sites unless disabled
+	if sharedSourcesAllowed(r) && req.Sources["web"] != "disabled" {
+		searched = append(searched, "web")
+		webResults := searchIndex(readerFor(r), req.Query, "web")
+		allReferences = append(allReferences, webResults.References...)
+		allSearchResults = append(allSearchResults, sharedResults(webResults.SearchResults)...)
+	}
+
+	// Search offline imports (Slack, mail and Confluence exports) unless disabled
+	if req.Sources["imports"] != "disabled" {
+		searched = append(searched, "imports")
+		importResults := searchIndex(readerFor(r), req.Query, services.ImportSources...)
+		allReferences = append(allReferences, importResults.References...)
+		allSearchResults = append(allSearchResults, importResults.SearchResults...)
+	}
+
 	// Search Slack if token is provided
 	if req.SlackToken != "" {
+		searched = append(searched, "slack")
 		log.Printf("Slack token provided, searching for: %s", req.Query)
 		slackResults, err := searchSlack(req.SlackToken, req.Query)
 		if err != nil {
@@ -106,25 +402,136 @@ func ChatHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// Search GitHub with the user's token, or the configured personal access token
+	if githubToken := githubTokenFor(r, *req); githubToken != "" {
+		searched = append(searched, "github")
+		githubResults, err := searchGitHub(githubToken, req.Query)
+		if err != nil {
+			log.Printf("GitHub search error: %v", err)
+		} else {
+			allReferences = append(allReferences, githubResults.References...)
+			if req.GitHubToken == "" {
+				sharedResults(githubResults.SearchResults)
+			}
+			allSearchResults = append(allSearchResults, githubResults.SearchResults...)
+		}
+	}
+
+	return allReferences, allSearchResults, searched
+}
+
+// sharedResults marks results found with the deployment's access rather than
+// the user's own, whose sensitive values stay masked for them
+func sharedResults(results []services.SearchResult) []services.SearchResult {
+	for i := range results {
+		results[i].Shared = true
+	}
+	return results
+}
+
+func ChatHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req ChatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	resolveCredentials(r, &req)
+
+	allReferences, allSearchResults, searched := searchSources(r, &req)
+
 	// Generate response using OpenAI
 	var responseText string
+	var usage *services.OpenAIUsage
+	redaction := openaiService.Redactor.NewRedaction()
 	if len(allSearchResults) > 0 {
-		aiResponse, err := openaiService.GenerateResponse(req.Query, allSearchResults)
+		aiResponse, err := openaiService.GenerateResponse(req.Query, allSearchResults, redaction)
 		if err != nil {
 			log.Printf("OpenAI error: %v", err)
 			responseText = fmt.Sprintf("Found %d relevant results from your sources, but couldn't generate a detailed response. Please try again.", len(allSearchResults))
-		} else if len(aiResponse.Choices) > 0 {
-			responseText = aiResponse.Choices[0].Message.Content
 		} else {
-			responseText = "No response generated from AI service."
+			usage = &aiResponse.Usage
+			rateLimiter.RecordUsage(rateLimitKey(r), aiResponse.Usage)
+			if len(aiResponse.Choices) > 0 {
+				responseText = aiResponse.Choices[0].Message.Content
+			} else {
+				responseText = "No response generated from AI service."
+			}
 		}
 	} else {
 		responseText = "I couldn't find any relevant information in your connected sources. Please make sure you've connected your data sources and try a different query."
 	}
 
+	conversationID := recordQuestion(r, req.ConversationID, req.Query)
+	recordAnswer(r, conversationID, responseText, allReferences)
+	recordAudit(r, services.AuditActionChat, &req, conversationID, searched, allReferences, redaction.Mask(responseText), usage)
+
 	response := ChatResponse{
-		Response:   responseText,
-		References: allReferences,
+		Response:       responseText,
+		References:     allReferences,
+		ConversationID: conversationID,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+type SearchResponse struct {
+	References []Reference           `json:"references"`
+	Results    []SearchResultPayload `json:"results"`
+}
+
+// SearchResultPayload is a retrieved passage, as it would be given to the model
+type SearchResultPayload struct {
+	Title      string `json:"title"`
+	Content    string `json:"content"`
+	Source     string `json:"source"`
+	URL        string `json:"url"`
+	Suspicious bool   `json:"suspicious,omitempty"` // Looks like prompt injection; treat it as data only
+}
+
+// SearchHandler takes the same request as ChatHandler but returns the
+// retrieved sources instead of an answer, for tools that bring their own model
+func SearchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req ChatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	resolveCredentials(r, &req)
+
+	allReferences, allSearchResults, searched := searchSources(r, &req)
+	recordAudit(r, services.AuditActionSearch, &req, "", searched, allReferences, "", nil)
+
+	response := SearchResponse{References: allReferences, Results: []SearchResultPayload{}}
+	if response.References == nil {
+		response.References = []Reference{}
+	}
+	// Shared results are masked as they would be in an answer, and everything
+	// is screened like the model's documents, for tools that pass them to their own
+	redaction := openaiService.Redactor.NewRedaction()
+	for i, result := range redaction.RedactResults(allSearchResults) {
+		if result.Shared {
+			allSearchResults[i] = result
+		}
+	}
+	for _, result := range openaiService.InjectionDetector.Screen(allSearchResults) {
+		response.Results = append(response.Results, SearchResultPayload{
+			Title:      result.Title,
+			Content:    result.Content,
+			Source:     result.Source,
+			URL:        result.URL,
+			Suspicious: result.Suspicious,
+		})
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -141,54 +548,32 @@ func ChatStreamHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
 
 	var req ChatRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid JSON", http.StatusBadRequest)
 		return
 	}
+	resolveCredentials(r, &req)
 
 	// Send initial status
 	fmt.Printf("Starting SSE stream\n")
 	fmt.Fprintf(w, "data: {\"type\":\"status\",\"message\":\"Searching your sources...\"}\n\n")
 	w.(http.Flusher).Flush()
 
-	var allReferences []Reference
-	var allSearchResults []services.SearchResult
-
-	// Search Confluence if token is provided
-	if req.ConfluenceToken != "" {
-		confluenceResults, err := searchConfluence(req.ConfluenceToken, req.Query)
-		if err != nil {
-			log.Printf("Confluence search error: %v", err)
-		} else {
-			allReferences = append(allReferences, confluenceResults.References...)
-			allSearchResults = append(allSearchResults, confluenceResults.SearchResults...)
-		}
-	}
-
-	// Search Gmail if token is provided
-	if req.GmailToken != "" {
-		gmailResults, err := searchGmail(req.GmailToken, req.Query)
-		if err != nil {
-			log.Printf("Gmail search error: %v", err)
-		} else {
-			allReferences = append(allReferences, gmailResults.References...)
-			allSearchResults = append(allSearchResults, gmailResults.SearchResults...)
-		}
+	// Record the question up front, so the client learns the conversation's
+	// ID before "done" ends the stream
+	conversationID := recordQuestion(r, req.ConversationID, req.Query)
+	if conversationID != "" {
+		conversationJSON, _ := json.Marshal(map[string]string{
+			"type": "conversation",
+			"id":   conversationID,
+		})
+		fmt.Fprintf(w, "data: %s\n\n", conversationJSON)
+		w.(http.Flusher).Flush()
 	}
 
-	// Search Slack if token is provided
-	if req.SlackToken != "" {
-		slackResults, err := searchSlack(req.SlackToken, req.Query)
-		if err != nil {
-			log.Printf("Slack search error: %v", err)
-		} else {
-			allReferences = append(allReferences, slackResults.References...)
-			allSearchResults = append(allSearchResults, slackResults.SearchResults...)
-		}
-	}
+	allReferences, allSearchResults, searched := searchSources(r, &req)
 
 	// Send references
 	if len(allReferences) > 0 {
@@ -207,7 +592,13 @@ func ChatStreamHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Generate streaming response
 	if len(allSearchResults) > 0 {
-		err := openaiService.GenerateStreamingResponse(req.Query, allSearchResults, w)
+		redaction := openaiService.Redactor.NewRedaction()
+		answer, usage, err := openaiService.GenerateStreamingResponse(req.Query, allSearchResults, redaction, w)
+		rateLimiter.RecordUsage(rateLimitKey(r), usage)
+		if answer != "" {
+			recordAnswer(r, conversationID, answer, allReferences)
+		}
+		recordAudit(r, services.AuditActionChat, &req, conversationID, searched, allReferences, redaction.Mask(answer), &usage)
 		if err != nil {
 			log.Printf("OpenAI streaming error: %v", err)
 			errorData := map[string]string{
@@ -226,6 +617,8 @@ func ChatStreamHandler(w http.ResponseWriter, r *http.Request) {
 		messageJSON, _ := json.Marshal(messageData)
 		fmt.Fprintf(w, "data: %s\n\n", messageJSON)
 		fmt.Fprintf(w, "data: {\"type\":\"done\"}\n\n")
+		recordAnswer(r, conversationID, messageData["content"], nil)
+		recordAudit(r, services.AuditActionChat, &req, conversationID, searched, nil, messageData["content"], nil)
 	}
 
 	w.(http.Flusher).Flush()
@@ -236,62 +629,75 @@ type ConfluenceSearchResults struct {
 	SearchResults []services.SearchResult
 }
 
-func searchConfluence(accessToken, query string) (*ConfluenceSearchResults, error) {
-	// Get accessible resources first (using the global confluenceService from oauth.go)
-	resources, err := confluenceService.GetAccessibleResources(accessToken)
+func searchConfluence(accessToken, query string, siteSelectors []string) (*ConfluenceSearchResults, error) {
+	// Get accessible resources first (cached per token by confluenceService from oauth.go)
+	sites, err := confluenceService.GetSites(accessToken)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get accessible resources: %v", err)
+		return nil, fmt.Errorf("failed to get accessible resources: %w", err)
 	}
 
-	if len(resources.Values) == 0 {
+	if len(sites) == 0 {
 		return nil, fmt.Errorf("no accessible Confluence sites found")
 	}
 
-	// Use the first available site
-	cloudID := resources.Values[0].ID
-	baseURL := strings.TrimSuffix(resources.Values[0].URL, "/")
-
-	// Search for content
-	searchResults, err := confluenceService.SearchContent(accessToken, query, cloudID)
-	if err != nil {
-		return nil, fmt.Errorf("failed to search Confluence: %v", err)
+	sites = services.FilterSites(services.ConfluenceSites(sites), siteSelectors)
+	if len(sites) == 0 {
+		return nil, fmt.Errorf("none of the selected Confluence sites are accessible")
 	}
 
-	if len(searchResults.Results) == 0 {
-		return &ConfluenceSearchResults{
-			References: []Reference{},
-			SearchResults: []services.SearchResult{},
-		}, nil
+	// Step 1: Search every selected site in parallel
+	type siteResult struct {
+		hits []confluenceHit
+		err  error
 	}
+	siteResults := make([]siteResult, len(sites))
+
+	var wg sync.WaitGroup
+	for i, site := range sites {
+		wg.Add(1)
+		go func(i int, site services.ConfluenceResource) {
+			defer wg.Done()
+			hits, err := searchConfluenceSite(accessToken, query, site, len(sites) > 1)
+			siteResults[i] = siteResult{hits: hits, err: err}
+		}(i, site)
+	}
+	wg.Wait()
 
-	// Step 1: Convert search results to SearchResult format with available content
+	// Merge results, only failing if every site failed or the token was rejected
+	hitsByURL := make(map[string]confluenceHit)
 	var initialResults []services.SearchResult
-	for _, content := range searchResults.Results {
-		fullURL := baseURL + content.Links.WebUI
-		
-		// Extract content from the search result if available
-		var contentText string
-		if content.Body.View.Value != "" {
-			// Extract plain text from HTML
-			plainText := services.ExtractPlainText(content.Body.View.Value)
-			// Use intelligent chunking to extract relevant sections
-			contentText = services.ExtractRelevantSections(plainText, query, 1500)
-		} else {
-			contentText = content.Title // Fall back to title if no content
+	siteErrs := make([]error, len(sites))
+	for i, sr := range siteResults {
+		if sr.err != nil {
+			log.Printf("Confluence search error on site %s: %v", sites[i].Name, sr.err)
+			siteErrs[i] = sr.err
+			continue
+		}
+		for _, hit := range sr.hits {
+			hitsByURL[hit.Result.URL] = hit
+			initialResults = append(initialResults, hit.Result)
 		}
-		
-		initialResults = append(initialResults, services.SearchResult{
-			Title:   content.Title,
-			Content: contentText,
-			Source:  "confluence",
-			URL:     fullURL,
-		})
+	}
+	if err := siteSearchError(siteErrs); err != nil {
+		return nil, fmt.Errorf("failed to search Confluence: %w", err)
+	}
+
+	if len(initialResults) == 0 {
+		return &ConfluenceSearchResults{
+			References:    []Reference{},
+			SearchResults: []services.SearchResult{},
+		}, nil
 	}
 
 	// Step 2: Rerank results to get top 3 most relevant (now with actual content)
 	topResults := rankingService.RerankResults(query, initialResults, 3)
 
-	// Step 3: Prepare final results and references
+	// Step 3: Expand the top hits with comments, attachments and neighbouring
+	// pages, then rerank the enlarged candidate set
+	candidates := expandConfluenceHits(accessToken, query, topResults, hitsByURL)
+	topResults = rankingService.RerankResults(query, candidates, 3)
+
+	// Step 4: Prepare final results and references
 	var enhancedResults []services.SearchResult
 	var references []Reference
 
@@ -316,59 +722,334 @@ func searchConfluence(accessToken, query string) (*ConfluenceSearchResults, erro
 	}, nil
 }
 
-type GmailSearchResults struct {
-	References    []Reference
-	SearchResults []services.SearchResult
+// confluenceHit keeps the IDs needed to expand a search hit after reranking
+type confluenceHit struct {
+	Result    services.SearchResult
+	ContentID string
+	Site      services.ConfluenceResource
+	MultiSite bool
 }
 
-func searchGmail(accessToken, query string) (*GmailSearchResults, error) {
-	log.Printf("Searching Gmail with query: %s", query)
-	// Create Gmail service instance (reuse from oauth.go)
-	searchResults, err := gmailService.SearchMessages(accessToken, query, 10)
+// searchConfluenceSite searches a single Atlassian site and converts the hits to
+// SearchResults. When several sites are searched the site name is added to the title.
+func searchConfluenceSite(accessToken, query string, site services.ConfluenceResource, multiSite bool) ([]confluenceHit, error) {
+	searchResults, err := confluenceService.SearchContent(accessToken, query, site.ID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to search Gmail: %v", err)
+		return nil, err
 	}
 
-	log.Printf("Gmail API returned %d messages", len(searchResults.Messages))
-	if len(searchResults.Messages) == 0 {
-		log.Printf("No Gmail messages found for query: %s", query)
-		return &GmailSearchResults{
-			References:    []Reference{},
-			SearchResults: []services.SearchResult{},
-		}, nil
+	var hits []confluenceHit
+	for _, content := range searchResults.Results {
+		hits = append(hits, confluenceHit{
+			Result:    confluenceResult(query, site, multiSite, content.Title, content.Space.Name, content.Links.WebUI, services.PageMarkdown(content.Body.Storage.Value, content.Body.View.Value), nil),
+			ContentID: content.ID,
+			Site:      site,
+			MultiSite: multiSite,
+		})
 	}
 
-	// Step 1: Get details for all messages to prepare for reranking
-	var initialResults []services.SearchResult
-	for _, message := range searchResults.Messages {
-		messageDetail, err := gmailService.GetMessageDetail(accessToken, message.ID)
-		if err != nil {
-			log.Printf("Failed to get Gmail message detail for %s: %v", message.ID, err)
+	return hits, nil
+}
+
+// expandConfluenceHits replaces each hit with its full page context and adds
+// its attachments, parent and child pages as extra candidates.
+func expandConfluenceHits(accessToken, query string, results []services.SearchResult, hitsByURL map[string]confluenceHit) []services.SearchResult {
+	expanded := make([][]services.SearchResult, len(results))
+
+	var wg sync.WaitGroup
+	for i, result := range results {
+		hit, ok := hitsByURL[result.URL]
+		if !ok {
+			expanded[i] = []services.SearchResult{result}
 			continue
 		}
 
-		// Extract email information
-		subject, sender, content, date := gmailService.ExtractEmailInfo(messageDetail)
-		
-		// Create a Gmail URL (this would need to be adjusted for actual Gmail links)
-		gmailURL := fmt.Sprintf("https://mail.google.com/mail/u/0/#inbox/%s", message.ID)
-		
-		// Use intelligent chunking to extract relevant content
-		relevantContent := services.ExtractRelevantSections(content, query, 1200) // Slightly smaller for emails
-		
-		// Combine subject and content for better context
-		fullContent := fmt.Sprintf("Subject: %s\nFrom: %s\nDate: %s\n\n%s", 
-			subject, sender, date.Format("2006-01-02 15:04"), relevantContent)
-
-		initialResults = append(initialResults, services.SearchResult{
-			Title:   subject,
-			Content: fullContent,
-			Source:  "gmail",
-			URL:     gmailURL,
-		})
+		wg.Add(1)
+		go func(i int, hit confluenceHit) {
+			defer wg.Done()
+			pageContext, err := confluenceService.GetPageContext(accessToken, hit.ContentID, hit.Site.ID)
+			if err != nil {
+				log.Printf("Failed to expand Confluence page %s: %v", hit.ContentID, err)
+				expanded[i] = []services.SearchResult{hit.Result}
+				return
+			}
+			expanded[i] = confluenceContextResults(query, hit, pageContext)
+		}(i, hit)
 	}
-
-	// Step 2: Rerank to get top 3 most relevant emails
+	wg.Wait()
+
+	// Flatten, skipping pages reached through more than one hit
+	seen := make(map[string]bool)
+	var candidates []services.SearchResult
+	for _, group := range expanded {
+		for _, result := range group {
+			key := result.Title + "|" + result.URL
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			candidates = append(candidates, result)
+		}
+	}
+
+	return candidates
+}
+
+// confluenceContextResults turns a page context into SearchResults: the page
+// itself (with breadcrumb, labels and comments), one result per readable
+// attachment, and the parent and child pages.
+func confluenceContextResults(query string, hit confluenceHit, pageContext *services.ConfluencePageContext) []services.SearchResult {
+	detail := pageContext.Detail
+
+	var extra []string
+	if len(pageContext.Breadcrumb) > 0 {
+		extra = append(extra, "Path: "+strings.Join(pageContext.Breadcrumb, " > "))
+	}
+	if len(pageContext.Labels) > 0 {
+		extra = append(extra, "Labels: "+strings.Join(pageContext.Labels, ", "))
+	}
+
+	webUI := detail.Links.WebUI
+	if webUI == "" {
+		webUI = strings.TrimPrefix(hit.Result.URL, strings.TrimSuffix(hit.Site.URL, "/"))
+	}
+	page := confluenceResult(query, hit.Site, hit.MultiSite, detail.Title, detail.Space.Name, webUI, services.PageMarkdown(detail.Body.Storage.Value, detail.Body.View.Value), extra)
+
+	// Comments often hold the actual answer, append the relevant parts
+	var comments []string
+	for _, comment := range pageContext.Comments {
+		text := services.ExtractPlainText(comment.Body.View.Value)
+		if text == "" {
+			continue
+		}
+		comments = append(comments, fmt.Sprintf("%s: %s", comment.History.CreatedBy.DisplayName, text))
+	}
+	if len(comments) > 0 {
+		page.Content += "\n\nComments:\n" + services.ExtractRelevantSections(strings.Join(comments, "\n"), query, 800)
+	}
+
+	results := []services.SearchResult{page}
+
+	for title, text := range pageContext.AttachmentTexts {
+		results = append(results, services.SearchResult{
+			Title:   fmt.Sprintf("%s (attached to %s)", title, page.Title),
+			Content: fmt.Sprintf("Site: %s\nAttachment: %s\nPage: %s\n\n%s", hit.Site.Name, title, detail.Title, services.ExtractRelevantSections(text, query, 1500)),
+			Source:  "confluence",
+			URL:     page.URL,
+		})
+	}
+
+	if parent := pageContext.Parent; parent != nil {
+		results = append(results, confluenceResult(query, hit.Site, hit.MultiSite, parent.Title, parent.Space.Name, parent.Links.WebUI, services.PageMarkdown(parent.Body.Storage.Value, parent.Body.View.Value), []string{"Parent of: " + detail.Title}))
+	}
+	for _, child := range pageContext.Children {
+		results = append(results, confluenceResult(query, hit.Site, hit.MultiSite, child.Title, child.Space.Name, child.Links.WebUI, services.PageMarkdown(child.Body.Storage.Value, child.Body.View.Value), []string{"Child of: " + detail.Title}))
+	}
+
+	return results
+}
+
+// confluenceResult builds a SearchResult for a page on a site
+func confluenceResult(query string, site services.ConfluenceResource, multiSite bool, title, space, webUI, markdown string, extra []string) services.SearchResult {
+	// Extract content from the page body if available
+	var contentText string
+	if markdown != "" {
+		// Keep the sections under the headings that match the query
+		contentText = services.ExtractRelevantMarkdown(markdown, query, 1500)
+	} else {
+		contentText = title // Fall back to title if no content
+	}
+
+	header := []string{"Site: " + site.Name, "Space: " + space}
+	header = append(header, extra...)
+
+	displayTitle := title
+	if multiSite {
+		displayTitle = fmt.Sprintf("%s (%s)", title, site.Name)
+	}
+
+	return services.SearchResult{
+		Title:   displayTitle,
+		Content: strings.Join(header, "\n") + "\n\n" + contentText,
+		Source:  "confluence",
+		URL:     strings.TrimSuffix(site.URL, "/") + webUI,
+	}
+}
+
+// siteSearchError picks the error a search across Atlassian sites fails with.
+// A rejected token fails it even when other sites answered, so that
+// withTokenRefresh refreshes the token and searches again; other errors only
+// fail it when every site failed. errs has one entry per site, nil on success.
+func siteSearchError(errs []error) error {
+	var lastErr error
+	failed := 0
+	for _, err := range errs {
+		if err == nil {
+			continue
+		}
+		if services.IsUnauthorized(err) {
+			return err
+		}
+		lastErr = err
+		failed++
+	}
+	if failed > 0 && failed == len(errs) {
+		return lastErr
+	}
+	return nil
+}
+
+// confluenceSiteSelectors returns the sites chosen for this request. A
+// comma-separated "confluence_sites" entry in Sources overrides CONFLUENCE_SITES.
+func confluenceSiteSelectors(req ChatRequest) []string {
+	if selected := req.Sources["confluence_sites"]; selected != "" {
+		return strings.Split(selected, ",")
+	}
+	return appConfig.Confluence.Sites
+}
+
+type JiraSearchResults struct {
+	References    []Reference
+	SearchResults []services.SearchResult
+}
+
+func searchJira(accessToken, query string, siteSelectors []string) (*JiraSearchResults, error) {
+	sites, err := confluenceService.GetSites(accessToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get accessible resources: %w", err)
+	}
+
+	sites = services.FilterSites(services.JiraSites(sites), siteSelectors)
+	if len(sites) == 0 {
+		return nil, fmt.Errorf("no accessible Jira sites found")
+	}
+
+	jql := services.BuildJQL(query, appConfig.Jira.Projects)
+
+	// Step 1: Search every Jira site in parallel
+	siteResults := make([][]services.SearchResult, len(sites))
+	siteErrs := make([]error, len(sites))
+	var wg sync.WaitGroup
+	for i, site := range sites {
+		wg.Add(1)
+		go func(i int, site services.ConfluenceResource) {
+			defer wg.Done()
+			searchResults, err := jiraService.SearchIssues(accessToken, site.ID, jql, 10)
+			if err != nil {
+				log.Printf("Jira search error on site %s: %v", site.Name, err)
+				siteErrs[i] = err
+				return
+			}
+
+			baseURL := strings.TrimSuffix(site.URL, "/")
+			for _, issue := range searchResults.Issues {
+				issueText := jiraService.FormatIssue(issue)
+				siteResults[i] = append(siteResults[i], services.SearchResult{
+					Title:   fmt.Sprintf("%s: %s", issue.Key, issue.Fields.Summary),
+					Content: services.ExtractRelevantMarkdown(issueText, query, 1500),
+					Source:  "jira",
+					URL:     baseURL + "/browse/" + issue.Key,
+				})
+			}
+		}(i, site)
+	}
+	wg.Wait()
+	if err := siteSearchError(siteErrs); err != nil {
+		return nil, fmt.Errorf("failed to search Jira: %w", err)
+	}
+
+	var initialResults []services.SearchResult
+	for _, results := range siteResults {
+		initialResults = append(initialResults, results...)
+	}
+
+	if len(initialResults) == 0 {
+		return &JiraSearchResults{
+			References:    []Reference{},
+			SearchResults: []services.SearchResult{},
+		}, nil
+	}
+
+	// Step 2: Rerank to get top 3 most relevant issues
+	topResults := rankingService.RerankResults(query, initialResults, 3)
+
+	// Step 3: Prepare final results and references
+	var references []Reference
+	for _, result := range topResults {
+		references = append(references, Reference{
+			Title:  result.Title,
+			URL:    result.URL,
+			Source: "jira",
+		})
+	}
+
+	return &JiraSearchResults{
+		References:    references,
+		SearchResults: topResults,
+	}, nil
+}
+
+// jiraEnabled reports whether Jira should be searched for this request
+func jiraEnabled(req ChatRequest) bool {
+	if source, ok := req.Sources["jira"]; ok {
+		return source != "disabled"
+	}
+	return appConfig.Jira.Enabled
+}
+
+type GmailSearchResults struct {
+	References    []Reference
+	SearchResults []services.SearchResult
+}
+
+func searchGmail(accessToken, query string) (*GmailSearchResults, error) {
+	log.Printf("Searching Gmail with query: %s", query)
+	// Create Gmail service instance (reuse from oauth.go)
+	searchResults, err := gmailService.SearchMessages(accessToken, query, 10)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search Gmail: %w", err)
+	}
+
+	log.Printf("Gmail API returned %d messages", len(searchResults.Messages))
+	if len(searchResults.Messages) == 0 {
+		log.Printf("No Gmail messages found for query: %s", query)
+		return &GmailSearchResults{
+			References:    []Reference{},
+			SearchResults: []services.SearchResult{},
+		}, nil
+	}
+
+	// Step 1: Get details for all messages to prepare for reranking
+	var initialResults []services.SearchResult
+	for _, message := range searchResults.Messages {
+		messageDetail, err := gmailService.GetMessageDetail(accessToken, message.ID)
+		if err != nil {
+			log.Printf("Failed to get Gmail message detail for %s: %v", message.ID, err)
+			continue
+		}
+
+		// Extract email information
+		subject, sender, content, date := gmailService.ExtractEmailInfo(messageDetail)
+		
+		// Create a Gmail URL (this would need to be adjusted for actual Gmail links)
+		gmailURL := fmt.Sprintf("https://mail.google.com/mail/u/0/#inbox/%s", message.ID)
+		
+		// Use intelligent chunking to extract relevant content
+		relevantContent := services.ExtractRelevantSections(content, query, 1200) // Slightly smaller for emails
+		
+		// Combine subject and content for better context
+		fullContent := fmt.Sprintf("Subject: %s\nFrom: %s\nDate: %s\n\n%s", 
+			subject, sender, date.Format("2006-01-02 15:04"), relevantContent)
+
+		initialResults = append(initialResults, services.SearchResult{
+			Title:   subject,
+			Content: fullContent,
+			Source:  "gmail",
+			URL:     gmailURL,
+		})
+	}
+
+	// Step 2: Rerank to get top 3 most relevant emails
 	topResults := rankingService.RerankResults(query, initialResults, 3)
 
 	// Step 3: Prepare final results and references
@@ -396,6 +1077,412 @@ func searchGmail(accessToken, query string) (*GmailSearchResults, error) {
 	}, nil
 }
 
+type IMAPSearchResults struct {
+	References    []Reference
+	SearchResults []services.SearchResult
+}
+
+func searchIMAP(query string) (*IMAPSearchResults, error) {
+	log.Printf("Searching IMAP with query: %s", query)
+	messages, err := imapService.Search(query, 10)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search IMAP: %v", err)
+	}
+
+	// Step 1: Convert the fetched messages to prepare for reranking
+	var initialResults []services.SearchResult
+	for _, message := range messages {
+		email := message.Email
+
+		// Use intelligent chunking to extract relevant content
+		relevantContent := services.ExtractRelevantSections(email.Content(), query, 1200)
+
+		initialResults = append(initialResults, services.SearchResult{
+			Title:   email.Subject,
+			Content: fmt.Sprintf("Subject: %s\nFrom: %s\nDate: %s\nMailbox: %s\n\n%s", email.Subject, email.From, email.Date.Format("2006-01-02 15:04"), message.Mailbox, relevantContent),
+			Source:  "imap",
+			URL:     message.URL,
+		})
+	}
+
+	// Step 2: Rerank to get top 3 most relevant emails
+	topResults := rankingService.RerankResults(query, initialResults, 3)
+
+	// Step 3: Prepare final results and references
+	references := []Reference{}
+	for _, result := range topResults {
+		references = append(references, Reference{
+			Title:  result.Title,
+			URL:    result.URL,
+			Source: "imap",
+		})
+	}
+
+	return &IMAPSearchResults{
+		References:    references,
+		SearchResults: topResults,
+	}, nil
+}
+
+type DriveSearchResults struct {
+	References    []Reference
+	SearchResults []services.SearchResult
+}
+
+func searchDrive(accessToken, query string) (*DriveSearchResults, error) {
+	log.Printf("Searching Drive with query: %s", query)
+	fileList, err := driveService.SearchFiles(accessToken, services.BuildDriveQuery(query), 10)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search Drive: %w", err)
+	}
+
+	if len(fileList.Files) == 0 {
+		return &DriveSearchResults{
+			References:    []Reference{},
+			SearchResults: []services.SearchResult{},
+		}, nil
+	}
+
+	// Step 1: Export every file in parallel to prepare for reranking
+	fileResults := make([]*services.SearchResult, len(fileList.Files))
+	var wg sync.WaitGroup
+	for i, file := range fileList.Files {
+		wg.Add(1)
+		go func(i int, file services.DriveFile) {
+			defer wg.Done()
+			text, err := driveService.GetFileText(accessToken, file)
+			if err != nil {
+				log.Printf("Failed to get Drive file %s: %v", file.ID, err)
+				text = file.Name // Fall back to the file name
+			}
+
+			// Use intelligent chunking to extract relevant content
+			relevantContent := services.ExtractRelevantSections(text, query, 1500)
+
+			fileResults[i] = &services.SearchResult{
+				Title:   file.Name,
+				Content: driveService.FormatFileMetadata(file) + "\n\n" + relevantContent,
+				Source:  "drive",
+				URL:     file.WebViewLink,
+			}
+		}(i, file)
+	}
+	wg.Wait()
+
+	var initialResults []services.SearchResult
+	for _, result := range fileResults {
+		initialResults = append(initialResults, *result)
+	}
+
+	// Step 2: Rerank to get top 3 most relevant files
+	topResults := rankingService.RerankResults(query, initialResults, 3)
+
+	// Step 3: Prepare final results and references
+	var references []Reference
+	for _, result := range topResults {
+		references = append(references, Reference{
+			Title:  result.Title,
+			URL:    result.URL,
+			Source: "drive",
+		})
+	}
+
+	return &DriveSearchResults{
+		References:    references,
+		SearchResults: topResults,
+	}, nil
+}
+
+type NotionSearchResults struct {
+	References    []Reference
+	SearchResults []services.SearchResult
+}
+
+func searchNotion(accessToken, query string) (*NotionSearchResults, error) {
+	log.Printf("Searching Notion with query: %s", query)
+	searchResults, err := notionService.Search(accessToken, query, 10)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(searchResults.Results) == 0 {
+		return &NotionSearchResults{
+			References:    []Reference{},
+			SearchResults: []services.SearchResult{},
+		}, nil
+	}
+
+	// Step 1: Render each page's block tree in parallel to prepare for reranking
+	objectResults := make([]services.SearchResult, len(searchResults.Results))
+	var wg sync.WaitGroup
+	for i, object := range searchResults.Results {
+		wg.Add(1)
+		go func(i int, object services.NotionObject) {
+			defer wg.Done()
+			title := notionService.ObjectTitle(object)
+
+			text, err := notionService.RenderObject(accessToken, object)
+			if err != nil {
+				log.Printf("Failed to read Notion %s %s: %v", object.Object, object.ID, err)
+				text = title // Fall back to the title
+			}
+
+			objectResults[i] = services.SearchResult{
+				Title:   title,
+				Content: fmt.Sprintf("Notion %s: %s\n\n%s", object.Object, title, services.ExtractRelevantMarkdown(text, query, 1500)),
+				Source:  "notion",
+				URL:     object.URL,
+			}
+		}(i, object)
+	}
+	wg.Wait()
+
+	// Step 2: Rerank to get top 3 most relevant pages
+	topResults := rankingService.RerankResults(query, objectResults, 3)
+
+	// Step 3: Prepare final results and references
+	var references []Reference
+	for _, result := range topResults {
+		references = append(references, Reference{
+			Title:  result.Title,
+			URL:    result.URL,
+			Source: "notion",
+		})
+	}
+
+	return &NotionSearchResults{
+		References:    references,
+		SearchResults: topResults,
+	}, nil
+}
+
+type RESTSearchResults struct {
+	References    []Reference
+	SearchResults []services.SearchResult
+}
+
+func searchRESTConnector(connector *services.RESTConnector, query string) (*RESTSearchResults, error) {
+	name := connector.Definition.Name
+	items, err := connector.Search(query, 10)
+	if err != nil {
+		return nil, err
+	}
+
+	// Step 1: Convert items to search results; their bodies come with the search response
+	itemResults := make([]services.SearchResult, len(items))
+	for i, item := range items {
+		content := fmt.Sprintf("%s: %s", connector.Definition.DisplayName, item.Title)
+		if metadata := item.FormatMetadata(); metadata != "" {
+			content += "\n" + metadata
+		}
+		if item.Body != "" {
+			content += "\n\n" + services.ExtractRelevantMarkdown(item.Body, query, 1500)
+		}
+
+		itemResults[i] = services.SearchResult{
+			Title:   item.Title,
+			Content: content,
+			Source:  name,
+			URL:     item.URL,
+		}
+	}
+
+	// Step 2: Rerank to get top 3 most relevant items
+	topResults := rankingService.RerankResults(query, itemResults, 3)
+
+	// Step 3: Prepare final results and references
+	var references []Reference
+	for _, result := range topResults {
+		references = append(references, Reference{
+			Title:  result.Title,
+			URL:    result.URL,
+			Source: name,
+		})
+	}
+
+	return &RESTSearchResults{
+		References:    references,
+		SearchResults: topResults,
+	}, nil
+}
+
+type MicrosoftSearchResults struct {
+	References    []Reference
+	SearchResults []services.SearchResult
+}
+
+func searchOutlook(accessToken, query string) (*MicrosoftSearchResults, error) {
+	log.Printf("Searching Outlook with query: %s", query)
+	messages, summaries, err := microsoftService.SearchMessages(accessToken, query, 10)
+	if err != nil {
+		return nil, err
+	}
+
+	// Step 1: Fetch each message body in parallel to prepare for reranking
+	messageResults := make([]services.SearchResult, len(messages))
+	var wg sync.WaitGroup
+	for i, message := range messages {
+		wg.Add(1)
+		go func(i int, message services.GraphMessage) {
+			defer wg.Done()
+			body := summaries[i]
+			if full, err := microsoftService.GetMessage(accessToken, message.ID); err != nil {
+				log.Printf("Failed to get Outlook message %s: %v", message.ID, err)
+			} else {
+				message = *full
+				body = full.Body.Content
+			}
+
+			// Use intelligent chunking to extract relevant content
+			relevantContent := services.ExtractRelevantSections(body, query, 1500)
+
+			messageResults[i] = services.SearchResult{
+				Title:   message.Subject,
+				Content: fmt.Sprintf("Email from %s <%s>\nSubject: %s\nDate: %s\n\n%s", message.From.EmailAddress.Name, message.From.EmailAddress.Address, message.Subject, message.ReceivedDateTime, relevantContent),
+				Source:  "outlook",
+				URL:     message.WebLink,
+			}
+		}(i, message)
+	}
+	wg.Wait()
+
+	return rerankMicrosoftResults(query, "outlook", messageResults), nil
+}
+
+func searchTeams(accessToken, query string) (*MicrosoftSearchResults, error) {
+	log.Printf("Searching Teams with query: %s", query)
+	messages, summaries, err := microsoftService.SearchChatMessages(accessToken, query, 10)
+	if err != nil {
+		return nil, err
+	}
+
+	// Step 1: Fetch each message in parallel to prepare for reranking
+	messageResults := make([]services.SearchResult, len(messages))
+	var wg sync.WaitGroup
+	for i, message := range messages {
+		wg.Add(1)
+		go func(i int, message services.GraphChatMessage) {
+			defer wg.Done()
+			text := summaries[i]
+			if full, err := microsoftService.GetChatMessage(accessToken, message); err != nil {
+				log.Printf("Failed to get Teams message %s: %v", message.ID, err)
+			} else {
+				message = *full
+				text = services.ExtractPlainText(full.Body.Content)
+			}
+
+			location := "chat"
+			if message.ChannelIdentity != nil && message.ChannelIdentity.ChannelID != "" {
+				location = "channel"
+			}
+
+			messageResults[i] = services.SearchResult{
+				Title:   fmt.Sprintf("Teams %s message from %s", location, microsoftService.ChatMessageSender(message)),
+				Content: fmt.Sprintf("Teams %s message from %s on %s:\n%s", location, microsoftService.ChatMessageSender(message), message.CreatedDateTime, services.TruncateText(text, 1500)),
+				Source:  "teams",
+				URL:     message.WebURL,
+			}
+		}(i, message)
+	}
+	wg.Wait()
+
+	return rerankMicrosoftResults(query, "teams", messageResults), nil
+}
+
+func searchSharePoint(accessToken, query string) (*MicrosoftSearchResults, error) {
+	log.Printf("Searching SharePoint and OneDrive with query: %s", query)
+	items, summaries, err := microsoftService.SearchDriveItems(accessToken, query, 10)
+	if err != nil {
+		return nil, err
+	}
+
+	// Step 1: Download and extract every file in parallel to prepare for reranking
+	itemResults := make([]services.SearchResult, len(items))
+	var wg sync.WaitGroup
+	for i, item := range items {
+		wg.Add(1)
+		go func(i int, item services.GraphDriveItem) {
+			defer wg.Done()
+			text, err := microsoftService.GetDriveItemText(accessToken, item)
+			if err != nil {
+				log.Printf("Failed to get SharePoint file %s: %v", item.ID, err)
+				text = summaries[i] // Fall back to the search snippet
+			}
+
+			// Use intelligent chunking to extract relevant content
+			relevantContent := services.ExtractRelevantSections(text, query, 1500)
+
+			itemResults[i] = services.SearchResult{
+				Title:   item.Name,
+				Content: microsoftService.FormatDriveItemMetadata(item) + "\n\n" + relevantContent,
+				Source:  "sharepoint",
+				URL:     item.WebURL,
+			}
+		}(i, item)
+	}
+	wg.Wait()
+
+	return rerankMicrosoftResults(query, "sharepoint", itemResults), nil
+}
+
+// rerankMicrosoftResults runs the shared Step 2 and Step 3 of the Microsoft 365 searches
+func rerankMicrosoftResults(query, source string, results []services.SearchResult) *MicrosoftSearchResults {
+	// Step 2: Rerank to get top 3 most relevant results
+	topResults := rankingService.RerankResults(query, results, 3)
+
+	// Step 3: Prepare final results and references
+	references := []Reference{}
+	for _, result := range topResults {
+		references = append(references, Reference{
+			Title:  result.Title,
+			URL:    result.URL,
+			Source: source,
+		})
+	}
+
+	return &MicrosoftSearchResults{
+		References:    references,
+		SearchResults: topResults,
+	}
+}
+
+type IndexSearchResults struct {
+	References    []Reference
+	SearchResults []services.SearchResult
+}
+
+// searchIndex searches the documents that connectors or imports stored in the
+// local index under the given sources, among those reader may read
+func searchIndex(reader services.Reader, query string, sources ...string) *IndexSearchResults {
+	// Step 1: Score the sources' documents with BM25
+	candidates := localIndex.Search(reader, query, 10, func(doc *services.IndexedDocument) bool {
+		for _, source := range sources {
+			if doc.Source == source {
+				return true
+			}
+		}
+		return false
+	})
+
+	// Step 2: Rerank to get top 3 most relevant documents
+	topResults := rankingService.RerankResults(query, candidates, 3)
+
+	// Step 3: Prepare final results and references
+	references := []Reference{}
+	for _, result := range topResults {
+		references = append(references, Reference{
+			Title:  result.Title,
+			URL:    result.URL,
+			Source: result.Source,
+		})
+	}
+
+	return &IndexSearchResults{
+		References:    references,
+		SearchResults: topResults,
+	}
+}
+
 type SlackSearchResults struct {
 	References    []Reference
 	SearchResults []services.SearchResult
@@ -475,4 +1562,204 @@ func searchSlack(accessToken, query string) (*SlackSearchResults, error) {
 		References:    references,
 		SearchResults: enhancedResults,
 	}, nil
-}
\ No newline at end of file
+}
+
+type GitHubSearchResults struct {
+	References    []Reference
+	SearchResults []services.SearchResult
+}
+
+// githubTokenFor returns the request's GitHub token, falling back to
+// GITHUB_TOKEN for the default tenant
+func githubTokenFor(r *http.Request, req ChatRequest) string {
+	if req.Sources["github"] == "disabled" {
+		return ""
+	}
+	if req.GitHubToken != "" {
+		return req.GitHubToken
+	}
+	if !sharedSourcesAllowed(r) {
+		return ""
+	}
+	return appConfig.GitHub.Token
+}
+
+func searchGitHub(accessToken, query string) (*GitHubSearchResults, error) {
+	log.Printf("Searching GitHub with query: %s", query)
+
+	// Step 1: Search issues/PRs, code and discussions in parallel
+	var (
+		wg          sync.WaitGroup
+		issues      *services.GitHubIssueSearchResponse
+		code        *services.GitHubCodeSearchResponse
+		discussions []services.GitHubDiscussion
+		issuesErr   error
+	)
+
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		issues, issuesErr = githubService.SearchIssues(accessToken, query, 10)
+	}()
+	go func() {
+		defer wg.Done()
+		var err error
+		if code, err = githubService.SearchCode(accessToken, query, 5); err != nil {
+			log.Printf("GitHub code search error: %v", err)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		var err error
+		if discussions, err = githubService.SearchDiscussions(accessToken, query, 5); err != nil {
+			log.Printf("GitHub discussion search error: %v", err)
+		}
+	}()
+	wg.Wait()
+
+	if issuesErr != nil {
+		return nil, issuesErr
+	}
+
+	// Step 2: Convert hits to SearchResult format
+	var initialResults []services.SearchResult
+	issuesByURL := make(map[string]services.GitHubIssue)
+
+	for _, issue := range issues.Items {
+		issuesByURL[issue.HTMLURL] = issue
+		initialResults = append(initialResults, githubIssueResult(query, issue, ""))
+	}
+
+	if code != nil {
+		for _, item := range code.Items {
+			content := githubCodeContent(accessToken, query, item)
+			initialResults = append(initialResults, services.SearchResult{
+				Title:   fmt.Sprintf("%s/%s", item.Repository.FullName, item.Path),
+				Content: fmt.Sprintf("Repository: %s\nFile: %s\n\n%s", item.Repository.FullName, item.Path, content),
+				Source:  "github",
+				URL:     item.HTMLURL,
+			})
+		}
+	}
+
+	for _, discussion := range discussions {
+		text := discussion.Body
+		if discussion.Answer != nil {
+			text += "\n\nAccepted answer:\n" + discussion.Answer.Body
+		}
+		for _, comment := range discussion.Comments.Nodes {
+			text += fmt.Sprintf("\n\n%s: %s", comment.Author.Login, comment.Body)
+		}
+		initialResults = append(initialResults, services.SearchResult{
+			Title:   discussion.Title,
+			Content: fmt.Sprintf("Repository: %s\nDiscussion\n\n%s", discussion.Repository.NameWithOwner, services.ExtractRelevantMarkdown(text, query, 1500)),
+			Source:  "github",
+			URL:     discussion.URL,
+		})
+	}
+
+	// READMEs of explicitly configured repositories are always candidates
+	for _, repo := range appConfig.GitHub.Repos {
+		readme, err := githubService.GetReadme(accessToken, repo)
+		if err != nil {
+			continue
+		}
+		initialResults = append(initialResults, services.SearchResult{
+			Title:   repo + " README",
+			Content: fmt.Sprintf("Repository: %s\nFile: README\n\n%s", repo, services.ExtractRelevantMarkdown(readme, query, 1500)),
+			Source:  "github",
+			URL:     "https://github.com/" + repo,
+		})
+	}
+
+	if len(initialResults) == 0 {
+		return &GitHubSearchResults{
+			References:    []Reference{},
+			SearchResults: []services.SearchResult{},
+		}, nil
+	}
+
+	// Step 3: Rerank, then pull in comments and review threads for the top issues and PRs
+	topResults := rankingService.RerankResults(query, initialResults, 3)
+	for i, result := range topResults {
+		issue, ok := issuesByURL[result.URL]
+		if !ok {
+			continue
+		}
+
+		var discussion []string
+		if comments, err := githubService.GetIssueComments(accessToken, issue.Repository(), issue.Number); err == nil {
+			for _, comment := range comments {
+				discussion = append(discussion, fmt.Sprintf("%s: %s", comment.User.Login, comment.Body))
+			}
+		}
+		if issue.PullRequest != nil {
+			if comments, err := githubService.GetReviewComments(accessToken, issue.Repository(), issue.Number); err == nil && len(comments) > 0 {
+				discussion = append(discussion, githubService.FormatReviewThreads(comments))
+			}
+		}
+		topResults[i] = githubIssueResult(query, issue, strings.Join(discussion, "\n\n"))
+	}
+
+	// Step 4: Prepare final results and references
+	var references []Reference
+	for _, result := range topResults {
+		references = append(references, Reference{
+			Title:  result.Title,
+			URL:    result.URL,
+			Source: "github",
+		})
+	}
+
+	return &GitHubSearchResults{
+		References:    references,
+		SearchResults: topResults,
+	}, nil
+}
+
+// githubIssueResult formats an issue or pull request, with its discussion when loaded
+func githubIssueResult(query string, issue services.GitHubIssue, discussion string) services.SearchResult {
+	kind := "Issue"
+	if issue.PullRequest != nil {
+		kind = "Pull request"
+	}
+
+	var labels []string
+	for _, label := range issue.Labels {
+		labels = append(labels, label.Name)
+	}
+
+	text := issue.Body
+	if discussion != "" {
+		text += "\n\n" + discussion
+	}
+
+	header := fmt.Sprintf("Repository: %s\n%s #%d (%s) by %s", issue.Repository(), kind, issue.Number, issue.State, issue.User.Login)
+	if len(labels) > 0 {
+		header += "\nLabels: " + strings.Join(labels, ", ")
+	}
+
+	return services.SearchResult{
+		Title:   fmt.Sprintf("%s#%d: %s", issue.Repository(), issue.Number, issue.Title),
+		Content: header + "\n\n" + services.ExtractRelevantMarkdown(text, query, 1500),
+		Source:  "github",
+		URL:     issue.HTMLURL,
+	}
+}
+
+// githubCodeContent returns the relevant part of a matched file. Markdown docs
+// are fetched in full; other files use the search text matches.
+func githubCodeContent(accessToken, query string, item services.GitHubCodeItem) string {
+	lowerPath := strings.ToLower(item.Path)
+	if strings.HasSuffix(lowerPath, ".md") || strings.HasSuffix(lowerPath, ".markdown") {
+		if content, err := githubService.GetFileContent(accessToken, item.Repository.FullName, item.Path); err == nil {
+			return services.ExtractRelevantMarkdown(content, query, 1500)
+		}
+	}
+
+	var fragments []string
+	for _, match := range item.TextMatches {
+		fragments = append(fragments, match.Fragment)
+	}
+	return strings.Join(fragments, "\n...\n")
+}
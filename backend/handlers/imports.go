@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"rag-chatbot/services"
+)
+
+// maxImportUploadBytes limits export uploads; larger parts of the form are spooled to disk
+const maxImportUploadBytes = 1 << 30
+
+var importNamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,100}$`)
+
+type ImportListResponse struct {
+	Imports []services.ImportSummary `json:"imports"`
+}
+
+type ImportDeleteResponse struct {
+	Name    string `json:"name"`
+	Deleted int    `json:"deleted"`
+}
+
+// ImportsHandler uploads (POST), lists (GET) and removes (DELETE) offline
+// imports of the caller's tenant.
+//
+// POST takes a multipart form with "file" (a Slack export ZIP, a Confluence
+// space export ZIP, an .mbox file, an .eml file or a ZIP of them), an optional
+// "name" (defaults to the file name) and an optional "base_url" used to link
+// results back to the original workspace or site. Mail archives need "owner",
+// the mailbox owner's email address. "share=tenant" makes every document
+// readable by the whole tenant instead of following the export's permissions.
+func ImportsHandler(w http.ResponseWriter, r *http.Request) {
+	// Imports are searched by the whole tenant, so only admins manage them
+	if user, ok := currentUser(r); ok && !user.IsAdmin() && r.Method != http.MethodGet {
+		http.Error(w, "Admin role required", http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ImportListResponse{Imports: importService.ListImports(tenantFor(r))})
+	case http.MethodPost:
+		handleImportUpload(w, r)
+	case http.MethodDelete:
+		name := r.URL.Query().Get("name")
+		if name == "" {
+			http.Error(w, "Missing import name", http.StatusBadRequest)
+			return
+		}
+		deleted := importService.DeleteImport(tenantFor(r), name)
+		if err := localIndex.Save(); err != nil {
+			http.Error(w, "Failed to save index: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ImportDeleteResponse{Name: name, Deleted: deleted})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func handleImportUpload(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportUploadBytes)
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		http.Error(w, "Invalid upload: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "Missing file", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	name := r.FormValue("name")
+	if name == "" {
+		name = strings.TrimSuffix(filepath.Base(header.Filename), filepath.Ext(header.Filename))
+	}
+	if !importNamePattern.MatchString(name) {
+		http.Error(w, "Import name may only contain letters, digits, '.', '_' and '-'", http.StatusBadRequest)
+		return
+	}
+
+	share := r.FormValue("share")
+	if share != "" && share != "tenant" {
+		http.Error(w, "share must be empty or \"tenant\"", http.StatusBadRequest)
+		return
+	}
+
+	options := services.ImportOptions{
+		BaseURL: r.FormValue("base_url"),
+		Owner:   r.FormValue("owner"),
+		Shared:  share == "tenant",
+	}
+	result, err := importService.Import(tenantFor(r), name, header.Filename, file, header.Size, options)
+	if err != nil {
+		http.Error(w, "Import failed: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
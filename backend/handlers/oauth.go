@@ -2,7 +2,8 @@ package handlers
 
 import (
 	"encoding/json"
-	"fmt"
+	"html/template"
+	"log"
 	"net/http"
 	"rag-chatbot/config"
 	"rag-chatbot/services"
@@ -12,11 +13,27 @@ var (
 	confluenceService *services.ConfluenceService
 	gmailService      *services.GmailService
 	slackService      *services.SlackService
+	githubService     *services.GitHubService
+	driveService      *services.DriveService
+	notionService     *services.NotionService
+	microsoftService  *services.MicrosoftService
+	tokenVault        *services.TokenVault
 	appConfig         *config.Config
 )
 
 func init() {
 	appConfig = config.Load()
+	if appConfig.FrontendOrigin() == "" {
+		log.Fatalf("FRONTEND_URL must be an absolute URL, got %q", appConfig.FrontendURL)
+	}
+
+	var err error
+	tokenVault, err = services.NewTokenVault(appConfig.Vault.Path, appConfig.Vault.Key)
+	if err != nil {
+		log.Printf("Warning: failed to open token vault, using a temporary one: %v", err)
+		tokenVault, _ = services.NewTokenVault("", "")
+	}
+
 	confluenceService = services.NewConfluenceService(
 		appConfig.Confluence.ClientID,
 		appConfig.Confluence.ClientSecret,
@@ -32,6 +49,28 @@ func init() {
 		appConfig.Slack.ClientSecret,
 		appConfig.Slack.RedirectURL,
 	)
+	driveService = services.NewDriveService(
+		appConfig.Google.ClientID,
+		appConfig.Google.RedirectURL,
+	)
+	notionService = services.NewNotionService(
+		appConfig.Notion.ClientID,
+		appConfig.Notion.ClientSecret,
+		appConfig.Notion.RedirectURL,
+	)
+	microsoftService = services.NewMicrosoftService(
+		appConfig.Microsoft.ClientID,
+		appConfig.Microsoft.ClientSecret,
+		appConfig.Microsoft.RedirectURL,
+		appConfig.Microsoft.Tenant,
+	)
+	githubService = services.NewGitHubService(
+		appConfig.GitHub.ClientID,
+		appConfig.GitHub.ClientSecret,
+		appConfig.GitHub.RedirectURL,
+		appConfig.GitHub.Orgs,
+		appConfig.GitHub.Repos,
+	)
 }
 
 type AuthURLResponse struct {
@@ -55,7 +94,11 @@ func ConfluenceAuthHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	state := "random-state-" + "12345" // TODO: Generate secure random state
+	// Atlassian doesn't support PKCE, so only the state protects this flow
+	state, _, ok := startOAuth(w, r, "confluence")
+	if !ok {
+		return
+	}
 	authURL := confluenceService.GetAuthURL(state)
 
 	response := AuthURLResponse{
@@ -77,8 +120,38 @@ func GmailAuthHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	state := "gmail-state-" + "12345" // TODO: Generate secure random state
-	authURL := gmailService.GetAuthURL(state)
+	state, codeChallenge, ok := startOAuth(w, r, "gmail")
+	if !ok {
+		return
+	}
+	authURL := gmailService.GetAuthURL(state, codeChallenge)
+
+	response := AuthURLResponse{
+		AuthURL: authURL,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// DriveAuthHandler starts an incremental Google authorization that adds Drive
+// read access. It shares the Google callback with Gmail.
+func DriveAuthHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if appConfig.Google.ClientID == "" {
+		http.Error(w, "Google OAuth not configured: GOOGLE_CLIENT_ID not set", http.StatusInternalServerError)
+		return
+	}
+
+	state, codeChallenge, ok := startOAuth(w, r, "drive")
+	if !ok {
+		return
+	}
+	authURL := driveService.GetAuthURL(state, codeChallenge)
 
 	response := AuthURLResponse{
 		AuthURL: authURL,
@@ -94,55 +167,38 @@ func GmailCallbackHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get code and state from query parameters
+	// Get code from query parameters
 	code := r.URL.Query().Get("code")
-	state := r.URL.Query().Get("state")
 	
 	if code == "" {
 		http.Error(w, "Missing authorization code", http.StatusBadRequest)
 		return
 	}
 
-	// TODO: Verify state parameter matches
-	_ = state
+	// Drive and Gmail share this callback; the state tells them apart
+	authorization, ok := verifyOAuthState(w, r, "gmail", "drive")
+	if !ok {
+		return
+	}
 
-	tokenResponse, err := gmailService.ExchangeCodeForToken(code)
+	tokenResponse, err := gmailService.ExchangeCodeForToken(code, authorization.CodeVerifier)
 	if err != nil {
 		http.Error(w, "Failed to exchange code for token: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// Return HTML page that posts the token back to the parent window
-	html := `
-<!DOCTYPE html>
-<html>
-<head>
-    <title>Gmail Authorization Complete</title>
-</head>
-<body>
-    <script>
-        // Send token to parent window and close popup
-        if (window.opener) {
-            window.opener.postMessage({
-                type: 'GMAIL_AUTH_SUCCESS',
-                token: '` + tokenResponse.AccessToken + `',
-                expiresIn: ` + fmt.Sprintf("%d", tokenResponse.ExpiresIn) + `
-            }, '*');
-            
-            setTimeout(() => {
-                window.close();
-            }, 500);
-        } else {
-            document.body.innerHTML = '<h2>Authorization successful! You can close this window.</h2>';
-        }
-    </script>
-    <h2>Connecting to Gmail...</h2>
-    <p>This window should close automatically.</p>
-</body>
-</html>`
+	messageType := "GMAIL_AUTH_SUCCESS"
+	if authorization.Provider == "drive" {
+		messageType = "DRIVE_AUTH_SUCCESS"
+	}
 
-	w.Header().Set("Content-Type", "text/html")
-	w.Write([]byte(html))
+	// Keep the token server-side; the browser only gets the session cookie
+	if !storeToken(w, r, authorization.Provider, services.NewStoredToken(tokenResponse.AccessToken, tokenResponse.RefreshToken, tokenResponse.TokenType, tokenResponse.ExpiresIn)) {
+		return
+	}
+
+	// Tell the parent window the account is connected
+	writeAuthComplete(w, "Google", messageType)
 }
 
 func SlackAuthHandler(w http.ResponseWriter, r *http.Request) {
@@ -156,7 +212,11 @@ func SlackAuthHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	state := "slack-state-" + "12345" // TODO: Generate secure random state
+	// Slack doesn't support PKCE, so only the state protects this flow
+	state, _, ok := startOAuth(w, r, "slack")
+	if !ok {
+		return
+	}
 	authURL := slackService.GetAuthURL(state)
 
 	response := AuthURLResponse{
@@ -173,17 +233,17 @@ func SlackCallbackHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get code and state from query parameters
+	// Get code from query parameters
 	code := r.URL.Query().Get("code")
-	state := r.URL.Query().Get("state")
 	
 	if code == "" {
 		http.Error(w, "Missing authorization code", http.StatusBadRequest)
 		return
 	}
 
-	// TODO: Verify state parameter matches
-	_ = state
+	if _, ok := verifyOAuthState(w, r, "slack"); !ok {
+		return
+	}
 
 	tokenResponse, err := slackService.ExchangeCodeForToken(code)
 	if err != nil {
@@ -204,36 +264,13 @@ func SlackCallbackHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Return HTML page that posts the token back to the parent window
-	html := `
-<!DOCTYPE html>
-<html>
-<head>
-    <title>Slack Authorization Complete</title>
-</head>
-<body>
-    <script>
-        // Send token to parent window and close popup
-        if (window.opener) {
-            window.opener.postMessage({
-                type: 'SLACK_AUTH_SUCCESS',
-                token: '` + userAccessToken + `'
-            }, '*');
-            
-            setTimeout(() => {
-                window.close();
-            }, 500);
-        } else {
-            document.body.innerHTML = '<h2>Authorization successful! You can close this window.</h2>';
-        }
-    </script>
-    <h2>Connecting to Slack...</h2>
-    <p>This window should close automatically.</p>
-</body>
-</html>`
+	// Keep the token server-side; the browser only gets the session cookie
+	if !storeToken(w, r, "slack", services.NewStoredToken(userAccessToken, "", tokenResponse.AuthedUser.TokenType, 0)) {
+		return
+	}
 
-	w.Header().Set("Content-Type", "text/html")
-	w.Write([]byte(html))
+	// Tell the parent window the account is connected
+	writeAuthComplete(w, "Slack", "SLACK_AUTH_SUCCESS")
 }
 
 func ConfluenceCallbackHandler(w http.ResponseWriter, r *http.Request) {
@@ -242,17 +279,17 @@ func ConfluenceCallbackHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get code and state from query parameters
+	// Get code from query parameters
 	code := r.URL.Query().Get("code")
-	state := r.URL.Query().Get("state")
 	
 	if code == "" {
 		http.Error(w, "Missing authorization code", http.StatusBadRequest)
 		return
 	}
 
-	// TODO: Verify state parameter matches
-	_ = state // Ignore for now, will implement state verification later
+	if _, ok := verifyOAuthState(w, r, "confluence"); !ok {
+		return
+	}
 
 	tokenResponse, err := confluenceService.ExchangeCodeForToken(code)
 	if err != nil {
@@ -260,22 +297,206 @@ func ConfluenceCallbackHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Return HTML page that posts the token back to the parent window
-	html := `
-<!DOCTYPE html>
+	// Keep the token server-side; the browser only gets the session cookie
+	if !storeToken(w, r, "confluence", services.NewStoredToken(tokenResponse.AccessToken, tokenResponse.RefreshToken, tokenResponse.TokenType, tokenResponse.ExpiresIn)) {
+		return
+	}
+
+	// Tell the parent window the account is connected
+	writeAuthComplete(w, "Confluence", "CONFLUENCE_AUTH_SUCCESS")
+}
+
+func GitHubAuthHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if appConfig.GitHub.ClientID == "" {
+		http.Error(w, "GitHub OAuth not configured: GITHUB_CLIENT_ID not set", http.StatusInternalServerError)
+		return
+	}
+
+	state, codeChallenge, ok := startOAuth(w, r, "github")
+	if !ok {
+		return
+	}
+	authURL := githubService.GetAuthURL(state, codeChallenge)
+
+	response := AuthURLResponse{
+		AuthURL: authURL,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func GitHubCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Get code from query parameters
+	code := r.URL.Query().Get("code")
+
+	if code == "" {
+		http.Error(w, "Missing authorization code", http.StatusBadRequest)
+		return
+	}
+
+	authorization, ok := verifyOAuthState(w, r, "github")
+	if !ok {
+		return
+	}
+
+	tokenResponse, err := githubService.ExchangeCodeForToken(code, authorization.CodeVerifier)
+	if err != nil {
+		http.Error(w, "Failed to exchange code for token: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Keep the token server-side; the browser only gets the session cookie
+	if !storeToken(w, r, "github", services.NewStoredToken(tokenResponse.AccessToken, "", tokenResponse.TokenType, 0)) {
+		return
+	}
+
+	// Tell the parent window the account is connected
+	writeAuthComplete(w, "GitHub", "GITHUB_AUTH_SUCCESS")
+}
+
+func NotionAuthHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if appConfig.Notion.ClientID == "" {
+		http.Error(w, "Notion OAuth not configured: NOTION_CLIENT_ID not set", http.StatusInternalServerError)
+		return
+	}
+
+	// Notion doesn't support PKCE, so only the state protects this flow
+	state, _, ok := startOAuth(w, r, "notion")
+	if !ok {
+		return
+	}
+	authURL := notionService.GetAuthURL(state)
+
+	response := AuthURLResponse{
+		AuthURL: authURL,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func NotionCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Get code from query parameters
+	code := r.URL.Query().Get("code")
+
+	if code == "" {
+		http.Error(w, "Missing authorization code", http.StatusBadRequest)
+		return
+	}
+
+	if _, ok := verifyOAuthState(w, r, "notion"); !ok {
+		return
+	}
+
+	tokenResponse, err := notionService.ExchangeCodeForToken(code)
+	if err != nil {
+		http.Error(w, "Failed to exchange code for token: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Keep the token server-side; the browser only gets the session cookie
+	if !storeToken(w, r, "notion", services.NewStoredToken(tokenResponse.AccessToken, "", tokenResponse.TokenType, 0)) {
+		return
+	}
+
+	// Tell the parent window the account is connected
+	writeAuthComplete(w, "Notion", "NOTION_AUTH_SUCCESS")
+}
+
+func MicrosoftAuthHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if appConfig.Microsoft.ClientID == "" {
+		http.Error(w, "Microsoft OAuth not configured: MICROSOFT_CLIENT_ID not set", http.StatusInternalServerError)
+		return
+	}
+
+	state, codeChallenge, ok := startOAuth(w, r, "microsoft")
+	if !ok {
+		return
+	}
+	authURL := microsoftService.GetAuthURL(state, codeChallenge)
+
+	response := AuthURLResponse{
+		AuthURL: authURL,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func MicrosoftCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Get code from query parameters
+	code := r.URL.Query().Get("code")
+
+	if code == "" {
+		http.Error(w, "Missing authorization code", http.StatusBadRequest)
+		return
+	}
+
+	authorization, ok := verifyOAuthState(w, r, "microsoft")
+	if !ok {
+		return
+	}
+
+	tokenResponse, err := microsoftService.ExchangeCodeForToken(code, authorization.CodeVerifier)
+	if err != nil {
+		http.Error(w, "Failed to exchange code for token: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Keep the token server-side; the browser only gets the session cookie
+	if !storeToken(w, r, "microsoft", services.NewStoredToken(tokenResponse.AccessToken, tokenResponse.RefreshToken, tokenResponse.TokenType, tokenResponse.ExpiresIn)) {
+		return
+	}
+
+	// Tell the parent window the account is connected
+	writeAuthComplete(w, "Microsoft 365", "MICROSOFT_AUTH_SUCCESS")
+}
+// authCompletePage tells the window that opened the OAuth popup that an
+// account is connected. Its message goes only to the frontend's origin, and
+// its Content-Security-Policy lets nothing run but its own script.
+var authCompletePage = template.Must(template.New("auth-complete").Parse(`<!DOCTYPE html>
 <html>
 <head>
-    <title>Authorization Complete</title>
+    <title>{{.Provider}} Authorization Complete</title>
 </head>
 <body>
-    <script>
-        // Send token to parent window and close popup
+    <script nonce="{{.Nonce}}">
+        // Tell the parent window and close popup
         if (window.opener) {
             window.opener.postMessage({
-                type: 'CONFLUENCE_AUTH_SUCCESS',
-                token: '` + tokenResponse.AccessToken + `',
-                expiresIn: ` + fmt.Sprintf("%d", tokenResponse.ExpiresIn) + `
-            }, '*');
+                type: {{.MessageType}}
+            }, {{.TargetOrigin}});
             
             setTimeout(() => {
                 window.close();
@@ -284,11 +505,22 @@ func ConfluenceCallbackHandler(w http.ResponseWriter, r *http.Request) {
             document.body.innerHTML = '<h2>Authorization successful! You can close this window.</h2>';
         }
     </script>
-    <h2>Connecting to Confluence...</h2>
+    <h2>Connecting to {{.Provider}}...</h2>
     <p>This window should close automatically.</p>
 </body>
-</html>`
+</html>`))
+
+func writeAuthComplete(w http.ResponseWriter, provider, messageType string) {
+	nonce, err := randomToken(16)
+	if err != nil {
+		http.Error(w, "Failed to render page", http.StatusInternalServerError)
+		return
+	}
 
-	w.Header().Set("Content-Type", "text/html")
-	w.Write([]byte(html))
-}
\ No newline at end of file
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; script-src 'nonce-"+nonce+"'; base-uri 'none'; form-action 'none'; frame-ancestors 'none'")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	authCompletePage.Execute(w, struct {
+		Provider, MessageType, TargetOrigin, Nonce string
+	}{provider, messageType, appConfig.FrontendOrigin(), nonce})
+}
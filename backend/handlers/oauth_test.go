@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var scriptNonce = regexp.MustCompile(`<script nonce="([^"]+)">`)
+
+// The callback page posts only to the frontend's origin and runs only its own script
+func TestAuthCompletePage(t *testing.T) {
+	withConfig(t)
+	appConfig.FrontendURL = "https://chat.example.com/app/"
+
+	var nonces []string
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		writeAuthComplete(w, "Slack", "SLACK_AUTH_SUCCESS")
+		page := w.Body.String()
+
+		match := scriptNonce.FindStringSubmatch(page)
+		if match == nil {
+			t.Fatalf("page has no script nonce:\n%s", page)
+		}
+		nonce := match[1]
+		nonces = append(nonces, nonce)
+
+		csp := w.Header().Get("Content-Security-Policy")
+		if !strings.Contains(csp, "script-src 'nonce-"+nonce+"'") || !strings.Contains(csp, "default-src 'none'") {
+			t.Errorf("Content-Security-Policy = %q, want only the page's own script allowed", csp)
+		}
+		if w.Header().Get("Referrer-Policy") != "no-referrer" {
+			t.Errorf("Referrer-Policy = %q", w.Header().Get("Referrer-Policy"))
+		}
+
+		// The target is the origin alone, without the frontend's path
+		if !strings.Contains(page, `}, "https://chat.example.com");`) {
+			t.Errorf("postMessage doesn't target the frontend origin:\n%s", page)
+		}
+		if strings.Contains(page, `'*'`) || strings.Contains(page, `"*"`) {
+			t.Errorf("page posts to any origin:\n%s", page)
+		}
+		if !strings.Contains(page, `type: "SLACK_AUTH_SUCCESS"`) {
+			t.Errorf("page doesn't send the message type:\n%s", page)
+		}
+	}
+
+	if nonces[0] == nonces[1] {
+		t.Errorf("nonce %q was reused", nonces[0])
+	}
+}
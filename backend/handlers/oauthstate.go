@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// sessionCookieName identifies the browser across the OAuth popup round trip
+const sessionCookieName = "rag_session"
+
+// oauthStateTTL is how long a user has to finish an authorization
+const oauthStateTTL = 10 * time.Minute
+
+var (
+	errOAuthStateUnknown  = errors.New("unknown or already used state")
+	errOAuthStateExpired  = errors.New("state expired")
+	errOAuthStateMismatch = errors.New("state was issued to a different session or provider")
+)
+
+// oauthState is an authorization in progress
+type oauthState struct {
+	Provider     string
+	Session      string
+	CodeVerifier string // PKCE verifier; empty for providers without PKCE
+	Expires      time.Time
+}
+
+// OAuthStateStore issues random, single-use, expiring OAuth state values
+// bound to the browser session that started the authorization
+type OAuthStateStore struct {
+	TTL time.Duration
+	Now func() time.Time // Overridable clock
+
+	mu     sync.Mutex
+	states map[string]oauthState
+}
+
+func NewOAuthStateStore(ttl time.Duration) *OAuthStateStore {
+	return &OAuthStateStore{
+		TTL:    ttl,
+		Now:    time.Now,
+		states: make(map[string]oauthState),
+	}
+}
+
+var oauthStates = NewOAuthStateStore(oauthStateTTL)
+
+// Issue creates a state for provider and session, along with a PKCE verifier
+func (s *OAuthStateStore) Issue(provider, session string) (state, codeVerifier string, err error) {
+	state, err = randomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+	codeVerifier, err = randomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Drop abandoned authorizations
+	now := s.Now()
+	for key, entry := range s.states {
+		if now.After(entry.Expires) {
+			delete(s.states, key)
+		}
+	}
+
+	s.states[state] = oauthState{
+		Provider:     provider,
+		Session:      session,
+		CodeVerifier: codeVerifier,
+		Expires:      now.Add(s.TTL),
+	}
+	return state, codeVerifier, nil
+}
+
+// Consume checks a state returned to a callback and removes it, so it can
+// only be used once even when the check fails. Any of providers may match,
+// for callbacks shared by several authorizations.
+func (s *OAuthStateStore) Consume(state, session string, providers ...string) (oauthState, error) {
+	s.mu.Lock()
+	entry, ok := s.states[state]
+	delete(s.states, state)
+	s.mu.Unlock()
+
+	if !ok || state == "" {
+		return oauthState{}, errOAuthStateUnknown
+	}
+	if s.Now().After(entry.Expires) {
+		return oauthState{}, errOAuthStateExpired
+	}
+	if session == "" || subtle.ConstantTimeCompare([]byte(entry.Session), []byte(session)) != 1 {
+		return oauthState{}, errOAuthStateMismatch
+	}
+	for _, provider := range providers {
+		if entry.Provider == provider {
+			return entry, nil
+		}
+	}
+	return oauthState{}, errOAuthStateMismatch
+}
+
+// browserSession returns the session ID from the request's cookie, setting a
+// new cookie when there is none
+func browserSession(w http.ResponseWriter, r *http.Request) (string, error) {
+	if cookie, err := r.Cookie(sessionCookieName); err == nil && cookie.Value != "" {
+		return cookie.Value, nil
+	}
+	return newBrowserSession(w, r)
+}
+
+// newBrowserSession sets a fresh session cookie, replacing any existing one
+func newBrowserSession(w http.ResponseWriter, r *http.Request) (string, error) {
+	session, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    session,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+		SameSite: http.SameSiteLaxMode, // Sent on the provider's top-level redirect back to us
+	})
+	return session, nil
+}
+
+// startOAuth issues a state for the browser's session and returns it with the
+// S256 PKCE code challenge
+func startOAuth(w http.ResponseWriter, r *http.Request, provider string) (state, codeChallenge string, ok bool) {
+	session, err := browserSession(w, r)
+	if err != nil {
+		http.Error(w, "Failed to start session", http.StatusInternalServerError)
+		return "", "", false
+	}
+
+	state, codeVerifier, err := oauthStates.Issue(provider, session)
+	if err != nil {
+		http.Error(w, "Failed to generate OAuth state", http.StatusInternalServerError)
+		return "", "", false
+	}
+	return state, pkceChallenge(codeVerifier), true
+}
+
+// verifyOAuthState checks the callback's state against the browser session,
+// writing a 400 response when it doesn't match
+func verifyOAuthState(w http.ResponseWriter, r *http.Request, providers ...string) (oauthState, bool) {
+	session := ""
+	if cookie, err := r.Cookie(sessionCookieName); err == nil {
+		session = cookie.Value
+	}
+
+	entry, err := oauthStates.Consume(r.URL.Query().Get("state"), session, providers...)
+	if err != nil {
+		http.Error(w, "Invalid OAuth state: "+err.Error(), http.StatusBadRequest)
+		return oauthState{}, false
+	}
+	return entry, true
+}
+
+// pkceChallenge derives the S256 code challenge from a verifier (RFC 7636)
+func pkceChallenge(codeVerifier string) string {
+	sum := sha256.Sum256([]byte(codeVerifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// randomToken returns n random bytes, base64url-encoded
+func randomToken(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOAuthStateConsume(t *testing.T) {
+	tests := []struct {
+		name      string
+		session   string        // Session presented at the callback
+		providers []string      // Providers the callback accepts
+		elapsed   time.Duration // Time between issuing and consuming
+		replay    bool          // Consume the state once before the checked call
+		wantErr   error
+	}{
+		{name: "valid", session: "session-a", providers: []string{"github"}},
+		{name: "valid shared callback", session: "session-a", providers: []string{"gmail", "github"}},
+		{name: "replayed", session: "session-a", providers: []string{"github"}, replay: true, wantErr: errOAuthStateUnknown},
+		{name: "expired", session: "session-a", providers: []string{"github"}, elapsed: oauthStateTTL + time.Second, wantErr: errOAuthStateExpired},
+		{name: "other session", session: "session-b", providers: []string{"github"}, wantErr: errOAuthStateMismatch},
+		{name: "no session", session: "", providers: []string{"github"}, wantErr: errOAuthStateMismatch},
+		{name: "other provider", session: "session-a", providers: []string{"slack"}, wantErr: errOAuthStateMismatch},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			now := time.Date(2024, 3, 4, 9, 0, 0, 0, time.UTC)
+			store := NewOAuthStateStore(oauthStateTTL)
+			store.Now = func() time.Time { return now }
+
+			state, verifier, err := store.Issue("github", "session-a")
+			if err != nil {
+				t.Fatalf("Issue: %v", err)
+			}
+			if test.replay {
+				if _, err := store.Consume(state, "session-a", "github"); err != nil {
+					t.Fatalf("first Consume: %v", err)
+				}
+			}
+			now = now.Add(test.elapsed)
+
+			entry, err := store.Consume(state, test.session, test.providers...)
+			if err != test.wantErr {
+				t.Fatalf("Consume error = %v, want %v", err, test.wantErr)
+			}
+			if test.wantErr == nil && (entry.Provider != "github" || entry.CodeVerifier != verifier) {
+				t.Errorf("Consume = %+v, want the github state with its verifier", entry)
+			}
+
+			// A state is used up by any attempt, so it can't be tried again
+			if _, err := store.Consume(state, "session-a", "github"); err != errOAuthStateUnknown {
+				t.Errorf("second Consume error = %v, want %v", err, errOAuthStateUnknown)
+			}
+		})
+	}
+}
+
+func TestOAuthStateUnknown(t *testing.T) {
+	store := NewOAuthStateStore(oauthStateTTL)
+	for _, state := range []string{"", "made-up"} {
+		if _, err := store.Consume(state, "session-a", "github"); err != errOAuthStateUnknown {
+			t.Errorf("Consume(%q) error = %v, want %v", state, err, errOAuthStateUnknown)
+		}
+	}
+}
+
+func TestPKCEChallenge(t *testing.T) {
+	// Example from RFC 7636, appendix B
+	if got := pkceChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"); got != "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM" {
+		t.Errorf("pkceChallenge = %q", got)
+	}
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rateLimitedPaths are the endpoints that call OpenAI or the connected
+// services, and sign-in, where passwords could otherwise be guessed at full
+// speed. The value says whether they use the LLM token quota.
+var rateLimitedPaths = map[string]bool{
+	"/api/chat":        true,
+	"/api/chat/stream": true,
+	"/api/search":      false,
+	loginPath:          false,
+}
+
+const loginPath = "/api/auth/login"
+
+// RateLimit answers 429 Too Many Requests when a client makes chat, search or
+// sign-in requests faster than RATE_LIMIT_PER_MINUTE, or asks questions after using
+// up its daily LLM_DAILY_TOKEN_QUOTA. It sits behind RequireAuth, which tells
+// it who the client is.
+func RateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		usesLLM, limited := rateLimitedPaths[r.URL.Path]
+		if !limited || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := rateLimitKey(r)
+		if allowed, retryAfter := rateLimiter.Allow(key); !allowed {
+			tooManyRequests(w, retryAfter, "Too many requests, please wait a moment and try again")
+			return
+		}
+		if usesLLM {
+			if exceeded, retryAfter := rateLimiter.QuotaExceeded(key); exceeded {
+				tooManyRequests(w, retryAfter, "Daily AI usage quota reached, it resets at midnight UTC")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rateLimitKey identifies who a request counts against: the API key it was
+// made with, the signed-in user or, for anonymous requests, the client's IP.
+// Sign-in attempts count against the IP in a bucket of their own, so failed
+// logins and chat requests don't use up each other's allowance.
+func rateLimitKey(r *http.Request) string {
+	if r.URL.Path == loginPath {
+		return "login:" + clientIP(r)
+	}
+	if key, ok := currentAPIKey(r); ok {
+		return "key:" + key.ID
+	}
+	if user, ok := currentUser(r); ok {
+		return "user:" + user.ID
+	}
+	return "ip:" + clientIP(r)
+}
+
+// clientIP returns the address the request came from. Behind a reverse proxy
+// (RATE_LIMIT_TRUST_PROXY=true) that is the last X-Forwarded-For address,
+// the one our proxy appended: every request would otherwise come from the
+// proxy, and the earlier addresses are whatever the client chose to send.
+func clientIP(r *http.Request) string {
+	if trustProxy {
+		forwarded := r.Header.Values("X-Forwarded-For")
+		if len(forwarded) > 0 {
+			addresses := strings.Split(forwarded[len(forwarded)-1], ",")
+			if last := strings.TrimSpace(addresses[len(addresses)-1]); net.ParseIP(last) != nil {
+				return last
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func tooManyRequests(w http.ResponseWriter, retryAfter time.Duration, message string) {
+	w.Header().Set("Retry-After", retryAfterSeconds(retryAfter))
+	http.Error(w, message, http.StatusTooManyRequests)
+}
+
+// retryAfterSeconds rounds up, so clients that wait that long get through
+func retryAfterSeconds(retryAfter time.Duration) string {
+	return strconv.Itoa(int(math.Max(1, math.Ceil(retryAfter.Seconds()))))
+}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"rag-chatbot/services"
+)
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name       string
+		trustProxy bool
+		forwarded  []string
+		want       string
+	}{
+		{"direct", false, nil, "10.0.0.7"},
+		{"forwarded header ignored without a proxy", false, []string{"203.0.113.9"}, "10.0.0.7"},
+		{"behind a proxy", true, []string{"203.0.113.9"}, "203.0.113.9"},
+		{"spoofed addresses before the proxy's", true, []string{"1.2.3.4, 5.6.7.8, 203.0.113.9"}, "203.0.113.9"},
+		{"several headers", true, []string{"1.2.3.4", "203.0.113.9"}, "203.0.113.9"},
+		{"garbage", true, []string{"1.2.3.4, not-an-ip"}, "10.0.0.7"},
+		{"no header", true, nil, "10.0.0.7"},
+	}
+
+	saved := trustProxy
+	t.Cleanup(func() { trustProxy = saved })
+	for _, test := range tests {
+		trustProxy = test.trustProxy
+		r := httptest.NewRequest(http.MethodPost, "/api/chat", nil)
+		r.RemoteAddr = "10.0.0.7:51234"
+		for _, value := range test.forwarded {
+			r.Header.Add("X-Forwarded-For", value)
+		}
+		if got := clientIP(r); got != test.want {
+			t.Errorf("%s: clientIP = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestRateLimitLogin(t *testing.T) {
+	saved := rateLimiter
+	t.Cleanup(func() { rateLimiter = saved })
+	rateLimiter = services.NewRateLimiter(services.NewMemoryRateLimitStore(), services.RateLimit{Rate: 0.01, Burst: 2}, 0)
+
+	handler := RateLimit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized) // A wrong password
+	}))
+	request := func(path, remoteAddr string) int {
+		r := httptest.NewRequest(http.MethodPost, path, nil)
+		r.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	for i := 0; i < 2; i++ {
+		if code := request(loginPath, "192.0.2.1:1000"); code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: status %d", i+1, code)
+		}
+	}
+	if code := request(loginPath, "192.0.2.1:1001"); code != http.StatusTooManyRequests {
+		t.Errorf("third attempt: status %d, want %d", code, http.StatusTooManyRequests)
+	}
+	if code := request(loginPath, "192.0.2.2:1000"); code != http.StatusUnauthorized {
+		t.Errorf("another address: status %d", code)
+	}
+	// Sign-in attempts don't use up the address's chat requests
+	if code := request("/api/search", "192.0.2.1:1000"); code != http.StatusUnauthorized {
+		t.Errorf("search after the failed logins: status %d", code)
+	}
+}
@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"rag-chatbot/services"
+)
+
+// refreshLeeway refreshes tokens this long before they expire, so a search
+// doesn't start with a token that dies halfway through
+const refreshLeeway = 2 * time.Minute
+
+var errNoRefreshToken = errors.New("no refresh token stored")
+
+// tokenRefreshers trade a refresh token for a new token, per provider. Drive
+// and Gmail are both Google tokens from the same OAuth client.
+var tokenRefreshers = map[string]func(refreshToken string) (services.StoredToken, error){
+	"gmail":      refreshGoogleToken,
+	"drive":      refreshGoogleToken,
+	"confluence": refreshAtlassianToken,
+}
+
+func refreshGoogleToken(refreshToken string) (services.StoredToken, error) {
+	response, err := gmailService.RefreshAccessToken(refreshToken)
+	if err != nil {
+		return services.StoredToken{}, err
+	}
+	return services.NewStoredToken(response.AccessToken, response.RefreshToken, response.TokenType, response.ExpiresIn), nil
+}
+
+func refreshAtlassianToken(refreshToken string) (services.StoredToken, error) {
+	response, err := confluenceService.RefreshAccessToken(refreshToken)
+	if err != nil {
+		return services.StoredToken{}, err
+	}
+	return services.NewStoredToken(response.AccessToken, response.RefreshToken, response.TokenType, response.ExpiresIn), nil
+}
+
+// refreshCall is a refresh in progress that other requests can wait on
+type refreshCall struct {
+	done  chan struct{}
+	token services.StoredToken
+	err   error
+}
+
+// TokenRefresher keeps vault tokens fresh. Concurrent refreshes of the same
+// credential share one request, which matters for Atlassian: its rotating
+// refresh tokens are invalidated by the first use.
+type TokenRefresher struct {
+	mu       sync.Mutex
+	inflight map[string]*refreshCall
+}
+
+var tokenRefresher = &TokenRefresher{inflight: make(map[string]*refreshCall)}
+
+// AccessToken returns the session's token for provider, refreshing it first
+// when it is about to expire
+func (tr *TokenRefresher) AccessToken(session, provider string) (string, bool) {
+	token, ok := tokenVault.Get(session, provider)
+	if !ok {
+		return "", false
+	}
+
+	if !token.Expiry.IsZero() && time.Until(token.Expiry) < refreshLeeway {
+		refreshed, err := tr.Refresh(session, provider, token.AccessToken)
+		if err != nil {
+			log.Printf("Failed to refresh %s token: %v", provider, err)
+			return token.AccessToken, true // It may still work; a 401 will tell
+		}
+		return refreshed, true
+	}
+	return token.AccessToken, true
+}
+
+// Refresh replaces a rejected or expiring access token. When another request
+// already replaced it, the newer token is returned without refreshing again.
+func (tr *TokenRefresher) Refresh(session, provider, staleAccessToken string) (string, error) {
+	refresh, ok := tokenRefreshers[provider]
+	if !ok || session == "" {
+		return "", errNoRefreshToken
+	}
+
+	key := session + "/" + provider
+	tr.mu.Lock()
+	if call, ok := tr.inflight[key]; ok {
+		tr.mu.Unlock()
+		<-call.done
+		return call.token.AccessToken, call.err
+	}
+
+	token, ok := tokenVault.Get(session, provider)
+	if ok && token.AccessToken != staleAccessToken {
+		tr.mu.Unlock()
+		return token.AccessToken, nil // Refreshed since the caller read it
+	}
+	if !ok || token.RefreshToken == "" {
+		tr.mu.Unlock()
+		return "", errNoRefreshToken
+	}
+
+	call := &refreshCall{done: make(chan struct{})}
+	tr.inflight[key] = call
+	tr.mu.Unlock()
+
+	call.token, call.err = tr.refresh(session, provider, token, refresh)
+
+	tr.mu.Lock()
+	delete(tr.inflight, key)
+	tr.mu.Unlock()
+	close(call.done)
+
+	return call.token.AccessToken, call.err
+}
+
+func (tr *TokenRefresher) refresh(session, provider string, token services.StoredToken, refresh func(string) (services.StoredToken, error)) (services.StoredToken, error) {
+	refreshed, err := refresh(token.RefreshToken)
+	if err != nil {
+		// A revoked or already rotated refresh token won't work again, so
+		// disconnect the account and let the user reconnect
+		var statusErr *services.StatusError
+		if errors.As(err, &statusErr) && (statusErr.StatusCode == http.StatusBadRequest || statusErr.StatusCode == http.StatusUnauthorized) {
+			log.Printf("%s refresh token rejected, disconnecting", provider)
+			tokenVault.Delete(session, provider)
+		}
+		return services.StoredToken{}, err
+	}
+
+	// Providers that don't rotate refresh tokens leave them out of the response
+	if refreshed.RefreshToken == "" {
+		refreshed.RefreshToken = token.RefreshToken
+	}
+	if err := tokenVault.Put(session, provider, refreshed); err != nil {
+		return services.StoredToken{}, err
+	}
+	log.Printf("Refreshed %s token", provider)
+	return refreshed, nil
+}
+
+// withTokenRefresh runs a search and, if the provider rejects the token,
+// refreshes it and tries once more
+func withTokenRefresh[T any](r *http.Request, provider string, accessToken *string, search func(accessToken string) (T, error)) (T, error) {
+	result, err := search(*accessToken)
+	if err == nil || !services.IsUnauthorized(err) {
+		return result, err
+	}
+
+	refreshed, refreshErr := tokenRefresher.Refresh(credentialOwner(r), provider, *accessToken)
+	if refreshErr != nil {
+		if refreshErr != errNoRefreshToken {
+			log.Printf("Failed to refresh %s token: %v", provider, refreshErr)
+		}
+		return result, err
+	}
+
+	*accessToken = refreshed
+	return search(refreshed)
+}
@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"rag-chatbot/services"
+)
+
+// atlassianStub serves two Atlassian sites. Site "one" rejects the expired
+// token, as Atlassian does once a token runs out; site "two" answers anyway,
+// so a rejection on one site must not be hidden by results from the other.
+type atlassianStub struct {
+	server *httptest.Server
+
+	mu     sync.Mutex
+	tokens []string // Tokens used for searches, in order
+}
+
+func newAtlassianStub(t *testing.T) *atlassianStub {
+	stub := &atlassianStub{}
+	stub.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if r.URL.Path == "/oauth/token/accessible-resources" {
+			fmt.Fprintf(w, `[
+				{"id": "one", "name": "One", "url": "%[1]s/one", "scopes": ["read:confluence-content.all", "read:jira-work"]},
+				{"id": "two", "name": "Two", "url": "%[1]s/two", "scopes": ["read:confluence-content.all", "read:jira-work"]}
+			]`, stub.server.URL)
+			return
+		}
+
+		var site string
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/rest/api/content/search"):
+			site = strings.Split(strings.TrimPrefix(r.URL.Path, "/ex/confluence/"), "/")[0]
+		case strings.HasSuffix(r.URL.Path, "/rest/api/3/search/jql"):
+			site = strings.Split(strings.TrimPrefix(r.URL.Path, "/ex/jira/"), "/")[0]
+		default:
+			http.NotFound(w, r) // Page expansion isn't needed here
+			return
+		}
+		stub.mu.Lock()
+		stub.tokens = append(stub.tokens, site+":"+token)
+		stub.mu.Unlock()
+
+		if site == "one" && token == "expired" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if strings.Contains(r.URL.Path, "/ex/jira/") {
+			fmt.Fprintf(w, `{"issues": [{"key": "%s-1", "fields": {"summary": "Deploy runbook"}}]}`, strings.ToUpper(site))
+			return
+		}
+		fmt.Fprintf(w, `{"results": [{"id": "%[1]s1", "title": "Deploy guide %[1]s", "space": {"name": "Ops"},
+			"body": {"storage": {"value": "<p>How to deploy</p>"}}, "_links": {"webui": "/wiki/%[1]s1"}}]}`, site)
+	}))
+	t.Cleanup(stub.server.Close)
+	return stub
+}
+
+func (s *atlassianStub) used() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.tokens...)
+}
+
+// withAtlassianStub points the Atlassian services at stub and gives session
+// an expired token whose refresh returns "fresh"; it returns the number of
+// refreshes made so far
+func withAtlassianStub(t *testing.T, stub *atlassianStub, session string) func() int {
+	savedConfluenceURL, savedJiraURL := confluenceService.APIBaseURL, jiraService.APIBaseURL
+	savedVault, savedRefresher := tokenVault, tokenRefreshers["confluence"]
+	t.Cleanup(func() {
+		confluenceService.APIBaseURL, jiraService.APIBaseURL = savedConfluenceURL, savedJiraURL
+		tokenVault, tokenRefreshers["confluence"] = savedVault, savedRefresher
+	})
+
+	confluenceService.APIBaseURL = stub.server.URL
+	jiraService.APIBaseURL = stub.server.URL
+	tokenVault, _ = services.NewTokenVault("", "")
+	if err := tokenVault.Put(session, "confluence", services.StoredToken{AccessToken: "expired", RefreshToken: "refresh-1"}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	var mu sync.Mutex
+	refreshes := 0
+	tokenRefreshers["confluence"] = func(refreshToken string) (services.StoredToken, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if refreshToken != "refresh-1" {
+			return services.StoredToken{}, fmt.Errorf("unexpected refresh token %q", refreshToken)
+		}
+		refreshes++
+		return services.StoredToken{AccessToken: "fresh", RefreshToken: "refresh-2"}, nil
+	}
+	return func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return refreshes
+	}
+}
+
+func sessionRequest(session string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/api/chat", nil)
+	r.AddCookie(&http.Cookie{Name: sessionCookieName, Value: session})
+	return r
+}
+
+func TestConfluenceSearchRefreshesRejectedToken(t *testing.T) {
+	stub := newAtlassianStub(t)
+	refreshes := withAtlassianStub(t, stub, "session-a")
+
+	accessToken := "expired"
+	results, err := withTokenRefresh(sessionRequest("session-a"), "confluence", &accessToken, func(accessToken string) (*ConfluenceSearchResults, error) {
+		return searchConfluence(accessToken, "deploy", nil)
+	})
+	if err != nil {
+		t.Fatalf("search failed: %v", err)
+	}
+
+	if n := refreshes(); n != 1 {
+		t.Errorf("refreshed %d times, want once", n)
+	}
+	if accessToken != "fresh" {
+		t.Errorf("access token = %q, want the refreshed one", accessToken)
+	}
+	if stored, _ := tokenVault.Get("session-a", "confluence"); stored.AccessToken != "fresh" || stored.RefreshToken != "refresh-2" {
+		t.Errorf("vault holds %+v, want the refreshed token", stored)
+	}
+	if len(results.References) != 2 {
+		t.Errorf("got %d references, want one from each site after the retry", len(results.References))
+	}
+
+	// Both sites were searched again with the new token
+	used := stub.used()
+	retried := map[string]bool{}
+	for _, entry := range used {
+		if strings.HasSuffix(entry, ":fresh") {
+			retried[entry] = true
+		}
+	}
+	if !retried["one:fresh"] || !retried["two:fresh"] {
+		t.Errorf("searches = %v, want both sites retried with the fresh token", used)
+	}
+}
+
+func TestJiraSearchRefreshesRejectedToken(t *testing.T) {
+	stub := newAtlassianStub(t)
+	refreshes := withAtlassianStub(t, stub, "session-a")
+
+	accessToken := "expired"
+	results, err := withTokenRefresh(sessionRequest("session-a"), "confluence", &accessToken, func(accessToken string) (*JiraSearchResults, error) {
+		return searchJira(accessToken, "deploy", nil)
+	})
+	if err != nil {
+		t.Fatalf("search failed: %v", err)
+	}
+	if n := refreshes(); n != 1 {
+		t.Errorf("refreshed %d times, want once", n)
+	}
+	if len(results.References) != 2 {
+		t.Errorf("got %d references, want one from each site after the retry", len(results.References))
+	}
+}
+
+func TestSiteSearchError(t *testing.T) {
+	unauthorized := fmt.Errorf("failed to search: %w", &services.StatusError{StatusCode: http.StatusUnauthorized, Status: "401 Unauthorized"})
+	unavailable := fmt.Errorf("failed to search: %w", &services.StatusError{StatusCode: http.StatusServiceUnavailable, Status: "503 Service Unavailable"})
+
+	tests := []struct {
+		name string
+		errs []error
+		want error
+	}{
+		{"all succeeded", []error{nil, nil}, nil},
+		{"one failed", []error{unavailable, nil}, nil},
+		{"all failed", []error{unavailable, unavailable}, unavailable},
+		{"one unauthorized", []error{nil, unauthorized}, unauthorized},
+		{"unauthorized among failures", []error{unavailable, unauthorized}, unauthorized},
+	}
+	for _, test := range tests {
+		if got := siteSearchError(test.errs); got != test.want {
+			t.Errorf("%s: siteSearchError = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestRefreshWithoutRefreshTokenKeepsError(t *testing.T) {
+	stub := newAtlassianStub(t)
+	refreshes := withAtlassianStub(t, stub, "session-a")
+
+	// Another browser has no stored token, so the 401 is returned as is
+	accessToken := "expired"
+	_, err := withTokenRefresh(sessionRequest("session-b"), "confluence", &accessToken, func(accessToken string) (*ConfluenceSearchResults, error) {
+		return searchConfluence(accessToken, "deploy", nil)
+	})
+	if !services.IsUnauthorized(err) {
+		t.Errorf("err = %v, want the 401", err)
+	}
+	if n := refreshes(); n != 0 {
+		t.Errorf("refreshed %d times without a stored token", n)
+	}
+}
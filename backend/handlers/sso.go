@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"log"
+	"net/http"
+
+	"rag-chatbot/services"
+)
+
+// SSOLoginHandler sends the browser to the identity provider's login page
+func SSOLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if authMode != authModeOIDC {
+		http.Error(w, "Single sign-on is not enabled", http.StatusNotFound)
+		return
+	}
+
+	state, codeChallenge, ok := startOAuth(w, r, "sso")
+	if !ok {
+		return
+	}
+	authURL, err := oidcService.GetAuthURL(state, oidcNonce(codeChallenge), codeChallenge)
+	if err != nil {
+		log.Printf("SSO error: %v", err)
+		http.Error(w, "Failed to reach the identity provider", http.StatusBadGateway)
+		return
+	}
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// SSOCallbackHandler finishes single sign-on: it validates the ID token,
+// maps the user's groups to a tenant and role, and signs them in
+func SSOCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if authMode != authModeOIDC {
+		http.Error(w, "Single sign-on is not enabled", http.StatusNotFound)
+		return
+	}
+
+	authorization, ok := verifyOAuthState(w, r, "sso")
+	if !ok {
+		return
+	}
+	if errorCode := r.URL.Query().Get("error"); errorCode != "" {
+		http.Error(w, "Sign-in failed: "+errorCode+" "+r.URL.Query().Get("error_description"), http.StatusUnauthorized)
+		return
+	}
+	code := r.URL.Query().Get("code")
+	if code == "" {
+		http.Error(w, "Missing authorization code", http.StatusBadRequest)
+		return
+	}
+
+	tokenResponse, err := oidcService.ExchangeCodeForToken(code, authorization.CodeVerifier)
+	if err != nil {
+		log.Printf("SSO error: %v", err)
+		http.Error(w, "Failed to exchange code for token", http.StatusBadGateway)
+		return
+	}
+	claims, err := oidcService.VerifyIDToken(tokenResponse.IDToken, oidcNonce(pkceChallenge(authorization.CodeVerifier)))
+	if err != nil {
+		log.Printf("SSO error: rejected ID token: %v", err)
+		http.Error(w, "Invalid ID token", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := ssoUser(claims, tokenResponse.AccessToken)
+	switch {
+	case errors.Is(err, services.ErrLocalAccount):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		log.Printf("SSO sign-in of %s refused: %v", claims.Subject, err)
+		http.Error(w, "Sign-in refused: "+err.Error(), http.StatusForbidden)
+		return
+	}
+
+	if !signIn(w, r, user) {
+		return
+	}
+	log.Printf("%s signed in with SSO", user.Email)
+	http.Redirect(w, r, appConfig.FrontendURL, http.StatusFound)
+}
+
+// ssoUser creates or updates the user for a validated ID token. Groups and a
+// verified email come from the ID token, or from the userinfo endpoint when
+// the provider leaves them out of the token. An unverified email is never
+// used, as it would let anyone claim the address of an existing account.
+func ssoUser(claims *services.OIDCClaims, accessToken string) (*services.User, error) {
+	email, name := services.VerifiedEmail(claims.Raw), claims.Name
+	groups := services.StringListClaim(claims.Raw, oidcGroupsClaim)
+	if _, ok := claims.Raw[oidcGroupsClaim]; !ok || email == "" {
+		userInfo, err := oidcService.UserInfo(accessToken)
+		// The userinfo response must be about the same user (OIDC Core 5.3.2)
+		if err == nil && userInfo["sub"] == claims.Subject {
+			if _, ok := userInfo[oidcGroupsClaim]; ok {
+				groups = services.StringListClaim(userInfo, oidcGroupsClaim)
+			}
+			if email == "" {
+				email = services.VerifiedEmail(userInfo)
+			}
+			if name == "" {
+				name, _ = userInfo["name"].(string)
+			}
+		}
+	}
+	if email == "" {
+		return nil, errors.New("your identity provider didn't share a verified email address")
+	}
+
+	tenant, role, err := oidcGroups.Resolve(groups)
+	if err != nil {
+		return nil, err
+	}
+	return userStore.UpsertExternalUser(claims.Issuer, claims.Subject, email, name, tenant, role, groups)
+}
+
+// oidcNonce derives the ID token nonce from the PKCE challenge, so the state
+// store doesn't need to keep a separate value. Like the challenge, it is
+// unguessable before the login starts and tied to the browser session.
+func oidcNonce(codeChallenge string) string {
+	sum := sha256.Sum256([]byte("oidc-nonce:" + codeChallenge))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
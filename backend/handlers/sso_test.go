@@ -0,0 +1,254 @@
+package handlers
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"rag-chatbot/services"
+)
+
+// mockIdP is an OpenID Connect provider serving discovery, its signing keys,
+// the token endpoint and userinfo. The ID token carries idClaims plus the
+// nonce of the login being completed.
+type mockIdP struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu       sync.Mutex
+	nonce    string
+	idClaims map[string]interface{}
+	userInfo map[string]interface{}
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	idp := &mockIdP{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.server.URL,
+			"authorization_endpoint": idp.server.URL + "/authorize",
+			"token_endpoint":         idp.server.URL + "/token",
+			"userinfo_endpoint":      idp.server.URL + "/userinfo",
+			"jwks_uri":               idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA", "kid": "key-1", "use": "sig", "alg": "RS256",
+			"n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("code") != "code-1" || r.Form.Get("code_verifier") == "" {
+			http.Error(w, `{"error": "invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "idp-access",
+			"token_type":   "Bearer",
+			"id_token":     idp.idToken(t),
+		})
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer idp-access" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		idp.mu.Lock()
+		defer idp.mu.Unlock()
+		json.NewEncoder(w).Encode(idp.userInfo)
+	})
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+func (idp *mockIdP) idToken(t *testing.T) string {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+
+	claims := map[string]interface{}{
+		"iss":   idp.server.URL,
+		"aud":   "chatbot",
+		"sub":   "subject-1",
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"nonce": idp.nonce,
+	}
+	for name, value := range idp.idClaims {
+		claims[name] = value
+	}
+
+	encode := func(v interface{}) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatalf("Marshal: %v", err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	signed := encode(map[string]string{"alg": "RS256", "kid": "key-1", "typ": "JWT"}) + "." + encode(claims)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, idp.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatalf("SignPKCS1v15: %v", err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// withMockIdP switches the handlers to single sign-on with idp
+func withMockIdP(t *testing.T, idp *mockIdP, groups services.OIDCGroupMapping) {
+	withUserStore(t)
+	savedMode, savedService, savedGroups, savedClaim := authMode, oidcService, oidcGroups, oidcGroupsClaim
+	t.Cleanup(func() {
+		authMode, oidcService, oidcGroups, oidcGroupsClaim = savedMode, savedService, savedGroups, savedClaim
+	})
+
+	authMode = authModeOIDC
+	oidcService = services.NewOIDCService(idp.server.URL, "chatbot", "secret", "https://chat.example.com/api/auth/sso/callback", []string{"openid", "email"})
+	oidcGroups = groups
+	oidcGroupsClaim = "groups"
+}
+
+// ssoSignIn goes through the login redirect and the callback in one browser
+// session, returning the callback's response
+func ssoSignIn(t *testing.T, idp *mockIdP) *httptest.ResponseRecorder {
+	cookie := &http.Cookie{Name: sessionCookieName, Value: "browser-1"}
+
+	r := httptest.NewRequest(http.MethodGet, "/api/auth/sso/login", nil)
+	r.AddCookie(cookie)
+	w := httptest.NewRecorder()
+	SSOLoginHandler(w, r)
+	if w.Code != http.StatusFound {
+		t.Fatalf("login status = %d: %s", w.Code, w.Body.String())
+	}
+	authURL, err := url.Parse(w.Header().Get("Location"))
+	if err != nil || !strings.HasPrefix(authURL.String(), idp.server.URL+"/authorize?") {
+		t.Fatalf("login redirected to %q", w.Header().Get("Location"))
+	}
+	idp.mu.Lock()
+	idp.nonce = authURL.Query().Get("nonce")
+	idp.mu.Unlock()
+
+	r = httptest.NewRequest(http.MethodGet, "/api/auth/sso/callback?code=code-1&state="+url.QueryEscape(authURL.Query().Get("state")), nil)
+	r.AddCookie(cookie)
+	w = httptest.NewRecorder()
+	SSOCallbackHandler(w, r)
+	return w
+}
+
+func TestSSOSignIn(t *testing.T) {
+	tenantGroups := map[string]string{"acme-staff": "acme", "globex-staff": "globex"}
+
+	tests := []struct {
+		name       string
+		idClaims   map[string]interface{}
+		userInfo   map[string]interface{}
+		groups     services.OIDCGroupMapping
+		wantStatus int
+		wantTenant string
+	}{
+		{
+			name:       "verified email and mapped group",
+			idClaims:   map[string]interface{}{"email": "ada@acme.test", "email_verified": true, "groups": []string{"acme-staff"}},
+			groups:     services.OIDCGroupMapping{TenantGroups: tenantGroups},
+			wantStatus: http.StatusFound,
+			wantTenant: "acme",
+		},
+		{
+			name:     "verified email and groups from userinfo",
+			idClaims: map[string]interface{}{"email": "ada@acme.test", "email_verified": false},
+			userInfo: map[string]interface{}{"sub": "subject-1", "email": "ada@acme.test", "email_verified": "true",
+				"groups": []string{"globex-staff"}},
+			groups:     services.OIDCGroupMapping{TenantGroups: tenantGroups},
+			wantStatus: http.StatusFound,
+			wantTenant: "globex",
+		},
+		{
+			name:       "unverified email",
+			idClaims:   map[string]interface{}{"email": "admin@acme.test", "email_verified": false, "groups": []string{"acme-staff"}},
+			userInfo:   map[string]interface{}{"sub": "subject-1", "email": "admin@acme.test"},
+			groups:     services.OIDCGroupMapping{TenantGroups: tenantGroups},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "userinfo about another subject",
+			idClaims:   map[string]interface{}{"groups": []string{"acme-staff"}},
+			userInfo:   map[string]interface{}{"sub": "subject-2", "email": "ada@acme.test", "email_verified": true},
+			groups:     services.OIDCGroupMapping{TenantGroups: tenantGroups},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "preferred username only",
+			idClaims:   map[string]interface{}{"preferred_username": "admin@acme.test", "groups": []string{"acme-staff"}},
+			groups:     services.OIDCGroupMapping{TenantGroups: tenantGroups},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "no mapped group",
+			idClaims:   map[string]interface{}{"email": "eve@example.com", "email_verified": true, "groups": []string{"contractors"}},
+			groups:     services.OIDCGroupMapping{TenantGroups: tenantGroups},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "no mapped group with an unmapped tenant",
+			idClaims:   map[string]interface{}{"email": "eve@example.com", "email_verified": true, "groups": []string{"contractors"}},
+			groups:     services.OIDCGroupMapping{TenantGroups: tenantGroups, UnmappedTenant: "guests"},
+			wantStatus: http.StatusFound,
+			wantTenant: "guests",
+		},
+		{
+			name:       "single tenant",
+			idClaims:   map[string]interface{}{"email": "eve@example.com", "email_verified": true, "groups": []string{}},
+			wantStatus: http.StatusFound,
+			wantTenant: services.DefaultTenant,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			idp := newMockIdP(t)
+			idp.idClaims, idp.userInfo = test.idClaims, test.userInfo
+			withMockIdP(t, idp, test.groups)
+
+			w := ssoSignIn(t, idp)
+			if w.Code != test.wantStatus {
+				t.Fatalf("callback status = %d, want %d: %s", w.Code, test.wantStatus, w.Body.String())
+			}
+
+			users := userStore.List(test.wantTenant)
+			if test.wantStatus != http.StatusFound {
+				for _, tenant := range []string{"acme", "globex", "guests", services.DefaultTenant} {
+					if users := userStore.List(tenant); len(users) != 0 {
+						t.Errorf("refused sign-in created %+v in %s", users[0], tenant)
+					}
+				}
+				return
+			}
+			if len(users) != 1 || users[0].Subject != "subject-1" {
+				t.Fatalf("users in %s = %+v", test.wantTenant, users)
+			}
+			if len(w.Result().Cookies()) == 0 {
+				t.Errorf("sign-in set no session cookie")
+			}
+		})
+	}
+}
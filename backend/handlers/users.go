@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"rag-chatbot/services"
+)
+
+type UserListResponse struct {
+	Users []*UserResponse `json:"users"`
+}
+
+type CreateUserRequest struct {
+	Email    string `json:"email"`
+	Name     string `json:"name"`
+	Password string `json:"password"`
+	Role     string `json:"role"`    // "admin" or "member" (default)
+	Tenant   string `json:"tenant"`  // Defaults to the admin's tenant
+	Service  bool   `json:"service"` // A service account for API keys; needs only a name
+}
+
+// UsersHandler lets admins list (GET), create (POST) and remove (DELETE ?id=)
+// the users and service accounts of their tenant. Admins of the default
+// tenant may also create them in other tenants.
+func UsersHandler(w http.ResponseWriter, r *http.Request) {
+	admin, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+	if !admin.IsAdmin() {
+		http.Error(w, "Admin role required", http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		response := UserListResponse{Users: []*UserResponse{}}
+		for _, user := range userStore.List(admin.Tenant) {
+			response.Users = append(response.Users, userResponse(user))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+
+	case http.MethodPost:
+		var req CreateUserRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if req.Tenant == "" {
+			req.Tenant = admin.Tenant
+		}
+		if req.Tenant != admin.Tenant && admin.Tenant != services.DefaultTenant {
+			http.Error(w, "Admins can only create users in their own tenant", http.StatusForbidden)
+			return
+		}
+
+		var user *services.User
+		var err error
+		if req.Service {
+			user, err = userStore.CreateServiceAccount(req.Tenant, req.Name, req.Role)
+		} else {
+			user, err = userStore.CreateLocalUser(req.Tenant, req.Email, req.Name, req.Password, req.Role)
+		}
+		if errors.Is(err, services.ErrUserExists) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to create user: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("%s created user %s in tenant %s", userName(admin), userName(user), user.Tenant)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(userResponse(user))
+
+	case http.MethodDelete:
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			http.Error(w, "Missing user id", http.StatusBadRequest)
+			return
+		}
+		if id == admin.ID {
+			http.Error(w, "Admins can't delete themselves", http.StatusBadRequest)
+			return
+		}
+
+		if err := userStore.Delete(admin.Tenant, id); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		// The user's history, connected accounts and API keys go with them
+		if err := conversationStore.DeleteUser(admin.Tenant, id); err != nil {
+			log.Printf("Failed to delete conversations of user %s: %v", id, err)
+		}
+		for _, provider := range oauthProviders {
+			tokenVault.Delete(userCredentialOwner(id), provider)
+		}
+		if err := apiKeyStore.DeleteUser(id); err != nil {
+			log.Printf("Failed to delete API keys of user %s: %v", id, err)
+		}
+		log.Printf("%s deleted user %s", userName(admin), id)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+type CredentialsResponse struct {
+	Connected []string `json:"connected"`
+}
+
+// StoreCredentialsRequest is a token for one of the OAuth providers, for
+// example a GitHub personal access token or a Notion integration token
+type StoreCredentialsRequest struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"` // Seconds; 0 when the token doesn't expire
+}
+
+// CredentialsHandler manages the connected accounts of a service account,
+// which can't go through the OAuth popup: GET ?id= lists the connected
+// providers, PUT ?id=&provider= stores a token and DELETE ?id=&provider=
+// removes it. Only admins of the account's tenant may use it.
+func CredentialsHandler(w http.ResponseWriter, r *http.Request) {
+	admin, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+	if !admin.IsAdmin() {
+		http.Error(w, "Admin role required", http.StatusForbidden)
+		return
+	}
+
+	account, ok := userStore.Get(r.URL.Query().Get("id"))
+	if !ok || account.Tenant != admin.Tenant {
+		http.Error(w, services.ErrUserNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	// People connect their own accounts; nobody else may plant tokens for them
+	if !account.Service {
+		http.Error(w, "Only service accounts' credentials can be managed", http.StatusBadRequest)
+		return
+	}
+	owner := userCredentialOwner(account.ID)
+
+	provider := r.URL.Query().Get("provider")
+	if r.Method != http.MethodGet && !containsFold(oauthProviders, provider) {
+		http.Error(w, "Unknown provider, expected one of "+strings.Join(oauthProviders, ", "), http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		// Handled below
+	case http.MethodPut:
+		var req StoreCredentialsRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.AccessToken == "" {
+			http.Error(w, "Invalid JSON: access_token is required", http.StatusBadRequest)
+			return
+		}
+		token := services.NewStoredToken(req.AccessToken, req.RefreshToken, req.TokenType, req.ExpiresIn)
+		if err := tokenVault.Put(owner, provider, token); err != nil {
+			http.Error(w, "Failed to store token: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("%s stored %s credentials for service account %s", userName(admin), provider, account.Name)
+	case http.MethodDelete:
+		if err := tokenVault.Delete(owner, provider); err != nil {
+			http.Error(w, "Failed to remove token: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	response := CredentialsResponse{Connected: tokenVault.Providers(owner)}
+	if response.Connected == nil {
+		response.Connected = []string{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// userName names a user in logs: people by email, service accounts by name
+func userName(user *services.User) string {
+	if user.Service {
+		return "service account " + user.Name
+	}
+	return user.Email
+}
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"rag-chatbot/services"
+)
+
+// withUserStore gives the handlers an in-memory user store and vault
+func withUserStore(t *testing.T) {
+	savedUsers, savedVault := userStore, tokenVault
+	t.Cleanup(func() { userStore, tokenVault = savedUsers, savedVault })
+
+	userStore, _ = services.NewUserStore("", 0)
+	tokenVault, _ = services.NewTokenVault("", "")
+}
+
+func asUser(r *http.Request, user *services.User) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), userContextKey, user))
+}
+
+func TestCredentialsHandlerStoresServiceAccountTokens(t *testing.T) {
+	withUserStore(t)
+	admin, err := userStore.CreateLocalUser("acme", "admin@acme.test", "Admin", "correct horse", services.RoleAdmin)
+	if err != nil {
+		t.Fatalf("CreateLocalUser: %v", err)
+	}
+	other, err := userStore.CreateLocalUser("globex", "admin@globex.test", "Other", "correct horse", services.RoleAdmin)
+	if err != nil {
+		t.Fatalf("CreateLocalUser: %v", err)
+	}
+	account, err := userStore.CreateServiceAccount("acme", "nightly-report", services.RoleMember)
+	if err != nil {
+		t.Fatalf("CreateServiceAccount: %v", err)
+	}
+
+	body := strings.NewReader(`{"access_token": "ghp_service"}`)
+	r := asUser(httptest.NewRequest(http.MethodPut, "/api/users/credentials?id="+account.ID+"&provider=github", body), admin)
+	w := httptest.NewRecorder()
+	CredentialsHandler(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT status = %d: %s", w.Code, w.Body.String())
+	}
+	var response CredentialsResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil || len(response.Connected) != 1 || response.Connected[0] != "github" {
+		t.Errorf("response = %+v (%v)", response, err)
+	}
+
+	// The token is held by the account as a user, whose credentials the vault
+	// keeps until they're removed, not by an expiring browser session
+	if token, ok := tokenVault.Get(services.UserOwner(account.ID), "github"); !ok || token.AccessToken != "ghp_service" {
+		t.Errorf("vault holds %+v, %t for the service account", token, ok)
+	}
+
+	// Admins of another tenant can't see or change the account
+	r = asUser(httptest.NewRequest(http.MethodGet, "/api/users/credentials?id="+account.ID, nil), other)
+	w = httptest.NewRecorder()
+	CredentialsHandler(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("other tenant's GET status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
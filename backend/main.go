@@ -16,40 +16,50 @@ import (
 )
 
 func main() {
-	mux := http.NewServeMux()
+	handlers.StartWatchers()
 
-	// CORS middleware
-	corsHandler := func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			w.Header().Set("Access-Control-Allow-Origin", "*")
-			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-			
-			if r.Method == "OPTIONS" {
-				w.WriteHeader(http.StatusOK)
-				return
-			}
-			
-			next.ServeHTTP(w, r)
-		})
-	}
+	mux := http.NewServeMux()
 
 	// Routes
 	mux.HandleFunc("/api/chat", handlers.ChatHandler)
 	mux.HandleFunc("/api/chat/stream", handlers.ChatStreamHandler)
+	mux.HandleFunc("/api/search", handlers.SearchHandler)
 	mux.HandleFunc("/api/health", handlers.HealthHandler)
+	mux.HandleFunc("/api/imports", handlers.ImportsHandler)
+	mux.HandleFunc("/api/auth/connections", handlers.ConnectionsHandler)
+	
+	// Account routes
+	mux.HandleFunc("/api/auth/login", handlers.LoginHandler)
+	mux.HandleFunc("/api/auth/logout", handlers.LogoutHandler)
+	mux.HandleFunc("/api/auth/sso", handlers.SSOLoginHandler)
+	mux.HandleFunc("/api/auth/sso/callback", handlers.SSOCallbackHandler)
+	mux.HandleFunc("/api/me", handlers.MeHandler)
+	mux.HandleFunc("/api/me/settings", handlers.SettingsHandler)
+	mux.HandleFunc("/api/users", handlers.UsersHandler)
+	mux.HandleFunc("/api/users/credentials", handlers.CredentialsHandler)
+	mux.HandleFunc("/api/api-keys", handlers.APIKeysHandler)
+	mux.HandleFunc("/api/audit", handlers.AuditHandler)
+	mux.HandleFunc("/api/audit/verify", handlers.AuditVerifyHandler)
+	mux.HandleFunc("/api/conversations", handlers.ConversationsHandler)
 	
 	// OAuth routes
 	mux.HandleFunc("/api/auth/confluence", handlers.ConfluenceAuthHandler)
 	mux.HandleFunc("/api/auth/confluence/callback", handlers.ConfluenceCallbackHandler)
 	mux.HandleFunc("/api/auth/gmail", handlers.GmailAuthHandler)
+	mux.HandleFunc("/api/auth/drive", handlers.DriveAuthHandler)
 	mux.HandleFunc("/api/auth/google/callback", handlers.GmailCallbackHandler)
 	mux.HandleFunc("/api/auth/slack", handlers.SlackAuthHandler)
 	mux.HandleFunc("/api/auth/slack/callback", handlers.SlackCallbackHandler)
+	mux.HandleFunc("/api/auth/github", handlers.GitHubAuthHandler)
+	mux.HandleFunc("/api/auth/github/callback", handlers.GitHubCallbackHandler)
+	mux.HandleFunc("/api/auth/notion", handlers.NotionAuthHandler)
+	mux.HandleFunc("/api/auth/notion/callback", handlers.NotionCallbackHandler)
+	mux.HandleFunc("/api/auth/microsoft", handlers.MicrosoftAuthHandler)
+	mux.HandleFunc("/api/auth/microsoft/callback", handlers.MicrosoftCallbackHandler)
 
 	server := &http.Server{
 		Addr:    ":8085",
-		Handler: corsHandler(mux),
+		Handler: handlers.CORS(handlers.RequireAuth(handlers.RateLimit(mux))),
 	}
 
 	// Check if we should run with HTTPS (for Slack OAuth)
@@ -0,0 +1,79 @@
+package services
+
+import "strings"
+
+// EveryonePrincipal grants a document to every reader in its tenant
+const EveryonePrincipal = "everyone"
+
+// DocumentACL says who may read an indexed document. Each clause lists
+// principals - EveryonePrincipal, UserPrincipal or GroupPrincipal - and a
+// reader must match at least one principal of every clause, so a Confluence
+// page restricted below a restricted parent needs both restrictions to pass.
+//
+// Access is denied by default: a document without clauses, or with a clause
+// nobody matches, is returned to no one. Connectors grant access explicitly.
+type DocumentACL [][]string
+
+// Reader identifies who is searching the index
+type Reader struct {
+	Tenant string
+	Email  string // Empty for anonymous readers, who only see documents shared with everyone
+	Groups []string
+}
+
+// PublicACL grants a document to everyone in its tenant
+func PublicACL() DocumentACL {
+	return DocumentACL{{EveryonePrincipal}}
+}
+
+// UserPrincipal names a reader by email address
+func UserPrincipal(email string) string {
+	return "user:" + normalizeEmail(email)
+}
+
+// GroupPrincipal names a group, as the identity provider reports it
+func GroupPrincipal(group string) string {
+	return "group:" + strings.ToLower(strings.TrimSpace(group))
+}
+
+// Allows reports whether reader passes every clause
+func (acl DocumentACL) Allows(reader Reader) bool {
+	if len(acl) == 0 {
+		return false
+	}
+
+	principals := map[string]bool{EveryonePrincipal: true}
+	if reader.Email != "" {
+		principals[UserPrincipal(reader.Email)] = true
+	}
+	for _, group := range reader.Groups {
+		principals[GroupPrincipal(group)] = true
+	}
+
+	for _, clause := range acl {
+		matched := false
+		for _, principal := range clause {
+			if principals[principal] {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// DeniesEveryone reports whether no reader at all can pass the ACL
+func (acl DocumentACL) DeniesEveryone() bool {
+	if len(acl) == 0 {
+		return true
+	}
+	for _, clause := range acl {
+		if len(clause) == 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,261 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scopes an API key can be given
+const (
+	ScopeChat   = "chat"   // Asking questions and reading the account's conversations
+	ScopeSearch = "search" // Retrieving sources without generating an answer
+	ScopeAdmin  = "admin"  // Managing users, imports and keys, for admin service accounts
+)
+
+// APIKeyScopes lists every scope
+var APIKeyScopes = []string{ScopeChat, ScopeSearch, ScopeAdmin}
+
+const (
+	// apiKeyPrefix makes the chatbot's keys easy to spot in code and logs
+	apiKeyPrefix   = "rck_"
+	apiKeyIDLength = 16 // Hex characters after the prefix
+
+	// lastUsedSaveInterval limits how often using a key rewrites the store
+	lastUsedSaveInterval = time.Minute
+)
+
+var (
+	// ErrInvalidAPIKey covers unknown, revoked and expired keys alike
+	ErrInvalidAPIKey  = errors.New("invalid API key")
+	ErrAPIKeyNotFound = errors.New("API key not found")
+)
+
+// APIKey lets a tool act as a service account. Only a hash of the key is
+// stored; the key itself is shown once, when it is created.
+type APIKey struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"user_id"` // Service account the key acts as
+	Tenant    string    `json:"tenant"`
+	Name      string    `json:"name"` // What the key is for
+	Scopes    []string  `json:"scopes"`
+	Hash      string    `json:"hash"` // Hex SHA-256 of the key
+	CreatedBy string    `json:"created_by"`
+	Created   time.Time `json:"created"`
+	Expires   time.Time `json:"expires"`
+	LastUsed  time.Time `json:"last_used,omitempty"`
+	Revoked   time.Time `json:"revoked,omitempty"`
+}
+
+// HasScope reports whether the key was given scope
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Active reports whether the key can still be used
+func (k *APIKey) Active(now time.Time) bool {
+	return k.Revoked.IsZero() && now.Before(k.Expires)
+}
+
+// APIKeyStore keeps API keys, persisted as JSON
+type APIKeyStore struct {
+	path      string
+	mu        sync.Mutex
+	keys      map[string]*APIKey
+	lastSaved time.Time
+}
+
+// NewAPIKeyStore opens the store at path; an empty path keeps it in memory
+func NewAPIKeyStore(path string) (*APIKeyStore, error) {
+	store := &APIKeyStore{path: path, keys: make(map[string]*APIKey)}
+
+	if path == "" {
+		return store, nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read API keys: %v", err)
+	}
+
+	var keys []*APIKey
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("failed to parse API keys: %v", err)
+	}
+	for _, key := range keys {
+		store.keys[key.ID] = key
+	}
+	return store, nil
+}
+
+// Create issues a key for a service account and returns it with its record.
+// The key can't be recovered later.
+func (ks *APIKeyStore) Create(userID, tenant, name string, scopes []string, expires time.Time, createdBy string) (string, *APIKey, error) {
+	if len(scopes) == 0 {
+		return "", nil, errors.New("at least one scope is required")
+	}
+	for _, scope := range scopes {
+		if !containsString(APIKeyScopes, scope) {
+			return "", nil, fmt.Errorf("unknown scope %q, expected one of %s", scope, strings.Join(APIKeyScopes, ", "))
+		}
+	}
+	if !expires.After(time.Now()) {
+		return "", nil, errors.New("expiry must be in the future")
+	}
+
+	idBytes := make([]byte, apiKeyIDLength/2)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", nil, err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return "", nil, err
+	}
+	id := hex.EncodeToString(idBytes)
+	plaintext := apiKeyPrefix + id + base64.RawURLEncoding.EncodeToString(secret)
+
+	key := &APIKey{
+		ID:        id,
+		UserID:    userID,
+		Tenant:    tenant,
+		Name:      strings.TrimSpace(name),
+		Scopes:    append([]string(nil), scopes...),
+		Hash:      hashAPIKey(plaintext),
+		CreatedBy: createdBy,
+		Created:   time.Now().UTC(),
+		Expires:   expires.UTC(),
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.keys[id] = key
+	return plaintext, copyAPIKey(key), ks.save()
+}
+
+// Authenticate looks up an active key and records that it was used
+func (ks *APIKeyStore) Authenticate(plaintext string) (*APIKey, error) {
+	if !strings.HasPrefix(plaintext, apiKeyPrefix) || len(plaintext) <= len(apiKeyPrefix)+apiKeyIDLength {
+		return nil, ErrInvalidAPIKey
+	}
+	id := plaintext[len(apiKeyPrefix) : len(apiKeyPrefix)+apiKeyIDLength]
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	key, ok := ks.keys[id]
+	if !ok || subtle.ConstantTimeCompare([]byte(hashAPIKey(plaintext)), []byte(key.Hash)) != 1 {
+		return nil, ErrInvalidAPIKey
+	}
+	now := time.Now().UTC()
+	if !key.Active(now) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	key.LastUsed = now
+	if now.Sub(ks.lastSaved) >= lastUsedSaveInterval {
+		// Failing to record the time isn't a reason to refuse the request
+		ks.save()
+	}
+	return copyAPIKey(key), nil
+}
+
+// List returns a tenant's keys, revoked and expired ones included
+func (ks *APIKeyStore) List(tenant string) []*APIKey {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	keys := []*APIKey{}
+	for _, key := range ks.keys {
+		if key.Tenant == tenant {
+			keys = append(keys, copyAPIKey(key))
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Created.Before(keys[j].Created) })
+	return keys
+}
+
+// Revoke stops a key of a tenant from working. The record is kept so the
+// key still shows up, as revoked, in the list.
+func (ks *APIKeyStore) Revoke(tenant, id string) (*APIKey, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	key, ok := ks.keys[id]
+	if !ok || key.Tenant != tenant {
+		return nil, ErrAPIKeyNotFound
+	}
+	if key.Revoked.IsZero() {
+		key.Revoked = time.Now().UTC()
+	}
+	return copyAPIKey(key), ks.save()
+}
+
+// DeleteUser removes the keys of a deleted service account
+func (ks *APIKeyStore) DeleteUser(userID string) error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	for id, key := range ks.keys {
+		if key.UserID == userID {
+			delete(ks.keys, id)
+		}
+	}
+	return ks.save()
+}
+
+// save writes the store atomically. Callers hold mu.
+func (ks *APIKeyStore) save() error {
+	ks.lastSaved = time.Now()
+	if ks.path == "" {
+		return nil
+	}
+
+	keys := make([]*APIKey, 0, len(ks.keys))
+	for _, key := range ks.keys {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+
+	data, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(ks.path), 0o700); err != nil {
+		return err
+	}
+	tmp := ks.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, ks.path)
+}
+
+// hashAPIKey needs no salt or stretching: keys are random, not chosen by people
+func hashAPIKey(plaintext string) string {
+	sum := sha256.Sum256([]byte(plaintext))
+	return hex.EncodeToString(sum[:])
+}
+
+func copyAPIKey(key *APIKey) *APIKey {
+	copied := *key
+	copied.Scopes = append([]string(nil), key.Scopes...)
+	return &copied
+}
@@ -0,0 +1,372 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	auditFileName   = "audit.jsonl"
+	auditFilePrefix = "audit-"
+	auditFileSuffix = ".jsonl"
+
+	// auditRotatedFormat names rotated files so they sort in the order written
+	auditRotatedFormat = "20060102T150405.000000000Z"
+)
+
+// Audit actions
+const (
+	AuditActionChat   = "chat"
+	AuditActionSearch = "search"
+)
+
+// AuditEntry records who asked what, where it was looked up and what they
+// were shown. Each entry includes the hash of the one before it, so removing
+// or changing an entry breaks the chain from there on.
+type AuditEntry struct {
+	Seq            int64           `json:"seq"`
+	Time           time.Time       `json:"time"`
+	Action         string          `json:"action"` // AuditActionChat or AuditActionSearch
+	Tenant         string          `json:"tenant"`
+	UserID         string          `json:"user_id,omitempty"`
+	User           string          `json:"user,omitempty"` // Email, or the service account's name
+	APIKeyID       string          `json:"api_key_id,omitempty"`
+	ClientIP       string          `json:"client_ip,omitempty"`
+	ConversationID string          `json:"conversation_id,omitempty"`
+	Query          string          `json:"query"`
+	Sources        []string        `json:"sources"`   // Sources that were searched
+	Documents      []AuditDocument `json:"documents"` // Documents shown as references
+	Answer         string          `json:"answer,omitempty"`
+	Model          string          `json:"model,omitempty"`
+	Usage          *OpenAIUsage    `json:"usage,omitempty"`
+	PrevHash       string          `json:"prev_hash"`
+	Hash           string          `json:"hash"` // Hex SHA-256 of PrevHash and the entry without Hash
+}
+
+// AuditDocument identifies a document by its URL, the one ID every source has
+type AuditDocument struct {
+	ID     string `json:"id"`
+	Title  string `json:"title"`
+	Source string `json:"source"`
+}
+
+// AuditFilter selects entries of a tenant; zero fields match everything
+type AuditFilter struct {
+	Tenant   string
+	UserID   string
+	Action   string
+	Contains string // Case-insensitive text in the query
+	Since    time.Time
+	Until    time.Time
+	Limit    int
+}
+
+func (f AuditFilter) matches(entry *AuditEntry) bool {
+	return entry.Tenant == f.Tenant &&
+		(f.UserID == "" || entry.UserID == f.UserID) &&
+		(f.Action == "" || entry.Action == f.Action) &&
+		(f.Contains == "" || strings.Contains(strings.ToLower(entry.Query), strings.ToLower(f.Contains))) &&
+		(f.Since.IsZero() || !entry.Time.Before(f.Since)) &&
+		(f.Until.IsZero() || entry.Time.Before(f.Until))
+}
+
+// AuditVerification is the result of checking the hash chain
+type AuditVerification struct {
+	Valid    bool   `json:"valid"`
+	Entries  int    `json:"entries"`
+	FirstSeq int64  `json:"first_seq,omitempty"`
+	LastSeq  int64  `json:"last_seq,omitempty"`
+	LastHash string `json:"last_hash,omitempty"` // Note it elsewhere to detect the log being cut short later
+	Problem  string `json:"problem,omitempty"`
+}
+
+// AuditLog appends entries to a JSONL file in dir. When the file would grow
+// past maxSize it is renamed with a timestamp and a new one started; the
+// chain continues across files. With maxFiles set, only that many rotated
+// files are kept.
+type AuditLog struct {
+	dir      string
+	maxSize  int64
+	maxFiles int
+
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	lastSeq  int64
+	lastHash string
+}
+
+// NewAuditLog opens the log in dir, picking up the chain where it ended. The
+// directory and file are created with the first entry.
+func NewAuditLog(dir string, maxSize int64, maxFiles int) (*AuditLog, error) {
+	audit := &AuditLog{dir: dir, maxSize: maxSize, maxFiles: maxFiles}
+
+	files, err := audit.files()
+	if err != nil {
+		return nil, err
+	}
+	// The last entry is in the newest file that has one
+	for i := len(files) - 1; i >= 0 && audit.lastSeq == 0; i-- {
+		err := readAuditFile(files[i], func(entry *AuditEntry) error {
+			audit.lastSeq, audit.lastHash = entry.Seq, entry.Hash
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return audit, nil
+}
+
+// Append completes entry with its sequence number, time and hashes and writes
+// it to the log
+func (a *AuditLog) Append(entry AuditEntry) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	entry.Seq = a.lastSeq + 1
+	entry.Time = time.Now().UTC()
+	entry.PrevHash = a.lastHash
+	entry.Hash = ""
+	hash, err := hashAuditEntry(&entry)
+	if err != nil {
+		return err
+	}
+	entry.Hash = hash
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	if a.file == nil {
+		if err := a.open(); err != nil {
+			return err
+		}
+	}
+	if a.maxSize > 0 && a.size > 0 && a.size+int64(len(line)) > a.maxSize {
+		if err := a.rotate(); err != nil {
+			return fmt.Errorf("failed to rotate audit log: %v", err)
+		}
+	}
+	if _, err := a.file.Write(line); err != nil {
+		return fmt.Errorf("failed to write audit log: %v", err)
+	}
+	if err := a.file.Sync(); err != nil {
+		return fmt.Errorf("failed to write audit log: %v", err)
+	}
+	a.size += int64(len(line))
+	a.lastSeq, a.lastHash = entry.Seq, entry.Hash
+	return nil
+}
+
+// Query returns the entries matching filter, newest first
+func (a *AuditLog) Query(filter AuditFilter) ([]*AuditEntry, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	files, err := a.files()
+	if err != nil {
+		return nil, err
+	}
+	entries := []*AuditEntry{}
+	for _, file := range files {
+		err := readAuditFile(file, func(entry *AuditEntry) error {
+			if filter.matches(entry) {
+				entries = append(entries, entry)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Seq > entries[j].Seq })
+	if filter.Limit > 0 && len(entries) > filter.Limit {
+		entries = entries[:filter.Limit]
+	}
+	return entries, nil
+}
+
+// Verify walks every entry checking sequence numbers and hashes. Entries in
+// rotated files that were deleted can't be checked; the chain is verified
+// from the oldest entry that's left.
+func (a *AuditLog) Verify() (*AuditVerification, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	files, err := a.files()
+	if err != nil {
+		return nil, err
+	}
+	result := &AuditVerification{Valid: true}
+	errBroken := errors.New("chain broken")
+	for _, file := range files {
+		err := readAuditFile(file, func(entry *AuditEntry) error {
+			if result.Entries > 0 && (entry.Seq != result.LastSeq+1 || entry.PrevHash != result.LastHash) {
+				result.Problem = fmt.Sprintf("entry %d doesn't follow entry %d in %s", entry.Seq, result.LastSeq, filepath.Base(file))
+				return errBroken
+			}
+			hash, err := hashAuditEntry(entry)
+			if err != nil {
+				return err
+			}
+			if hash != entry.Hash {
+				result.Problem = fmt.Sprintf("entry %d in %s was modified", entry.Seq, filepath.Base(file))
+				return errBroken
+			}
+			if result.Entries == 0 {
+				result.FirstSeq = entry.Seq
+			}
+			result.Entries++
+			result.LastSeq, result.LastHash = entry.Seq, entry.Hash
+			return nil
+		})
+		if err != nil {
+			// A line that no longer parses is as much a break as a wrong hash
+			if !errors.Is(err, errBroken) {
+				result.Problem = err.Error()
+			}
+			result.Valid = false
+			return result, nil
+		}
+	}
+
+	if result.LastSeq != a.lastSeq || result.LastHash != a.lastHash {
+		result.Valid = false
+		result.Problem = fmt.Sprintf("the log ends at entry %d but entry %d was written", result.LastSeq, a.lastSeq)
+	}
+	return result, nil
+}
+
+// open opens the current file for appending, creating the directory if
+// needed. Callers hold mu.
+func (a *AuditLog) open() error {
+	if err := os.MkdirAll(a.dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create audit log directory: %v", err)
+	}
+	file, err := os.OpenFile(filepath.Join(a.dir, auditFileName), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %v", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to open audit log: %v", err)
+	}
+	a.file, a.size = file, info.Size()
+	return nil
+}
+
+// rotate renames the current file and starts a new one, then deletes the
+// oldest rotated files beyond maxFiles. Callers hold mu.
+func (a *AuditLog) rotate() error {
+	if err := a.file.Close(); err != nil {
+		return err
+	}
+	rotated := auditFilePrefix + time.Now().UTC().Format(auditRotatedFormat) + auditFileSuffix
+	if err := os.Rename(filepath.Join(a.dir, auditFileName), filepath.Join(a.dir, rotated)); err != nil {
+		return err
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	if a.maxFiles <= 0 {
+		return nil
+	}
+	files, err := a.files()
+	if err != nil {
+		return err
+	}
+	rotatedFiles := files[:len(files)-1]
+	for len(rotatedFiles) > a.maxFiles {
+		if err := os.Remove(rotatedFiles[0]); err != nil {
+			return err
+		}
+		rotatedFiles = rotatedFiles[1:]
+	}
+	return nil
+}
+
+// files lists the log's files, oldest first; the current file comes last
+func (a *AuditLog) files() ([]string, error) {
+	dirEntries, err := os.ReadDir(a.dir)
+	if os.IsNotExist(err) {
+		return nil, nil // Nothing was logged yet
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list audit log files: %v", err)
+	}
+	var files []string
+	for _, dirEntry := range dirEntries {
+		name := dirEntry.Name()
+		if strings.HasPrefix(name, auditFilePrefix) && strings.HasSuffix(name, auditFileSuffix) {
+			files = append(files, filepath.Join(a.dir, name))
+		}
+	}
+	sort.Strings(files)
+	if _, err := os.Stat(filepath.Join(a.dir, auditFileName)); err == nil {
+		files = append(files, filepath.Join(a.dir, auditFileName))
+	}
+	return files, nil
+}
+
+// readAuditFile calls fn with each entry of a file, in order
+func readAuditFile(path string, fn func(entry *AuditEntry) error) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read audit log: %v", err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	for lineNumber := 1; ; lineNumber++ {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			var entry AuditEntry
+			if err := json.Unmarshal(line, &entry); err != nil {
+				return fmt.Errorf("failed to parse line %d of %s: %v", lineNumber, filepath.Base(path), err)
+			}
+			if err := fn(&entry); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read audit log: %v", err)
+		}
+	}
+}
+
+// hashAuditEntry hashes the entry as JSON without its own hash. PrevHash is
+// part of the JSON, which links the entry to the one before.
+func hashAuditEntry(entry *AuditEntry) (string, error) {
+	unhashed := *entry
+	unhashed.Hash = ""
+	data, err := json.Marshal(unhashed)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,280 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestAuditLog(t *testing.T, dir string, maxSize int64, maxFiles int) *AuditLog {
+	t.Helper()
+	audit, err := NewAuditLog(dir, maxSize, maxFiles)
+	if err != nil {
+		t.Fatalf("NewAuditLog: %v", err)
+	}
+	return audit
+}
+
+// appendQuestions appends count chats asking "question N", numbered from first
+func appendQuestions(t *testing.T, audit *AuditLog, tenant string, first, count int) {
+	t.Helper()
+	for i := first; i < first+count; i++ {
+		err := audit.Append(AuditEntry{
+			Action:    AuditActionChat,
+			Tenant:    tenant,
+			UserID:    "u1",
+			Query:     fmt.Sprintf("question %d", i),
+			Sources:   []string{"confluence"},
+			Documents: []AuditDocument{{ID: "https://wiki.example.com/1", Title: "Deploys", Source: "confluence"}},
+		})
+		if err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+	}
+}
+
+func verify(t *testing.T, audit *AuditLog) *AuditVerification {
+	t.Helper()
+	verification, err := audit.Verify()
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	return verification
+}
+
+// editLines rewrites the current log file through edit
+func editLines(t *testing.T, dir string, edit func(lines []string) []string) {
+	t.Helper()
+	filePath := filepath.Join(dir, auditFileName)
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if err := os.WriteFile(filePath, []byte(strings.Join(edit(lines), "\n")+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAuditAppendAndVerify(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "audit")
+	audit := newTestAuditLog(t, dir, 0, 0)
+
+	// Nothing is created until there is something to record
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("NewAuditLog created %s before the first entry", dir)
+	}
+	if verification := verify(t, audit); !verification.Valid || verification.Entries != 0 {
+		t.Errorf("empty log verification = %+v", verification)
+	}
+
+	appendQuestions(t, audit, DefaultTenant, 1, 3)
+
+	entries, err := audit.Query(AuditFilter{Tenant: DefaultTenant})
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if len(entries) != 3 || entries[0].Seq != 3 || entries[2].PrevHash != "" || entries[1].PrevHash != entries[2].Hash {
+		t.Fatalf("entries = %+v, want three chained entries, newest first", entries)
+	}
+
+	verification := verify(t, audit)
+	if !verification.Valid || verification.Entries != 3 || verification.FirstSeq != 1 || verification.LastSeq != 3 || verification.LastHash != entries[0].Hash {
+		t.Errorf("verification = %+v", verification)
+	}
+	if info, err := os.Stat(filepath.Join(dir, auditFileName)); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("log file = %v, %v, want it readable by the server only", info, err)
+	}
+}
+
+func TestAuditVerifyDetectsTampering(t *testing.T) {
+	tests := []struct {
+		name    string
+		edit    func(lines []string) []string
+		problem string
+	}{
+		{
+			name: "edited line",
+			edit: func(lines []string) []string {
+				lines[1] = strings.Replace(lines[1], "question 2", "question two", 1)
+				return lines
+			},
+			problem: "entry 2 in audit.jsonl was modified",
+		},
+		{
+			name: "deleted middle line",
+			edit: func(lines []string) []string {
+				return append(lines[:1], lines[2:]...)
+			},
+			problem: "entry 3 doesn't follow entry 1 in audit.jsonl",
+		},
+		{
+			name: "reordered lines",
+			edit: func(lines []string) []string {
+				lines[1], lines[2] = lines[2], lines[1]
+				return lines
+			},
+			problem: "entry 3 doesn't follow entry 1 in audit.jsonl",
+		},
+		{
+			// Every remaining entry is intact, but the log knows what it wrote last
+			name: "truncated tail",
+			edit: func(lines []string) []string {
+				return lines[:2]
+			},
+			problem: "the log ends at entry 2 but entry 3 was written",
+		},
+		{
+			name: "garbled line",
+			edit: func(lines []string) []string {
+				lines[2] = lines[2][:20]
+				return lines
+			},
+			problem: "failed to parse line 3 of audit.jsonl",
+		},
+	}
+
+	for _, test := range tests {
+		dir := t.TempDir()
+		audit := newTestAuditLog(t, dir, 0, 0)
+		appendQuestions(t, audit, DefaultTenant, 1, 3)
+
+		editLines(t, dir, test.edit)
+
+		verification := verify(t, audit)
+		if verification.Valid || !strings.Contains(verification.Problem, test.problem) {
+			t.Errorf("%s: verification = %+v, want %q", test.name, verification, test.problem)
+		}
+	}
+}
+
+// The chain carries on across rotated files
+func TestAuditRotation(t *testing.T) {
+	dir := t.TempDir()
+	audit := newTestAuditLog(t, dir, 1, 0) // Every entry starts a new file
+	appendQuestions(t, audit, DefaultTenant, 1, 4)
+
+	files, err := audit.files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 4 || filepath.Base(files[3]) != auditFileName {
+		t.Fatalf("files = %v, want three rotated files and the current one", files)
+	}
+	for _, file := range files[:3] {
+		name := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(file), auditFilePrefix), auditFileSuffix)
+		if _, err := time.Parse(auditRotatedFormat, name); err != nil {
+			t.Errorf("rotated file %s isn't named by its time: %v", file, err)
+		}
+	}
+
+	verification := verify(t, audit)
+	if !verification.Valid || verification.Entries != 4 || verification.FirstSeq != 1 || verification.LastSeq != 4 {
+		t.Errorf("verification = %+v, want the chain verified across files", verification)
+	}
+	entries, err := audit.Query(AuditFilter{Tenant: DefaultTenant})
+	if err != nil || len(entries) != 4 || entries[0].Seq != 4 || entries[3].Seq != 1 {
+		t.Errorf("Query = %+v, %v, want every file read, newest first", entries, err)
+	}
+
+	// Removing a whole rotated file from the middle breaks the chain
+	if err := os.Remove(files[1]); err != nil {
+		t.Fatal(err)
+	}
+	if verification := verify(t, audit); verification.Valid || !strings.Contains(verification.Problem, "entry 3 doesn't follow entry 1") {
+		t.Errorf("verification = %+v, want the missing file noticed", verification)
+	}
+}
+
+// Only maxFiles rotated files are kept; the chain is verified from the oldest left
+func TestAuditRotationPrunesOldFiles(t *testing.T) {
+	dir := t.TempDir()
+	audit := newTestAuditLog(t, dir, 1, 2)
+	appendQuestions(t, audit, DefaultTenant, 1, 5)
+
+	files, err := audit.files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 {
+		t.Errorf("files = %v, want two rotated files and the current one", files)
+	}
+
+	verification := verify(t, audit)
+	if !verification.Valid || verification.Entries != 3 || verification.FirstSeq != 3 || verification.LastSeq != 5 {
+		t.Errorf("verification = %+v, want entries 3 to 5 verified", verification)
+	}
+}
+
+func TestAuditReopenResumesChain(t *testing.T) {
+	dir := t.TempDir()
+	appendQuestions(t, newTestAuditLog(t, dir, 0, 0), DefaultTenant, 1, 2)
+
+	reopened := newTestAuditLog(t, dir, 0, 0)
+	appendQuestions(t, reopened, DefaultTenant, 3, 1)
+
+	entries, err := reopened.Query(AuditFilter{Tenant: DefaultTenant, Limit: 1})
+	if err != nil || len(entries) != 1 || entries[0].Seq != 3 {
+		t.Fatalf("Query = %+v, %v, want the sequence continued", entries, err)
+	}
+	if verification := verify(t, reopened); !verification.Valid || verification.Entries != 3 {
+		t.Errorf("verification = %+v", verification)
+	}
+
+	// The last entry is found in a rotated file when the current one is empty
+	rotatedDir := t.TempDir()
+	appendQuestions(t, newTestAuditLog(t, rotatedDir, 0, 0), DefaultTenant, 1, 2)
+	rotated := auditFilePrefix + time.Now().UTC().Format(auditRotatedFormat) + auditFileSuffix
+	if err := os.Rename(filepath.Join(rotatedDir, auditFileName), filepath.Join(rotatedDir, rotated)); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(rotatedDir, auditFileName), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	reopened = newTestAuditLog(t, rotatedDir, 0, 0)
+	appendQuestions(t, reopened, DefaultTenant, 3, 1)
+	if verification := verify(t, reopened); !verification.Valid || verification.LastSeq != 3 {
+		t.Errorf("verification = %+v, want the chain picked up from the rotated file", verification)
+	}
+}
+
+func TestAuditQueryFilters(t *testing.T) {
+	audit := newTestAuditLog(t, t.TempDir(), 0, 0)
+	appendQuestions(t, audit, "acme", 1, 3)
+	appendQuestions(t, audit, "globex", 4, 2)
+	if err := audit.Append(AuditEntry{Action: AuditActionSearch, Tenant: "acme", UserID: "u2", Query: "Deploy FREEZE"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		filter AuditFilter
+		want   string // Sequence numbers, newest first
+	}{
+		{"tenant", AuditFilter{Tenant: "acme"}, "6,3,2,1"},
+		{"other tenant", AuditFilter{Tenant: "globex"}, "5,4"},
+		{"no tenant", AuditFilter{}, ""},
+		{"user", AuditFilter{Tenant: "acme", UserID: "u2"}, "6"},
+		{"action", AuditFilter{Tenant: "acme", Action: AuditActionChat}, "3,2,1"},
+		{"text", AuditFilter{Tenant: "acme", Contains: "freeze"}, "6"},
+		{"limit", AuditFilter{Tenant: "acme", Limit: 2}, "6,3"},
+		{"since", AuditFilter{Tenant: "acme", Since: time.Now().Add(time.Hour)}, ""},
+		{"until", AuditFilter{Tenant: "acme", Until: time.Now().Add(-time.Hour)}, ""},
+	}
+	for _, test := range tests {
+		entries, err := audit.Query(test.filter)
+		if err != nil {
+			t.Fatalf("%s: Query: %v", test.name, err)
+		}
+		var seqs []string
+		for _, entry := range entries {
+			seqs = append(seqs, fmt.Sprint(entry.Seq))
+		}
+		if got := strings.Join(seqs, ","); got != test.want {
+			t.Errorf("%s: entries %s, want %s", test.name, got, test.want)
+		}
+	}
+}
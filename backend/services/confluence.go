@@ -1,31 +1,50 @@
 package services
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"io"
 	"net/url"
 	"strings"
+	"sync"
+	"time"
 )
 
+// siteCacheTTL controls how long the accessible-resources list is reused for a token
+const siteCacheTTL = 10 * time.Minute
+
 type ConfluenceService struct {
 	ClientID     string
 	ClientSecret string
 	RedirectURL  string
+	APIBaseURL   string // Overridable for tests against a local stub
+
+	siteCacheMu sync.Mutex
+	siteCache   map[string]cachedSites
+}
+
+type cachedSites struct {
+	sites     []ConfluenceResource
+	expiresAt time.Time
 }
 
 type ConfluenceOAuthResponse struct {
-	AccessToken string `json:"access_token"`
-	TokenType   string `json:"token_type"`
-	ExpiresIn   int    `json:"expires_in"`
-	Scope       string `json:"scope"`
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"` // Rotates: every refresh returns a new one
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	Scope        string `json:"scope"`
 }
 
 type ConfluenceResource struct {
-	ID   string `json:"id"`
-	URL  string `json:"url"`
-	Name string `json:"name"`
-	Type string `json:"type"`
+	ID     string   `json:"id"`
+	URL    string   `json:"url"`
+	Name   string   `json:"name"`
+	Type   string   `json:"type"`
+	Scopes []string `json:"scopes"`
 }
 
 type ConfluenceResourcesResponse struct {
@@ -53,11 +72,15 @@ type ConfluenceContent struct {
 		View struct {
 			Value string `json:"value"`
 		} `json:"view"`
+		Storage struct {
+			Value string `json:"value"`
+		} `json:"storage"`
 	} `json:"body"`
 }
 
 type ConfluenceContentDetail struct {
 	ID    string `json:"id"`
+	Type  string `json:"type"`
 	Title string `json:"title"`
 	Body  struct {
 		View struct {
@@ -68,8 +91,87 @@ type ConfluenceContentDetail struct {
 		} `json:"storage"`
 	} `json:"body"`
 	Space struct {
+		Key  string `json:"key"`
 		Name string `json:"name"`
 	} `json:"space"`
+	Ancestors []ConfluenceAncestor `json:"ancestors"`
+	Metadata  struct {
+		Labels struct {
+			Results []ConfluenceLabel `json:"results"`
+		} `json:"labels"`
+	} `json:"metadata"`
+	Links struct {
+		WebUI string `json:"webui"`
+	} `json:"_links"`
+}
+
+type ConfluenceAncestor struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+	Links struct {
+		WebUI string `json:"webui"`
+	} `json:"_links"`
+}
+
+type ConfluenceLabel struct {
+	Prefix string `json:"prefix"`
+	Name   string `json:"name"`
+}
+
+type ConfluenceComment struct {
+	ID      string `json:"id"`
+	Title   string `json:"title"`
+	History struct {
+		CreatedBy struct {
+			DisplayName string `json:"displayName"`
+		} `json:"createdBy"`
+		CreatedDate string `json:"createdDate"`
+	} `json:"history"`
+	Body struct {
+		View struct {
+			Value string `json:"value"`
+		} `json:"view"`
+	} `json:"body"`
+}
+
+type ConfluenceAttachment struct {
+	ID       string `json:"id"`
+	Title    string `json:"title"`
+	Metadata struct {
+		MediaType string `json:"mediaType"`
+	} `json:"metadata"`
+	Extensions struct {
+		MediaType string `json:"mediaType"`
+		FileSize  int64  `json:"fileSize"`
+	} `json:"extensions"`
+	Links struct {
+		Download string `json:"download"`
+		WebUI    string `json:"webui"`
+	} `json:"_links"`
+}
+
+// MediaType returns the attachment media type from whichever field the API filled in
+func (a ConfluenceAttachment) MediaType() string {
+	if a.Metadata.MediaType != "" {
+		return a.Metadata.MediaType
+	}
+	return a.Extensions.MediaType
+}
+
+type confluenceChildResponse struct {
+	Results json.RawMessage `json:"results"`
+}
+
+// ConfluencePageContext is a page together with its breadcrumb, labels,
+// comments, attachment text and the neighbouring pages it can expand to.
+type ConfluencePageContext struct {
+	Detail          *ConfluenceContentDetail
+	Breadcrumb      []string
+	Labels          []string
+	Comments        []ConfluenceComment
+	AttachmentTexts map[string]string // Attachment title -> extracted text
+	Parent          *ConfluenceContentDetail
+	Children        []ConfluenceContentDetail
 }
 
 func NewConfluenceService(clientID, clientSecret, redirectURL string) *ConfluenceService {
@@ -77,6 +179,8 @@ func NewConfluenceService(clientID, clientSecret, redirectURL string) *Confluenc
 		ClientID:     clientID,
 		ClientSecret: clientSecret,
 		RedirectURL:  redirectURL,
+		APIBaseURL:   "https://api.atlassian.com",
+		siteCache:    make(map[string]cachedSites),
 	}
 }
 
@@ -85,7 +189,7 @@ func (cs *ConfluenceService) GetAuthURL(state string) string {
 	params := url.Values{}
 	params.Add("audience", "api.atlassian.com")
 	params.Add("client_id", cs.ClientID)
-	params.Add("scope", "read:confluence-content.all read:confluence-content.summary read:confluence-space.summary search:confluence")
+	params.Add("scope", "offline_access read:confluence-content.all read:confluence-content.summary read:confluence-space.summary search:confluence readonly:content.attachment:confluence read:jira-work read:jira-user")
 	params.Add("redirect_uri", cs.RedirectURL)
 	params.Add("state", state)
 	params.Add("response_type", "code")
@@ -130,8 +234,45 @@ func (cs *ConfluenceService) ExchangeCodeForToken(code string) (*ConfluenceOAuth
 	return &tokenResponse, nil
 }
 
+// RefreshAccessToken trades a refresh token for a new access token. Atlassian
+// rotates refresh tokens, so the returned refresh token replaces the old one.
+func (cs *ConfluenceService) RefreshAccessToken(refreshToken string) (*ConfluenceOAuthResponse, error) {
+	tokenURL := "https://auth.atlassian.com/oauth/token"
+
+	data := url.Values{}
+	data.Set("grant_type", "refresh_token")
+	data.Set("client_id", cs.ClientID)
+	data.Set("client_secret", cs.ClientSecret)
+	data.Set("refresh_token", refreshToken)
+
+	req, err := http.NewRequest("POST", tokenURL, strings.NewReader(data.Encode()))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to refresh token: %w", newStatusError(resp))
+	}
+
+	var tokenResponse ConfluenceOAuthResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResponse); err != nil {
+		return nil, err
+	}
+
+	return &tokenResponse, nil
+}
+
 func (cs *ConfluenceService) GetAccessibleResources(accessToken string) (*ConfluenceResourcesResponse, error) {
-	resourcesURL := "https://api.atlassian.com/oauth/token/accessible-resources"
+	resourcesURL := cs.APIBaseURL + "/oauth/token/accessible-resources"
 
 	req, err := http.NewRequest("GET", resourcesURL, nil)
 	if err != nil {
@@ -149,7 +290,7 @@ func (cs *ConfluenceService) GetAccessibleResources(accessToken string) (*Conflu
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to get accessible resources: %s", resp.Status)
+		return nil, fmt.Errorf("failed to get accessible resources: %w", newStatusError(resp))
 	}
 
 	var resources ConfluenceResourcesResponse
@@ -161,7 +302,7 @@ func (cs *ConfluenceService) GetAccessibleResources(accessToken string) (*Conflu
 }
 
 func (cs *ConfluenceService) SearchContent(accessToken, query, cloudID string) (*ConfluenceSearchResult, error) {
-	searchURL := fmt.Sprintf("https://api.atlassian.com/ex/confluence/%s/rest/api/content/search", cloudID)
+	searchURL := fmt.Sprintf("%s/ex/confluence/%s/rest/api/content/search", cs.APIBaseURL, cloudID)
 
 	params := url.Values{}
 	params.Add("cql", fmt.Sprintf("text ~ \"%s\"", query))
@@ -186,7 +327,7 @@ func (cs *ConfluenceService) SearchContent(accessToken, query, cloudID string) (
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to search Confluence: %s", resp.Status)
+		return nil, fmt.Errorf("failed to search Confluence: %w", newStatusError(resp))
 	}
 
 	var searchResult ConfluenceSearchResult
@@ -198,10 +339,10 @@ func (cs *ConfluenceService) SearchContent(accessToken, query, cloudID string) (
 }
 
 func (cs *ConfluenceService) GetContentDetail(accessToken, contentID, cloudID string) (*ConfluenceContentDetail, error) {
-	contentURL := fmt.Sprintf("https://api.atlassian.com/ex/confluence/%s/rest/api/content/%s", cloudID, contentID)
+	contentURL := fmt.Sprintf("%s/ex/confluence/%s/rest/api/content/%s", cs.APIBaseURL, cloudID, contentID)
 	
 	params := url.Values{}
-	params.Add("expand", "body.view,body.storage,space")
+	params.Add("expand", "body.view,body.storage,space,ancestors,metadata.labels")
 	
 	fullURL := fmt.Sprintf("%s?%s", contentURL, params.Encode())
 
@@ -221,7 +362,7 @@ func (cs *ConfluenceService) GetContentDetail(accessToken, contentID, cloudID st
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to get content detail: %s", resp.Status)
+		return nil, fmt.Errorf("failed to get content detail: %w", newStatusError(resp))
 	}
 
 	var contentDetail ConfluenceContentDetail
@@ -231,3 +372,289 @@ func (cs *ConfluenceService) GetContentDetail(accessToken, contentID, cloudID st
 
 	return &contentDetail, nil
 }
+
+// GetContentComments returns the footer and inline comments of a page, including replies
+func (cs *ConfluenceService) GetContentComments(accessToken, contentID, cloudID string) ([]ConfluenceComment, error) {
+	params := url.Values{}
+	params.Add("expand", "body.view,history")
+	params.Add("depth", "all")
+	params.Add("limit", "25")
+
+	var comments []ConfluenceComment
+	if err := cs.getChildren(accessToken, cloudID, contentID, "comment", params, &comments); err != nil {
+		return nil, fmt.Errorf("failed to get comments: %v", err)
+	}
+	return comments, nil
+}
+
+// GetChildPages returns the direct child pages of a page
+func (cs *ConfluenceService) GetChildPages(accessToken, contentID, cloudID string) ([]ConfluenceContentDetail, error) {
+	params := url.Values{}
+	params.Add("expand", "body.view,body.storage,space")
+	params.Add("limit", "10")
+
+	var children []ConfluenceContentDetail
+	if err := cs.getChildren(accessToken, cloudID, contentID, "page", params, &children); err != nil {
+		return nil, fmt.Errorf("failed to get child pages: %v", err)
+	}
+	return children, nil
+}
+
+// GetAttachments lists the attachments of a page
+func (cs *ConfluenceService) GetAttachments(accessToken, contentID, cloudID string) ([]ConfluenceAttachment, error) {
+	params := url.Values{}
+	params.Add("limit", "25")
+
+	var attachments []ConfluenceAttachment
+	if err := cs.getChildren(accessToken, cloudID, contentID, "attachment", params, &attachments); err != nil {
+		return nil, fmt.Errorf("failed to get attachments: %v", err)
+	}
+	return attachments, nil
+}
+
+// DownloadAttachment fetches the binary content of an attachment
+func (cs *ConfluenceService) DownloadAttachment(accessToken, contentID, attachmentID, cloudID string) ([]byte, error) {
+	downloadURL := fmt.Sprintf("%s/ex/confluence/%s/rest/api/content/%s/child/attachment/%s/download", cs.APIBaseURL, cloudID, contentID, attachmentID)
+
+	req, err := http.NewRequest("GET", downloadURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Add("Authorization", "Bearer "+accessToken)
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download attachment: %s", resp.Status)
+	}
+
+	return io.ReadAll(io.LimitReader(resp.Body, maxDocumentBytes+1))
+}
+
+// GetPageContext loads everything around a page that can answer a question:
+// the ancestor breadcrumb, labels, comments, readable attachments, the parent
+// page and the child pages. Only a failure to read the page itself is returned;
+// the other parts are left empty when they cannot be loaded.
+func (cs *ConfluenceService) GetPageContext(accessToken, contentID, cloudID string) (*ConfluencePageContext, error) {
+	detail, err := cs.GetContentDetail(accessToken, contentID, cloudID)
+	if err != nil {
+		return nil, err
+	}
+
+	pageContext := &ConfluencePageContext{
+		Detail:          detail,
+		AttachmentTexts: make(map[string]string),
+	}
+
+	for _, ancestor := range detail.Ancestors {
+		pageContext.Breadcrumb = append(pageContext.Breadcrumb, ancestor.Title)
+	}
+	for _, label := range detail.Metadata.Labels.Results {
+		pageContext.Labels = append(pageContext.Labels, label.Name)
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+
+	wg.Add(4)
+	go func() {
+		defer wg.Done()
+		if comments, err := cs.GetContentComments(accessToken, contentID, cloudID); err == nil {
+			mu.Lock()
+			pageContext.Comments = comments
+			mu.Unlock()
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		if children, err := cs.GetChildPages(accessToken, contentID, cloudID); err == nil {
+			mu.Lock()
+			pageContext.Children = children
+			mu.Unlock()
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		// The closest ancestor is the parent page
+		if len(detail.Ancestors) == 0 {
+			return
+		}
+		parentID := detail.Ancestors[len(detail.Ancestors)-1].ID
+		if parent, err := cs.GetContentDetail(accessToken, parentID, cloudID); err == nil {
+			mu.Lock()
+			pageContext.Parent = parent
+			mu.Unlock()
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		attachments, err := cs.GetAttachments(accessToken, contentID, cloudID)
+		if err != nil {
+			return
+		}
+		for _, attachment := range attachments {
+			if !IsSupportedDocument(attachment.Title, attachment.MediaType()) {
+				continue
+			}
+			if attachment.Extensions.FileSize > maxDocumentBytes {
+				continue
+			}
+			data, err := cs.DownloadAttachment(accessToken, contentID, attachment.ID, cloudID)
+			if err != nil {
+				continue
+			}
+			text, err := ExtractDocumentText(attachment.Title, attachment.MediaType(), data)
+			if err != nil || text == "" {
+				continue
+			}
+			mu.Lock()
+			pageContext.AttachmentTexts[attachment.Title] = text
+			mu.Unlock()
+		}
+	}()
+	wg.Wait()
+
+	return pageContext, nil
+}
+
+// getChildren fetches /content/{id}/child/{childType} and decodes the results into out
+func (cs *ConfluenceService) getChildren(accessToken, cloudID, contentID, childType string, params url.Values, out interface{}) error {
+	childURL := fmt.Sprintf("%s/ex/confluence/%s/rest/api/content/%s/child/%s", cs.APIBaseURL, cloudID, contentID, childType)
+	fullURL := fmt.Sprintf("%s?%s", childURL, params.Encode())
+
+	req, err := http.NewRequest("GET", fullURL, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Add("Authorization", "Bearer "+accessToken)
+	req.Header.Add("Accept", "application/json")
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var children confluenceChildResponse
+	if err := json.NewDecoder(resp.Body).Decode(&children); err != nil {
+		return err
+	}
+
+	return json.Unmarshal(children.Results, out)
+}
+
+// GetSites returns the Atlassian sites the token can access, cached per token
+// so accessible-resources is not called on every query.
+func (cs *ConfluenceService) GetSites(accessToken string) ([]ConfluenceResource, error) {
+	key := tokenCacheKey(accessToken)
+	now := time.Now()
+
+	cs.siteCacheMu.Lock()
+	if cached, ok := cs.siteCache[key]; ok && now.Before(cached.expiresAt) {
+		cs.siteCacheMu.Unlock()
+		return cached.sites, nil
+	}
+	cs.siteCacheMu.Unlock()
+
+	resources, err := cs.GetAccessibleResources(accessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	cs.siteCacheMu.Lock()
+	defer cs.siteCacheMu.Unlock()
+
+	// Drop expired entries so the cache doesn't grow with every token ever seen
+	for k, cached := range cs.siteCache {
+		if now.After(cached.expiresAt) {
+			delete(cs.siteCache, k)
+		}
+	}
+	cs.siteCache[key] = cachedSites{
+		sites:     resources.Values,
+		expiresAt: now.Add(siteCacheTTL),
+	}
+
+	return resources.Values, nil
+}
+
+// FilterSites keeps the sites matching any of the selectors. A selector may be
+// the cloud ID, the site name or the site host (e.g. "acme.atlassian.net").
+// An empty selector list keeps every site.
+func FilterSites(sites []ConfluenceResource, selectors []string) []ConfluenceResource {
+	if len(selectors) == 0 {
+		return sites
+	}
+
+	var filtered []ConfluenceResource
+	for _, site := range sites {
+		host := site.URL
+		if parsed, err := url.Parse(site.URL); err == nil && parsed.Host != "" {
+			host = parsed.Host
+		}
+
+		for _, selector := range selectors {
+			selector = strings.TrimSpace(selector)
+			if selector == "" {
+				continue
+			}
+			if strings.EqualFold(selector, site.ID) ||
+				strings.EqualFold(selector, site.Name) ||
+				strings.EqualFold(selector, host) {
+				filtered = append(filtered, site)
+				break
+			}
+		}
+	}
+
+	return filtered
+}
+
+// ConfluenceSites keeps the sites the token was granted Confluence scopes on.
+// Sites listed without scopes are kept for backwards compatibility.
+func ConfluenceSites(sites []ConfluenceResource) []ConfluenceResource {
+	var confluenceSites []ConfluenceResource
+	for _, site := range sites {
+		if len(site.Scopes) == 0 {
+			confluenceSites = append(confluenceSites, site)
+			continue
+		}
+		for _, scope := range site.Scopes {
+			if strings.Contains(scope, "confluence") {
+				confluenceSites = append(confluenceSites, site)
+				break
+			}
+		}
+	}
+	return confluenceSites
+}
+
+// tokenCacheKey hashes a token so raw credentials are not kept as map keys
+func tokenCacheKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// PageMarkdown converts a page body to Markdown, preferring the storage format
+// because macros are easier to unwrap there than in the rendered view
+func PageMarkdown(storage, view string) string {
+	if storage != "" {
+		return ConfluenceToMarkdown(storage)
+	}
+	if view != "" {
+		return ConfluenceToMarkdown(view)
+	}
+	return ""
+}
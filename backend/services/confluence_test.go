@@ -0,0 +1,303 @@
+package services
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFilterSites(t *testing.T) {
+	sites := []ConfluenceResource{
+		{ID: "c1", Name: "acme", URL: "https://acme.atlassian.net"},
+		{ID: "c2", Name: "Acme Labs", URL: "https://acme-labs.atlassian.net"},
+		{ID: "c3", Name: "globex", URL: "https://globex.atlassian.net"},
+	}
+
+	tests := []struct {
+		selectors []string
+		want      string // IDs of the kept sites
+	}{
+		{nil, "c1,c2,c3"},
+		{[]string{"c3"}, "c3"},
+		{[]string{"ACME LABS"}, "c2"},
+		{[]string{"globex.atlassian.net", " c1 "}, "c1,c3"},
+		{[]string{"acme-labs.atlassian.net", "acme-labs"}, "c2"},
+		{[]string{"initech"}, ""},
+		{[]string{""}, ""},
+	}
+	for _, test := range tests {
+		var ids []string
+		for _, site := range FilterSites(sites, test.selectors) {
+			ids = append(ids, site.ID)
+		}
+		if got := strings.Join(ids, ","); got != test.want {
+			t.Errorf("FilterSites(%q) = %s, want %s", test.selectors, got, test.want)
+		}
+	}
+}
+
+func TestConfluenceSitesKeepsConfluenceScopes(t *testing.T) {
+	sites := []ConfluenceResource{
+		{ID: "wiki", Scopes: []string{"read:confluence-content.all"}},
+		{ID: "jira", Scopes: []string{"read:jira-work"}},
+		{ID: "legacy"},
+	}
+
+	var ids []string
+	for _, site := range ConfluenceSites(sites) {
+		ids = append(ids, site.ID)
+	}
+	if got := strings.Join(ids, ","); got != "wiki,legacy" {
+		t.Errorf("ConfluenceSites = %s, want the Confluence site and the one listed without scopes", got)
+	}
+}
+
+// accessible-resources is fetched once per token until the cache expires
+func TestGetSitesIsCachedPerToken(t *testing.T) {
+	var mu sync.Mutex
+	fetches := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/oauth/token/accessible-resources" {
+			t.Errorf("unexpected request %s", r.URL)
+			http.NotFound(w, r)
+			return
+		}
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		mu.Lock()
+		fetches[token]++
+		mu.Unlock()
+		fmt.Fprintf(w, `[{"id": "site-%s", "name": "%s", "url": "https://%s.atlassian.net"}]`, token, token, token)
+	}))
+	defer server.Close()
+
+	cs := NewConfluenceService("id", "secret", "")
+	cs.APIBaseURL = server.URL
+
+	for i := 0; i < 3; i++ {
+		for _, token := range []string{"ada", "grace"} {
+			sites, err := cs.GetSites(token)
+			if err != nil {
+				t.Fatalf("GetSites(%s): %v", token, err)
+			}
+			if len(sites) != 1 || sites[0].ID != "site-"+token {
+				t.Errorf("GetSites(%s) = %+v, want the token's own sites", token, sites)
+			}
+		}
+	}
+	if fetches["ada"] != 1 || fetches["grace"] != 1 {
+		t.Errorf("accessible-resources fetches = %v, want one per token", fetches)
+	}
+
+	// Raw tokens aren't kept as cache keys
+	for key := range cs.siteCache {
+		if key == "ada" || key == "grace" {
+			t.Errorf("site cache is keyed by the raw token %q", key)
+		}
+	}
+
+	// An expired entry is fetched again
+	key := tokenCacheKey("ada")
+	cs.siteCache[key] = cachedSites{sites: cs.siteCache[key].sites, expiresAt: time.Now().Add(-time.Second)}
+	if _, err := cs.GetSites("ada"); err != nil {
+		t.Fatalf("GetSites: %v", err)
+	}
+	if fetches["ada"] != 2 {
+		t.Errorf("ada's sites were fetched %d times, want a refetch after expiry", fetches["ada"])
+	}
+}
+
+// A failed lookup isn't cached, so the next query tries again
+func TestGetSitesDoesNotCacheErrors(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`[{"id": "c1", "name": "acme", "url": "https://acme.atlassian.net"}]`))
+	}))
+	defer server.Close()
+
+	cs := NewConfluenceService("id", "secret", "")
+	cs.APIBaseURL = server.URL
+
+	if _, err := cs.GetSites("token"); err == nil {
+		t.Fatalf("GetSites succeeded on a 503")
+	}
+	if sites, err := cs.GetSites("token"); err != nil || len(sites) != 1 {
+		t.Errorf("GetSites = %+v, %v after the error, want the sites", sites, err)
+	}
+}
+
+func TestExtractAttachmentText(t *testing.T) {
+	tests := []struct {
+		file      string
+		mediaType string
+		want      []string
+	}{
+		{"rollback.pdf", "application/pdf", []string{"Rollback plan", "Restore the", "previous release"}},
+		{"oncall.docx", "application/vnd.openxmlformats-officedocument.wordprocessingml.document", []string{"On-call checklist\nPage the incident lead"}},
+		// The extension is used when the media type is generic
+		{"oncall.docx", "application/octet-stream", []string{"On-call checklist"}},
+	}
+	for _, test := range tests {
+		data, err := os.ReadFile(filepath.Join("testdata", "attachments", test.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		text, err := ExtractDocumentText(test.file, test.mediaType, data)
+		if err != nil {
+			t.Errorf("ExtractDocumentText(%s, %s): %v", test.file, test.mediaType, err)
+			continue
+		}
+		for _, want := range test.want {
+			if !strings.Contains(text, want) {
+				t.Errorf("text of %s = %q, want it to contain %q", test.file, text, want)
+			}
+		}
+	}
+
+	if _, err := ExtractDocumentText("rollback.pdf", "application/pdf", []byte("not a pdf")); err == nil {
+		t.Errorf("a file without the PDF header was read")
+	}
+}
+
+// GetPageContext gathers the breadcrumb, labels, comments, parent, children and
+// the text of readable attachments around a page
+func TestGetPageContext(t *testing.T) {
+	pdf, err := os.ReadFile(filepath.Join("testdata", "attachments", "rollback.pdf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	docx, err := os.ReadFile(filepath.Join("testdata", "attachments", "oncall.docx"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	downloads := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			t.Errorf("%s sent without the token", r.URL)
+		}
+		switch strings.TrimPrefix(r.URL.Path, "/ex/confluence/cloud1/rest/api/content/") {
+		case "42":
+			if expand := r.URL.Query().Get("expand"); !strings.Contains(expand, "ancestors") || !strings.Contains(expand, "metadata.labels") {
+				t.Errorf("expand = %q, want ancestors and labels", expand)
+			}
+			w.Write([]byte(`{"id": "42", "title": "Deploys", "body": {"view": {"value": "<p>How we deploy</p>"}},
+				"ancestors": [{"id": "1", "title": "Engineering"}, {"id": "7", "title": "Runbooks"}],
+				"metadata": {"labels": {"results": [{"name": "ops"}, {"name": "deploy"}]}}}`))
+		case "7":
+			w.Write([]byte(`{"id": "7", "title": "Runbooks"}`))
+		case "42/child/comment":
+			if r.URL.Query().Get("depth") != "all" {
+				t.Errorf("comments aren't fetched with their replies: %s", r.URL.RawQuery)
+			}
+			w.Write([]byte(`{"results": [{"id": "c1", "history": {"createdBy": {"displayName": "Grace"}},
+				"body": {"view": {"value": "<p>Freeze on Fridays</p>"}}}]}`))
+		case "42/child/page":
+			w.Write([]byte(`{"results": [{"id": "43", "title": "Deploying the API"}]}`))
+		case "42/child/attachment":
+			w.Write([]byte(`{"results": [
+				{"id": "a1", "title": "rollback.pdf", "metadata": {"mediaType": "application/pdf"}, "extensions": {"fileSize": 664}},
+				{"id": "a2", "title": "oncall.docx", "extensions": {"mediaType": "application/vnd.openxmlformats-officedocument.wordprocessingml.document", "fileSize": 668}},
+				{"id": "a3", "title": "diagram.png", "metadata": {"mediaType": "image/png"}},
+				{"id": "a4", "title": "dump.pdf", "metadata": {"mediaType": "application/pdf"}, "extensions": {"fileSize": 104857600}},
+				{"id": "a5", "title": "broken.pdf", "metadata": {"mediaType": "application/pdf"}}
+			]}`))
+		case "42/child/attachment/a1/download", "42/child/attachment/a2/download", "42/child/attachment/a5/download":
+			id := strings.Split(r.URL.Path, "/")[10]
+			mu.Lock()
+			downloads[id]++
+			mu.Unlock()
+			switch id {
+			case "a1":
+				w.Write(pdf)
+			case "a2":
+				w.Write(docx)
+			default:
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+		default:
+			t.Errorf("unexpected request %s", r.URL)
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	cs := NewConfluenceService("id", "secret", "")
+	cs.APIBaseURL = server.URL
+
+	pageContext, err := cs.GetPageContext("token", "42", "cloud1")
+	if err != nil {
+		t.Fatalf("GetPageContext: %v", err)
+	}
+
+	if got := strings.Join(pageContext.Breadcrumb, " > "); got != "Engineering > Runbooks" {
+		t.Errorf("breadcrumb = %q, want the ancestors in order", got)
+	}
+	if got := strings.Join(pageContext.Labels, ","); got != "ops,deploy" {
+		t.Errorf("labels = %q", got)
+	}
+	if pageContext.Parent == nil || pageContext.Parent.ID != "7" {
+		t.Errorf("parent = %+v, want the closest ancestor", pageContext.Parent)
+	}
+	if len(pageContext.Children) != 1 || pageContext.Children[0].ID != "43" {
+		t.Errorf("children = %+v", pageContext.Children)
+	}
+	if len(pageContext.Comments) != 1 || pageContext.Comments[0].History.CreatedBy.DisplayName != "Grace" {
+		t.Errorf("comments = %+v", pageContext.Comments)
+	}
+
+	if len(pageContext.AttachmentTexts) != 2 {
+		t.Errorf("attachment texts = %q, want the PDF and the DOCX", pageContext.AttachmentTexts)
+	}
+	if text := pageContext.AttachmentTexts["rollback.pdf"]; !strings.Contains(text, "Rollback plan") {
+		t.Errorf("rollback.pdf text = %q", text)
+	}
+	if text := pageContext.AttachmentTexts["oncall.docx"]; !strings.Contains(text, "incident lead") {
+		t.Errorf("oncall.docx text = %q", text)
+	}
+	// Images and attachments over the size limit are never downloaded
+	if downloads["a3"] != 0 || downloads["a4"] != 0 || downloads["a1"] != 1 || downloads["a2"] != 1 {
+		t.Errorf("downloads = %v", downloads)
+	}
+}
+
+// Only a page that can't be read fails; missing parts are left empty
+func TestGetPageContextToleratesMissingParts(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ex/confluence/cloud1/rest/api/content/42":
+			w.Write([]byte(`{"id": "42", "title": "Deploys"}`))
+		case "/ex/confluence/cloud1/rest/api/content/404":
+			http.NotFound(w, r)
+		default:
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer server.Close()
+
+	cs := NewConfluenceService("id", "secret", "")
+	cs.APIBaseURL = server.URL
+
+	pageContext, err := cs.GetPageContext("token", "42", "cloud1")
+	if err != nil {
+		t.Fatalf("GetPageContext: %v", err)
+	}
+	if pageContext.Parent != nil || len(pageContext.Comments) != 0 || len(pageContext.Children) != 0 || len(pageContext.AttachmentTexts) != 0 {
+		t.Errorf("page context = %+v, want only the page", pageContext)
+	}
+
+	if _, err := cs.GetPageContext("token", "404", "cloud1"); err == nil {
+		t.Errorf("GetPageContext succeeded for a missing page")
+	}
+}
@@ -0,0 +1,207 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxConversationTitle cuts the title taken from a conversation's first question
+const maxConversationTitle = 80
+
+var ErrConversationNotFound = errors.New("conversation not found")
+
+// ConversationMessage is a question or an answer in a conversation
+type ConversationMessage struct {
+	Role       string                  `json:"role"` // "user" or "assistant"
+	Content    string                  `json:"content"`
+	References []ConversationReference `json:"references,omitempty"`
+	Time       time.Time               `json:"time"`
+}
+
+// ConversationReference is a source an answer was based on
+type ConversationReference struct {
+	Title  string `json:"title"`
+	URL    string `json:"url"`
+	Source string `json:"source"`
+}
+
+// Conversation is a user's chat history
+type Conversation struct {
+	ID       string                `json:"id"`
+	Tenant   string                `json:"tenant"`
+	UserID   string                `json:"user_id"`
+	Title    string                `json:"title"`
+	Messages []ConversationMessage `json:"messages,omitempty"`
+	Created  time.Time             `json:"created"`
+	Updated  time.Time             `json:"updated"`
+}
+
+// ConversationStore keeps users' conversations, persisted as JSON. Every
+// lookup is scoped to the tenant and user, so one user can never read or
+// extend another's conversation, even with its ID.
+type ConversationStore struct {
+	path          string
+	mu            sync.Mutex
+	conversations map[string]*Conversation
+}
+
+// NewConversationStore opens the store at path; an empty path keeps it in memory
+func NewConversationStore(path string) (*ConversationStore, error) {
+	store := &ConversationStore{
+		path:          path,
+		conversations: make(map[string]*Conversation),
+	}
+
+	if path == "" {
+		return store, nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history: %v", err)
+	}
+
+	var conversations []*Conversation
+	if err := json.Unmarshal(data, &conversations); err != nil {
+		return nil, fmt.Errorf("failed to parse history: %v", err)
+	}
+	for _, conversation := range conversations {
+		store.conversations[conversation.ID] = conversation
+	}
+	return store, nil
+}
+
+// Append adds messages to a user's conversation, starting a new one when id
+// is empty, and returns the conversation's ID
+func (cs *ConversationStore) Append(tenant, userID, id string, messages ...ConversationMessage) (string, error) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	now := time.Now().UTC()
+	var conversation *Conversation
+	if id != "" {
+		conversation = cs.find(tenant, userID, id)
+		if conversation == nil {
+			return "", ErrConversationNotFound
+		}
+	} else {
+		newID, err := randomID()
+		if err != nil {
+			return "", err
+		}
+		conversation = &Conversation{ID: newID, Tenant: tenant, UserID: userID, Created: now}
+		cs.conversations[newID] = conversation
+	}
+
+	for _, message := range messages {
+		if message.Time.IsZero() {
+			message.Time = now
+		}
+		if conversation.Title == "" && message.Role == "user" {
+			conversation.Title = TruncateText(message.Content, maxConversationTitle)
+		}
+		conversation.Messages = append(conversation.Messages, message)
+	}
+	conversation.Updated = now
+
+	return conversation.ID, cs.save()
+}
+
+// List returns a user's conversations without their messages, most recent first
+func (cs *ConversationStore) List(tenant, userID string) []Conversation {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	list := []Conversation{}
+	for _, conversation := range cs.conversations {
+		if conversation.Tenant == tenant && conversation.UserID == userID {
+			summary := *conversation
+			summary.Messages = nil
+			list = append(list, summary)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Updated.After(list[j].Updated) })
+	return list
+}
+
+// Get returns a copy of a user's conversation
+func (cs *ConversationStore) Get(tenant, userID, id string) (Conversation, bool) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	conversation := cs.find(tenant, userID, id)
+	if conversation == nil {
+		return Conversation{}, false
+	}
+	copied := *conversation
+	copied.Messages = append([]ConversationMessage(nil), conversation.Messages...)
+	return copied, true
+}
+
+// Delete removes a user's conversation
+func (cs *ConversationStore) Delete(tenant, userID, id string) error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	if cs.find(tenant, userID, id) == nil {
+		return ErrConversationNotFound
+	}
+	delete(cs.conversations, id)
+	return cs.save()
+}
+
+// DeleteUser removes every conversation of a user
+func (cs *ConversationStore) DeleteUser(tenant, userID string) error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	for id, conversation := range cs.conversations {
+		if conversation.Tenant == tenant && conversation.UserID == userID {
+			delete(cs.conversations, id)
+		}
+	}
+	return cs.save()
+}
+
+// find looks a conversation up within a tenant and user. Callers hold mu.
+func (cs *ConversationStore) find(tenant, userID, id string) *Conversation {
+	conversation, ok := cs.conversations[id]
+	if !ok || conversation.Tenant != tenant || conversation.UserID != userID {
+		return nil
+	}
+	return conversation
+}
+
+// save writes the store atomically. Callers hold mu.
+func (cs *ConversationStore) save() error {
+	if cs.path == "" {
+		return nil
+	}
+
+	conversations := make([]*Conversation, 0, len(cs.conversations))
+	for _, conversation := range cs.conversations {
+		conversations = append(conversations, conversation)
+	}
+	sort.Slice(conversations, func(i, j int) bool { return conversations[i].ID < conversations[j].ID })
+
+	data, err := json.Marshal(conversations)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(cs.path), 0o700); err != nil {
+		return err
+	}
+	tmp := cs.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, cs.path)
+}
@@ -0,0 +1,635 @@
+package services
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// crawlerSource is the Source of documents indexed by the crawler
+const crawlerSource = "web"
+
+// maxCrawlPageBytes skips very large pages
+const maxCrawlPageBytes = 5 << 20
+
+// CrawlerService crawls internal documentation sites into a LocalIndex. It
+// starts from seed URLs and sitemaps, stays on the seeds' hosts (and path
+// prefixes, when set), respects robots.txt and revalidates pages with
+// conditional GETs on later crawls.
+type CrawlerService struct {
+	Seeds           []string
+	Sitemaps        []string // Extra sitemap URLs; /sitemap.xml and robots.txt sitemaps are found automatically
+	PathPrefixes    []string // Only URLs under these paths are crawled; empty means the whole host
+	MaxDepth        int      // Link hops from a seed or sitemap entry
+	MaxPages        int
+	RequestInterval time.Duration // Minimum time between requests to the same host
+	RecrawlInterval time.Duration
+	UserAgent       string
+	Index           *LocalIndex
+	Client          *http.Client
+
+	mu          sync.Mutex
+	robots      map[string]*robotsRules // host -> rules
+	lastRequest map[string]time.Time    // host -> time of the last request
+}
+
+type crawlItem struct {
+	url   string
+	depth int
+}
+
+func NewCrawlerService(seeds, sitemaps, pathPrefixes []string, maxDepth, maxPages int, requestInterval, recrawlInterval time.Duration, index *LocalIndex) *CrawlerService {
+	if maxDepth == 0 {
+		maxDepth = 3
+	}
+	if maxPages == 0 {
+		maxPages = 500
+	}
+	if recrawlInterval == 0 {
+		recrawlInterval = time.Hour
+	}
+	return &CrawlerService{
+		Seeds:           seeds,
+		Sitemaps:        sitemaps,
+		PathPrefixes:    pathPrefixes,
+		MaxDepth:        maxDepth,
+		MaxPages:        maxPages,
+		RequestInterval: requestInterval,
+		RecrawlInterval: recrawlInterval,
+		UserAgent:       "rag-chatbot-crawler/1.0",
+		Index:           index,
+		Client:          &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Watch crawls immediately and then every RecrawlInterval until stop is closed
+func (cs *CrawlerService) Watch(stop <-chan struct{}) {
+	if err := cs.Crawl(); err != nil {
+		log.Printf("Crawl error: %v", err)
+	}
+
+	ticker := time.NewTicker(cs.RecrawlInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := cs.Crawl(); err != nil {
+				log.Printf("Crawl error: %v", err)
+			}
+		}
+	}
+}
+
+// Crawl visits every reachable page once. Unchanged pages are answered with
+// 304 Not Modified and keep their indexed content; pages that disappeared are
+// removed from the index.
+func (cs *CrawlerService) Crawl() error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	// robots.txt may change between crawls
+	cs.robots = make(map[string]*robotsRules)
+	if cs.lastRequest == nil {
+		cs.lastRequest = make(map[string]time.Time)
+	}
+
+	allowedHosts := map[string]bool{}
+	var queue []crawlItem
+	for _, seed := range cs.Seeds {
+		normalized, ok := normalizeCrawlURL(seed, nil)
+		if !ok {
+			return fmt.Errorf("invalid seed URL: %s", seed)
+		}
+		parsed, _ := url.Parse(normalized)
+		allowedHosts[parsed.Host] = true
+		queue = append(queue, crawlItem{url: normalized})
+	}
+
+	// Sitemap entries are crawled like seeds
+	for _, pageURL := range cs.sitemapURLs(allowedHosts) {
+		if normalized, ok := normalizeCrawlURL(pageURL, nil); ok {
+			queue = append(queue, crawlItem{url: normalized})
+		}
+	}
+
+	seen := map[string]bool{}
+	visited := map[string]bool{}
+	changed := 0
+	truncated := false
+
+	for len(queue) > 0 {
+		item := queue[0]
+		queue = queue[1:]
+		if seen[item.url] || !cs.inScope(item.url, allowedHosts) {
+			continue
+		}
+		seen[item.url] = true
+
+		if len(visited) >= cs.MaxPages {
+			truncated = true
+			break
+		}
+		visited[item.url] = true
+
+		links, updated, err := cs.visit(item.url)
+		if err != nil {
+			log.Printf("Failed to crawl %s: %v", item.url, err)
+			continue
+		}
+		if updated {
+			changed++
+		}
+
+		if item.depth < cs.MaxDepth {
+			for _, link := range links {
+				if !seen[link] {
+					queue = append(queue, crawlItem{url: link, depth: item.depth + 1})
+				}
+			}
+		}
+	}
+
+	// Forget pages that can no longer be reached, unless the crawl stopped early
+	if !truncated {
+		for _, doc := range cs.Index.Documents(func(doc *IndexedDocument) bool { return doc.Source == crawlerSource }) {
+			if !visited[doc.ID] {
+				cs.Index.Delete(doc.ID)
+				changed++
+			}
+		}
+	}
+
+	log.Printf("Crawl visited %d pages, %d changed", len(visited), changed)
+	if changed > 0 {
+		return cs.Index.Save()
+	}
+	return nil
+}
+
+// inScope checks the host, path prefix and robots.txt rules for a URL
+func (cs *CrawlerService) inScope(pageURL string, allowedHosts map[string]bool) bool {
+	parsed, err := url.Parse(pageURL)
+	if err != nil || !allowedHosts[parsed.Host] {
+		return false
+	}
+
+	if len(cs.PathPrefixes) > 0 {
+		matched := false
+		for _, prefix := range cs.PathPrefixes {
+			if strings.HasPrefix(parsed.Path, prefix) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	return cs.robotsFor(parsed).allowed(parsed.RequestURI())
+}
+
+// visit fetches a page, indexing it when it changed, and returns its links
+func (cs *CrawlerService) visit(pageURL string) (links []string, updated bool, err error) {
+	existing, hasExisting := cs.Index.Get(pageURL)
+
+	req, err := http.NewRequest("GET", pageURL, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	req.Header.Set("Accept", "text/html,application/xhtml+xml,text/plain;q=0.8")
+	// A page indexed without permissions is fetched in full so it gets them
+	if hasExisting && len(existing.ACL) > 0 {
+		if etag := existing.Metadata["etag"]; etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		if lastModified := existing.Metadata["last_modified"]; lastModified != "" {
+			req.Header.Set("If-Modified-Since", lastModified)
+		}
+	}
+
+	resp, err := cs.do(req)
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && hasExisting:
+		// Links were saved with the page so unchanged pages still lead somewhere
+		return splitLines(existing.Metadata["links"]), false, nil
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		if hasExisting {
+			cs.Index.Delete(pageURL)
+			return nil, true, nil
+		}
+		return nil, false, nil
+	case resp.StatusCode != http.StatusOK:
+		return nil, false, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	// Redirects may leave the crawl's hosts; index under the final URL only if it's the same page
+	finalURL, _ := normalizeCrawlURL(resp.Request.URL.String(), nil)
+	if finalURL != pageURL {
+		return []string{finalURL}, false, nil
+	}
+
+	mediaType := strings.ToLower(strings.TrimSpace(strings.Split(resp.Header.Get("Content-Type"), ";")[0]))
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" && mediaType != "text/plain" {
+		return nil, false, nil
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxCrawlPageBytes))
+	if err != nil {
+		return nil, false, err
+	}
+
+	page := parseCrawledPage(resp.Request.URL, mediaType, string(data))
+	if page.noFollow {
+		page.links = nil
+	}
+	if page.noIndex {
+		if hasExisting {
+			cs.Index.Delete(pageURL)
+		}
+		return page.links, hasExisting, nil
+	}
+
+	cs.Index.Upsert(IndexedDocument{
+		ID:       pageURL,
+		Source:   crawlerSource,
+		ACL:      PublicACL(),
+		Title:    page.title,
+		Content:  page.content,
+		URL:      pageURL,
+		Modified: time.Now().UTC(),
+		Metadata: map[string]string{
+			"etag":          resp.Header.Get("ETag"),
+			"last_modified": resp.Header.Get("Last-Modified"),
+			"links":         strings.Join(page.links, "\n"),
+		},
+	})
+
+	return page.links, true, nil
+}
+
+// do sends a request, waiting as long as the host's rate limit requires
+func (cs *CrawlerService) do(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+	interval := cs.RequestInterval
+	if rules := cs.robots[host]; rules != nil && rules.crawlDelay > interval {
+		interval = rules.crawlDelay
+	}
+	if wait := time.Until(cs.lastRequest[host].Add(interval)); wait > 0 {
+		time.Sleep(wait)
+	}
+	cs.lastRequest[host] = time.Now()
+
+	req.Header.Set("User-Agent", cs.UserAgent)
+	return cs.Client.Do(req)
+}
+
+func (cs *CrawlerService) fetch(rawURL string) ([]byte, error) {
+	req, err := http.NewRequest("GET", rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := cs.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxCrawlPageBytes))
+}
+
+// crawledPage is the text, title and outgoing links of a page
+type crawledPage struct {
+	title    string
+	content  string
+	links    []string
+	noIndex  bool
+	noFollow bool
+}
+
+func parseCrawledPage(base *url.URL, mediaType, body string) crawledPage {
+	if mediaType == "text/plain" {
+		return crawledPage{title: path.Base(base.Path), content: body}
+	}
+
+	page := crawledPage{}
+	root, err := parseHTMLTree(stripDoctype(body))
+	if err != nil {
+		page.content = ExtractPlainText(body)
+		page.title = base.String()
+		return page
+	}
+
+	var titleNode *htmlNode
+	findFirst(root, "title", &titleNode)
+	if titleNode != nil {
+		page.title = strings.TrimSpace(titleNode.textContent())
+	}
+
+	// Prefer the main content area over navigation and footers
+	content := root
+	var mainNode *htmlNode
+	findFirst(root, "main", &mainNode)
+	if mainNode == nil {
+		findFirst(root, "article", &mainNode)
+	}
+	if mainNode != nil {
+		content = mainNode
+	}
+	var md markdownWriter
+	md.blockChildren(content)
+	page.content = md.String()
+
+	if page.title == "" {
+		if match := firstHeading.FindStringSubmatch(page.content); match != nil {
+			page.title = strings.TrimSpace(match[1])
+		} else {
+			page.title = base.String()
+		}
+	}
+
+	seen := map[string]bool{}
+	walkHTML(root, func(n *htmlNode) {
+		switch n.Name {
+		case "meta":
+			if strings.EqualFold(n.Attrs["name"], "robots") {
+				directives := strings.ToLower(n.Attrs["content"])
+				page.noIndex = page.noIndex || strings.Contains(directives, "noindex") || strings.Contains(directives, "none")
+				page.noFollow = page.noFollow || strings.Contains(directives, "nofollow") || strings.Contains(directives, "none")
+			}
+		case "a":
+			if strings.Contains(strings.ToLower(n.Attrs["rel"]), "nofollow") {
+				return
+			}
+			if link, ok := normalizeCrawlURL(n.Attrs["href"], base); ok && !seen[link] {
+				seen[link] = true
+				page.links = append(page.links, link)
+			}
+		}
+	})
+
+	return page
+}
+
+func walkHTML(n *htmlNode, visit func(*htmlNode)) {
+	for _, c := range n.Children {
+		if !c.isText() {
+			visit(c)
+			walkHTML(c, visit)
+		}
+	}
+}
+
+// normalizeCrawlURL resolves a link against base and drops fragments, so the
+// same page is only crawled once
+func normalizeCrawlURL(rawURL string, base *url.URL) (string, bool) {
+	rawURL = strings.TrimSpace(rawURL)
+	if rawURL == "" || strings.HasPrefix(rawURL, "#") {
+		return "", false
+	}
+
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return "", false
+	}
+	if base != nil {
+		parsed = base.ResolveReference(parsed)
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return "", false
+	}
+
+	parsed.Fragment = ""
+	parsed.RawFragment = ""
+	parsed.Host = strings.ToLower(parsed.Host)
+	if parsed.Path == "" {
+		parsed.Path = "/"
+	}
+	return parsed.String(), true
+}
+
+func splitLines(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, "\n")
+}
+
+// Sitemaps
+
+type sitemapDocument struct {
+	XMLName xml.Name
+	URLs    []struct {
+		Loc string `xml:"loc"`
+	} `xml:"url"`
+	Sitemaps []struct {
+		Loc string `xml:"loc"`
+	} `xml:"sitemap"`
+}
+
+// sitemapURLs reads the configured sitemaps, /sitemap.xml on every seed host
+// and sitemaps listed in robots.txt, following sitemap indexes
+func (cs *CrawlerService) sitemapURLs(allowedHosts map[string]bool) []string {
+	queue := append([]string{}, cs.Sitemaps...)
+	for _, seed := range cs.Seeds {
+		parsed, err := url.Parse(seed)
+		if err != nil {
+			continue
+		}
+		root := &url.URL{Scheme: parsed.Scheme, Host: parsed.Host}
+		queue = append(queue, root.String()+"/sitemap.xml")
+		queue = append(queue, cs.robotsFor(parsed).sitemaps...)
+	}
+
+	var pages []string
+	seen := map[string]bool{}
+	for len(queue) > 0 && len(seen) < 50 {
+		sitemapURL := queue[0]
+		queue = queue[1:]
+		if seen[sitemapURL] {
+			continue
+		}
+		seen[sitemapURL] = true
+
+		// Only read sitemaps on the crawl's hosts
+		if parsed, err := url.Parse(sitemapURL); err != nil || !allowedHosts[strings.ToLower(parsed.Host)] {
+			continue
+		}
+
+		data, err := cs.fetch(sitemapURL)
+		if err != nil {
+			continue // Most sites have no sitemap at the default location
+		}
+
+		var sitemap sitemapDocument
+		if err := xml.Unmarshal(data, &sitemap); err != nil {
+			log.Printf("Failed to parse sitemap %s: %v", sitemapURL, err)
+			continue
+		}
+		for _, entry := range sitemap.URLs {
+			pages = append(pages, strings.TrimSpace(entry.Loc))
+		}
+		for _, entry := range sitemap.Sitemaps {
+			queue = append(queue, strings.TrimSpace(entry.Loc))
+		}
+	}
+
+	return pages
+}
+
+// robots.txt
+
+type robotsRule struct {
+	path  string
+	allow bool
+}
+
+type robotsRules struct {
+	rules      []robotsRule
+	crawlDelay time.Duration
+	sitemaps   []string
+}
+
+// robotsFor fetches and caches the robots.txt of a URL's host. A missing or
+// unreadable robots.txt allows everything.
+func (cs *CrawlerService) robotsFor(pageURL *url.URL) *robotsRules {
+	if rules, ok := cs.robots[pageURL.Host]; ok {
+		return rules
+	}
+
+	rules := &robotsRules{}
+	cs.robots[pageURL.Host] = rules
+
+	robotsURL := (&url.URL{Scheme: pageURL.Scheme, Host: pageURL.Host, Path: "/robots.txt"}).String()
+	if data, err := cs.fetch(robotsURL); err == nil {
+		*rules = *parseRobots(string(data), cs.UserAgent)
+	}
+	return rules
+}
+
+// parseRobots keeps the group for our user agent, or the "*" group when no
+// group names us
+func parseRobots(content, userAgent string) *robotsRules {
+	agent := strings.ToLower(strings.Split(userAgent, "/")[0])
+
+	var specific, wildcard robotsRules
+	var current []*robotsRules
+	groupHasRules := false
+	sitemaps := []string{}
+	foundSpecific := false
+
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "user-agent":
+			// Consecutive user-agent lines share one group
+			if groupHasRules {
+				current = nil
+				groupHasRules = false
+			}
+			name := strings.ToLower(value)
+			switch {
+			case name == "*":
+				current = append(current, &wildcard)
+			case strings.Contains(agent, name) || strings.Contains(name, agent):
+				current = append(current, &specific)
+				foundSpecific = true
+			}
+		case "allow", "disallow":
+			groupHasRules = true
+			if value == "" && key == "disallow" {
+				continue // "Disallow:" with no path allows everything
+			}
+			for _, group := range current {
+				group.rules = append(group.rules, robotsRule{path: value, allow: key == "allow"})
+			}
+		case "crawl-delay":
+			groupHasRules = true
+			if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+				for _, group := range current {
+					group.crawlDelay = time.Duration(seconds * float64(time.Second))
+				}
+			}
+		case "sitemap":
+			sitemaps = append(sitemaps, value)
+		}
+	}
+
+	rules := wildcard
+	if foundSpecific {
+		rules = specific
+	}
+	rules.sitemaps = sitemaps
+	return &rules
+}
+
+// allowed applies the longest matching rule; Allow wins ties. Patterns may
+// use "*" wildcards and a trailing "$" anchor.
+func (r *robotsRules) allowed(requestURI string) bool {
+	best := -1
+	allowed := true
+	for _, rule := range r.rules {
+		if !robotsPatternMatches(rule.path, requestURI) {
+			continue
+		}
+		if len(rule.path) > best || (len(rule.path) == best && rule.allow) {
+			best = len(rule.path)
+			allowed = rule.allow
+		}
+	}
+	return allowed
+}
+
+func robotsPatternMatches(pattern, requestURI string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(requestURI, parts[0]) {
+		return false
+	}
+	position := len(parts[0])
+	for _, part := range parts[1:] {
+		i := strings.Index(requestURI[position:], part)
+		if i < 0 {
+			return false
+		}
+		position += i + len(part)
+	}
+
+	if anchored {
+		return position == len(requestURI) || strings.HasSuffix(requestURI, parts[len(parts)-1]) && len(parts) > 1
+	}
+	return true
+}
@@ -0,0 +1,176 @@
+package services
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testSite serves a small documentation site and counts the requests per path
+type testSite struct {
+	server *httptest.Server
+
+	mu          sync.Mutex
+	requests    map[string]int
+	conditional map[string]int // Requests answered with 304 Not Modified
+}
+
+func newTestSite(t *testing.T, otherHost string) *testSite {
+	site := &testSite{requests: map[string]int{}, conditional: map[string]int{}}
+	pages := map[string]string{
+		"/":             `<a href="/docs/a">A</a> <a href="/private/keys">Keys</a> <a href="` + otherHost + `/elsewhere">Elsewhere</a> <a href="/docs/a#intro">A again</a>`,
+		"/docs/a":       `<h1>Page A</h1><a href="/docs/b">B</a>`,
+		"/docs/b":       `<h1>Page B</h1><a href="/docs/c">C</a>`,
+		"/docs/c":       `<h1>Page C</h1>`,
+		"/private/keys": `<h1>Keys</h1>`,
+	}
+
+	site.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		site.mu.Lock()
+		site.requests[r.URL.Path]++
+		site.mu.Unlock()
+
+		if r.URL.Path == "/robots.txt" {
+			fmt.Fprint(w, "User-agent: *\nDisallow: /private/\n")
+			return
+		}
+		body, ok := pages[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		etag := `"` + r.URL.Path + `-v1"`
+		if r.Header.Get("If-None-Match") == etag {
+			site.mu.Lock()
+			site.conditional[r.URL.Path]++
+			site.mu.Unlock()
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprintf(w, "<html><head><title>%s</title></head><body><main>%s</main></body></html>", r.URL.Path, body)
+	}))
+	t.Cleanup(site.server.Close)
+	return site
+}
+
+func (s *testSite) count(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[path]
+}
+
+func (s *testSite) notModified(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conditional[path]
+}
+
+func crawledIDs(index *LocalIndex, base string) []string {
+	var paths []string
+	for _, doc := range index.Documents(func(doc *IndexedDocument) bool { return doc.Source == crawlerSource }) {
+		paths = append(paths, doc.ID[len(base):])
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func TestCrawl(t *testing.T) {
+	other := newTestSite(t, "")
+	site := newTestSite(t, other.server.URL)
+
+	index, err := NewLocalIndex(filepath.Join(t.TempDir(), "index.json"))
+	if err != nil {
+		t.Fatalf("NewLocalIndex: %v", err)
+	}
+	crawler := NewCrawlerService([]string{site.server.URL + "/"}, nil, nil, 2, 100, 0, time.Hour, index)
+
+	if err := crawler.Crawl(); err != nil {
+		t.Fatalf("Crawl: %v", err)
+	}
+
+	// /docs/c is three links away from the seed, beyond the depth limit
+	want := []string{"/", "/docs/a", "/docs/b"}
+	if got := crawledIDs(index, site.server.URL); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("indexed %v, want %v", got, want)
+	}
+	if n := site.count("/docs/c"); n != 0 {
+		t.Errorf("/docs/c was fetched %d times beyond the depth limit", n)
+	}
+	if n := site.count("/private/keys"); n != 0 {
+		t.Errorf("/private/keys was fetched %d times despite robots.txt", n)
+	}
+	if n := other.count("/elsewhere"); n != 0 {
+		t.Errorf("a page on another host was fetched %d times", n)
+	}
+	if n := site.count("/docs/a"); n != 1 {
+		t.Errorf("/docs/a was fetched %d times, want once despite the fragment link", n)
+	}
+
+	doc, ok := index.Get(site.server.URL + "/docs/a")
+	if !ok || doc.Title != "/docs/a" || doc.Metadata["etag"] != `"/docs/a-v1"` {
+		t.Errorf("indexed /docs/a as %+v", doc)
+	}
+
+	// The second crawl revalidates every page and keeps the unchanged content
+	if err := crawler.Crawl(); err != nil {
+		t.Fatalf("second Crawl: %v", err)
+	}
+	for _, path := range want {
+		if n := site.notModified(path); n != 1 {
+			t.Errorf("%s was answered with 304 %d times on the second crawl, want once", path, n)
+		}
+	}
+	if got := crawledIDs(index, site.server.URL); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("after the second crawl indexed %v, want %v", got, want)
+	}
+}
+
+func TestParseRobots(t *testing.T) {
+	robots := `
+User-agent: *
+Disallow: /private/
+Allow: /private/public$
+
+User-agent: rag-chatbot-crawler
+User-agent: other
+Disallow: /drafts/
+Allow: /drafts/*.html$
+Crawl-delay: 2
+
+Sitemap: https://docs.example.com/sitemap.xml
+`
+	rules := parseRobots(robots, "rag-chatbot-crawler/1.0")
+	tests := []struct {
+		uri  string
+		want bool
+	}{
+		{"/drafts/plan", false},
+		{"/drafts/plan.html", true},
+		{"/drafts/plan.html?x=1", false},
+		{"/private/keys", true}, // The "*" group doesn't apply when a group names us
+	}
+	for _, test := range tests {
+		if got := rules.allowed(test.uri); got != test.want {
+			t.Errorf("allowed(%q) = %t, want %t", test.uri, got, test.want)
+		}
+	}
+	if rules.crawlDelay != 2*time.Second {
+		t.Errorf("crawlDelay = %v", rules.crawlDelay)
+	}
+	if len(rules.sitemaps) != 1 {
+		t.Errorf("sitemaps = %v", rules.sitemaps)
+	}
+
+	wildcard := parseRobots(robots, "somebot/1.0")
+	if wildcard.allowed("/private/keys") || !wildcard.allowed("/private/public") {
+		t.Errorf("the * group isn't applied to other agents")
+	}
+}
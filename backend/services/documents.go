@@ -0,0 +1,418 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxDocumentBytes caps how much of a single attachment or file is parsed
+const maxDocumentBytes = 10 << 20
+
+// ExtractDocumentText extracts readable text from a binary or text document.
+// The format is picked from the media type, falling back to the file extension.
+// Supported formats are PDF, DOCX, PPTX, HTML and plain text.
+func ExtractDocumentText(filename, mediaType string, data []byte) (string, error) {
+	if len(data) > maxDocumentBytes {
+		return "", fmt.Errorf("document %s is too large (%d bytes)", filename, len(data))
+	}
+
+	switch documentKind(filename, mediaType) {
+	case "pdf":
+		return extractPDFText(data)
+	case "docx":
+		return extractDOCXText(data)
+	case "pptx":
+		return extractPPTXText(data)
+	case "html":
+		return ExtractPlainText(string(data)), nil
+	case "text":
+		if !utf8.Valid(data) {
+			return "", fmt.Errorf("document %s is not valid UTF-8 text", filename)
+		}
+		return string(data), nil
+	}
+
+	return "", fmt.Errorf("unsupported document type: %s (%s)", filename, mediaType)
+}
+
+// IsSupportedDocument reports whether ExtractDocumentText can handle the document
+func IsSupportedDocument(filename, mediaType string) bool {
+	return documentKind(filename, mediaType) != ""
+}
+
+func documentKind(filename, mediaType string) string {
+	mediaType = strings.ToLower(strings.TrimSpace(strings.Split(mediaType, ";")[0]))
+	switch mediaType {
+	case "application/pdf":
+		return "pdf"
+	case "application/vnd.openxmlformats-officedocument.wordprocessingml.document":
+		return "docx"
+	case "application/vnd.openxmlformats-officedocument.presentationml.presentation":
+		return "pptx"
+	case "text/html", "application/xhtml+xml":
+		return "html"
+	case "text/plain", "text/markdown", "text/csv", "application/json", "application/xml", "text/xml":
+		return "text"
+	}
+
+	switch strings.ToLower(path.Ext(filename)) {
+	case ".pdf":
+		return "pdf"
+	case ".docx":
+		return "docx"
+	case ".pptx":
+		return "pptx"
+	case ".html", ".htm":
+		return "html"
+	case ".txt", ".md", ".markdown", ".csv", ".json", ".xml", ".log":
+		return "text"
+	}
+
+	return ""
+}
+
+// extractDOCXText reads the paragraphs of word/document.xml
+func extractDOCXText(data []byte) (string, error) {
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", fmt.Errorf("failed to open docx: %v", err)
+	}
+
+	for _, file := range reader.File {
+		if file.Name == "word/document.xml" {
+			return readOOXMLText(file, "p")
+		}
+	}
+
+	return "", fmt.Errorf("docx has no word/document.xml")
+}
+
+// extractPPTXText reads the text frames of every slide in order
+func extractPPTXText(data []byte) (string, error) {
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", fmt.Errorf("failed to open pptx: %v", err)
+	}
+
+	slideName := regexp.MustCompile(`^ppt/slides/slide(\d+)\.xml$`)
+	var slides []*zip.File
+	for _, file := range reader.File {
+		if slideName.MatchString(file.Name) {
+			slides = append(slides, file)
+		}
+	}
+	sort.Slice(slides, func(i, j int) bool {
+		return slideNumber(slides[i].Name) < slideNumber(slides[j].Name)
+	})
+
+	var parts []string
+	for _, slide := range slides {
+		text, err := readOOXMLText(slide, "p")
+		if err != nil {
+			return "", err
+		}
+		if text != "" {
+			parts = append(parts, text)
+		}
+	}
+
+	return strings.Join(parts, "\n\n"), nil
+}
+
+func slideNumber(name string) int {
+	var n int
+	fmt.Sscanf(strings.TrimPrefix(name, "ppt/slides/slide"), "%d", &n)
+	return n
+}
+
+// readOOXMLText collects <t> runs and breaks lines at the end of each paragraph element
+func readOOXMLText(file *zip.File, paragraph string) (string, error) {
+	rc, err := file.Open()
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+
+	decoder := xml.NewDecoder(io.LimitReader(rc, maxDocumentBytes))
+	var text strings.Builder
+	inText := false
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to parse %s: %v", file.Name, err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "t":
+				inText = true
+			case "tab":
+				text.WriteString("\t")
+			case "br", "cr":
+				text.WriteString("\n")
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "t":
+				inText = false
+			case paragraph:
+				text.WriteString("\n")
+			}
+		case xml.CharData:
+			if inText {
+				text.Write(t)
+			}
+		}
+	}
+
+	return strings.TrimSpace(text.String()), nil
+}
+
+var (
+	pdfStreamPattern = regexp.MustCompile(`(?s)<<(.*?)>>\s*stream\r?\n`)
+	pdfTextOperator  = regexp.MustCompile(`^(Tj|TJ|'|"|T\*|Td|TD|ET)$`)
+)
+
+// extractPDFText is a best-effort extractor for text-based PDFs. It inflates
+// content streams and reads the strings shown by the Tj/TJ/'/" operators.
+// Scanned PDFs and fonts with custom encodings produce little or no text.
+func extractPDFText(data []byte) (string, error) {
+	if !bytes.HasPrefix(data, []byte("%PDF")) {
+		return "", fmt.Errorf("not a PDF document")
+	}
+
+	var text strings.Builder
+	for _, match := range pdfStreamPattern.FindAllSubmatchIndex(data, -1) {
+		dict := string(data[match[2]:match[3]])
+		start := match[1]
+		end := bytes.Index(data[start:], []byte("endstream"))
+		if end < 0 {
+			continue
+		}
+
+		// Images, fonts and metadata never carry page text
+		if strings.Contains(dict, "/Image") || strings.Contains(dict, "/Length1") ||
+			strings.Contains(dict, "/FontFile") || strings.Contains(dict, "/Metadata") {
+			continue
+		}
+
+		stream := data[start : start+end]
+		if strings.Contains(dict, "/FlateDecode") {
+			inflated, err := inflate(stream)
+			if err != nil {
+				continue
+			}
+			stream = inflated
+		} else if strings.Contains(dict, "/Filter") {
+			continue // Other filters (DCT, LZW, ...) are not supported
+		}
+
+		text.WriteString(pdfContentText(stream))
+	}
+
+	result := strings.TrimSpace(text.String())
+	if result == "" {
+		return "", fmt.Errorf("no extractable text in PDF")
+	}
+	return result, nil
+}
+
+func inflate(data []byte) ([]byte, error) {
+	reader, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	// Truncated streams are common, keep whatever was inflated
+	out, err := io.ReadAll(io.LimitReader(reader, maxDocumentBytes))
+	if err != nil && len(out) == 0 {
+		return nil, err
+	}
+	return out, nil
+}
+
+// pdfContentText walks a content stream, buffering string operands until a
+// text-showing operator consumes them.
+func pdfContentText(stream []byte) string {
+	var text strings.Builder
+	var operands []string
+
+	for i := 0; i < len(stream); {
+		c := stream[i]
+		switch {
+		case c == '(':
+			s, next := readPDFLiteral(stream, i)
+			operands = append(operands, s)
+			i = next
+		case c == '<' && i+1 < len(stream) && stream[i+1] != '<':
+			s, next := readPDFHex(stream, i)
+			operands = append(operands, s)
+			i = next
+		case c == '[' || c == ']':
+			i++
+		case c == '%':
+			for i < len(stream) && stream[i] != '\n' && stream[i] != '\r' {
+				i++
+			}
+		case isPDFSpace(c):
+			i++
+		default:
+			start := i
+			for i < len(stream) && !isPDFSpace(stream[i]) && !strings.ContainsRune("()<>[]/%", rune(stream[i])) {
+				i++
+			}
+			if i == start {
+				i++ // Skip delimiters such as '/' or '>'
+				continue
+			}
+
+			op := string(stream[start:i])
+			if !pdfTextOperator.MatchString(op) {
+				if !isPDFNumber(op) {
+					operands = operands[:0]
+				}
+				continue
+			}
+
+			switch op {
+			case "Tj", "TJ":
+				text.WriteString(strings.Join(operands, ""))
+			case "'", "\"":
+				text.WriteString("\n")
+				text.WriteString(strings.Join(operands, ""))
+			case "T*", "Td", "TD", "ET":
+				text.WriteString("\n")
+			}
+			operands = operands[:0]
+		}
+	}
+
+	return collapseBlankLines(text.String())
+}
+
+func readPDFLiteral(stream []byte, i int) (string, int) {
+	var s strings.Builder
+	depth := 0
+	for i < len(stream) {
+		c := stream[i]
+		switch {
+		case c == '\\' && i+1 < len(stream):
+			i++
+			switch esc := stream[i]; esc {
+			case 'n':
+				s.WriteByte('\n')
+			case 'r':
+				s.WriteByte('\r')
+			case 't':
+				s.WriteByte('\t')
+			case 'b', 'f':
+			case '\r', '\n':
+				// Line continuation
+			default:
+				if esc >= '0' && esc <= '7' {
+					value := 0
+					for n := 0; n < 3 && i < len(stream) && stream[i] >= '0' && stream[i] <= '7'; n++ {
+						value = value*8 + int(stream[i]-'0')
+						i++
+					}
+					i--
+					s.WriteRune(rune(value))
+				} else {
+					s.WriteByte(esc)
+				}
+			}
+		case c == '(':
+			if depth > 0 {
+				s.WriteByte(c)
+			}
+			depth++
+		case c == ')':
+			depth--
+			if depth == 0 {
+				return s.String(), i + 1
+			}
+			s.WriteByte(c)
+		default:
+			if depth > 0 {
+				s.WriteByte(c)
+			}
+		}
+		i++
+	}
+	return s.String(), i
+}
+
+func readPDFHex(stream []byte, i int) (string, int) {
+	end := bytes.IndexByte(stream[i:], '>')
+	if end < 0 {
+		return "", len(stream)
+	}
+
+	var digits []byte
+	for _, c := range stream[i+1 : i+end] {
+		if !isPDFSpace(c) {
+			digits = append(digits, c)
+		}
+	}
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+
+	var s strings.Builder
+	for j := 0; j+1 < len(digits); j += 2 {
+		var b byte
+		fmt.Sscanf(string(digits[j:j+2]), "%02x", &b)
+		// Two-byte CID strings usually start with a zero byte; keep the printable half
+		if b != 0 {
+			s.WriteByte(b)
+		}
+	}
+	return s.String(), i + end + 1
+}
+
+func isPDFSpace(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\r' || c == '\t' || c == '\f' || c == 0
+}
+
+func isPDFNumber(s string) bool {
+	for _, c := range s {
+		if (c < '0' || c > '9') && c != '.' && c != '-' && c != '+' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// collapseBlankLines trims each line and drops runs of empty lines
+func collapseBlankLines(text string) string {
+	var lines []string
+	blank := false
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			if !blank && len(lines) > 0 {
+				lines = append(lines, "")
+			}
+			blank = true
+			continue
+		}
+		blank = false
+		lines = append(lines, line)
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
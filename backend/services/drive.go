@@ -0,0 +1,237 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Google Workspace MIME types that must be exported rather than downloaded
+const (
+	googleDocMimeType    = "application/vnd.google-apps.document"
+	googleSheetMimeType  = "application/vnd.google-apps.spreadsheet"
+	googleSlidesMimeType = "application/vnd.google-apps.presentation"
+)
+
+// DriveService searches Google Drive. It shares the Google OAuth client with
+// GmailService and asks for Drive access incrementally on top of Gmail.
+type DriveService struct {
+	ClientID    string
+	RedirectURL string
+	APIBaseURL  string // Overridable for tests against a local stub
+}
+
+type DriveFileList struct {
+	Files         []DriveFile `json:"files"`
+	NextPageToken string      `json:"nextPageToken"`
+}
+
+type DriveFile struct {
+	ID           string            `json:"id"`
+	Name         string            `json:"name"`
+	MimeType     string            `json:"mimeType"`
+	WebViewLink  string            `json:"webViewLink"`
+	ModifiedTime string            `json:"modifiedTime"`
+	Size         string            `json:"size"`
+	Shared       bool              `json:"shared"`
+	Owners       []DriveUser       `json:"owners"`
+	SharingUser  *DriveUser        `json:"sharingUser"`
+	Permissions  []DrivePermission `json:"permissions"`
+}
+
+type DriveUser struct {
+	DisplayName  string `json:"displayName"`
+	EmailAddress string `json:"emailAddress"`
+}
+
+type DrivePermission struct {
+	Type         string `json:"type"` // user, group, domain or anyone
+	Role         string `json:"role"`
+	EmailAddress string `json:"emailAddress"`
+	Domain       string `json:"domain"`
+}
+
+func NewDriveService(clientID, redirectURL string) *DriveService {
+	return &DriveService{
+		ClientID:    clientID,
+		RedirectURL: redirectURL,
+		APIBaseURL:  "https://www.googleapis.com",
+	}
+}
+
+// GetAuthURL requests Drive read access, keeping the scopes already granted
+// to the Google client (such as Gmail) on the resulting token
+func (ds *DriveService) GetAuthURL(state, codeChallenge string) string {
+	baseURL := "https://accounts.google.com/o/oauth2/v2/auth"
+	params := url.Values{}
+	params.Add("client_id", ds.ClientID)
+	params.Add("redirect_uri", ds.RedirectURL)
+	params.Add("response_type", "code")
+	params.Add("scope", "https://www.googleapis.com/auth/drive.readonly")
+	params.Add("include_granted_scopes", "true")
+	params.Add("access_type", "offline")
+	params.Add("state", state)
+	if codeChallenge != "" {
+		params.Add("code_challenge", codeChallenge)
+		params.Add("code_challenge_method", "S256")
+	}
+	params.Add("prompt", "consent")
+
+	return fmt.Sprintf("%s?%s", baseURL, params.Encode())
+}
+
+// SearchFiles runs a files.list query. Use BuildDriveQuery to turn free text
+// into the Drive query language.
+func (ds *DriveService) SearchFiles(accessToken, driveQuery string, pageSize int) (*DriveFileList, error) {
+	if pageSize == 0 {
+		pageSize = 10
+	}
+
+	params := url.Values{}
+	params.Add("q", driveQuery)
+	params.Add("pageSize", fmt.Sprintf("%d", pageSize))
+	params.Add("corpora", "allDrives")
+	params.Add("includeItemsFromAllDrives", "true")
+	params.Add("supportsAllDrives", "true")
+	params.Add("fields", "files(id,name,mimeType,webViewLink,modifiedTime,size,shared,owners(displayName,emailAddress),sharingUser(displayName,emailAddress),permissions(type,role,emailAddress,domain)),nextPageToken")
+
+	fullURL := fmt.Sprintf("%s/drive/v3/files?%s", ds.APIBaseURL, params.Encode())
+
+	req, err := http.NewRequest("GET", fullURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Add("Authorization", "Bearer "+accessToken)
+	req.Header.Add("Accept", "application/json")
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to search Drive: %w", newStatusError(resp))
+	}
+
+	var fileList DriveFileList
+	if err := json.NewDecoder(resp.Body).Decode(&fileList); err != nil {
+		return nil, err
+	}
+
+	return &fileList, nil
+}
+
+// BuildDriveQuery builds a full-text Drive query that skips trashed files and folders
+func BuildDriveQuery(query string) string {
+	escaped := strings.ReplaceAll(query, `\`, `\\`)
+	escaped = strings.ReplaceAll(escaped, `'`, `\'`)
+	return fmt.Sprintf("fullText contains '%s' and trashed = false and mimeType != 'application/vnd.google-apps.folder'", escaped)
+}
+
+// GetFileText exports Docs and Slides as plain text and Sheets as CSV, and
+// downloads other supported files and extracts their text
+func (ds *DriveService) GetFileText(accessToken string, file DriveFile) (string, error) {
+	var fileURL string
+	switch file.MimeType {
+	case googleDocMimeType, googleSlidesMimeType:
+		fileURL = fmt.Sprintf("%s/drive/v3/files/%s/export?mimeType=%s", ds.APIBaseURL, url.PathEscape(file.ID), url.QueryEscape("text/plain"))
+	case googleSheetMimeType:
+		// CSV export only covers the first sheet
+		fileURL = fmt.Sprintf("%s/drive/v3/files/%s/export?mimeType=%s", ds.APIBaseURL, url.PathEscape(file.ID), url.QueryEscape("text/csv"))
+	default:
+		if !IsSupportedDocument(file.Name, file.MimeType) {
+			return "", fmt.Errorf("unsupported Drive file type: %s", file.MimeType)
+		}
+		fileURL = fmt.Sprintf("%s/drive/v3/files/%s?alt=media&supportsAllDrives=true", ds.APIBaseURL, url.PathEscape(file.ID))
+	}
+
+	req, err := http.NewRequest("GET", fileURL, nil)
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Add("Authorization", "Bearer "+accessToken)
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to get Drive file content: %w", newStatusError(resp))
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxDocumentBytes+1))
+	if err != nil {
+		return "", err
+	}
+
+	switch file.MimeType {
+	case googleDocMimeType, googleSlidesMimeType:
+		return string(data), nil
+	case googleSheetMimeType:
+		return ExtractDocumentText(file.Name+".csv", "text/csv", data)
+	}
+	return ExtractDocumentText(file.Name, file.MimeType, data)
+}
+
+// FormatFileMetadata describes owner, modified time and sharing of a file
+func (ds *DriveService) FormatFileMetadata(file DriveFile) string {
+	var lines []string
+	lines = append(lines, "File: "+file.Name)
+
+	var owners []string
+	for _, owner := range file.Owners {
+		owners = append(owners, formatDriveUser(owner))
+	}
+	if len(owners) > 0 {
+		lines = append(lines, "Owner: "+strings.Join(owners, ", "))
+	}
+
+	if modified, err := time.Parse(time.RFC3339, file.ModifiedTime); err == nil {
+		lines = append(lines, "Modified: "+modified.Format("2006-01-02 15:04"))
+	}
+
+	if file.SharingUser != nil {
+		lines = append(lines, "Shared by: "+formatDriveUser(*file.SharingUser))
+	}
+
+	if file.Shared {
+		var sharedWith []string
+		for _, permission := range file.Permissions {
+			switch permission.Type {
+			case "anyone":
+				sharedWith = append(sharedWith, "anyone with the link ("+permission.Role+")")
+			case "domain":
+				sharedWith = append(sharedWith, permission.Domain+" ("+permission.Role+")")
+			case "user", "group":
+				if permission.Role != "owner" {
+					sharedWith = append(sharedWith, permission.EmailAddress+" ("+permission.Role+")")
+				}
+			}
+		}
+		if len(sharedWith) > 0 {
+			lines = append(lines, "Shared with: "+strings.Join(sharedWith, ", "))
+		}
+	} else {
+		lines = append(lines, "Sharing: private")
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func formatDriveUser(user DriveUser) string {
+	if user.EmailAddress == "" {
+		return user.DisplayName
+	}
+	return fmt.Sprintf("%s <%s>", user.DisplayName, user.EmailAddress)
+}
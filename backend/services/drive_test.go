@@ -0,0 +1,162 @@
+package services
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func newTestDriveService(t *testing.T, handler http.HandlerFunc) *DriveService {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	ds := NewDriveService("id", "")
+	ds.APIBaseURL = server.URL
+	return ds
+}
+
+// Drive access is asked for on top of the scopes Gmail already has
+func TestDriveAuthURLIsIncremental(t *testing.T) {
+	authURL, err := url.Parse(NewDriveService("id", "https://chat.example.com/callback").GetAuthURL("state-1", "challenge"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := authURL.Query()
+	if scope := query.Get("scope"); scope != "https://www.googleapis.com/auth/drive.readonly" {
+		t.Errorf("scope = %q, want read-only Drive access", scope)
+	}
+	if query.Get("include_granted_scopes") != "true" || query.Get("access_type") != "offline" {
+		t.Errorf("auth URL %s isn't incremental with offline access", authURL)
+	}
+	if query.Get("code_challenge") != "challenge" || query.Get("code_challenge_method") != "S256" {
+		t.Errorf("auth URL %s is missing the PKCE challenge", authURL)
+	}
+}
+
+func TestDriveSearchFiles(t *testing.T) {
+	ds := newTestDriveService(t, func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		want := `fullText contains 'team\'s onboarding' and trashed = false and mimeType != 'application/vnd.google-apps.folder'`
+		if q := query.Get("q"); q != want {
+			t.Errorf("q = %q, want %q", q, want)
+		}
+		// Shared drives are only searched when asked for
+		if query.Get("corpora") != "allDrives" || query.Get("includeItemsFromAllDrives") != "true" {
+			t.Errorf("search doesn't cover shared drives: %s", r.URL.RawQuery)
+		}
+		if fields := query.Get("fields"); !strings.Contains(fields, "owners(") || !strings.Contains(fields, "permissions(") {
+			t.Errorf("fields = %q, want owners and permissions", fields)
+		}
+		w.Write([]byte(`{"nextPageToken": "page-2", "files": [
+			{"id": "doc1", "name": "Onboarding", "mimeType": "application/vnd.google-apps.document", "modifiedTime": "2024-03-04T09:30:00Z"}
+		]}`))
+	})
+
+	files, err := ds.SearchFiles("token", BuildDriveQuery("team's onboarding"), 5)
+	if err != nil {
+		t.Fatalf("SearchFiles: %v", err)
+	}
+	if len(files.Files) != 1 || files.Files[0].ID != "doc1" || files.NextPageToken != "page-2" {
+		t.Errorf("files = %+v", files)
+	}
+}
+
+func TestDriveSearchFilesUnauthorized(t *testing.T) {
+	ds := newTestDriveService(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+
+	if _, err := ds.SearchFiles("expired", BuildDriveQuery("x"), 5); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("err = %v, want the 401", err)
+	}
+}
+
+// Google files are exported in the format that keeps their text; other
+// files are downloaded as they are
+func TestDriveGetFileText(t *testing.T) {
+	ds := newTestDriveService(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path + "?" + r.URL.RawQuery {
+		case "/drive/v3/files/doc1/export?mimeType=text%2Fplain":
+			w.Write([]byte("Welcome to the team"))
+		case "/drive/v3/files/slides1/export?mimeType=text%2Fplain":
+			w.Write([]byte("Q3 roadmap"))
+		case "/drive/v3/files/sheet1/export?mimeType=text%2Fcsv":
+			w.Write([]byte("item,cost\nlaptops,9000\n"))
+		case "/drive/v3/files/notes1?alt=media&supportsAllDrives=true":
+			w.Write([]byte("Plain notes"))
+		default:
+			t.Errorf("unexpected request %s", r.URL)
+			http.NotFound(w, r)
+		}
+	})
+
+	tests := []struct {
+		file DriveFile
+		want string
+	}{
+		{DriveFile{ID: "doc1", Name: "Onboarding", MimeType: googleDocMimeType}, "Welcome to the team"},
+		{DriveFile{ID: "slides1", Name: "Roadmap", MimeType: googleSlidesMimeType}, "Q3 roadmap"},
+		{DriveFile{ID: "sheet1", Name: "Budget", MimeType: googleSheetMimeType}, "laptops"},
+		{DriveFile{ID: "notes1", Name: "notes.txt", MimeType: "text/plain"}, "Plain notes"},
+	}
+	for _, test := range tests {
+		text, err := ds.GetFileText("token", test.file)
+		if err != nil {
+			t.Errorf("GetFileText(%s): %v", test.file.Name, err)
+			continue
+		}
+		if !strings.Contains(text, test.want) {
+			t.Errorf("GetFileText(%s) = %q, want it to contain %q", test.file.Name, text, test.want)
+		}
+	}
+
+	if _, err := ds.GetFileText("token", DriveFile{ID: "bin", Name: "tool.exe", MimeType: "application/octet-stream"}); err == nil {
+		t.Errorf("unsupported file type was downloaded")
+	}
+}
+
+func TestDriveFileMetadata(t *testing.T) {
+	ds := NewDriveService("id", "")
+
+	tests := []struct {
+		name string
+		file DriveFile
+		want []string
+	}{
+		{
+			name: "shared",
+			file: DriveFile{
+				Name: "Onboarding", ModifiedTime: "2024-03-04T09:30:00Z", Shared: true,
+				Owners:      []DriveUser{{DisplayName: "Ada", EmailAddress: "ada@example.com"}},
+				SharingUser: &DriveUser{DisplayName: "Grace"},
+				Permissions: []DrivePermission{
+					{Type: "user", Role: "owner", EmailAddress: "ada@example.com"},
+					{Type: "group", Role: "reader", EmailAddress: "eng@example.com"},
+					{Type: "domain", Role: "commenter", Domain: "example.com"},
+					{Type: "anyone", Role: "reader"},
+				},
+			},
+			want: []string{
+				"Owner: Ada <ada@example.com>",
+				"Modified: 2024-03-04 09:30",
+				"Shared by: Grace",
+				"Shared with: eng@example.com (reader), example.com (commenter), anyone with the link (reader)",
+			},
+		},
+		{
+			name: "private",
+			file: DriveFile{Name: "Budget", Owners: []DriveUser{{DisplayName: "Ada"}}},
+			want: []string{"Owner: Ada\n", "Sharing: private"},
+		},
+	}
+	for _, test := range tests {
+		metadata := ds.FormatFileMetadata(test.file)
+		for _, want := range test.want {
+			if !strings.Contains(metadata, want) {
+				t.Errorf("%s: FormatFileMetadata is missing %q:\n%s", test.name, want, metadata)
+			}
+		}
+	}
+}
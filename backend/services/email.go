@@ -0,0 +1,222 @@
+package services
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// EmailMessage is a parsed email, independent of the mailbox it came from
+type EmailMessage struct {
+	Subject   string
+	From      string
+	To        string
+	Date      time.Time
+	MessageID string
+	TextBody  string // First text/plain part
+	HTMLBody  string // First text/html part
+}
+
+// Content returns the message body as text without quoted replies. The plain
+// text part is preferred; HTML-only messages are converted to Markdown.
+func (m *EmailMessage) Content() string {
+	return EmailBodyText(m.TextBody, m.HTMLBody)
+}
+
+// EmailBodyText picks the readable body of a message and strips quoted replies
+func EmailBodyText(textBody, htmlBody string) string {
+	body := textBody
+	if strings.TrimSpace(body) == "" && htmlBody != "" {
+		body = HTMLToMarkdown(htmlBody)
+	}
+	return StripQuotedReply(body)
+}
+
+var (
+	// Attribution lines that introduce a quoted reply, e.g. "On Mon, 3 Jun 2024 at 10:00, Ann <a@x> wrote:"
+	replyAttribution = regexp.MustCompile(`(?i)^\s*(on\s.+\swrote:|le\s.+\sa écrit\s?:|am\s.+\sschrieb\s.+:)\s*$`)
+	// Outlook-style headers of a forwarded or replied-to message
+	outlookSeparator = regexp.MustCompile(`(?i)^\s*(-{2,}\s*(original message|forwarded message)\s*-{2,}|_{10,})\s*$`)
+	outlookFromLine  = regexp.MustCompile(`(?i)^\s*\**from:\**\s`)
+)
+
+// StripQuotedReply removes the quoted history of a reply: "> " lines, "On ...
+// wrote:" attributions and everything after an Outlook original-message header
+func StripQuotedReply(body string) string {
+	body = strings.ReplaceAll(body, "\r\n", "\n")
+	lines := strings.Split(body, "\n")
+
+	var kept []string
+	for i, line := range lines {
+		if outlookSeparator.MatchString(line) {
+			break
+		}
+		// Outlook without a separator: "From:" directly followed by "Sent:"/"Date:"
+		if outlookFromLine.MatchString(line) && i+1 < len(lines) {
+			next := strings.ToLower(strings.TrimLeft(lines[i+1], " *"))
+			if strings.HasPrefix(next, "sent:") || strings.HasPrefix(next, "date:") {
+				break
+			}
+		}
+		if replyAttribution.MatchString(line) {
+			break
+		}
+		// Attributions are often wrapped over two lines
+		if strings.HasPrefix(strings.TrimSpace(line), "On ") && i+1 < len(lines) &&
+			replyAttribution.MatchString(line+" "+strings.TrimSpace(lines[i+1])) {
+			break
+		}
+		if strings.HasPrefix(strings.TrimSpace(line), ">") {
+			continue
+		}
+		kept = append(kept, line)
+	}
+
+	return collapseBlankLines(strings.TrimSpace(strings.Join(kept, "\n")))
+}
+
+// ParseEmailDate parses the Date header formats seen in the wild
+func ParseEmailDate(value string) time.Time {
+	if date, err := mail.ParseDate(value); err == nil {
+		return date
+	}
+	// Some clients append a zone name, e.g. "... +0000 (UTC)"
+	if i := strings.Index(value, " ("); i > 0 {
+		if date, err := mail.ParseDate(value[:i]); err == nil {
+			return date
+		}
+	}
+	return time.Time{}
+}
+
+var headerDecoder = &mime.WordDecoder{CharsetReader: charsetReader}
+
+// DecodeEmailHeader decodes RFC 2047 encoded words such as "=?UTF-8?B?...?="
+func DecodeEmailHeader(value string) string {
+	decoded, err := headerDecoder.DecodeHeader(value)
+	if err != nil {
+		return value
+	}
+	return decoded
+}
+
+// DecodeBase64URL decodes the unpadded base64url encoding used by the Gmail API
+func DecodeBase64URL(data string) (string, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(data, "="))
+	if err != nil {
+		return "", err
+	}
+	return string(decoded), nil
+}
+
+// ParseMIMEMessage parses a raw RFC 5322 message, decoding transfer encodings
+// and charsets. A truncated message yields whatever parts could be read.
+func ParseMIMEMessage(raw []byte) (*EmailMessage, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse email: %v", err)
+	}
+
+	email := &EmailMessage{
+		Subject:   DecodeEmailHeader(msg.Header.Get("Subject")),
+		From:      DecodeEmailHeader(msg.Header.Get("From")),
+		To:        DecodeEmailHeader(msg.Header.Get("To")),
+		Date:      ParseEmailDate(msg.Header.Get("Date")),
+		MessageID: strings.Trim(msg.Header.Get("Message-Id"), "<>"),
+	}
+
+	walkMIMEPart(email, msg.Header, msg.Body, 0)
+	return email, nil
+}
+
+// headerGetter is satisfied by both mail.Header and textproto.MIMEHeader
+type headerGetter interface {
+	Get(key string) string
+}
+
+// walkMIMEPart fills the first text/plain and text/html bodies of a message
+func walkMIMEPart(email *EmailMessage, header headerGetter, body io.Reader, depth int) {
+	if depth > 10 {
+		return
+	}
+
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextPart()
+			if err != nil {
+				return // io.EOF, or a truncated message
+			}
+			walkMIMEPart(email, part.Header, part, depth+1)
+		}
+	}
+
+	if mediaType != "text/plain" && mediaType != "text/html" {
+		return
+	}
+	if disposition, _, _ := mime.ParseMediaType(header.Get("Content-Disposition")); disposition == "attachment" {
+		return
+	}
+
+	data, _ := io.ReadAll(transferDecoder(header.Get("Content-Transfer-Encoding"), body))
+	text := decodeCharset(params["charset"], data)
+
+	switch {
+	case mediaType == "text/plain" && email.TextBody == "":
+		email.TextBody = text
+	case mediaType == "text/html" && email.HTMLBody == "":
+		email.HTMLBody = text
+	}
+}
+
+func transferDecoder(encoding string, body io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		// The decoder skips the line breaks of bodies wrapped at 76 columns
+		return base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		return quotedprintable.NewReader(body)
+	}
+	return body
+}
+
+// decodeCharset converts the charsets common in mail to UTF-8. Unknown
+// charsets are passed through when they happen to be valid UTF-8.
+func decodeCharset(charset string, data []byte) string {
+	switch strings.ToLower(charset) {
+	case "", "utf-8", "utf8", "us-ascii", "ascii":
+		return string(data)
+	case "iso-8859-1", "latin1", "iso-8859-15", "windows-1252", "cp1252":
+		if utf8.Valid(data) {
+			return string(data) // Mislabelled UTF-8 is common
+		}
+		runes := make([]rune, len(data))
+		for i, b := range data {
+			runes[i] = rune(b)
+		}
+		return string(runes)
+	}
+	return strings.ToValidUTF8(string(data), "�")
+}
+
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	data, err := io.ReadAll(input)
+	if err != nil {
+		return nil, err
+	}
+	return strings.NewReader(decodeCharset(charset, data)), nil
+}
@@ -0,0 +1,28 @@
+package services
+
+import (
+	"errors"
+	"net/http"
+)
+
+// StatusError is an unexpected HTTP status from an API. Its message is just
+// the status, so callers wrap it with context.
+type StatusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *StatusError) Error() string {
+	return e.Status
+}
+
+func newStatusError(resp *http.Response) *StatusError {
+	return &StatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+}
+
+// IsUnauthorized reports whether an API rejected the access token, which
+// usually means it expired
+func IsUnauthorized(err error) bool {
+	var statusErr *StatusError
+	return errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusUnauthorized
+}
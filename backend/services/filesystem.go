@@ -0,0 +1,504 @@
+package services
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FilesystemRoot is a directory to index. When WebBaseURL is set, references
+// point at WebBaseURL + relative path instead of a file:// URL.
+type FilesystemRoot struct {
+	Path       string
+	WebBaseURL string
+}
+
+// FilesystemService indexes Markdown vaults, docs and code checkouts into a
+// LocalIndex and keeps the index current by polling for changes.
+type FilesystemService struct {
+	Roots        []FilesystemRoot
+	Index        *LocalIndex
+	PollInterval time.Duration
+
+	mu    sync.Mutex
+	known map[string]fileState // Indexed file path -> state at indexing time
+}
+
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// filesystemSource is the Source of documents indexed from local files
+const filesystemSource = "files"
+
+// maxIndexedFileBytes skips very large files such as generated code or logs
+const maxIndexedFileBytes = 2 << 20
+
+var codeExtensions = map[string]string{
+	".go": "go", ".py": "python", ".js": "javascript", ".jsx": "javascript", ".ts": "typescript",
+	".tsx": "typescript", ".java": "java", ".kt": "kotlin", ".rb": "ruby", ".rs": "rust",
+	".c": "c", ".h": "c", ".cpp": "cpp", ".cs": "csharp", ".php": "php", ".swift": "swift",
+	".sh": "bash", ".bash": "bash", ".sql": "sql", ".yaml": "yaml", ".yml": "yaml",
+	".toml": "toml", ".tf": "hcl", ".proto": "protobuf", ".ini": "ini", ".conf": "",
+}
+
+func NewFilesystemService(roots []FilesystemRoot, index *LocalIndex, pollInterval time.Duration) *FilesystemService {
+	if pollInterval == 0 {
+		pollInterval = 30 * time.Second
+	}
+	return &FilesystemService{
+		Roots:        roots,
+		Index:        index,
+		PollInterval: pollInterval,
+		known:        make(map[string]fileState),
+	}
+}
+
+// ParseFilesystemRoots parses entries of the form "path" or "path=https://web/base"
+func ParseFilesystemRoots(entries []string) []FilesystemRoot {
+	var roots []FilesystemRoot
+	for _, entry := range entries {
+		root := FilesystemRoot{Path: entry}
+		if i := strings.Index(entry, "="); i >= 0 {
+			root.Path = entry[:i]
+			root.WebBaseURL = strings.TrimSuffix(entry[i+1:], "/")
+		}
+		if abs, err := filepath.Abs(root.Path); err == nil {
+			root.Path = abs
+		}
+		roots = append(roots, root)
+	}
+	return roots
+}
+
+// Watch syncs immediately and then every PollInterval until stop is closed
+func (fs *FilesystemService) Watch(stop <-chan struct{}) {
+	if err := fs.Sync(); err != nil {
+		log.Printf("Filesystem sync error: %v", err)
+	}
+
+	ticker := time.NewTicker(fs.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := fs.Sync(); err != nil {
+				log.Printf("Filesystem sync error: %v", err)
+			}
+		}
+	}
+}
+
+// Sync walks every root, reindexing new or modified files and removing
+// documents whose files were deleted or became ignored
+func (fs *FilesystemService) Sync() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	seen := make(map[string]bool)
+	changed := 0
+	var errs []string
+
+	for _, root := range fs.Roots {
+		if err := fs.syncRoot(root, seen, &changed); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", root.Path, err))
+		}
+	}
+
+	// Compare against the index rather than known so documents loaded from a
+	// saved index are removed too when their files are gone
+	for _, doc := range fs.Index.Documents(func(doc *IndexedDocument) bool { return doc.Source == filesystemSource }) {
+		if !seen[doc.ID] {
+			fs.Index.Delete(doc.ID)
+			delete(fs.known, doc.ID)
+			changed++
+		}
+	}
+
+	if changed > 0 {
+		log.Printf("Filesystem sync updated %d files", changed)
+		if err := fs.Index.Save(); err != nil {
+			errs = append(errs, fmt.Sprintf("saving index: %v", err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to sync %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func (fs *FilesystemService) syncRoot(root FilesystemRoot, seen map[string]bool, changed *int) error {
+	ignores := map[string]*gitignore{}
+
+	return filepath.Walk(root.Path, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil // Unreadable entries are skipped, not fatal
+		}
+
+		rel, _ := filepath.Rel(root.Path, filePath)
+		rel = filepath.ToSlash(rel)
+		name := info.Name()
+
+		if info.IsDir() {
+			if rel != "." && (strings.HasPrefix(name, ".") || name == "node_modules") {
+				return filepath.SkipDir
+			}
+			if rel != "." && isIgnored(ignores, rel, true) {
+				return filepath.SkipDir
+			}
+			if ignore, err := loadGitignore(filepath.Join(filePath, ".gitignore"), rel); err == nil {
+				ignores[rel] = ignore
+			}
+			return nil
+		}
+
+		if strings.HasPrefix(name, ".") || info.Size() > maxIndexedFileBytes || !isIndexableFile(name) {
+			return nil
+		}
+		if isIgnored(ignores, rel, false) {
+			return nil
+		}
+
+		seen[filePath] = true
+		state := fileState{modTime: info.ModTime(), size: info.Size()}
+		if known, ok := fs.known[filePath]; ok && known == state {
+			return nil
+		}
+
+		doc, err := fs.readFile(root, filePath, rel, info)
+		if err != nil {
+			log.Printf("Failed to index %s: %v", filePath, err)
+			return nil
+		}
+
+		fs.Index.Upsert(*doc)
+		fs.known[filePath] = state
+		*changed++
+		return nil
+	})
+}
+
+func isIndexableFile(name string) bool {
+	ext := strings.ToLower(path.Ext(name))
+	if _, ok := codeExtensions[ext]; ok {
+		return true
+	}
+	return IsSupportedDocument(name, "")
+}
+
+// readFile converts a file to an IndexedDocument
+func (fs *FilesystemService) readFile(root FilesystemRoot, filePath, rel string, info os.FileInfo) (*IndexedDocument, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	ext := strings.ToLower(path.Ext(rel))
+	doc := &IndexedDocument{
+		ID:       filePath,
+		Source:   filesystemSource,
+		ACL:      PublicACL(), // The roots are shared with the whole deployment
+		Title:    rel,
+		URL:      fileURL(root, filePath, rel),
+		Modified: info.ModTime(),
+		Metadata: map[string]string{"path": rel},
+	}
+
+	switch {
+	case ext == ".md" || ext == ".markdown":
+		note := ParseMarkdownNote(string(data))
+		doc.Content = note.Body
+		if note.Title != "" {
+			doc.Title = note.Title
+		}
+		if len(note.Tags) > 0 {
+			doc.Metadata["tags"] = strings.Join(note.Tags, ",")
+			doc.Content = "Tags: " + strings.Join(note.Tags, ", ") + "\n\n" + doc.Content
+		}
+		if len(note.Links) > 0 {
+			doc.Metadata["links"] = strings.Join(note.Links, ",")
+		}
+	case ext == ".html" || ext == ".htm":
+		doc.Content = HTMLToMarkdown(string(data))
+		if match := firstHeading.FindStringSubmatch(doc.Content); match != nil {
+			doc.Title = strings.TrimSpace(match[1])
+		}
+	case codeExtensions[ext] != "" || ext == ".conf":
+		doc.Content = fmt.Sprintf("File: %s\n\n```%s\n%s\n```", rel, codeExtensions[ext], string(data))
+	default:
+		text, err := ExtractDocumentText(rel, "", data)
+		if err != nil {
+			return nil, err
+		}
+		doc.Content = text
+	}
+
+	return doc, nil
+}
+
+func fileURL(root FilesystemRoot, filePath, rel string) string {
+	if root.WebBaseURL != "" {
+		var segments []string
+		for _, segment := range strings.Split(rel, "/") {
+			segments = append(segments, url.PathEscape(segment))
+		}
+		return root.WebBaseURL + "/" + strings.Join(segments, "/")
+	}
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(filePath)}).String()
+}
+
+// MarkdownNote is a Markdown file with its frontmatter and wiki-links resolved
+type MarkdownNote struct {
+	Title string
+	Tags  []string
+	Links []string // Targets of [[wiki-links]]
+	Body  string   // Content without frontmatter, wiki-links replaced by their text
+}
+
+var (
+	wikiLinkPattern  = regexp.MustCompile(`!?\[\[([^\]|#]+)(#[^\]|]*)?(\|([^\]]*))?\]\]`)
+	firstHeading     = regexp.MustCompile(`(?m)^#\s+(.+)$`)
+	frontmatterField = regexp.MustCompile(`^([A-Za-z0-9_-]+):\s*(.*)$`)
+)
+
+// ParseMarkdownNote reads YAML frontmatter (title, tags, aliases) and
+// Obsidian-style wiki-links from a Markdown document
+func ParseMarkdownNote(content string) MarkdownNote {
+	var note MarkdownNote
+	body := content
+
+	// Frontmatter is a "---" fenced block at the very start of the file
+	if strings.HasPrefix(content, "---\n") || strings.HasPrefix(content, "---\r\n") {
+		rest := content[strings.Index(content, "\n")+1:]
+		if end := strings.Index(rest, "\n---"); end >= 0 {
+			parseFrontmatter(rest[:end], &note)
+			body = rest[end+len("\n---"):]
+			if i := strings.Index(body, "\n"); i >= 0 {
+				body = body[i+1:]
+			} else {
+				body = ""
+			}
+		}
+	}
+
+	seen := make(map[string]bool)
+	body = wikiLinkPattern.ReplaceAllStringFunc(body, func(link string) string {
+		match := wikiLinkPattern.FindStringSubmatch(link)
+		target := strings.TrimSpace(match[1])
+		if !seen[target] {
+			seen[target] = true
+			note.Links = append(note.Links, target)
+		}
+		if match[4] != "" {
+			return match[4]
+		}
+		return target
+	})
+
+	if note.Title == "" {
+		if match := firstHeading.FindStringSubmatch(body); match != nil {
+			note.Title = strings.TrimSpace(match[1])
+		}
+	}
+
+	note.Body = strings.TrimSpace(body)
+	return note
+}
+
+// parseFrontmatter understands the simple YAML used by note-taking tools:
+// scalar fields, inline lists ([a, b]) and block lists ("- a")
+func parseFrontmatter(frontmatter string, note *MarkdownNote) {
+	var listKey string
+	for _, line := range strings.Split(frontmatter, "\n") {
+		line = strings.TrimRight(line, "\r")
+		trimmed := strings.TrimSpace(line)
+
+		if strings.HasPrefix(trimmed, "- ") && listKey != "" {
+			addFrontmatterValue(note, listKey, unquoteYAML(strings.TrimPrefix(trimmed, "- ")))
+			continue
+		}
+
+		match := frontmatterField.FindStringSubmatch(trimmed)
+		if match == nil {
+			continue
+		}
+		key, value := strings.ToLower(match[1]), strings.TrimSpace(match[2])
+		listKey = ""
+
+		switch {
+		case value == "":
+			listKey = key
+		case strings.HasPrefix(value, "[") && strings.HasSuffix(value, "]"):
+			for _, item := range strings.Split(strings.Trim(value, "[]"), ",") {
+				addFrontmatterValue(note, key, unquoteYAML(strings.TrimSpace(item)))
+			}
+		default:
+			addFrontmatterValue(note, key, unquoteYAML(value))
+		}
+	}
+}
+
+func addFrontmatterValue(note *MarkdownNote, key, value string) {
+	if value == "" {
+		return
+	}
+	switch key {
+	case "title":
+		note.Title = value
+	case "tags", "tag":
+		for _, tag := range strings.Fields(strings.ReplaceAll(value, ",", " ")) {
+			note.Tags = append(note.Tags, strings.TrimPrefix(tag, "#"))
+		}
+	case "aliases", "alias":
+		if note.Title == "" {
+			note.Title = value
+		}
+	}
+}
+
+func unquoteYAML(value string) string {
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
+
+// gitignore holds the rules of one .gitignore file
+type gitignore struct {
+	base  string // Directory of the .gitignore relative to the root ("." for the root)
+	rules []gitignoreRule
+}
+
+type gitignoreRule struct {
+	pattern *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+func loadGitignore(filePath, base string) (*gitignore, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	ignore := &gitignore{base: base}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		rule := gitignoreRule{}
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimSuffix(line, "/")
+		}
+
+		pattern, err := regexp.Compile(gitignorePatternToRegexp(line))
+		if err != nil {
+			continue
+		}
+		rule.pattern = pattern
+		ignore.rules = append(ignore.rules, rule)
+	}
+
+	return ignore, scanner.Err()
+}
+
+// gitignorePatternToRegexp translates a gitignore glob. Patterns without a
+// slash match at any depth; patterns with one are anchored to the .gitignore.
+func gitignorePatternToRegexp(pattern string) string {
+	anchored := strings.Contains(pattern, "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+
+	var re strings.Builder
+	re.WriteString("^")
+	if !anchored {
+		re.WriteString("(?:.*/)?")
+	}
+
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			re.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "/**/"):
+			// Matches zero or more directories, so a/**/b matches a/b
+			re.WriteString("/(?:.*/)?")
+			i += 3
+		case strings.HasPrefix(pattern[i:], "/**"):
+			re.WriteString("/.*")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			re.WriteString(".*")
+			i++
+		case c == '*':
+			re.WriteString("[^/]*")
+		case c == '?':
+			re.WriteString("[^/]")
+		case c == '\\' && i+1 < len(pattern):
+			i++
+			re.WriteString(regexp.QuoteMeta(string(pattern[i])))
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	re.WriteString("$")
+	return re.String()
+}
+
+// isIgnored applies the .gitignore files from the root down to the path's
+// directory; the last matching rule wins
+func isIgnored(ignores map[string]*gitignore, rel string, isDir bool) bool {
+	ignored := false
+
+	dirs := []string{"."}
+	parts := strings.Split(rel, "/")
+	for i := 1; i < len(parts); i++ {
+		dirs = append(dirs, strings.Join(parts[:i], "/"))
+	}
+
+	for _, dir := range dirs {
+		ignore, ok := ignores[dir]
+		if !ok {
+			continue
+		}
+
+		relToBase := rel
+		if dir != "." {
+			relToBase = strings.TrimPrefix(rel, dir+"/")
+		}
+
+		for _, rule := range ignore.rules {
+			if rule.dirOnly && !isDir {
+				continue
+			}
+			if rule.pattern.MatchString(relToBase) {
+				ignored = !rule.negate
+			}
+		}
+	}
+
+	return ignored
+}
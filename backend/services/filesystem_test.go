@@ -0,0 +1,263 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeFiles creates files under dir from a map of slash-separated paths to contents
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		filePath := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filePath, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestGitignorePatternToRegexp(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		match   bool
+	}{
+		// Patterns without a slash match at any depth
+		{"*.log", "app.log", true},
+		{"*.log", "logs/app.log", true},
+		{"*.log", "app.log.txt", false},
+		// Patterns with a slash are anchored to the .gitignore
+		{"/build", "build", true},
+		{"/build", "src/build", false},
+		{"docs/generated", "docs/generated", true},
+		{"docs/generated", "src/docs/generated", false},
+		// A single star stays within one path segment
+		{"src/*.go", "src/main.go", true},
+		{"src/*.go", "src/cmd/main.go", false},
+		// ** crosses directories
+		{"**/tmp", "tmp", true},
+		{"**/tmp", "a/b/tmp", true},
+		{"cache/**", "cache/a/b.json", true},
+		{"cache/**", "cache", false},
+		{"a/**/b", "a/b", true},
+		{"a/**/b", "a/x/y/b", true},
+		{"secret?.md", "secret1.md", true},
+		{"secret?.md", "secret12.md", false},
+		{`\#notes.md`, "#notes.md", true},
+	}
+	for _, test := range tests {
+		pattern := regexp.MustCompile(gitignorePatternToRegexp(test.pattern))
+		if got := pattern.MatchString(test.path); got != test.match {
+			t.Errorf("%q matching %q = %v, want %v", test.pattern, test.path, got, test.match)
+		}
+	}
+}
+
+func TestIsIgnored(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		".gitignore":      "# Build output\n*.log\n!keep.log\n/build\ngenerated/\n",
+		"docs/.gitignore": "draft*.md\n!draft-public.md\n",
+	})
+
+	ignores := map[string]*gitignore{}
+	for base, name := range map[string]string{".": ".gitignore", "docs": "docs/.gitignore"} {
+		ignore, err := loadGitignore(filepath.Join(dir, filepath.FromSlash(name)), base)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ignores[base] = ignore
+	}
+
+	tests := []struct {
+		path    string
+		isDir   bool
+		ignored bool
+	}{
+		{"app.log", false, true},
+		{"src/app.log", false, true},
+		// The later negation wins
+		{"keep.log", false, false},
+		{"src/keep.log", false, false},
+		{"build", true, true},
+		{"src/build", true, false},
+		// Directory-only rules don't apply to files
+		{"generated", true, true},
+		{"src/generated", true, true},
+		{"generated", false, false},
+		// Nested .gitignore files apply below their own directory only
+		{"docs/draft1.md", false, true},
+		{"docs/api/draft1.md", false, true},
+		{"docs/draft-public.md", false, false},
+		{"draft1.md", false, false},
+		{"docs/guide.md", false, false},
+	}
+	for _, test := range tests {
+		if got := isIgnored(ignores, test.path, test.isDir); got != test.ignored {
+			t.Errorf("isIgnored(%q, dir=%v) = %v, want %v", test.path, test.isDir, got, test.ignored)
+		}
+	}
+}
+
+func TestParseMarkdownNote(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    MarkdownNote
+	}{
+		{
+			name: "frontmatter and wiki-links",
+			content: "---\ntitle: \"Deploy notes\"\ntags: [ops, '#release']\naliases:\n  - Deploys\n---\n" +
+				"# Heading\nSee [[Rollback|the rollback plan]], [[Runbook#Restarts]] and ![[diagram.png]].\nAlso [[Rollback]].\n",
+			want: MarkdownNote{
+				Title: "Deploy notes",
+				Tags:  []string{"ops", "release"},
+				Links: []string{"Rollback", "Runbook", "diagram.png"},
+				Body:  "# Heading\nSee the rollback plan, Runbook and diagram.png.\nAlso Rollback.",
+			},
+		},
+		{
+			name:    "block list tags and alias as title",
+			content: "---\r\ntags:\r\n  - infra\r\n  - \"on-call\"\r\nalias: Pager duty\r\n---\r\nRotate weekly.",
+			want: MarkdownNote{
+				Title: "Pager duty",
+				Tags:  []string{"infra", "on-call"},
+				Body:  "Rotate weekly.",
+			},
+		},
+		{
+			name:    "title from the first heading",
+			content: "Intro line\n\n# Incident review\n\nDetails",
+			want: MarkdownNote{
+				Title: "Incident review",
+				Body:  "Intro line\n\n# Incident review\n\nDetails",
+			},
+		},
+		{
+			name:    "unterminated frontmatter is content",
+			content: "---\ntitle: Draft\n",
+			want:    MarkdownNote{Body: "---\ntitle: Draft"},
+		},
+	}
+	for _, test := range tests {
+		got := ParseMarkdownNote(test.content)
+		if got.Title != test.want.Title || got.Body != test.want.Body ||
+			strings.Join(got.Tags, ",") != strings.Join(test.want.Tags, ",") ||
+			strings.Join(got.Links, ",") != strings.Join(test.want.Links, ",") {
+			t.Errorf("%s:\ngot  %#v\nwant %#v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestFileURL(t *testing.T) {
+	tests := []struct {
+		root FilesystemRoot
+		path string
+		rel  string
+		want string
+	}{
+		{
+			FilesystemRoot{Path: "/srv/notes", WebBaseURL: "https://git.example.com/notes/blob/main"},
+			"/srv/notes/My Notes/a#b.md", "My Notes/a#b.md",
+			"https://git.example.com/notes/blob/main/My%20Notes/a%23b.md",
+		},
+		{
+			FilesystemRoot{Path: "/srv/notes"},
+			"/srv/notes/My Notes/plan.md", "My Notes/plan.md",
+			"file:///srv/notes/My%20Notes/plan.md",
+		},
+	}
+	for _, test := range tests {
+		if got := fileURL(test.root, test.path, test.rel); got != test.want {
+			t.Errorf("fileURL(%s) = %q, want %q", test.rel, got, test.want)
+		}
+	}
+}
+
+func TestFilesystemSync(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		".gitignore":             "*.log\nvendor/\n",
+		"notes/deploy.md":        "---\ntags: [ops]\n---\n# Deploys\nSee [[Rollback]]",
+		"cmd/main.go":            "package main",
+		"docs/guide.html":        "<html><body><h1>Guide</h1><p>Start here</p></body></html>",
+		"app.log":                "ignored",
+		"vendor/lib/lib.go":      "package lib",
+		".obsidian/workspace.md": "hidden",
+		"node_modules/x/x.js":    "module",
+		"logo.png":               "not text",
+	})
+
+	index, err := NewLocalIndex("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := NewFilesystemService([]FilesystemRoot{{Path: dir}}, index, 0)
+
+	indexed := func() string {
+		var paths []string
+		for _, doc := range index.Documents(nil) {
+			paths = append(paths, doc.Metadata["path"])
+		}
+		sort.Strings(paths)
+		return strings.Join(paths, ",")
+	}
+
+	if err := fs.Sync(); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if got := indexed(); got != "cmd/main.go,docs/guide.html,notes/deploy.md" {
+		t.Fatalf("indexed %s, want the notes, code and docs without ignored or hidden files", got)
+	}
+
+	note, _ := index.Get(filepath.Join(dir, "notes", "deploy.md"))
+	if note.Title != "Deploys" || note.Metadata["tags"] != "ops" || note.Metadata["links"] != "Rollback" || !strings.HasPrefix(note.Content, "Tags: ops") {
+		t.Errorf("note = %+v", note)
+	}
+	if code, _ := index.Get(filepath.Join(dir, "cmd", "main.go")); !strings.Contains(code.Content, "```go\npackage main\n```") {
+		t.Errorf("code content = %q", code.Content)
+	}
+	if page, _ := index.Get(filepath.Join(dir, "docs", "guide.html")); page.Title != "Guide" {
+		t.Errorf("HTML title = %q, want its first heading", page.Title)
+	}
+
+	// Change one file, add one and delete one
+	writeFiles(t, dir, map[string]string{
+		"notes/deploy.md": "# Deploys\nShip on Tuesdays",
+		"notes/oncall.md": "# On-call",
+	})
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(filepath.Join(dir, "notes", "deploy.md"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, "cmd", "main.go")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := fs.Sync(); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if got := indexed(); got != "docs/guide.html,notes/deploy.md,notes/oncall.md" {
+		t.Errorf("indexed %s after the changes", got)
+	}
+	if note, _ := index.Get(filepath.Join(dir, "notes", "deploy.md")); !strings.Contains(note.Content, "Ship on Tuesdays") || note.Metadata["tags"] != "" {
+		t.Errorf("changed note = %+v, want it reindexed", note)
+	}
+
+	// Newly ignored files are removed too
+	writeFiles(t, dir, map[string]string{".gitignore": "*.log\nvendor/\nnotes/\n"})
+	if err := fs.Sync(); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if got := indexed(); got != "docs/guide.html" {
+		t.Errorf("indexed %s, want the ignored notes removed", got)
+	}
+}
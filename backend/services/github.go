@@ -0,0 +1,409 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type GitHubService struct {
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	APIBaseURL   string   // Overridable for GitHub Enterprise or a local stub
+	Orgs         []string // Organizations to search; empty means everything the token can see
+	Repos        []string // Repositories ("owner/name") to search
+}
+
+type GitHubOAuthResponse struct {
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type"`
+	Scope            string `json:"scope"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+type GitHubIssueSearchResponse struct {
+	TotalCount int           `json:"total_count"`
+	Items      []GitHubIssue `json:"items"`
+}
+
+type GitHubIssue struct {
+	Number        int    `json:"number"`
+	Title         string `json:"title"`
+	Body          string `json:"body"`
+	State         string `json:"state"`
+	HTMLURL       string `json:"html_url"`
+	RepositoryURL string `json:"repository_url"`
+	UpdatedAt     string `json:"updated_at"`
+	User          struct {
+		Login string `json:"login"`
+	} `json:"user"`
+	Labels []struct {
+		Name string `json:"name"`
+	} `json:"labels"`
+	PullRequest *struct {
+		URL      string `json:"url"`
+		MergedAt string `json:"merged_at"`
+	} `json:"pull_request"`
+}
+
+// Repository returns the "owner/name" of the issue's repository
+func (i GitHubIssue) Repository() string {
+	index := strings.Index(i.RepositoryURL, "/repos/")
+	if index < 0 {
+		return ""
+	}
+	return i.RepositoryURL[index+len("/repos/"):]
+}
+
+type GitHubCodeSearchResponse struct {
+	TotalCount int              `json:"total_count"`
+	Items      []GitHubCodeItem `json:"items"`
+}
+
+type GitHubCodeItem struct {
+	Name       string `json:"name"`
+	Path       string `json:"path"`
+	HTMLURL    string `json:"html_url"`
+	Repository struct {
+		FullName string `json:"full_name"`
+	} `json:"repository"`
+	TextMatches []struct {
+		Fragment string `json:"fragment"`
+	} `json:"text_matches"`
+}
+
+type GitHubComment struct {
+	ID        int64  `json:"id"`
+	Body      string `json:"body"`
+	Path      string `json:"path"`     // Review comments only
+	DiffHunk  string `json:"diff_hunk"` // Review comments only
+	InReplyTo int64  `json:"in_reply_to_id"`
+	HTMLURL   string `json:"html_url"`
+	User      struct {
+		Login string `json:"login"`
+	} `json:"user"`
+}
+
+type GitHubDiscussion struct {
+	Title      string `json:"title"`
+	Body       string `json:"body"`
+	URL        string `json:"url"`
+	UpdatedAt  string `json:"updatedAt"`
+	Repository struct {
+		NameWithOwner string `json:"nameWithOwner"`
+	} `json:"repository"`
+	Answer *struct {
+		Body string `json:"body"`
+	} `json:"answer"`
+	Comments struct {
+		Nodes []struct {
+			Body   string `json:"body"`
+			Author struct {
+				Login string `json:"login"`
+			} `json:"author"`
+		} `json:"nodes"`
+	} `json:"comments"`
+}
+
+func NewGitHubService(clientID, clientSecret, redirectURL string, orgs, repos []string) *GitHubService {
+	return &GitHubService{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+		APIBaseURL:   "https://api.github.com",
+		Orgs:         orgs,
+		Repos:        repos,
+	}
+}
+
+func (gh *GitHubService) GetAuthURL(state, codeChallenge string) string {
+	baseURL := "https://github.com/login/oauth/authorize"
+	params := url.Values{}
+	params.Add("client_id", gh.ClientID)
+	params.Add("redirect_uri", gh.RedirectURL)
+	params.Add("scope", "repo read:org read:discussion")
+	params.Add("state", state)
+	if codeChallenge != "" {
+		params.Add("code_challenge", codeChallenge)
+		params.Add("code_challenge_method", "S256")
+	}
+
+	return fmt.Sprintf("%s?%s", baseURL, params.Encode())
+}
+
+func (gh *GitHubService) ExchangeCodeForToken(code, codeVerifier string) (*GitHubOAuthResponse, error) {
+	tokenURL := "https://github.com/login/oauth/access_token"
+
+	data := url.Values{}
+	data.Set("client_id", gh.ClientID)
+	data.Set("client_secret", gh.ClientSecret)
+	data.Set("code", code)
+	if codeVerifier != "" {
+		data.Set("code_verifier", codeVerifier)
+	}
+	data.Set("redirect_uri", gh.RedirectURL)
+
+	req, err := http.NewRequest("POST", tokenURL, strings.NewReader(data.Encode()))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Add("Accept", "application/json")
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to exchange code for token: %s", resp.Status)
+	}
+
+	var tokenResponse GitHubOAuthResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResponse); err != nil {
+		return nil, err
+	}
+
+	// GitHub reports OAuth errors with a 200 status
+	if tokenResponse.Error != "" {
+		return nil, fmt.Errorf("github oauth failed: %s", tokenResponse.ErrorDescription)
+	}
+
+	return &tokenResponse, nil
+}
+
+// scopeQualifiers limits a search to the configured orgs and repositories
+func (gh *GitHubService) scopeQualifiers() string {
+	var qualifiers []string
+	for _, org := range gh.Orgs {
+		qualifiers = append(qualifiers, "org:"+org)
+	}
+	for _, repo := range gh.Repos {
+		qualifiers = append(qualifiers, "repo:"+repo)
+	}
+	return strings.Join(qualifiers, " ")
+}
+
+// SearchIssues searches issues and pull requests
+func (gh *GitHubService) SearchIssues(accessToken, query string, perPage int) (*GitHubIssueSearchResponse, error) {
+	if perPage == 0 {
+		perPage = 10
+	}
+
+	params := url.Values{}
+	params.Add("q", strings.TrimSpace(query+" "+gh.scopeQualifiers()))
+	params.Add("per_page", fmt.Sprintf("%d", perPage))
+
+	var searchResults GitHubIssueSearchResponse
+	if err := gh.getJSON(accessToken, "/search/issues?"+params.Encode(), "application/vnd.github+json", &searchResults); err != nil {
+		return nil, fmt.Errorf("failed to search GitHub issues: %v", err)
+	}
+
+	return &searchResults, nil
+}
+
+// SearchCode searches repository files. Code search requires at least one
+// org or repo qualifier, so it is skipped when none are configured.
+func (gh *GitHubService) SearchCode(accessToken, query string, perPage int) (*GitHubCodeSearchResponse, error) {
+	qualifiers := gh.scopeQualifiers()
+	if qualifiers == "" {
+		return &GitHubCodeSearchResponse{}, nil
+	}
+	if perPage == 0 {
+		perPage = 5
+	}
+
+	params := url.Values{}
+	params.Add("q", query+" "+qualifiers)
+	params.Add("per_page", fmt.Sprintf("%d", perPage))
+
+	var searchResults GitHubCodeSearchResponse
+	if err := gh.getJSON(accessToken, "/search/code?"+params.Encode(), "application/vnd.github.text-match+json", &searchResults); err != nil {
+		return nil, fmt.Errorf("failed to search GitHub code: %v", err)
+	}
+
+	return &searchResults, nil
+}
+
+// SearchDiscussions searches repository discussions through the GraphQL API
+func (gh *GitHubService) SearchDiscussions(accessToken, query string, first int) ([]GitHubDiscussion, error) {
+	if first == 0 {
+		first = 5
+	}
+
+	graphQL := `query($q: String!, $first: Int!) {
+  search(query: $q, type: DISCUSSION, first: $first) {
+    nodes {
+      ... on Discussion {
+        title body url updatedAt
+        repository { nameWithOwner }
+        answer { body }
+        comments(first: 5) { nodes { body author { login } } }
+      }
+    }
+  }
+}`
+	payload, err := json.Marshal(map[string]interface{}{
+		"query": graphQL,
+		"variables": map[string]interface{}{
+			"q":     strings.TrimSpace(query + " " + gh.scopeQualifiers()),
+			"first": first,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", gh.APIBaseURL+"/graphql", bytes.NewBuffer(payload))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Add("Authorization", "Bearer "+accessToken)
+	req.Header.Add("Content-Type", "application/json")
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to search GitHub discussions: %s", resp.Status)
+	}
+
+	var result struct {
+		Data struct {
+			Search struct {
+				Nodes []GitHubDiscussion `json:"nodes"`
+			} `json:"search"`
+		} `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	if len(result.Errors) > 0 {
+		return nil, fmt.Errorf("failed to search GitHub discussions: %s", result.Errors[0].Message)
+	}
+
+	return result.Data.Search.Nodes, nil
+}
+
+// GetIssueComments returns the conversation comments of an issue or pull request
+func (gh *GitHubService) GetIssueComments(accessToken, repo string, number int) ([]GitHubComment, error) {
+	var comments []GitHubComment
+	path := fmt.Sprintf("/repos/%s/issues/%d/comments?per_page=30", repo, number)
+	if err := gh.getJSON(accessToken, path, "application/vnd.github+json", &comments); err != nil {
+		return nil, fmt.Errorf("failed to get issue comments: %v", err)
+	}
+	return comments, nil
+}
+
+// GetReviewComments returns the review thread comments of a pull request
+func (gh *GitHubService) GetReviewComments(accessToken, repo string, number int) ([]GitHubComment, error) {
+	var comments []GitHubComment
+	path := fmt.Sprintf("/repos/%s/pulls/%d/comments?per_page=50", repo, number)
+	if err := gh.getJSON(accessToken, path, "application/vnd.github+json", &comments); err != nil {
+		return nil, fmt.Errorf("failed to get review comments: %v", err)
+	}
+	return comments, nil
+}
+
+// GetFileContent returns the raw content of a file in a repository
+func (gh *GitHubService) GetFileContent(accessToken, repo, filePath string) (string, error) {
+	escaped := strings.ReplaceAll(url.PathEscape(filePath), "%2F", "/")
+	return gh.getRaw(accessToken, fmt.Sprintf("/repos/%s/contents/%s", repo, escaped))
+}
+
+// GetReadme returns the raw README of a repository
+func (gh *GitHubService) GetReadme(accessToken, repo string) (string, error) {
+	return gh.getRaw(accessToken, fmt.Sprintf("/repos/%s/readme", repo))
+}
+
+// FormatReviewThreads groups review comments into threads under the file they comment on
+func (gh *GitHubService) FormatReviewThreads(comments []GitHubComment) string {
+	var order []int64
+	threads := make(map[int64][]GitHubComment)
+	for _, comment := range comments {
+		root := comment.ID
+		if comment.InReplyTo != 0 {
+			root = comment.InReplyTo
+		}
+		if _, ok := threads[root]; !ok {
+			order = append(order, root)
+		}
+		threads[root] = append(threads[root], comment)
+	}
+
+	var parts []string
+	for _, root := range order {
+		thread := threads[root]
+		lines := []string{fmt.Sprintf("Review thread on %s:", thread[0].Path)}
+		for _, comment := range thread {
+			lines = append(lines, fmt.Sprintf("%s: %s", comment.User.Login, comment.Body))
+		}
+		parts = append(parts, strings.Join(lines, "\n"))
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+func (gh *GitHubService) getJSON(accessToken, path, accept string, out interface{}) error {
+	body, err := gh.get(accessToken, path, accept)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	return json.NewDecoder(body).Decode(out)
+}
+
+func (gh *GitHubService) getRaw(accessToken, path string) (string, error) {
+	body, err := gh.get(accessToken, path, "application/vnd.github.raw+json")
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(body, maxDocumentBytes))
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func (gh *GitHubService) get(accessToken, path, accept string) (io.ReadCloser, error) {
+	req, err := http.NewRequest("GET", gh.APIBaseURL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Add("Authorization", "Bearer "+accessToken)
+	req.Header.Add("Accept", accept)
+	req.Header.Add("X-GitHub-Api-Version", "2022-11-28")
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return resp.Body, nil
+}
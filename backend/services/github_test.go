@@ -0,0 +1,179 @@
+package services
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newTestGitHubService(t *testing.T, handler http.HandlerFunc, orgs, repos []string) *GitHubService {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	gh := NewGitHubService("id", "secret", "", orgs, repos)
+	gh.APIBaseURL = server.URL
+	return gh
+}
+
+// Every search is limited to the configured orgs and repositories
+func TestGitHubSearchIsScopedToOrgsAndRepos(t *testing.T) {
+	const want = "flaky login org:acme repo:globex/api"
+	gh := newTestGitHubService(t, func(w http.ResponseWriter, r *http.Request) {
+		if auth := r.Header.Get("Authorization"); auth != "Bearer token" {
+			t.Errorf("%s: Authorization = %q", r.URL.Path, auth)
+		}
+		switch r.URL.Path {
+		case "/search/issues":
+			if q := r.URL.Query().Get("q"); q != want {
+				t.Errorf("issue search q = %q, want %q", q, want)
+			}
+			w.Write([]byte(`{"total_count": 1, "items": [{
+				"number": 7, "title": "Flaky login test", "state": "open",
+				"html_url": "https://github.com/acme/web/pull/7",
+				"repository_url": "https://api.github.com/repos/acme/web",
+				"pull_request": {"url": "https://api.github.com/repos/acme/web/pulls/7"}
+			}]}`))
+		case "/search/code":
+			if q := r.URL.Query().Get("q"); q != want {
+				t.Errorf("code search q = %q, want %q", q, want)
+			}
+			// Text matches are only returned for this media type
+			if accept := r.Header.Get("Accept"); accept != "application/vnd.github.text-match+json" {
+				t.Errorf("code search Accept = %q", accept)
+			}
+			w.Write([]byte(`{"total_count": 1, "items": [{
+				"name": "login.go", "path": "auth/login.go",
+				"repository": {"full_name": "acme/web"},
+				"text_matches": [{"fragment": "func Login()"}]
+			}]}`))
+		case "/graphql":
+			var payload struct {
+				Variables struct {
+					Q string `json:"q"`
+				} `json:"variables"`
+			}
+			json.NewDecoder(r.Body).Decode(&payload)
+			if payload.Variables.Q != want {
+				t.Errorf("discussion search q = %q, want %q", payload.Variables.Q, want)
+			}
+			w.Write([]byte(`{"data": {"search": {"nodes": [{
+				"title": "How do we log in?",
+				"repository": {"nameWithOwner": "acme/web"},
+				"answer": {"body": "With SSO"}
+			}]}}}`))
+		default:
+			t.Errorf("unexpected request %s", r.URL)
+			http.NotFound(w, r)
+		}
+	}, []string{"acme"}, []string{"globex/api"})
+
+	issues, err := gh.SearchIssues("token", "flaky login", 5)
+	if err != nil {
+		t.Fatalf("SearchIssues: %v", err)
+	}
+	if len(issues.Items) != 1 || issues.Items[0].Repository() != "acme/web" || issues.Items[0].PullRequest == nil {
+		t.Errorf("issues = %+v, want the pull request in acme/web", issues.Items)
+	}
+
+	code, err := gh.SearchCode("token", "flaky login", 5)
+	if err != nil {
+		t.Fatalf("SearchCode: %v", err)
+	}
+	if len(code.Items) != 1 || code.Items[0].TextMatches[0].Fragment != "func Login()" {
+		t.Errorf("code = %+v", code.Items)
+	}
+
+	discussions, err := gh.SearchDiscussions("token", "flaky login", 5)
+	if err != nil {
+		t.Fatalf("SearchDiscussions: %v", err)
+	}
+	if len(discussions) != 1 || discussions[0].Answer == nil || discussions[0].Answer.Body != "With SSO" {
+		t.Errorf("discussions = %+v", discussions)
+	}
+}
+
+// GitHub refuses code search without an org or repo qualifier
+func TestGitHubCodeSearchNeedsOrgOrRepo(t *testing.T) {
+	gh := newTestGitHubService(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("code search ran without an org or repo: %s", r.URL)
+	}, nil, nil)
+
+	code, err := gh.SearchCode("token", "login", 5)
+	if err != nil || len(code.Items) != 0 {
+		t.Errorf("SearchCode = %+v, %v, want no results", code, err)
+	}
+}
+
+func TestGitHubReviewThreads(t *testing.T) {
+	gh := newTestGitHubService(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repos/acme/web/pulls/7/comments" {
+			http.NotFound(w, r)
+			return
+		}
+		// Replies come after other threads' comments, as GitHub orders them by time
+		w.Write([]byte(`[
+			{"id": 1, "body": "Why sleep here?", "path": "auth/login_test.go", "user": {"login": "grace"}},
+			{"id": 3, "body": "Typo", "path": "README.md", "user": {"login": "grace"}},
+			{"id": 2, "body": "The mock is slow", "path": "auth/login_test.go", "in_reply_to_id": 1, "user": {"login": "ada"}}
+		]`))
+	}, []string{"acme"}, nil)
+
+	comments, err := gh.GetReviewComments("token", "acme/web", 7)
+	if err != nil {
+		t.Fatalf("GetReviewComments: %v", err)
+	}
+	want := "Review thread on auth/login_test.go:\ngrace: Why sleep here?\nada: The mock is slow\n\n" +
+		"Review thread on README.md:\ngrace: Typo"
+	if got := gh.FormatReviewThreads(comments); got != want {
+		t.Errorf("FormatReviewThreads = %q, want %q", got, want)
+	}
+}
+
+func TestGitHubReadmeAndDocs(t *testing.T) {
+	gh := newTestGitHubService(t, func(w http.ResponseWriter, r *http.Request) {
+		if accept := r.Header.Get("Accept"); accept != "application/vnd.github.raw+json" {
+			t.Errorf("%s: Accept = %q, want the raw file", r.URL.Path, accept)
+		}
+		switch r.URL.EscapedPath() {
+		case "/repos/acme/web/readme":
+			w.Write([]byte("# Web"))
+		case "/repos/acme/web/contents/docs/getting%20started.md":
+			w.Write([]byte("# Getting started"))
+		default:
+			http.NotFound(w, r)
+		}
+	}, []string{"acme"}, nil)
+
+	readme, err := gh.GetReadme("token", "acme/web")
+	if err != nil || readme != "# Web" {
+		t.Errorf("GetReadme = %q, %v", readme, err)
+	}
+	doc, err := gh.GetFileContent("token", "acme/web", "docs/getting started.md")
+	if err != nil || doc != "# Getting started" {
+		t.Errorf("GetFileContent = %q, %v", doc, err)
+	}
+	if _, err := gh.GetFileContent("token", "acme/web", "docs/missing.md"); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("err = %v, want a 404", err)
+	}
+}
+
+// A rate-limited token fails the search rather than returning nothing
+func TestGitHubRateLimited(t *testing.T) {
+	gh := newTestGitHubService(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/graphql" {
+			w.Write([]byte(`{"errors": [{"type": "RATE_LIMITED", "message": "API rate limit exceeded"}]}`))
+			return
+		}
+		w.Header().Set("X-RateLimit-Remaining", "0")
+		w.WriteHeader(http.StatusForbidden)
+	}, []string{"acme"}, nil)
+
+	if _, err := gh.SearchIssues("token", "login", 5); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("SearchIssues err = %v, want the 403", err)
+	}
+	if _, err := gh.SearchDiscussions("token", "login", 5); err == nil || !strings.Contains(err.Error(), "rate limit") {
+		t.Errorf("SearchDiscussions err = %v, want the GraphQL error", err)
+	}
+}
@@ -72,27 +72,35 @@ func NewGmailService(clientID, clientSecret, redirectURL string) *GmailService {
 	}
 }
 
-func (gs *GmailService) GetAuthURL(state string) string {
+func (gs *GmailService) GetAuthURL(state, codeChallenge string) string {
 	baseURL := "https://accounts.google.com/o/oauth2/v2/auth"
 	params := url.Values{}
 	params.Add("client_id", gs.ClientID)
 	params.Add("redirect_uri", gs.RedirectURL)
 	params.Add("response_type", "code")
 	params.Add("scope", "https://www.googleapis.com/auth/gmail.readonly")
+	params.Add("include_granted_scopes", "true")
 	params.Add("access_type", "offline")
 	params.Add("state", state)
+	if codeChallenge != "" {
+		params.Add("code_challenge", codeChallenge)
+		params.Add("code_challenge_method", "S256")
+	}
 	params.Add("prompt", "consent")
 
 	return fmt.Sprintf("%s?%s", baseURL, params.Encode())
 }
 
-func (gs *GmailService) ExchangeCodeForToken(code string) (*GmailOAuthResponse, error) {
+func (gs *GmailService) ExchangeCodeForToken(code, codeVerifier string) (*GmailOAuthResponse, error) {
 	tokenURL := "https://oauth2.googleapis.com/token"
 
 	data := url.Values{}
 	data.Set("client_id", gs.ClientID)
 	data.Set("client_secret", gs.ClientSecret)
 	data.Set("code", code)
+	if codeVerifier != "" {
+		data.Set("code_verifier", codeVerifier)
+	}
 	data.Set("grant_type", "authorization_code")
 	data.Set("redirect_uri", gs.RedirectURL)
 
@@ -122,6 +130,44 @@ func (gs *GmailService) ExchangeCodeForToken(code string) (*GmailOAuthResponse,
 	return &tokenResponse, nil
 }
 
+// RefreshAccessToken trades a refresh token for a new access token. Google
+// keeps the refresh token, so the response usually doesn't include one.
+// Drive tokens come from the same client and are refreshed here too.
+func (gs *GmailService) RefreshAccessToken(refreshToken string) (*GmailOAuthResponse, error) {
+	tokenURL := "https://oauth2.googleapis.com/token"
+
+	data := url.Values{}
+	data.Set("client_id", gs.ClientID)
+	data.Set("client_secret", gs.ClientSecret)
+	data.Set("refresh_token", refreshToken)
+	data.Set("grant_type", "refresh_token")
+
+	req, err := http.NewRequest("POST", tokenURL, strings.NewReader(data.Encode()))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to refresh token: %w", newStatusError(resp))
+	}
+
+	var tokenResponse GmailOAuthResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResponse); err != nil {
+		return nil, err
+	}
+
+	return &tokenResponse, nil
+}
+
 func (gs *GmailService) SearchMessages(accessToken, query string, maxResults int) (*GmailSearchResponse, error) {
 	if maxResults == 0 {
 		maxResults = 10
@@ -150,7 +196,7 @@ func (gs *GmailService) SearchMessages(accessToken, query string, maxResults int
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to search Gmail: %s", resp.Status)
+		return nil, fmt.Errorf("failed to search Gmail: %w", newStatusError(resp))
 	}
 
 	var searchResults GmailSearchResponse
@@ -180,7 +226,7 @@ func (gs *GmailService) GetMessageDetail(accessToken, messageID string) (*GmailM
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to get message detail: %s", resp.Status)
+		return nil, fmt.Errorf("failed to get message detail: %w", newStatusError(resp))
 	}
 
 	var messageDetail GmailMessageDetail
@@ -191,24 +237,25 @@ func (gs *GmailService) GetMessageDetail(accessToken, messageID string) (*GmailM
 	return &messageDetail, nil
 }
 
-// Helper function to extract email content and metadata
+// ExtractEmailInfo returns the headers and the readable body of a message,
+// without quoted replies
 func (gs *GmailService) ExtractEmailInfo(message *GmailMessageDetail) (subject, sender, content string, date time.Time) {
 	// Extract headers
 	for _, header := range message.Payload.Headers {
 		switch header.Name {
 		case "Subject":
-			subject = header.Value
+			subject = DecodeEmailHeader(header.Value)
 		case "From":
-			sender = header.Value
+			sender = DecodeEmailHeader(header.Value)
 		case "Date":
-			if parsedDate, err := time.Parse(time.RFC1123Z, header.Value); err == nil {
-				date = parsedDate
-			}
+			date = ParseEmailDate(header.Value)
 		}
 	}
 
 	// Extract body content
-	content = gs.extractBody(&message.Payload)
+	email := &EmailMessage{}
+	gs.extractBody(&message.Payload, email)
+	content = email.Content()
 	if content == "" {
 		content = message.Snippet // Fall back to snippet
 	}
@@ -216,44 +263,24 @@ func (gs *GmailService) ExtractEmailInfo(message *GmailMessageDetail) (subject,
 	return subject, sender, content, date
 }
 
-func (gs *GmailService) extractBody(payload *GmailMessagePayload) string {
+// extractBody fills the first text/plain and text/html bodies of the payload tree
+func (gs *GmailService) extractBody(payload *GmailMessagePayload, email *EmailMessage) {
 	// If this part has a body with data, extract it
-	if payload.Body.Data != "" {
+	if payload.Body.Data != "" && payload.Filename == "" {
 		// Gmail API returns base64url-encoded data
-		decoded, err := gs.decodeBase64URL(payload.Body.Data)
+		decoded, err := DecodeBase64URL(payload.Body.Data)
 		if err == nil {
-			return decoded
+			switch {
+			case payload.MimeType == "text/html" && email.HTMLBody == "":
+				email.HTMLBody = decoded
+			case payload.MimeType != "text/html" && email.TextBody == "":
+				email.TextBody = decoded
+			}
 		}
 	}
 
 	// If this is a multipart message, search through parts
-	for _, part := range payload.Parts {
-		if part.MimeType == "text/plain" || part.MimeType == "text/html" {
-			body := gs.extractBody(&part)
-			if body != "" {
-				return body
-			}
-		}
+	for i := range payload.Parts {
+		gs.extractBody(&payload.Parts[i], email)
 	}
-
-	return ""
 }
-
-func (gs *GmailService) decodeBase64URL(data string) (string, error) {
-	// Gmail uses base64url encoding without padding
-	// Convert to standard base64 and add padding if needed
-	data = strings.ReplaceAll(data, "-", "+")
-	data = strings.ReplaceAll(data, "_", "/")
-	
-	// Add padding
-	switch len(data) % 4 {
-	case 2:
-		data += "=="
-	case 3:
-		data += "="
-	}
-
-	// Decode would require base64 package - for now return the data as is
-	// In production, you'd want to properly decode this
-	return data, nil
-}
\ No newline at end of file
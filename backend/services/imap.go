@@ -0,0 +1,517 @@
+package services
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// imapMaxMessageBytes limits how much of each message is fetched; bodies are
+// cut down to the relevant sections later anyway
+const imapMaxMessageBytes = 256 << 10
+
+// IMAPService searches mailboxes on a plain IMAP server, such as shared
+// support inboxes. Searching happens on the server; messages are parsed locally.
+type IMAPService struct {
+	Host       string
+	Port       int
+	Username   string
+	Password   string   // Password for LOGIN, or an OAuth access token for XOAUTH2
+	AuthMethod string   // "login" or "xoauth2"
+	Security   string   // "tls" (implicit TLS), "starttls", or "none" for local test servers
+	Mailboxes  []string // Mailboxes to search, e.g. INBOX
+	Timeout    time.Duration
+}
+
+// IMAPMessage is a message found by Search
+type IMAPMessage struct {
+	Mailbox string
+	UID     uint32
+	URL     string // RFC 5092 IMAP URL of the message
+	Email   *EmailMessage
+}
+
+func NewIMAPService(host string, port int, username, password, authMethod, security string, mailboxes []string) *IMAPService {
+	if port == 0 {
+		port = 993
+		if security == "starttls" || security == "none" {
+			port = 143
+		}
+	}
+	if len(mailboxes) == 0 {
+		mailboxes = []string{"INBOX"}
+	}
+	return &IMAPService{
+		Host:       host,
+		Port:       port,
+		Username:   username,
+		Password:   password,
+		AuthMethod: strings.ToLower(authMethod),
+		Security:   strings.ToLower(security),
+		Mailboxes:  mailboxes,
+		Timeout:    30 * time.Second,
+	}
+}
+
+// Search finds the newest messages matching all query keywords, falling back
+// to any keyword when nothing matches all of them
+func (is *IMAPService) Search(query string, maxResults int) ([]IMAPMessage, error) {
+	if maxResults == 0 {
+		maxResults = 10
+	}
+
+	keywords := extractKeywords(strings.ToLower(query))
+	if len(keywords) > 5 {
+		keywords = keywords[:5]
+	}
+	if len(keywords) == 0 {
+		return nil, nil
+	}
+
+	conn, err := is.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.close()
+
+	var messages []IMAPMessage
+	for _, mailbox := range is.Mailboxes {
+		uidValidity, err := conn.examine(mailbox)
+		if err != nil {
+			return nil, err
+		}
+
+		uids, err := conn.uidSearch(keywords, false)
+		if err == nil && len(uids) == 0 && len(keywords) > 1 {
+			uids, err = conn.uidSearch(keywords, true)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// Higher UIDs are newer messages
+		sort.Slice(uids, func(i, j int) bool { return uids[i] > uids[j] })
+		if len(uids) > maxResults {
+			uids = uids[:maxResults]
+		}
+
+		bodies, err := conn.uidFetchBodies(uids)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, uid := range uids {
+			raw, ok := bodies[uid]
+			if !ok {
+				continue
+			}
+			email, err := ParseMIMEMessage(raw)
+			if err != nil {
+				continue
+			}
+			messages = append(messages, IMAPMessage{
+				Mailbox: mailbox,
+				UID:     uid,
+				URL:     is.messageURL(mailbox, uidValidity, uid),
+				Email:   email,
+			})
+		}
+	}
+
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].Email.Date.After(messages[j].Email.Date)
+	})
+	if len(messages) > maxResults {
+		messages = messages[:maxResults]
+	}
+
+	return messages, nil
+}
+
+func (is *IMAPService) messageURL(mailbox string, uidValidity, uid uint32) string {
+	u := url.URL{
+		Scheme: "imap",
+		User:   url.User(is.Username),
+		Host:   net.JoinHostPort(is.Host, strconv.Itoa(is.Port)),
+	}
+	return fmt.Sprintf("%s/%s;UIDVALIDITY=%d/;UID=%d", u.String(), url.PathEscape(mailbox), uidValidity, uid)
+}
+
+// connect opens a session and authenticates
+func (is *IMAPService) connect() (*imapConn, error) {
+	address := net.JoinHostPort(is.Host, strconv.Itoa(is.Port))
+	dialer := &net.Dialer{Timeout: is.Timeout}
+	tlsConfig := &tls.Config{ServerName: is.Host}
+
+	var netConn net.Conn
+	var err error
+	if is.Security == "starttls" || is.Security == "none" {
+		netConn, err = dialer.Dial("tcp", address)
+	} else {
+		netConn, err = tls.DialWithDialer(dialer, "tcp", address, tlsConfig)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to IMAP server: %v", err)
+	}
+	netConn.SetDeadline(time.Now().Add(is.Timeout))
+
+	conn := newIMAPConn(netConn)
+	greeting, err := conn.readResponse()
+	if err != nil {
+		netConn.Close()
+		return nil, fmt.Errorf("failed to read IMAP greeting: %v", err)
+	}
+	if !strings.HasPrefix(greeting.text, "* OK") && !strings.HasPrefix(greeting.text, "* PREAUTH") {
+		netConn.Close()
+		return nil, fmt.Errorf("IMAP server refused connection: %s", greeting.text)
+	}
+
+	if is.Security == "starttls" {
+		if _, err := conn.command("STARTTLS"); err != nil {
+			netConn.Close()
+			return nil, err
+		}
+		tlsConn := tls.Client(netConn, tlsConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			netConn.Close()
+			return nil, fmt.Errorf("failed to start TLS: %v", err)
+		}
+		conn = newIMAPConn(tlsConn)
+	}
+
+	if err := conn.authenticate(is.AuthMethod, is.Username, is.Password); err != nil {
+		conn.conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// imapConn is a minimal IMAP4rev1 client connection
+type imapConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+	tag  int
+}
+
+// imapResponse is one response line; literals ({n} followed by n bytes) are
+// collected separately and marked in text by "{}"
+type imapResponse struct {
+	text     string
+	literals [][]byte
+}
+
+// imapString is a command argument sent as a quoted string, or as a literal
+// when it contains characters quoted strings can't carry
+type imapString string
+
+var literalSuffix = regexp.MustCompile(`\{(\d+)\+?\}$`)
+
+func newIMAPConn(conn net.Conn) *imapConn {
+	return &imapConn{conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn)}
+}
+
+func (c *imapConn) close() {
+	c.command("LOGOUT")
+	c.conn.Close()
+}
+
+func (c *imapConn) readLine() (string, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func (c *imapConn) readResponse() (*imapResponse, error) {
+	response := &imapResponse{}
+	var text strings.Builder
+
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return nil, err
+		}
+
+		match := literalSuffix.FindStringSubmatchIndex(line)
+		if match == nil {
+			text.WriteString(line)
+			response.text = text.String()
+			return response, nil
+		}
+
+		size, _ := strconv.Atoi(line[match[2]:match[3]])
+		if size > 2*imapMaxMessageBytes {
+			return nil, fmt.Errorf("IMAP literal too large: %d bytes", size)
+		}
+		literal := make([]byte, size)
+		if _, err := io.ReadFull(c.r, literal); err != nil {
+			return nil, err
+		}
+		text.WriteString(line[:match[0]] + "{}")
+		response.literals = append(response.literals, literal)
+	}
+}
+
+// command sends a tagged command and returns its untagged responses. Arguments
+// are atoms (string) or strings (imapString).
+func (c *imapConn) command(args ...interface{}) ([]*imapResponse, error) {
+	c.tag++
+	tag := fmt.Sprintf("a%03d", c.tag)
+	c.w.WriteString(tag)
+
+	for _, arg := range args {
+		c.w.WriteString(" ")
+		switch value := arg.(type) {
+		case imapString:
+			if isQuotable(string(value)) {
+				c.w.WriteString(`"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(string(value)) + `"`)
+				continue
+			}
+			fmt.Fprintf(c.w, "{%d}\r\n", len(value))
+			if err := c.waitForContinuation(tag); err != nil {
+				return nil, err
+			}
+			c.w.WriteString(string(value))
+		default:
+			fmt.Fprint(c.w, value)
+		}
+	}
+
+	c.w.WriteString("\r\n")
+	if err := c.w.Flush(); err != nil {
+		return nil, err
+	}
+
+	return c.readUntilTagged(tag)
+}
+
+func isQuotable(value string) bool {
+	for _, r := range value {
+		if r > 127 || r == '\r' || r == '\n' || r == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *imapConn) waitForContinuation(tag string) error {
+	if err := c.w.Flush(); err != nil {
+		return err
+	}
+	for {
+		response, err := c.readResponse()
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(response.text, "+") {
+			return nil
+		}
+		if strings.HasPrefix(response.text, tag+" ") {
+			return fmt.Errorf("IMAP command rejected: %s", response.text)
+		}
+	}
+}
+
+func (c *imapConn) readUntilTagged(tag string) ([]*imapResponse, error) {
+	var untagged []*imapResponse
+	for {
+		response, err := c.readResponse()
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(response.text, tag+" ") {
+			untagged = append(untagged, response)
+			continue
+		}
+
+		status := strings.TrimPrefix(response.text, tag+" ")
+		if !strings.HasPrefix(status, "OK") {
+			return nil, fmt.Errorf("IMAP command failed: %s", status)
+		}
+		return untagged, nil
+	}
+}
+
+func (c *imapConn) capabilities() (map[string]bool, error) {
+	responses, err := c.command("CAPABILITY")
+	if err != nil {
+		return nil, err
+	}
+	capabilities := make(map[string]bool)
+	for _, response := range responses {
+		if strings.HasPrefix(response.text, "* CAPABILITY ") {
+			for _, capability := range strings.Fields(strings.TrimPrefix(response.text, "* CAPABILITY ")) {
+				capabilities[strings.ToUpper(capability)] = true
+			}
+		}
+	}
+	return capabilities, nil
+}
+
+func (c *imapConn) authenticate(method, username, secret string) error {
+	capabilities, err := c.capabilities()
+	if err != nil {
+		return err
+	}
+
+	if method != "xoauth2" {
+		if capabilities["LOGINDISABLED"] {
+			return fmt.Errorf("IMAP server does not allow LOGIN on this connection")
+		}
+		if _, err := c.command("LOGIN", imapString(username), imapString(secret)); err != nil {
+			return fmt.Errorf("failed to log in to IMAP server: %v", err)
+		}
+		return nil
+	}
+
+	if !capabilities["AUTH=XOAUTH2"] {
+		return fmt.Errorf("IMAP server does not support XOAUTH2")
+	}
+	initialResponse := base64.StdEncoding.EncodeToString([]byte("user=" + username + "\x01auth=Bearer " + secret + "\x01\x01"))
+
+	if err := c.authenticateXOAUTH2(initialResponse, capabilities["SASL-IR"]); err != nil {
+		return fmt.Errorf("failed to authenticate to IMAP server: %v", err)
+	}
+	return nil
+}
+
+// authenticateXOAUTH2 sends the SASL response inline (SASL-IR) or after the
+// server's continuation. On failure the server sends an error challenge that
+// must be answered with an empty line before the tagged NO.
+func (c *imapConn) authenticateXOAUTH2(initialResponse string, inline bool) error {
+	c.tag++
+	tag := fmt.Sprintf("a%03d", c.tag)
+
+	if inline {
+		fmt.Fprintf(c.w, "%s AUTHENTICATE XOAUTH2 %s\r\n", tag, initialResponse)
+	} else {
+		fmt.Fprintf(c.w, "%s AUTHENTICATE XOAUTH2\r\n", tag)
+		if err := c.waitForContinuation(tag); err != nil {
+			return err
+		}
+		c.w.WriteString(initialResponse + "\r\n")
+	}
+	if err := c.w.Flush(); err != nil {
+		return err
+	}
+
+	for {
+		response, err := c.readResponse()
+		if err != nil {
+			return err
+		}
+		switch {
+		case strings.HasPrefix(response.text, "+"):
+			c.w.WriteString("\r\n")
+			if err := c.w.Flush(); err != nil {
+				return err
+			}
+		case strings.HasPrefix(response.text, tag+" OK"):
+			return nil
+		case strings.HasPrefix(response.text, tag+" "):
+			return fmt.Errorf("%s", strings.TrimPrefix(response.text, tag+" "))
+		}
+	}
+}
+
+var uidValidityPattern = regexp.MustCompile(`\[UIDVALIDITY (\d+)\]`)
+
+// examine opens a mailbox read-only and returns its UIDVALIDITY
+func (c *imapConn) examine(mailbox string) (uint32, error) {
+	responses, err := c.command("EXAMINE", imapString(mailbox))
+	if err != nil {
+		return 0, fmt.Errorf("failed to open mailbox %s: %v", mailbox, err)
+	}
+	for _, response := range responses {
+		if match := uidValidityPattern.FindStringSubmatch(response.text); match != nil {
+			value, _ := strconv.ParseUint(match[1], 10, 32)
+			return uint32(value), nil
+		}
+	}
+	return 0, nil
+}
+
+// uidSearch finds messages whose headers or body contain all keywords, or any
+// of them when matchAny is set
+func (c *imapConn) uidSearch(keywords []string, matchAny bool) ([]uint32, error) {
+	args := []interface{}{"UID", "SEARCH"}
+	for _, keyword := range keywords {
+		if !isQuotable(keyword) {
+			args = append(args, "CHARSET", "UTF-8")
+			break
+		}
+	}
+	if matchAny {
+		// OR takes two keys, so n keys need n-1 ORs in prefix notation
+		for i := 1; i < len(keywords); i++ {
+			args = append(args, "OR")
+		}
+	}
+	for _, keyword := range keywords {
+		args = append(args, "TEXT", imapString(keyword))
+	}
+
+	responses, err := c.command(args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search IMAP mailbox: %v", err)
+	}
+
+	var uids []uint32
+	for _, response := range responses {
+		if !strings.HasPrefix(response.text, "* SEARCH") {
+			continue
+		}
+		for _, field := range strings.Fields(strings.TrimPrefix(response.text, "* SEARCH")) {
+			if uid, err := strconv.ParseUint(field, 10, 32); err == nil {
+				uids = append(uids, uint32(uid))
+			}
+		}
+	}
+	return uids, nil
+}
+
+var fetchUIDPattern = regexp.MustCompile(`\bUID (\d+)`)
+
+// uidFetchBodies downloads the start of each message without marking it as read
+func (c *imapConn) uidFetchBodies(uids []uint32) (map[uint32][]byte, error) {
+	bodies := make(map[uint32][]byte)
+	if len(uids) == 0 {
+		return bodies, nil
+	}
+
+	set := make([]string, len(uids))
+	for i, uid := range uids {
+		set[i] = strconv.FormatUint(uint64(uid), 10)
+	}
+
+	responses, err := c.command("UID", "FETCH", strings.Join(set, ","), fmt.Sprintf("(UID BODY.PEEK[]<0.%d>)", imapMaxMessageBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch IMAP messages: %v", err)
+	}
+
+	for _, response := range responses {
+		if !strings.Contains(response.text, " FETCH ") || len(response.literals) == 0 {
+			continue
+		}
+		match := fetchUIDPattern.FindStringSubmatch(response.text)
+		if match == nil {
+			continue
+		}
+		uid, _ := strconv.ParseUint(match[1], 10, 32)
+		bodies[uint32(uid)] = response.literals[0]
+	}
+
+	return bodies, nil
+}
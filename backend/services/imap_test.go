@@ -0,0 +1,251 @@
+package services
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+var imapTestMessages = map[uint32]string{
+	11: "From: Ada <ada@example.com>\r\nTo: support@example.com\r\nSubject: Rollback plan\r\n" +
+		"Date: Mon, 4 Mar 2024 09:00:00 +0000\r\nContent-Type: text/plain\r\n\r\nRoll back with the previous image.\r\n",
+	12: "From: Grace <grace@example.com>\r\nTo: support@example.com\r\nSubject: Deploy failed\r\n" +
+		"Date: Tue, 5 Mar 2024 09:00:00 +0000\r\nContent-Type: text/plain\r\n\r\nThe deploy failed at step 3.\r\n",
+}
+
+// fakeIMAPServer speaks just enough IMAP4rev1 for IMAPService: it accepts one
+// login, has a single mailbox and answers SEARCH with fixed UIDs
+type fakeIMAPServer struct {
+	listener net.Listener
+	username string
+	password string
+
+	mu       sync.Mutex
+	commands []string
+}
+
+func newFakeIMAPServer(t *testing.T, username, password string) *fakeIMAPServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	server := &fakeIMAPServer{listener: listener, username: username, password: password}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	return server
+}
+
+func (s *fakeIMAPServer) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *fakeIMAPServer) received() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.commands...)
+}
+
+func (s *fakeIMAPServer) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	reply := func(format string, args ...interface{}) {
+		fmt.Fprintf(w, format+"\r\n", args...)
+		w.Flush()
+	}
+
+	reply("* OK fake IMAP ready")
+	for {
+		tag, args, err := readIMAPCommand(r, func() { reply("+ go ahead") })
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.commands = append(s.commands, strings.Join(args, " "))
+		s.mu.Unlock()
+
+		switch strings.ToUpper(args[0]) {
+		case "CAPABILITY":
+			reply("* CAPABILITY IMAP4rev1 AUTH=PLAIN")
+			reply("%s OK CAPABILITY completed", tag)
+		case "LOGIN":
+			if len(args) != 3 || args[1] != s.username || args[2] != s.password {
+				reply("%s NO [AUTHENTICATIONFAILED] Invalid credentials", tag)
+				continue
+			}
+			reply("%s OK LOGIN completed", tag)
+		case "EXAMINE":
+			reply("* 2 EXISTS")
+			reply("* OK [UIDVALIDITY 7] UIDs valid")
+			reply("%s OK [READ-ONLY] EXAMINE completed", tag)
+		case "UID":
+			s.uidCommand(tag, args[1:], reply, w)
+		case "LOGOUT":
+			reply("* BYE")
+			reply("%s OK LOGOUT completed", tag)
+			return
+		default:
+			reply("%s BAD unknown command", tag)
+		}
+	}
+}
+
+func (s *fakeIMAPServer) uidCommand(tag string, args []string, reply func(string, ...interface{}), w *bufio.Writer) {
+	switch strings.ToUpper(args[0]) {
+	case "SEARCH":
+		// Nothing matches every keyword; the OR search finds both messages
+		if len(args) > 1 && args[1] == "OR" {
+			reply("* SEARCH 11 12")
+		} else {
+			reply("* SEARCH")
+		}
+		reply("%s OK SEARCH completed", tag)
+	case "FETCH":
+		for _, field := range strings.Split(args[1], ",") {
+			uid, _ := strconv.ParseUint(field, 10, 32)
+			message, ok := imapTestMessages[uint32(uid)]
+			if !ok {
+				continue
+			}
+			// Servers may put the UID before or after the literal
+			if uid == 11 {
+				fmt.Fprintf(w, "* 1 FETCH (UID %d BODY[]<0> {%d}\r\n%s)\r\n", uid, len(message), message)
+			} else {
+				fmt.Fprintf(w, "* 2 FETCH (BODY[]<0> {%d}\r\n%s UID %d)\r\n", len(message), message, uid)
+			}
+		}
+		reply("%s OK FETCH completed", tag)
+	default:
+		reply("%s BAD unknown UID command", tag)
+	}
+}
+
+// readIMAPCommand reads a tagged command, unquoting strings and reading
+// literals after sending a continuation
+func readIMAPCommand(r *bufio.Reader, continuation func()) (string, []string, error) {
+	var args []string
+	var current strings.Builder
+	inArg, quoted := false, false
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return "", nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		if open := strings.LastIndex(line, "{"); open >= 0 && strings.HasSuffix(line, "}") && !quoted {
+			size, err := strconv.Atoi(line[open+1 : len(line)-1])
+			if err == nil {
+				parseIMAPArgs(line[:open], &args, &current, &inArg, &quoted)
+				continuation()
+				literal := make([]byte, size)
+				if _, err := io.ReadFull(r, literal); err != nil {
+					return "", nil, err
+				}
+				args = append(args, string(literal))
+				continue
+			}
+		}
+
+		parseIMAPArgs(line, &args, &current, &inArg, &quoted)
+		if inArg {
+			args = append(args, current.String())
+		}
+		if len(args) < 2 {
+			return "", nil, fmt.Errorf("malformed command %q", line)
+		}
+		return args[0], args[1:], nil
+	}
+}
+
+func parseIMAPArgs(text string, args *[]string, current *strings.Builder, inArg, quoted *bool) {
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case *quoted && c == '\\' && i+1 < len(text):
+			i++
+			current.WriteByte(text[i])
+		case *quoted && c == '"':
+			*quoted = false
+		case *quoted:
+			current.WriteByte(c)
+		case c == '"':
+			*quoted, *inArg = true, true
+		case c == ' ':
+			if *inArg {
+				*args = append(*args, current.String())
+				current.Reset()
+				*inArg = false
+			}
+		default:
+			current.WriteByte(c)
+			*inArg = true
+		}
+	}
+}
+
+func TestIMAPSearch(t *testing.T) {
+	// The non-ASCII password can't be quoted, so it's sent as a literal
+	server := newFakeIMAPServer(t, "support@example.com", "pässwörd")
+	is := NewIMAPService("127.0.0.1", server.port(), "support@example.com", "pässwörd", "login", "none", nil)
+
+	messages, err := is.Search("deploy rollback", 10)
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if len(messages) != 2 {
+		t.Fatalf("found %d messages, want 2", len(messages))
+	}
+
+	// Newest first
+	if messages[0].UID != 12 || messages[0].Email.Subject != "Deploy failed" {
+		t.Errorf("first message = %d %q", messages[0].UID, messages[0].Email.Subject)
+	}
+	if messages[1].UID != 11 || messages[1].Email.Subject != "Rollback plan" {
+		t.Errorf("second message = %d %q", messages[1].UID, messages[1].Email.Subject)
+	}
+	if !strings.Contains(messages[0].Email.TextBody, "failed at step 3") {
+		t.Errorf("body = %q", messages[0].Email.TextBody)
+	}
+	wantURL := fmt.Sprintf("imap://support%%40example.com@127.0.0.1:%d/INBOX;UIDVALIDITY=7/;UID=12", server.port())
+	if messages[0].URL != wantURL {
+		t.Errorf("URL = %q, want %q", messages[0].URL, wantURL)
+	}
+
+	want := []string{
+		"CAPABILITY",
+		"LOGIN support@example.com pässwörd",
+		"EXAMINE INBOX",
+		"UID SEARCH TEXT deploy TEXT rollback",
+		"UID SEARCH OR TEXT deploy TEXT rollback",
+		"UID FETCH 12,11 (UID BODY.PEEK[]<0.262144>)",
+		"LOGOUT",
+	}
+	if got := server.received(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("commands =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestIMAPLoginFailure(t *testing.T) {
+	server := newFakeIMAPServer(t, "support@example.com", "right")
+	is := NewIMAPService("127.0.0.1", server.port(), "support@example.com", "wrong", "login", "none", nil)
+
+	_, err := is.Search("deploy", 10)
+	if err == nil || !strings.Contains(err.Error(), "AUTHENTICATIONFAILED") {
+		t.Errorf("err = %v, want the server's login failure", err)
+	}
+}
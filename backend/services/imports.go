@@ -0,0 +1,1074 @@
+package services
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sources of imported documents in the LocalIndex
+const (
+	SlackImportSource      = "slack-export"
+	EmailImportSource      = "email-archive"
+	ConfluenceImportSource = "confluence-export"
+)
+
+// ImportSources lists every source an import can produce
+var ImportSources = []string{SlackImportSource, EmailImportSource, ConfluenceImportSource}
+
+// maxImportEntryBytes skips oversized files inside export archives
+const maxImportEntryBytes = 50 << 20
+
+// importOptionsFile holds the ImportOptions of an export kept in ImportService.Dir
+const importOptionsFile = "import.json"
+
+// ImportService loads admin-provided exports into the LocalIndex for
+// workspaces where OAuth apps can't be installed.
+//
+// When Dir is set, every export is kept in Dir/<tenant>/<name>/ next to its
+// options in import.json, and Sync imports the ones that are new or changed.
+// A newer export copied there, say by a nightly export job, brings the
+// source's current permissions without anyone uploading it again.
+type ImportService struct {
+	Index        *LocalIndex
+	Dir          string // Where exports are kept; empty keeps none
+	PollInterval time.Duration
+
+	mu     sync.Mutex
+	known  map[string]fileState // Kept export path -> state when last imported
+	warned map[string]bool      // Unreadable imports already logged
+}
+
+// ImportOptions are the uploader's settings for an import
+type ImportOptions struct {
+	BaseURL string // Links results back to the original system, when set
+	Owner   string // Email of the mailbox owner; mail archives are readable by them only
+	Shared  bool   // Everyone in the tenant may read every document, whatever the export says
+}
+
+// ImportResult describes a finished import
+type ImportResult struct {
+	Name       string `json:"name"`
+	Source     string `json:"source"`
+	Documents  int    `json:"documents"`
+	Skipped    int    `json:"skipped"`
+	Unreadable int    `json:"unreadable"` // Documents the export grants to no one, so they are never returned
+}
+
+// ImportSummary describes an import stored in the index
+type ImportSummary struct {
+	Name       string    `json:"name"`
+	Source     string    `json:"source"`
+	Documents  int       `json:"documents"`
+	Unreadable int       `json:"unreadable"` // Documents nobody may read, such as those imported before permissions were kept
+	Imported   time.Time `json:"imported"`
+}
+
+func NewImportService(index *LocalIndex, dir string, pollInterval time.Duration) *ImportService {
+	if pollInterval == 0 {
+		pollInterval = 5 * time.Minute
+	}
+	return &ImportService{
+		Index:        index,
+		Dir:          dir,
+		PollInterval: pollInterval,
+		known:        make(map[string]fileState),
+		warned:       make(map[string]bool),
+	}
+}
+
+// Import detects the kind of export from its file name and contents and
+// indexes it for tenant under name, replacing the tenant's earlier import with
+// the same name.
+//
+// Every document gets the permissions the export records: Slack channel
+// membership, Confluence space permissions and page restrictions, or the
+// mailbox owner. Importing a newer export again is how permission changes
+// reach the index; with Dir set the export is kept there for Sync.
+func (is *ImportService) Import(tenant, name, filename string, r io.ReaderAt, size int64, options ImportOptions) (*ImportResult, error) {
+	dir, err := is.exportDir(tenant, name)
+	if err != nil {
+		return nil, err
+	}
+
+	is.mu.Lock()
+	defer is.mu.Unlock()
+
+	result, err := is.importExport(tenant, name, filename, r, size, options)
+	if err != nil || dir == "" {
+		return result, err
+	}
+	if err := is.keepExport(dir, filepath.Base(filename), io.NewSectionReader(r, 0, size), options); err != nil {
+		return nil, fmt.Errorf("failed to keep export: %v", err)
+	}
+	return result, nil
+}
+
+// importExport indexes an export, replacing the documents of the tenant's
+// import with the same name. Callers hold mu.
+func (is *ImportService) importExport(tenant, name, filename string, r io.ReaderAt, size int64, options ImportOptions) (*ImportResult, error) {
+	baseURL := strings.TrimSuffix(options.BaseURL, "/")
+
+	var docs []IndexedDocument
+	var skipped int
+	var source string
+	var err error
+
+	lower := strings.ToLower(filename)
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		zr, zerr := zip.NewReader(r, size)
+		if zerr != nil {
+			return nil, fmt.Errorf("failed to open ZIP archive: %v", zerr)
+		}
+		switch detectZipExport(zr) {
+		case SlackImportSource:
+			source = SlackImportSource
+			docs, skipped, err = parseSlackExport(zr, baseURL)
+		case ConfluenceImportSource:
+			source = ConfluenceImportSource
+			docs, skipped, err = parseConfluenceExport(zr, baseURL)
+		case EmailImportSource:
+			source = EmailImportSource
+			docs, skipped, err = parseMailZip(zr)
+		default:
+			return nil, fmt.Errorf("unrecognized export: expected a Slack export, a Confluence space export or .eml/.mbox files")
+		}
+	case strings.HasSuffix(lower, ".mbox") || strings.HasSuffix(lower, ".eml") || strings.HasSuffix(lower, ".mbx"):
+		source = EmailImportSource
+		docs, skipped, err = parseMailArchive(filename, io.NewSectionReader(r, 0, size))
+	default:
+		return nil, fmt.Errorf("unsupported import file %s: expected .zip, .mbox or .eml", filename)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// A mail archive doesn't say whose mailbox it is
+	if source == EmailImportSource && !options.Shared && !strings.Contains(options.Owner, "@") {
+		return nil, fmt.Errorf("mail archives need the email address of the mailbox owner, or sharing with the whole tenant")
+	}
+
+	imported := time.Now().UTC().Format(time.RFC3339)
+	unreadable := 0
+	is.deleteDocuments(tenant, name)
+	for _, doc := range docs {
+		doc.ID = "import:" + tenant + ":" + name + ":" + doc.ID
+		doc.Source = source
+		doc.Tenant = tenant
+		switch {
+		case options.Shared:
+			doc.ACL = PublicACL()
+		case source == EmailImportSource:
+			doc.ACL = DocumentACL{{UserPrincipal(options.Owner)}}
+		}
+		if doc.ACL.DeniesEveryone() {
+			unreadable++
+		}
+		if doc.Metadata == nil {
+			doc.Metadata = map[string]string{}
+		}
+		doc.Metadata["import"] = name
+		doc.Metadata["imported"] = imported
+		is.Index.Upsert(doc)
+	}
+
+	if err := is.Index.Save(); err != nil {
+		return nil, fmt.Errorf("failed to save index: %v", err)
+	}
+
+	return &ImportResult{Name: name, Source: source, Documents: len(docs), Skipped: skipped, Unreadable: unreadable}, nil
+}
+
+// DeleteImport removes every document of a tenant's import, and its kept
+// export, and returns how many documents were removed
+func (is *ImportService) DeleteImport(tenant, name string) int {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+
+	if dir, err := is.exportDir(tenant, name); err == nil && dir != "" {
+		if err := os.RemoveAll(dir); err != nil {
+			log.Printf("Failed to remove kept export %s: %v", dir, err)
+		}
+	}
+	return is.deleteDocuments(tenant, name)
+}
+
+// deleteDocuments removes an import's documents from the index. Callers hold mu.
+func (is *ImportService) deleteDocuments(tenant, name string) int {
+	docs := is.Index.Documents(func(doc *IndexedDocument) bool {
+		return doc.Metadata["import"] == name && isImportSource(doc.Source) && doc.InTenant(tenant)
+	})
+	for _, doc := range docs {
+		is.Index.Delete(doc.ID)
+	}
+	return len(docs)
+}
+
+// ListImports summarizes a tenant's imports stored in the index
+func (is *ImportService) ListImports(tenant string) []ImportSummary {
+	summaries := map[string]*ImportSummary{}
+	for _, doc := range is.Index.Documents(func(doc *IndexedDocument) bool { return isImportSource(doc.Source) && doc.InTenant(tenant) }) {
+		name := doc.Metadata["import"]
+		summary, ok := summaries[name]
+		if !ok {
+			imported, _ := time.Parse(time.RFC3339, doc.Metadata["imported"])
+			summary = &ImportSummary{Name: name, Source: doc.Source, Imported: imported}
+			summaries[name] = summary
+		}
+		summary.Documents++
+		if doc.ACL.DeniesEveryone() {
+			summary.Unreadable++
+		}
+	}
+
+	list := make([]ImportSummary, 0, len(summaries))
+	for _, summary := range summaries {
+		list = append(list, *summary)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Watch syncs immediately and then every PollInterval until stop is closed
+func (is *ImportService) Watch(stop <-chan struct{}) {
+	if err := is.Sync(); err != nil {
+		log.Printf("Import sync error: %v", err)
+	}
+
+	ticker := time.NewTicker(is.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := is.Sync(); err != nil {
+				log.Printf("Import sync error: %v", err)
+			}
+		}
+	}
+}
+
+// Sync imports every kept export that is new or changed since it was last
+// imported. The first sync after a start imports them all, so they follow the
+// current permission rules. Imports whose documents nobody may read and that
+// have no kept export, such as those indexed before permissions were kept,
+// are logged: only uploading them again can fix them.
+func (is *ImportService) Sync() error {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+
+	var errs []string
+	kept := map[string]bool{} // tenant + "/" + name
+	if is.Dir != "" {
+		exports, err := filepath.Glob(filepath.Join(is.Dir, "*", "*"))
+		if err != nil {
+			return err
+		}
+		changed := 0
+		for _, dir := range exports {
+			tenant, name := filepath.Base(filepath.Dir(dir)), filepath.Base(dir)
+			kept[tenant+"/"+name] = true
+			imported, err := is.syncExport(tenant, name, dir)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s/%s: %v", tenant, name, err))
+			}
+			if imported {
+				changed++
+			}
+		}
+		if changed > 0 {
+			log.Printf("Import sync updated %d imports", changed)
+		}
+	}
+
+	for _, doc := range is.Index.Documents(func(doc *IndexedDocument) bool { return isImportSource(doc.Source) && len(doc.ACL) == 0 }) {
+		tenant := doc.Tenant
+		if tenant == "" {
+			tenant = DefaultTenant
+		}
+		if key := tenant + "/" + doc.Metadata["import"]; !kept[key] && !is.warned[key] {
+			is.warned[key] = true
+			log.Printf("Warning: import %s has documents without permissions, which nobody can read; upload it again", key)
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to sync imports: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// syncExport imports a kept export when it changed and reports whether it
+// did. Callers hold mu.
+func (is *ImportService) syncExport(tenant, name, dir string) (bool, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return false, err
+	}
+	var exportPath string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && entry.Name() != importOptionsFile && !strings.HasPrefix(entry.Name(), ".") {
+			exportPath = filepath.Join(dir, entry.Name())
+			break
+		}
+	}
+	if exportPath == "" {
+		return false, nil
+	}
+
+	info, err := os.Stat(exportPath)
+	if err != nil {
+		return false, err
+	}
+	state := fileState{modTime: info.ModTime(), size: info.Size()}
+	if known, ok := is.known[exportPath]; ok && known == state {
+		return false, nil
+	}
+
+	var options ImportOptions
+	if data, err := os.ReadFile(filepath.Join(dir, importOptionsFile)); err == nil {
+		if err := json.Unmarshal(data, &options); err != nil {
+			return false, fmt.Errorf("invalid %s: %v", importOptionsFile, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return false, err
+	}
+
+	file, err := os.Open(exportPath)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	if _, err := is.importExport(tenant, name, filepath.Base(exportPath), file, info.Size(), options); err != nil {
+		return false, err
+	}
+	is.known[exportPath] = state
+	return true, nil
+}
+
+// keepExport replaces the export kept in dir. Callers hold mu.
+func (is *ImportService) keepExport(dir, filename string, r io.Reader, options ImportOptions) error {
+	if filename == importOptionsFile || strings.HasPrefix(filename, ".") {
+		filename = "export-" + filename
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+
+	optionsData, err := json.Marshal(options)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, importOptionsFile), optionsData, 0o600); err != nil {
+		return err
+	}
+
+	exportPath := filepath.Join(dir, filename)
+	file, err := os.OpenFile(exportPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, r); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	// Already imported; Sync only needs to act when the file changes
+	info, err := os.Stat(exportPath)
+	if err != nil {
+		return err
+	}
+	is.known[exportPath] = fileState{modTime: info.ModTime(), size: info.Size()}
+	return nil
+}
+
+// exportDir returns where an import's export is kept, or "" without Dir
+func (is *ImportService) exportDir(tenant, name string) (string, error) {
+	if is.Dir == "" {
+		return "", nil
+	}
+	for _, part := range []string{tenant, name} {
+		if part == "" || part == "." || part == ".." || strings.ContainsAny(part, `/\`) {
+			return "", fmt.Errorf("invalid import name %q", part)
+		}
+	}
+	return filepath.Join(is.Dir, tenant, name), nil
+}
+
+func isImportSource(source string) bool {
+	for _, s := range ImportSources {
+		if s == source {
+			return true
+		}
+	}
+	return false
+}
+
+var slackDayFile = regexp.MustCompile(`^(?:.*/)?([^/]+)/(\d{4}-\d{2}-\d{2})\.json$`)
+
+func detectZipExport(zr *zip.Reader) string {
+	mail := false
+	for _, file := range zr.File {
+		name := strings.ToLower(file.Name)
+		switch {
+		case path.Base(name) == "channels.json" || path.Base(name) == "users.json" || slackDayFile.MatchString(name):
+			return SlackImportSource
+		case path.Base(name) == "entities.xml" || strings.HasSuffix(name, ".html"):
+			return ConfluenceImportSource
+		case strings.HasSuffix(name, ".eml") || strings.HasSuffix(name, ".mbox"):
+			mail = true
+		}
+	}
+	if mail {
+		return EmailImportSource
+	}
+	return ""
+}
+
+func readZipFile(file *zip.File) ([]byte, error) {
+	if file.UncompressedSize64 > maxImportEntryBytes {
+		return nil, fmt.Errorf("%s is too large", file.Name)
+	}
+	rc, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(io.LimitReader(rc, maxImportEntryBytes))
+}
+
+// Slack workspace exports
+
+type slackExportUser struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Profile struct {
+		RealName    string `json:"real_name"`
+		DisplayName string `json:"display_name"`
+		Email       string `json:"email"`
+	} `json:"profile"`
+}
+
+type slackExportChannel struct {
+	ID      string   `json:"id"`
+	Name    string   `json:"name"`
+	Members []string `json:"members"`
+}
+
+type slackExportMessage struct {
+	Type        string `json:"type"`
+	Subtype     string `json:"subtype"`
+	User        string `json:"user"`
+	Username    string `json:"username"`
+	Text        string `json:"text"`
+	TS          string `json:"ts"`
+	ThreadTS    string `json:"thread_ts"`
+	UserProfile *struct {
+		RealName    string `json:"real_name"`
+		DisplayName string `json:"display_name"`
+	} `json:"user_profile"`
+	Files []struct {
+		Name  string `json:"name"`
+		Title string `json:"title"`
+	} `json:"files"`
+}
+
+var (
+	slackUserMention    = regexp.MustCompile(`<@([A-Z0-9]+)(?:\|([^>]+))?>`)
+	slackChannelMention = regexp.MustCompile(`<#[A-Z0-9]+\|([^>]+)>`)
+	slackLink           = regexp.MustCompile(`<((?:https?|mailto):[^>|]+)(?:\|([^>]+))?>`)
+	slackSpecial        = regexp.MustCompile(`<!(here|channel|everyone)[^>]*>`)
+)
+
+// parseSlackExport turns a workspace export into one document per channel and
+// day. Public channels are readable by everyone, private channels and direct
+// messages by their members.
+func parseSlackExport(zr *zip.Reader, baseURL string) ([]IndexedDocument, int, error) {
+	users := map[string]string{}
+	emails := map[string]string{}
+	channelIDs := map[string]string{}
+	channelMembers := map[string][]string{} // channel folder -> member IDs; nil for public channels
+
+	for _, file := range zr.File {
+		switch path.Base(file.Name) {
+		case "users.json":
+			data, err := readZipFile(file)
+			if err != nil {
+				return nil, 0, err
+			}
+			var list []slackExportUser
+			if err := json.Unmarshal(data, &list); err == nil {
+				for _, user := range list {
+					users[user.ID] = firstNonEmpty(user.Profile.DisplayName, user.Profile.RealName, user.Name)
+					emails[user.ID] = user.Profile.Email
+				}
+			}
+		case "channels.json", "groups.json", "mpims.json", "dms.json":
+			data, err := readZipFile(file)
+			if err != nil {
+				return nil, 0, err
+			}
+			var list []slackExportChannel
+			if err := json.Unmarshal(data, &list); err == nil {
+				for _, channel := range list {
+					channelIDs[channel.Name] = channel.ID
+					// Direct message folders are named by ID
+					folder := firstNonEmpty(channel.Name, channel.ID)
+					if path.Base(file.Name) == "channels.json" {
+						channelMembers[folder] = nil
+					} else {
+						channelMembers[folder] = append([]string{}, channel.Members...)
+					}
+				}
+			}
+		}
+	}
+
+	// Channels missing from the channel lists stay unreadable
+	channelACLs := map[string]DocumentACL{}
+	for folder, members := range channelMembers {
+		if members == nil {
+			channelACLs[folder] = PublicACL()
+			continue
+		}
+		readers := []string{}
+		for _, member := range members {
+			if emails[member] != "" {
+				readers = append(readers, UserPrincipal(emails[member]))
+			}
+		}
+		channelACLs[folder] = DocumentACL{readers}
+	}
+
+	var docs []IndexedDocument
+	skipped := 0
+	for _, file := range zr.File {
+		match := slackDayFile.FindStringSubmatch(file.Name)
+		if match == nil {
+			continue
+		}
+		channel, day := match[1], match[2]
+
+		data, err := readZipFile(file)
+		if err != nil {
+			skipped++
+			continue
+		}
+		var messages []slackExportMessage
+		if err := json.Unmarshal(data, &messages); err != nil {
+			skipped++
+			continue
+		}
+
+		var lines []string
+		firstTS := ""
+		for _, message := range messages {
+			if message.Type != "message" || message.Subtype == "channel_join" || message.Subtype == "channel_leave" {
+				continue
+			}
+			text := formatSlackExportText(message, users)
+			if text == "" {
+				continue
+			}
+			if firstTS == "" {
+				firstTS = message.TS
+			}
+
+			prefix := ""
+			if message.ThreadTS != "" && message.ThreadTS != message.TS {
+				prefix = "  ↳ "
+			}
+			lines = append(lines, fmt.Sprintf("%s[%s] %s: %s", prefix, slackTime(message.TS), slackAuthor(message, users), text))
+		}
+		if len(lines) == 0 {
+			continue
+		}
+
+		doc := IndexedDocument{
+			ID:       "slack/" + channel + "/" + day,
+			ACL:      channelACLs[channel],
+			Title:    fmt.Sprintf("#%s on %s", channel, day),
+			Content:  fmt.Sprintf("Slack channel #%s, %s\n\n%s", channel, day, strings.Join(lines, "\n")),
+			Metadata: map[string]string{"channel": channel, "date": day},
+		}
+		if modified, err := time.Parse("2006-01-02", day); err == nil {
+			doc.Modified = modified
+		}
+		if baseURL != "" && channelIDs[channel] != "" {
+			doc.URL = fmt.Sprintf("%s/archives/%s/p%s", baseURL, channelIDs[channel], strings.ReplaceAll(firstTS, ".", ""))
+		}
+		docs = append(docs, doc)
+	}
+
+	return docs, skipped, nil
+}
+
+// formatSlackExportText resolves mentions and links in Slack's mrkdwn
+func formatSlackExportText(message slackExportMessage, users map[string]string) string {
+	text := slackUserMention.ReplaceAllStringFunc(message.Text, func(mention string) string {
+		match := slackUserMention.FindStringSubmatch(mention)
+		if name := users[match[1]]; name != "" {
+			return "@" + name
+		}
+		if match[2] != "" {
+			return "@" + match[2]
+		}
+		return "@" + match[1]
+	})
+	text = slackChannelMention.ReplaceAllString(text, "#$1")
+	text = slackLink.ReplaceAllStringFunc(text, func(link string) string {
+		match := slackLink.FindStringSubmatch(link)
+		if match[2] != "" {
+			return match[2] + " (" + match[1] + ")"
+		}
+		return match[1]
+	})
+	text = slackSpecial.ReplaceAllString(text, "@$1")
+	text = strings.NewReplacer("&lt;", "<", "&gt;", ">", "&amp;", "&").Replace(text)
+
+	for _, file := range message.Files {
+		text += " [file: " + firstNonEmpty(file.Title, file.Name) + "]"
+	}
+	return strings.TrimSpace(text)
+}
+
+func slackAuthor(message slackExportMessage, users map[string]string) string {
+	if message.UserProfile != nil {
+		if name := firstNonEmpty(message.UserProfile.DisplayName, message.UserProfile.RealName); name != "" {
+			return name
+		}
+	}
+	return firstNonEmpty(users[message.User], message.Username, message.User, "unknown")
+}
+
+func slackTime(ts string) string {
+	seconds, err := strconv.ParseFloat(ts, 64)
+	if err != nil {
+		return ts
+	}
+	return time.Unix(int64(seconds), 0).UTC().Format("15:04")
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// Mail archives
+
+func parseMailZip(zr *zip.Reader) ([]IndexedDocument, int, error) {
+	var docs []IndexedDocument
+	skipped := 0
+	for _, file := range zr.File {
+		name := strings.ToLower(file.Name)
+		if !strings.HasSuffix(name, ".eml") && !strings.HasSuffix(name, ".mbox") {
+			continue
+		}
+		data, err := readZipFile(file)
+		if err != nil {
+			skipped++
+			continue
+		}
+		fileDocs, fileSkipped, err := parseMailArchive(file.Name, bytes.NewReader(data))
+		if err != nil {
+			skipped++
+			continue
+		}
+		docs = append(docs, fileDocs...)
+		skipped += fileSkipped
+	}
+	return docs, skipped, nil
+}
+
+// parseMailArchive reads an mbox file, or a single .eml message, into one document per message
+func parseMailArchive(filename string, r io.Reader) ([]IndexedDocument, int, error) {
+	var docs []IndexedDocument
+	skipped := 0
+
+	addMessage := func(raw []byte, index int) {
+		email, err := ParseMIMEMessage(raw)
+		if err != nil {
+			skipped++
+			return
+		}
+		id := email.MessageID
+		if id == "" {
+			id = fmt.Sprintf("%s#%d", filename, index)
+		}
+		docs = append(docs, IndexedDocument{
+			ID:       "mail/" + id,
+			Title:    firstNonEmpty(email.Subject, "(no subject)"),
+			Content:  formatEmailHeader(email) + "\n\n" + email.Content(),
+			Modified: email.Date,
+			Metadata: map[string]string{"from": email.From, "file": filename},
+		})
+	}
+
+	reader := bufio.NewReader(r)
+	first, _ := reader.Peek(5)
+	if string(first) != "From " {
+		// A single message
+		raw, err := io.ReadAll(io.LimitReader(reader, maxImportEntryBytes))
+		if err != nil {
+			return nil, 0, err
+		}
+		addMessage(raw, 0)
+		return docs, skipped, nil
+	}
+
+	// mbox: every message starts with a "From " separator line; body lines
+	// starting with "From " were escaped as ">From " (one more ">" in mboxrd)
+	var message bytes.Buffer
+	index := 0
+	previousBlank := true
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			switch {
+			case bytes.HasPrefix(line, []byte("From ")) && previousBlank:
+				if message.Len() > 0 {
+					addMessage(message.Bytes(), index)
+					index++
+				}
+				message.Reset()
+			case isEscapedFromLine(line):
+				message.Write(line[1:])
+			default:
+				if message.Len() < maxImportEntryBytes {
+					message.Write(line)
+				}
+			}
+			previousBlank = len(bytes.TrimRight(line, "\r\n")) == 0
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	if message.Len() > 0 {
+		addMessage(message.Bytes(), index)
+	}
+
+	return docs, skipped, nil
+}
+
+// formatEmailHeader lists the headers a message has
+func formatEmailHeader(email *EmailMessage) string {
+	lines := []string{"Subject: " + email.Subject}
+	if email.From != "" {
+		lines = append(lines, "From: "+email.From)
+	}
+	if email.To != "" {
+		lines = append(lines, "To: "+email.To)
+	}
+	if !email.Date.IsZero() {
+		lines = append(lines, "Date: "+email.Date.Format("2006-01-02 15:04"))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func isEscapedFromLine(line []byte) bool {
+	trimmed := bytes.TrimLeft(line, ">")
+	return len(trimmed) < len(line) && bytes.HasPrefix(trimmed, []byte("From "))
+}
+
+// Confluence space exports
+
+var confluencePageFileID = regexp.MustCompile(`_?(\d+)\.html$`)
+
+// parseConfluenceExport reads an XML export (entities.xml) or an HTML export
+func parseConfluenceExport(zr *zip.Reader, baseURL string) ([]IndexedDocument, int, error) {
+	for _, file := range zr.File {
+		if path.Base(file.Name) == "entities.xml" {
+			return parseConfluenceXMLExport(file, baseURL)
+		}
+	}
+	return parseConfluenceHTMLExport(zr, baseURL)
+}
+
+// parseConfluenceHTMLExport reads an HTML export. It carries no permissions,
+// so its pages are only readable when the import is shared with the tenant.
+func parseConfluenceHTMLExport(zr *zip.Reader, baseURL string) ([]IndexedDocument, int, error) {
+	var docs []IndexedDocument
+	skipped := 0
+
+	for _, file := range zr.File {
+		name := path.Base(file.Name)
+		if !strings.HasSuffix(strings.ToLower(name), ".html") || name == "index.html" {
+			continue
+		}
+
+		data, err := readZipFile(file)
+		if err != nil {
+			skipped++
+			continue
+		}
+		root, err := parseHTMLTree(stripDoctype(string(data)))
+		if err != nil {
+			skipped++
+			continue
+		}
+
+		// The page title is "Space : Page"
+		space, title := "", strings.TrimSuffix(name, ".html")
+		var titleNode *htmlNode
+		findFirst(root, "title", &titleNode)
+		if titleNode != nil {
+			title = strings.TrimSpace(titleNode.textContent())
+			if i := strings.Index(title, " : "); i >= 0 {
+				space, title = title[:i], title[i+3:]
+			}
+		}
+
+		content := findByID(root, "main-content")
+		if content == nil {
+			content = root
+		}
+		var md markdownWriter
+		md.blockChildren(content)
+
+		var breadcrumb []string
+		if breadcrumbs := findByID(root, "breadcrumbs"); breadcrumbs != nil {
+			for _, item := range breadcrumbs.Children {
+				if text := strings.TrimSpace(item.textContent()); text != "" {
+					breadcrumb = append(breadcrumb, text)
+				}
+			}
+		}
+
+		header := "Confluence page: " + title
+		if len(breadcrumb) > 0 {
+			header += "\nPath: " + strings.Join(append(breadcrumb, title), " > ")
+		}
+
+		doc := IndexedDocument{
+			ID:       "confluence/" + file.Name,
+			Title:    title,
+			Content:  header + "\n\n" + md.String(),
+			Modified: file.Modified,
+			Metadata: map[string]string{"space": space},
+		}
+		if match := confluencePageFileID.FindStringSubmatch(name); match != nil && baseURL != "" {
+			doc.URL = baseURL + "/pages/viewpage.action?pageId=" + match[1]
+		}
+		docs = append(docs, doc)
+	}
+
+	return docs, skipped, nil
+}
+
+// confluenceXMLObject is an <object> of entities.xml; properties are kept by name
+type confluenceXMLObject struct {
+	Class      string `xml:"class,attr"`
+	ID         string `xml:"id"`
+	Properties []struct {
+		Name  string `xml:"name,attr"`
+		Value string `xml:",chardata"`
+		ID    string `xml:"id"`
+	} `xml:"property"`
+}
+
+func (o *confluenceXMLObject) property(name string) (value, id string) {
+	for _, property := range o.Properties {
+		if property.Name == name {
+			return strings.TrimSpace(property.Value), strings.TrimSpace(property.ID)
+		}
+	}
+	return "", ""
+}
+
+// confluencePermission is a space permission or page restriction entry. An
+// entry with neither a group nor a user, such as anonymous access, grants
+// nobody anything here: sharing a space with everyone is the uploader's call.
+type confluencePermission struct {
+	group, userKey string
+}
+
+// parseConfluenceXMLExport reads the current version of every page from
+// entities.xml, readable by those with view permission on the space who also
+// pass the view restrictions of the page and its ancestors
+func parseConfluenceXMLExport(file *zip.File, baseURL string) ([]IndexedDocument, int, error) {
+	rc, err := file.Open()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rc.Close()
+
+	type page struct {
+		title, spaceID, parentID, modified string
+	}
+	pages := map[string]*page{}
+	bodies := map[string]string{}                           // page ID -> storage format
+	spaces := map[string]string{}                           // space ID -> key
+	userEmails := map[string]string{}                       // user key -> email
+	spacePermissions := map[string][]confluencePermission{} // space ID -> VIEWSPACE entries
+	restrictionSets := map[string][]string{}                // page ID -> IDs of its view restriction sets
+	restrictions := map[string][]confluencePermission{}     // restriction set ID -> entries
+
+	decoder := xml.NewDecoder(rc)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to parse entities.xml: %v", err)
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "object" {
+			continue
+		}
+
+		var object confluenceXMLObject
+		if err := decoder.DecodeElement(&object, &start); err != nil {
+			return nil, 0, fmt.Errorf("failed to parse entities.xml: %v", err)
+		}
+		object.ID = strings.TrimSpace(object.ID)
+
+		switch object.Class {
+		case "Page":
+			// Historical versions point at their current page
+			if _, original := object.property("originalVersion"); original != "" {
+				continue
+			}
+			if status, _ := object.property("contentStatus"); status != "" && status != "current" {
+				continue
+			}
+			title, _ := object.property("title")
+			_, spaceID := object.property("space")
+			_, parentID := object.property("parent")
+			modified, _ := object.property("lastModificationDate")
+			pages[object.ID] = &page{title: title, spaceID: spaceID, parentID: parentID, modified: modified}
+		case "BodyContent":
+			body, _ := object.property("body")
+			_, contentID := object.property("content")
+			bodies[contentID] = body
+		case "Space":
+			key, _ := object.property("key")
+			spaces[object.ID] = key
+		case "ConfluenceUserImpl":
+			email, _ := object.property("email")
+			userEmails[object.ID] = email
+		case "SpacePermission":
+			if permissionType, _ := object.property("type"); permissionType == "VIEWSPACE" {
+				_, spaceID := object.property("space")
+				group, _ := object.property("group")
+				_, userKey := object.property("userSubject")
+				spacePermissions[spaceID] = append(spacePermissions[spaceID], confluencePermission{group: group, userKey: userKey})
+			}
+		case "ContentPermissionSet":
+			if setType, _ := object.property("type"); setType == "View" {
+				_, contentID := object.property("owningContent")
+				restrictionSets[contentID] = append(restrictionSets[contentID], object.ID)
+			}
+		case "ContentPermission":
+			if permissionType, _ := object.property("type"); permissionType == "View" {
+				_, setID := object.property("owningSet")
+				group, _ := object.property("groupName")
+				_, userKey := object.property("userSubject")
+				restrictions[setID] = append(restrictions[setID], confluencePermission{group: group, userKey: userKey})
+			}
+		}
+	}
+
+	// Entries of users without an email address can't be matched to readers,
+	// and entries naming no one are ambiguous; both are dropped, and a clause
+	// left empty denies everyone
+	aclClause := func(permissions []confluencePermission) []string {
+		clause := []string{}
+		for _, permission := range permissions {
+			switch {
+			case permission.group != "":
+				clause = append(clause, GroupPrincipal(permission.group))
+			case permission.userKey != "":
+				if email := userEmails[permission.userKey]; email != "" {
+					clause = append(clause, UserPrincipal(email))
+				}
+			}
+		}
+		return clause
+	}
+
+	var docs []IndexedDocument
+	skipped := 0
+	for id, p := range pages {
+		body, ok := bodies[id]
+		if !ok {
+			skipped++
+			continue
+		}
+
+		var breadcrumb []string
+		restricted := append([]string{}, restrictionSets[id]...)
+		for parentID, depth := p.parentID, 0; parentID != "" && depth < 20; depth++ {
+			parent, ok := pages[parentID]
+			if !ok {
+				break
+			}
+			breadcrumb = append([]string{parent.title}, breadcrumb...)
+			restricted = append(restricted, restrictionSets[parentID]...)
+			parentID = parent.parentID
+		}
+
+		// Without space permissions in the export nobody may read the page
+		var acl DocumentACL
+		if viewers, ok := spacePermissions[p.spaceID]; ok {
+			acl = DocumentACL{aclClause(viewers)}
+			for _, setID := range restricted {
+				acl = append(acl, aclClause(restrictions[setID]))
+			}
+		}
+
+		header := "Confluence page: " + p.title
+		if len(breadcrumb) > 0 {
+			header += "\nPath: " + strings.Join(append(breadcrumb, p.title), " > ")
+		}
+
+		doc := IndexedDocument{
+			ID:       "confluence/" + id,
+			ACL:      acl,
+			Title:    p.title,
+			Content:  header + "\n\n" + ConfluenceToMarkdown(body),
+			Metadata: map[string]string{"space": spaces[p.spaceID], "page_id": id},
+		}
+		if modified, err := time.Parse("2006-01-02 15:04:05.000", p.modified); err == nil {
+			doc.Modified = modified
+		}
+		if baseURL != "" {
+			doc.URL = baseURL + "/pages/viewpage.action?pageId=" + id
+		}
+		docs = append(docs, doc)
+	}
+
+	sort.Slice(docs, func(i, j int) bool { return docs[i].ID < docs[j].ID })
+	return docs, skipped, nil
+}
@@ -0,0 +1,488 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// zipFixture zips a directory under testdata/imports the way the export
+// tools would
+func zipFixture(t *testing.T, dir string) []byte {
+	t.Helper()
+	root := filepath.Join("testdata", "imports", dir)
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		w, err := zw.Create(filepath.ToSlash(rel))
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", "imports", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// mailbox imports a mail archive as Ada's
+var mailbox = ImportOptions{Owner: "ada@example.com"}
+
+func newTestImportService(t *testing.T) *ImportService {
+	index, err := NewLocalIndex("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewImportService(index, "", 0)
+}
+
+func importFixture(t *testing.T, is *ImportService, name, filename string, data []byte, options ImportOptions) *ImportResult {
+	t.Helper()
+	result, err := is.Import(DefaultTenant, name, filename, bytes.NewReader(data), int64(len(data)), options)
+	if err != nil {
+		t.Fatalf("Import(%s): %v", filename, err)
+	}
+	return result
+}
+
+func readableBy(doc IndexedDocument, email string, groups ...string) bool {
+	return doc.ReadableBy(Reader{Tenant: DefaultTenant, Email: email, Groups: groups})
+}
+
+func importedDocuments(is *ImportService, name string) []IndexedDocument {
+	return is.Index.Documents(func(doc *IndexedDocument) bool { return doc.Metadata["import"] == name })
+}
+
+func TestImportSlackExport(t *testing.T) {
+	is := newTestImportService(t)
+	result := importFixture(t, is, "acme", "acme-slack.zip", zipFixture(t, "slack"), ImportOptions{BaseURL: "https://acme.slack.com/"})
+	if result.Source != SlackImportSource || result.Documents != 2 {
+		t.Fatalf("result = %+v, want a document per channel and day", result)
+	}
+
+	doc, ok := is.Index.Get("import:default:acme:slack/general/2024-03-04")
+	if !ok {
+		t.Fatalf("documents = %+v", importedDocuments(is, "acme"))
+	}
+	want := "Slack channel #general, 2024-03-04\n\n" +
+		"[10:00] ada: Deploy is at noon, @Grace Hopper can you watch #ops? Notes: runbook (https://wiki.example.com/deploy)\n" +
+		"  ↳ [10:01] Grace Hopper: Sure & I'll ping @here [file: Deploy checklist]"
+	if doc.Content != want {
+		t.Errorf("content =\n%s\nwant\n%s", doc.Content, want)
+	}
+	if doc.URL != "https://acme.slack.com/archives/C100/p1709546400000100" {
+		t.Errorf("URL = %q, want a link to the day's first message", doc.URL)
+	}
+	if doc.Source != SlackImportSource || doc.Metadata["channel"] != "general" {
+		t.Errorf("doc = %+v", doc)
+	}
+
+	// Public channels are open to the tenant, private ones to their members
+	if !readableBy(doc, "") {
+		t.Errorf("public channel isn't readable by everyone")
+	}
+	private, _ := is.Index.Get("import:default:acme:slack/leads/2024-03-04")
+	if !readableBy(private, "ada@example.com") || readableBy(private, "grace@example.com") {
+		t.Errorf("private channel ACL = %v, want its member only", private.ACL)
+	}
+}
+
+func TestImportMailArchives(t *testing.T) {
+	is := newTestImportService(t)
+	result := importFixture(t, is, "finance", "finance.mbox", readFixture(t, "archive.mbox"), mailbox)
+	if result.Source != EmailImportSource || result.Documents != 2 || result.Skipped != 0 {
+		t.Fatalf("result = %+v, want both messages", result)
+	}
+
+	byTitle := map[string]IndexedDocument{}
+	for _, doc := range importedDocuments(is, "finance") {
+		byTitle[doc.Title] = doc
+	}
+	budget := byTitle["Q3 budget"]
+	if !strings.Contains(budget.Content, "From: Ada <ada@example.com>") || !strings.Contains(budget.Content, "Date: 2024-03-04 09:30") {
+		t.Errorf("budget header is missing:\n%s", budget.Content)
+	}
+	// The escaped body line is restored and doesn't split the message
+	if !strings.Contains(budget.Content, "\nFrom now on, send receipts to finance.") {
+		t.Errorf("escaped From line wasn't restored:\n%s", budget.Content)
+	}
+	if offsite, ok := byTitle["Offsite"]; !ok || !strings.Contains(offsite.Content, "moves to June") {
+		t.Errorf("message without a Message-ID is missing: %+v", byTitle)
+	}
+
+	// A single message, and the same message inside a ZIP of mail files
+	result = importFixture(t, is, "launch", "launch.eml", readFixture(t, "message.eml"), mailbox)
+	if result.Documents != 1 {
+		t.Errorf("result = %+v, want the one message", result)
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range []string{"archive.mbox", "message.eml"} {
+		w, _ := zw.Create("mail/" + name)
+		w.Write(readFixture(t, name))
+	}
+	zw.Close()
+	result = importFixture(t, is, "all-mail", "mail.zip", buf.Bytes(), mailbox)
+	if result.Source != EmailImportSource || result.Documents != 3 {
+		t.Errorf("result = %+v, want every message in the ZIP", result)
+	}
+}
+
+func TestImportConfluenceXMLExport(t *testing.T) {
+	is := newTestImportService(t)
+	result := importFixture(t, is, "eng", "ENG-xml.zip", zipFixture(t, "confluence-xml"), ImportOptions{BaseURL: "https://acme.atlassian.net/wiki/"})
+	// Historical versions and deleted pages aren't imported
+	if result.Source != ConfluenceImportSource || result.Documents != 2 {
+		t.Fatalf("result = %+v, want the two current pages", result)
+	}
+
+	doc, ok := is.Index.Get("import:default:eng:confluence/101")
+	if !ok {
+		t.Fatalf("documents = %+v", importedDocuments(is, "eng"))
+	}
+	if !strings.HasPrefix(doc.Content, "Confluence page: Runbook\nPath: Operations > Runbook\n\n") {
+		t.Errorf("content doesn't start with the page path:\n%s", doc.Content)
+	}
+	if !strings.Contains(doc.Content, "## Restart") || strings.Contains(doc.Content, "older runbook") {
+		t.Errorf("content isn't the current version:\n%s", doc.Content)
+	}
+	if doc.URL != "https://acme.atlassian.net/wiki/pages/viewpage.action?pageId=101" || doc.Metadata["space"] != "ENG" {
+		t.Errorf("doc = %+v", doc)
+	}
+	if doc.Modified.Format("2006-01-02 15:04") != "2024-03-04 09:30" {
+		t.Errorf("modified = %v", doc.Modified)
+	}
+
+	// The space is viewable by engineering, and the parent page is
+	// restricted to Ada
+	if !readableBy(doc, "ada@example.com", "engineering") {
+		t.Errorf("Ada can't read the runbook: %v", doc.ACL)
+	}
+	if readableBy(doc, "grace@example.com", "engineering") {
+		t.Errorf("the parent page's restriction wasn't inherited: %v", doc.ACL)
+	}
+	if readableBy(doc, "ada@example.com") {
+		t.Errorf("the space permission wasn't applied: %v", doc.ACL)
+	}
+}
+
+func TestImportConfluenceHTMLExport(t *testing.T) {
+	is := newTestImportService(t)
+	result := importFixture(t, is, "eng", "ENG-html.zip", zipFixture(t, "confluence-html"), ImportOptions{BaseURL: "https://acme.atlassian.net/wiki"})
+	if result.Source != ConfluenceImportSource || result.Documents != 1 {
+		t.Fatalf("result = %+v, want the page without the index", result)
+	}
+
+	doc, ok := is.Index.Get("import:default:eng:confluence/ENG/Runbook_12345.html")
+	if !ok {
+		t.Fatalf("documents = %+v", importedDocuments(is, "eng"))
+	}
+	if !strings.HasPrefix(doc.Content, "Confluence page: Runbook\nPath: Engineering > Operations > Runbook\n\n") {
+		t.Errorf("content doesn't start with the page path:\n%s", doc.Content)
+	}
+	if !strings.Contains(doc.Content, "Run the restart script") || strings.Contains(doc.Content, "Generated by Confluence") {
+		t.Errorf("content isn't the main content:\n%s", doc.Content)
+	}
+	if doc.Title != "Runbook" || doc.Metadata["space"] != "Engineering" {
+		t.Errorf("doc = %+v, want the space split from the title", doc)
+	}
+	if doc.URL != "https://acme.atlassian.net/wiki/pages/viewpage.action?pageId=12345" {
+		t.Errorf("URL = %q", doc.URL)
+	}
+
+	// HTML exports carry no permissions
+	if result.Unreadable != 1 || readableBy(doc, "ada@example.com") {
+		t.Errorf("result = %+v, want the page unreadable", result)
+	}
+	result = importFixture(t, is, "eng", "ENG-html.zip", zipFixture(t, "confluence-html"), ImportOptions{Shared: true})
+	doc, _ = is.Index.Get("import:default:eng:confluence/ENG/Runbook_12345.html")
+	if result.Unreadable != 0 || !readableBy(doc, "") {
+		t.Errorf("result = %+v, want the shared page readable by everyone", result)
+	}
+}
+
+// A mail archive is its owner's unless it's shared with the tenant
+func TestImportMailPermissions(t *testing.T) {
+	is := newTestImportService(t)
+	mbox := readFixture(t, "archive.mbox")
+	if _, err := is.Import(DefaultTenant, "mail", "finance.mbox", bytes.NewReader(mbox), int64(len(mbox)), ImportOptions{}); err == nil {
+		t.Fatalf("a mail archive without an owner was imported")
+	}
+
+	importFixture(t, is, "mail", "finance.mbox", mbox, mailbox)
+	for _, doc := range importedDocuments(is, "mail") {
+		if !readableBy(doc, "Ada@Example.com") || readableBy(doc, "grace@example.com") {
+			t.Errorf("%s ACL = %v, want the owner only", doc.Title, doc.ACL)
+		}
+	}
+
+	importFixture(t, is, "mail", "finance.mbox", mbox, ImportOptions{Shared: true})
+	for _, doc := range importedDocuments(is, "mail") {
+		if !readableBy(doc, "grace@example.com") {
+			t.Errorf("%s ACL = %v, want everyone", doc.Title, doc.ACL)
+		}
+	}
+}
+
+// Importing under the same name replaces the earlier import
+func TestImportReplacesAndDeletes(t *testing.T) {
+	is := newTestImportService(t)
+	importFixture(t, is, "mail", "finance.mbox", readFixture(t, "archive.mbox"), mailbox)
+	importFixture(t, is, "mail", "launch.eml", readFixture(t, "message.eml"), mailbox)
+	importFixture(t, is, "acme", "acme-slack.zip", zipFixture(t, "slack"), ImportOptions{})
+
+	imports := is.ListImports(DefaultTenant)
+	if len(imports) != 2 || imports[0].Name != "acme" || imports[1].Name != "mail" || imports[1].Documents != 1 {
+		t.Fatalf("ListImports = %+v", imports)
+	}
+
+	if removed := is.DeleteImport(DefaultTenant, "mail"); removed != 1 {
+		t.Errorf("DeleteImport removed %d documents, want 1", removed)
+	}
+	if imports := is.ListImports(DefaultTenant); len(imports) != 1 || imports[0].Name != "acme" {
+		t.Errorf("ListImports = %+v after deleting mail", imports)
+	}
+}
+
+func TestImportRejectsUnknownFiles(t *testing.T) {
+	is := newTestImportService(t)
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, _ := zw.Create("photos/cat.jpg")
+	w.Write([]byte("not a photo"))
+	zw.Close()
+
+	for filename, data := range map[string][]byte{
+		"photos.zip": buf.Bytes(),
+		"notes.txt":  []byte("hello"),
+	} {
+		if _, err := is.Import(DefaultTenant, "x", filename, bytes.NewReader(data), int64(len(data)), ImportOptions{Shared: true}); err == nil {
+			t.Errorf("Import(%s) succeeded", filename)
+		}
+	}
+	if imports := is.ListImports(DefaultTenant); len(imports) != 0 {
+		t.Errorf("ListImports = %+v", imports)
+	}
+}
+
+// Tenants can use the same import names without seeing or replacing each
+// other's documents
+func TestImportIsPerTenant(t *testing.T) {
+	is := newTestImportService(t)
+	mbox := readFixture(t, "archive.mbox")
+	for _, tenant := range []string{"acme", "globex"} {
+		if _, err := is.Import(tenant, "mail", "finance.mbox", bytes.NewReader(mbox), int64(len(mbox)), mailbox); err != nil {
+			t.Fatalf("Import for %s: %v", tenant, err)
+		}
+	}
+
+	if imports := is.ListImports("acme"); len(imports) != 1 || imports[0].Documents != 2 {
+		t.Errorf("acme imports = %+v, want its own two messages", imports)
+	}
+	if imports := is.ListImports(DefaultTenant); len(imports) != 0 {
+		t.Errorf("default tenant sees %+v", imports)
+	}
+	for _, doc := range importedDocuments(is, "mail") {
+		if !strings.HasPrefix(doc.ID, "import:"+doc.Tenant+":mail:") {
+			t.Errorf("document %s isn't keyed by its tenant %q", doc.ID, doc.Tenant)
+		}
+	}
+
+	if removed := is.DeleteImport("globex", "mail"); removed != 2 {
+		t.Errorf("DeleteImport removed %d documents, want globex's 2", removed)
+	}
+	if imports := is.ListImports("acme"); len(imports) != 1 {
+		t.Errorf("deleting globex's import removed acme's: %+v", imports)
+	}
+}
+
+// confluenceXMLExport builds a space export whose only page is viewable by
+// group, next to an entry naming no one, as anonymous access is exported
+func confluenceXMLExport(t *testing.T, group string) []byte {
+	entities := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<hibernate-generic>
+<object class="Space" package="com.atlassian.confluence.spaces"><id name="id">10</id>
+	<property name="key"><![CDATA[OPS]]></property>
+</object>
+<object class="Page" package="com.atlassian.confluence.pages"><id name="id">1</id>
+	<property name="title"><![CDATA[Runbook]]></property>
+	<property name="space" class="Space"><id name="id">10</id></property>
+	<property name="contentStatus"><![CDATA[current]]></property>
+</object>
+<object class="BodyContent" package="com.atlassian.confluence.core"><id name="id">100</id>
+	<property name="body"><![CDATA[<p>Restart the deploy workers</p>]]></property>
+	<property name="content" class="Page"><id name="id">1</id></property>
+</object>
+<object class="SpacePermission" package="com.atlassian.confluence.security"><id name="id">20</id>
+	<property name="type"><![CDATA[VIEWSPACE]]></property>
+	<property name="space" class="Space"><id name="id">10</id></property>
+</object>
+<object class="SpacePermission" package="com.atlassian.confluence.security"><id name="id">21</id>
+	<property name="type"><![CDATA[VIEWSPACE]]></property>
+	<property name="space" class="Space"><id name="id">10</id></property>
+	<property name="group"><![CDATA[%s]]></property>
+</object>
+</hibernate-generic>`, group)
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create("entities.xml")
+	if err != nil {
+		t.Fatalf("zip: %v", err)
+	}
+	w.Write([]byte(entities))
+	if err := zw.Close(); err != nil {
+		t.Fatalf("zip: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func runbookReadable(t *testing.T, index *LocalIndex, groups ...string) bool {
+	t.Helper()
+	doc, ok := index.Get("import:acme:runbooks:confluence/1")
+	if !ok {
+		t.Fatalf("the runbook page isn't indexed")
+	}
+	return doc.ReadableBy(Reader{Tenant: "acme", Email: "ada@acme.test", Groups: groups})
+}
+
+func TestConfluenceImportDeniesPermissionsNamingNoOne(t *testing.T) {
+	is := newTestImportService(t)
+
+	export := confluenceXMLExport(t, "ops")
+	result, err := is.Import("acme", "runbooks", "space.zip", bytes.NewReader(export), int64(len(export)), ImportOptions{})
+	if err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+	if result.Documents != 1 || result.Unreadable != 0 {
+		t.Errorf("result = %+v", result)
+	}
+	if runbookReadable(t, is.Index) {
+		t.Errorf("a reader outside the space's group can read the page")
+	}
+	if !runbookReadable(t, is.Index, "ops") {
+		t.Errorf("a member of the space's group can't read the page")
+	}
+}
+
+func TestListImportsCountsUnreadableDocuments(t *testing.T) {
+	is := newTestImportService(t)
+
+	// Indexed before permissions were kept
+	is.Index.Upsert(IndexedDocument{ID: "import:acme:legacy:1", Source: SlackImportSource, Tenant: "acme",
+		Title: "general", Metadata: map[string]string{"import": "legacy"}})
+	if err := is.Sync(); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+
+	summaries := is.ListImports("acme")
+	if len(summaries) != 1 || summaries[0].Documents != 1 || summaries[0].Unreadable != 1 {
+		t.Errorf("ListImports = %+v, want the legacy document counted as unreadable", summaries)
+	}
+}
+
+func TestImportSyncPicksUpNewerExport(t *testing.T) {
+	dir := t.TempDir()
+	index, _ := NewLocalIndex("")
+	is := NewImportService(index, dir, 0)
+
+	export := confluenceXMLExport(t, "ops")
+	if _, err := is.Import("acme", "runbooks", "space.zip", bytes.NewReader(export), int64(len(export)), ImportOptions{BaseURL: "https://wiki.acme.test"}); err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+	kept := filepath.Join(dir, "acme", "runbooks", "space.zip")
+	if data, err := os.ReadFile(kept); err != nil || !bytes.Equal(data, export) {
+		t.Fatalf("export wasn't kept at %s: %v", kept, err)
+	}
+
+	// An unchanged export isn't imported again
+	index.Delete("import:acme:runbooks:confluence/1")
+	if err := is.Sync(); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if _, ok := index.Get("import:acme:runbooks:confluence/1"); ok {
+		t.Errorf("Sync imported an unchanged export")
+	}
+
+	// A newer export copied over the kept one brings its permissions
+	if err := os.WriteFile(kept, confluenceXMLExport(t, "engineering"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := is.Sync(); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if runbookReadable(t, index, "ops") || !runbookReadable(t, index, "engineering") {
+		t.Errorf("Sync didn't apply the newer export's permissions")
+	}
+	if doc, _ := index.Get("import:acme:runbooks:confluence/1"); doc.URL != "https://wiki.acme.test/pages/viewpage.action?pageId=1" {
+		t.Errorf("URL = %q, want the kept options applied", doc.URL)
+	}
+
+	// After a restart every kept export is imported again
+	restarted := NewImportService(index, dir, 0)
+	index.Delete("import:acme:runbooks:confluence/1")
+	if err := restarted.Sync(); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if !runbookReadable(t, index, "engineering") {
+		t.Errorf("the first sync after a restart didn't import the kept export")
+	}
+
+	// Deleting the import removes the kept export, so it stays deleted
+	if n := restarted.DeleteImport("acme", "runbooks"); n != 1 {
+		t.Errorf("DeleteImport removed %d documents", n)
+	}
+	if _, err := os.Stat(filepath.Dir(kept)); !os.IsNotExist(err) {
+		t.Errorf("kept export survived the deletion: %v", err)
+	}
+	if err := NewImportService(index, dir, 0).Sync(); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if len(index.Documents(nil)) != 0 {
+		t.Errorf("a deleted import came back")
+	}
+}
+
+func TestImportRejectsUnsafeNames(t *testing.T) {
+	index, _ := NewLocalIndex("")
+	is := NewImportService(index, t.TempDir(), 0)
+
+	export := confluenceXMLExport(t, "ops")
+	for _, name := range []string{"..", ".", "a/b", ""} {
+		if _, err := is.Import("acme", name, "space.zip", bytes.NewReader(export), int64(len(export)), ImportOptions{}); err == nil {
+			t.Errorf("Import accepted the name %q", name)
+		}
+	}
+}
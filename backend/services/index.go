@@ -0,0 +1,239 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IndexedDocument is a document stored in a LocalIndex. Connectors without a
+// live search API (files, imports, crawled sites) put their content here.
+type IndexedDocument struct {
+	ID       string            `json:"id"`               // Unique within the index, e.g. a path or URL
+	Source   string            `json:"source"`           // Connector that owns the document, e.g. "files"
+	Tenant   string            `json:"tenant,omitempty"` // Tenant that may search it; empty means DefaultTenant
+	ACL      DocumentACL       `json:"acl,omitempty"`    // Who in the tenant may read it; nobody when empty
+	Title    string            `json:"title"`
+	Content  string            `json:"content"`
+	URL      string            `json:"url"`
+	Modified time.Time         `json:"modified"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// InTenant reports whether the document belongs to tenant
+func (doc *IndexedDocument) InTenant(tenant string) bool {
+	if doc.Tenant == "" {
+		return tenant == DefaultTenant
+	}
+	return doc.Tenant == tenant
+}
+
+// ReadableBy reports whether reader may get the document in search results
+func (doc *IndexedDocument) ReadableBy(reader Reader) bool {
+	return doc.InTenant(reader.Tenant) && doc.ACL.Allows(reader)
+}
+
+// LocalIndex is an in-memory inverted index with optional JSON persistence
+type LocalIndex struct {
+	mu       sync.RWMutex
+	docs     map[string]*IndexedDocument
+	postings map[string]map[string]int // term -> document ID -> term frequency
+	lengths  map[string]int            // document ID -> number of terms
+	path     string
+}
+
+// NewLocalIndex creates an index persisted at path. An empty path keeps the
+// index in memory only. An existing file is loaded.
+func NewLocalIndex(path string) (*LocalIndex, error) {
+	index := &LocalIndex{
+		docs:     make(map[string]*IndexedDocument),
+		postings: make(map[string]map[string]int),
+		lengths:  make(map[string]int),
+		path:     path,
+	}
+
+	if path == "" {
+		return index, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return index, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read index: %v", err)
+	}
+
+	var docs []IndexedDocument
+	if err := json.Unmarshal(data, &docs); err != nil {
+		return nil, fmt.Errorf("failed to parse index: %v", err)
+	}
+	for _, doc := range docs {
+		index.Upsert(doc)
+	}
+
+	return index, nil
+}
+
+// Upsert adds or replaces a document
+func (ix *LocalIndex) Upsert(doc IndexedDocument) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	ix.remove(doc.ID)
+
+	terms := extractKeywords(strings.ToLower(doc.Title + " " + doc.Title + " " + doc.Content))
+	for _, term := range terms {
+		if ix.postings[term] == nil {
+			ix.postings[term] = make(map[string]int)
+		}
+		ix.postings[term][doc.ID]++
+	}
+	ix.lengths[doc.ID] = len(terms)
+	ix.docs[doc.ID] = &doc
+}
+
+// Delete removes a document if present
+func (ix *LocalIndex) Delete(id string) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.remove(id)
+}
+
+func (ix *LocalIndex) remove(id string) {
+	doc, ok := ix.docs[id]
+	if !ok {
+		return
+	}
+
+	for _, term := range extractKeywords(strings.ToLower(doc.Title + " " + doc.Title + " " + doc.Content)) {
+		if postings := ix.postings[term]; postings != nil {
+			delete(postings, id)
+			if len(postings) == 0 {
+				delete(ix.postings, term)
+			}
+		}
+	}
+	delete(ix.lengths, id)
+	delete(ix.docs, id)
+}
+
+// Get returns a copy of a document
+func (ix *LocalIndex) Get(id string) (IndexedDocument, bool) {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	doc, ok := ix.docs[id]
+	if !ok {
+		return IndexedDocument{}, false
+	}
+	return *doc, true
+}
+
+// Documents returns copies of the documents matching the filter (all when nil)
+func (ix *LocalIndex) Documents(filter func(*IndexedDocument) bool) []IndexedDocument {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	var docs []IndexedDocument
+	for _, doc := range ix.docs {
+		if filter == nil || filter(doc) {
+			docs = append(docs, *doc)
+		}
+	}
+	sort.Slice(docs, func(i, j int) bool { return docs[i].ID < docs[j].ID })
+	return docs
+}
+
+// Search scores the documents reader may read with BM25 and returns the best
+// matches that pass the filter. The returned content is cut down to the
+// sections matching the query.
+func (ix *LocalIndex) Search(reader Reader, query string, limit int, filter func(*IndexedDocument) bool) []SearchResult {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	terms := extractKeywords(strings.ToLower(query))
+	if len(terms) == 0 || len(ix.docs) == 0 {
+		return nil
+	}
+
+	totalLength := 0
+	for _, length := range ix.lengths {
+		totalLength += length
+	}
+	avgLength := float64(totalLength) / float64(len(ix.docs))
+
+	const k1, b = 1.2, 0.75
+	scores := make(map[string]float64)
+	for _, term := range terms {
+		postings := ix.postings[term]
+		if len(postings) == 0 {
+			continue
+		}
+		idf := math.Log(1 + (float64(len(ix.docs))-float64(len(postings))+0.5)/(float64(len(postings))+0.5))
+		for id, tf := range postings {
+			if !ix.docs[id].ReadableBy(reader) || (filter != nil && !filter(ix.docs[id])) {
+				continue
+			}
+			norm := float64(tf) * (k1 + 1) / (float64(tf) + k1*(1-b+b*float64(ix.lengths[id])/avgLength))
+			scores[id] += idf * norm
+		}
+	}
+
+	ids := make([]string, 0, len(scores))
+	for id := range scores {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if scores[ids[i]] == scores[ids[j]] {
+			return ids[i] < ids[j]
+		}
+		return scores[ids[i]] > scores[ids[j]]
+	})
+	if limit > 0 && len(ids) > limit {
+		ids = ids[:limit]
+	}
+
+	var results []SearchResult
+	for _, id := range ids {
+		doc := ix.docs[id]
+		results = append(results, SearchResult{
+			Title:   doc.Title,
+			Content: ExtractRelevantMarkdown(doc.Content, query, 1500),
+			Source:  doc.Source,
+			URL:     doc.URL,
+		})
+	}
+
+	return results
+}
+
+// Save writes the index to its file, if it has one
+func (ix *LocalIndex) Save() error {
+	if ix.path == "" {
+		return nil
+	}
+
+	docs := ix.Documents(nil)
+	data, err := json.Marshal(docs)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(ix.path), 0o700); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a crash never leaves a truncated index
+	tmp := ix.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, ix.path)
+}
@@ -0,0 +1,133 @@
+package services
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// What to do with retrieved documents that look like prompt injection
+const (
+	InjectionActionFlag     = "flag"     // Mark them as suspicious for the model
+	InjectionActionDownrank = "downrank" // Mark them and put them after the other documents
+	InjectionActionDrop     = "drop"     // Leave them out of the prompt
+)
+
+// injectionThreshold is the score at which a document counts as suspicious.
+// Strong signals score 1 on their own; weak ones need company.
+const injectionThreshold = 1.0
+
+type injectionHeuristic struct {
+	name    string
+	pattern *regexp.Regexp
+	weight  float64
+}
+
+// injectionHeuristics match the normalized text: lower case, with zero-width
+// characters removed and whitespace collapsed
+var injectionHeuristics = []injectionHeuristic{
+	// Attempts to replace the instructions
+	{"override", regexp.MustCompile(`\b(?:ignore|disregard|forget|skip|override|bypass)\b(?:\s+\w+){0,3}\s+(?:previous|prior|above|earlier|preceding|original|system|existing|all)\b(?:\s+\w+){0,2}\s+(?:instructions?|prompts?|rules|directions|guidelines|context)\b`), 1},
+	{"new instructions", regexp.MustCompile(`\b(?:new|updated|real|actual|additional|hidden|secret)\s+(?:system\s+)?(?:instructions?|prompt|rules)\s*:`), 1},
+	{"system prompt", regexp.MustCompile(`\b(?:system|developer)\s+(?:prompt|message|instructions?)\b`), 0.5},
+	{"reveal", regexp.MustCompile(`\b(?:reveal|print|repeat|show|output|leak)\b(?:\s+\w+){0,3}\s+(?:your\s+(?:system\s+)?(?:prompt|instructions)|the\s+system\s+prompt)\b`), 1},
+
+	// Attempts to change the model's role
+	{"role change", regexp.MustCompile(`\b(?:you are now|from now on,? you|you will now act|pretend (?:to be|you are)|roleplay as)\b|\b(?:switch to|enter)\b(?:\s+\w+){0,2}\s+mode\b`), 0.5},
+	{"jailbreak", regexp.MustCompile(`\b(?:jailbreak|jailbroken|dan mode|developer mode|do anything now|no restrictions|without (?:any )?restrictions)\b`), 1},
+
+	// Fake conversation turns and chat template tokens
+	{"chat markup", regexp.MustCompile(`<\|?(?:im_start|im_end|system|endoftext)\|?>|\[/?inst\]|<</?sys>>|</?document\b`), 1},
+	{"fake system turn", regexp.MustCompile(`(?:^|\n)\s*(?:#+\s*)?system\s*:\s*(?:you\b|the (?:assistant|ai|model)\b)`), 1},
+	{"fake turn", regexp.MustCompile(`(?:^|\n)\s*(?:#+\s*)?(?:system|assistant|ai|instruction|instructions)\s*:`), 0.5},
+
+	// Addressing the model instead of people
+	{"commands the model", regexp.MustCompile(`\b(?:ai|assistant|chatbot|language model|llm|gpt|chatgpt)\b[,:]?\s+(?:please\s+)?(?:ignore|disregard|you must)\b`), 1},
+	{"addresses the model", regexp.MustCompile(`\b(?:ai|assistant|chatbot|language model|llm|gpt|chatgpt)\b[,:]?\s+(?:please\s+)?(?:do not|don't|you should|always|never|respond|reply|answer|say|tell)\b`), 0.5},
+	{"hide from user", regexp.MustCompile(`\b(?:do not|don't|never)\s+(?:tell|inform|mention|reveal|show)\b(?:\s+\w+){0,2}\s+(?:the\s+)?user\b`), 0.5},
+
+	// Getting data out through links the answer would render. Images with
+	// query strings are common in wiki pages, so they only add to the score.
+	{"exfiltration", regexp.MustCompile(`\b(?:send|post|forward|upload|exfiltrate)\b(?:\s+\w+){0,4}\s+to\s+https?://`), 1},
+	{"image with parameters", regexp.MustCompile(`!\[[^\]]*\]\(\s*https?://[^)\s]*\?[^)\s]*=`), 0.5},
+}
+
+// zeroWidth is used to hide instructions from people reading the document
+var zeroWidth = strings.NewReplacer("\u200b", "", "\u200c", "", "\u200d", "", "\u2060", "", "\ufeff", "")
+
+// InjectionDetector scores retrieved documents for prompt injection with
+// heuristics. It can't prove a document is harmless; the prompt keeps
+// documents apart from instructions either way.
+type InjectionDetector struct {
+	action string
+}
+
+// NewInjectionDetector creates a detector doing action with suspicious documents
+func NewInjectionDetector(action string) (*InjectionDetector, error) {
+	switch action {
+	case InjectionActionFlag, InjectionActionDownrank, InjectionActionDrop:
+		return &InjectionDetector{action: action}, nil
+	}
+	return nil, fmt.Errorf("unknown prompt injection action %q, expected flag, downrank or drop", action)
+}
+
+// Score rates how much text looks like an attempt to instruct the model and
+// names the heuristics that matched
+func (d *InjectionDetector) Score(text string) (float64, []string) {
+	normalized := strings.ToLower(text)
+	var score float64
+	var reasons []string
+	if stripped := zeroWidth.Replace(normalized); stripped != normalized {
+		score += 0.5
+		reasons = append(reasons, "hidden characters")
+		normalized = stripped
+	}
+	normalized = collapseSpaces(normalized)
+
+	for _, heuristic := range injectionHeuristics {
+		if heuristic.pattern.MatchString(normalized) {
+			score += heuristic.weight
+			reasons = append(reasons, heuristic.name)
+		}
+	}
+	return score, reasons
+}
+
+// ScreenedResult is a search result with the detector's verdict
+type ScreenedResult struct {
+	SearchResult
+	Suspicious bool
+	Reasons    []string
+}
+
+// Screen checks each result and flags, moves back or drops the suspicious
+// ones, keeping the order otherwise. A nil detector passes everything.
+func (d *InjectionDetector) Screen(results []SearchResult) []ScreenedResult {
+	screened := make([]ScreenedResult, 0, len(results))
+	for _, result := range results {
+		entry := ScreenedResult{SearchResult: result}
+		if d != nil {
+			score, reasons := d.Score(result.Title + "\n" + result.Content)
+			entry.Suspicious = score >= injectionThreshold
+			entry.Reasons = reasons
+		}
+		if entry.Suspicious && d.action == InjectionActionDrop {
+			continue
+		}
+		screened = append(screened, entry)
+	}
+	if d != nil && d.action == InjectionActionDownrank {
+		sort.SliceStable(screened, func(i, j int) bool { return !screened[i].Suspicious && screened[j].Suspicious })
+	}
+	return screened
+}
+
+// collapseSpaces keeps line breaks, which the fake turn heuristic needs
+func collapseSpaces(text string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.Join(strings.Fields(line), " ")
+	}
+	return strings.Join(lines, "\n")
+}
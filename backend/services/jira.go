@@ -0,0 +1,297 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// JiraService searches Jira Cloud with the Atlassian 3LO token obtained by
+// ConfluenceService, so it has no OAuth flow of its own.
+type JiraService struct {
+	APIBaseURL string // Overridable for tests against a local stub
+}
+
+type JiraSearchResponse struct {
+	Issues        []JiraIssue `json:"issues"`
+	NextPageToken string      `json:"nextPageToken"`
+	IsLast        bool        `json:"isLast"`
+}
+
+type JiraIssue struct {
+	ID     string          `json:"id"`
+	Key    string          `json:"key"`
+	Fields JiraIssueFields `json:"fields"`
+}
+
+type JiraIssueFields struct {
+	Summary     string          `json:"summary"`
+	Description json.RawMessage `json:"description"` // Atlassian Document Format
+	Updated     string          `json:"updated"`
+	Status      struct {
+		Name string `json:"name"`
+	} `json:"status"`
+	IssueType struct {
+		Name string `json:"name"`
+	} `json:"issuetype"`
+	Project struct {
+		Key  string `json:"key"`
+		Name string `json:"name"`
+	} `json:"project"`
+	Assignee *JiraUser `json:"assignee"`
+	Reporter *JiraUser `json:"reporter"`
+	Comment  struct {
+		Comments []JiraComment `json:"comments"`
+	} `json:"comment"`
+	IssueLinks []JiraIssueLink `json:"issuelinks"`
+}
+
+type JiraUser struct {
+	AccountID   string `json:"accountId"`
+	DisplayName string `json:"displayName"`
+}
+
+type JiraComment struct {
+	ID      string          `json:"id"`
+	Author  *JiraUser       `json:"author"`
+	Body    json.RawMessage `json:"body"` // Atlassian Document Format
+	Created string          `json:"created"`
+}
+
+type JiraIssueLink struct {
+	Type struct {
+		Name    string `json:"name"`
+		Inward  string `json:"inward"`
+		Outward string `json:"outward"`
+	} `json:"type"`
+	InwardIssue  *JiraLinkedIssue `json:"inwardIssue"`
+	OutwardIssue *JiraLinkedIssue `json:"outwardIssue"`
+}
+
+type JiraLinkedIssue struct {
+	Key    string `json:"key"`
+	Fields struct {
+		Summary string `json:"summary"`
+		Status  struct {
+			Name string `json:"name"`
+		} `json:"status"`
+	} `json:"fields"`
+}
+
+// adfNode is a node of an Atlassian Document Format tree
+type adfNode struct {
+	Type    string                 `json:"type"`
+	Text    string                 `json:"text"`
+	Attrs   map[string]interface{} `json:"attrs"`
+	Content []adfNode              `json:"content"`
+}
+
+func NewJiraService() *JiraService {
+	return &JiraService{
+		APIBaseURL: "https://api.atlassian.com",
+	}
+}
+
+// SearchIssues runs a JQL query against the Jira site identified by cloudID
+func (js *JiraService) SearchIssues(accessToken, cloudID, jql string, maxResults int) (*JiraSearchResponse, error) {
+	if maxResults == 0 {
+		maxResults = 10
+	}
+
+	searchURL := fmt.Sprintf("%s/ex/jira/%s/rest/api/3/search/jql", js.APIBaseURL, cloudID)
+	params := url.Values{}
+	params.Add("jql", jql)
+	params.Add("maxResults", fmt.Sprintf("%d", maxResults))
+	params.Add("fields", "summary,description,status,issuetype,project,assignee,reporter,comment,issuelinks,updated")
+
+	fullURL := fmt.Sprintf("%s?%s", searchURL, params.Encode())
+
+	req, err := http.NewRequest("GET", fullURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Add("Authorization", "Bearer "+accessToken)
+	req.Header.Add("Accept", "application/json")
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to search Jira: %w", newStatusError(resp))
+	}
+
+	var searchResults JiraSearchResponse
+	if err := json.NewDecoder(resp.Body).Decode(&searchResults); err != nil {
+		return nil, err
+	}
+
+	return &searchResults, nil
+}
+
+// BuildJQL turns a free-text question into a JQL text search, optionally
+// limited to a set of project keys
+func BuildJQL(query string, projects []string) string {
+	escaped := strings.ReplaceAll(query, `\`, `\\`)
+	escaped = strings.ReplaceAll(escaped, `"`, `\"`)
+	jql := fmt.Sprintf(`text ~ "%s"`, escaped)
+
+	if len(projects) > 0 {
+		var quoted []string
+		for _, project := range projects {
+			quoted = append(quoted, fmt.Sprintf(`"%s"`, strings.ReplaceAll(project, `"`, "")))
+		}
+		jql += fmt.Sprintf(" AND project in (%s)", strings.Join(quoted, ", "))
+	}
+
+	return jql + " ORDER BY updated DESC"
+}
+
+// JiraSites keeps the Atlassian sites the token was granted Jira scopes on
+func JiraSites(sites []ConfluenceResource) []ConfluenceResource {
+	var jiraSites []ConfluenceResource
+	for _, site := range sites {
+		for _, scope := range site.Scopes {
+			if strings.Contains(scope, "jira") {
+				jiraSites = append(jiraSites, site)
+				break
+			}
+		}
+	}
+	return jiraSites
+}
+
+// FormatIssue renders an issue with its status, people, description,
+// comments and links as plain text for the LLM context
+func (js *JiraService) FormatIssue(issue JiraIssue) string {
+	fields := issue.Fields
+
+	var lines []string
+	lines = append(lines, fmt.Sprintf("Issue: %s - %s", issue.Key, fields.Summary))
+	lines = append(lines, fmt.Sprintf("Project: %s", fields.Project.Name))
+	lines = append(lines, fmt.Sprintf("Type: %s", fields.IssueType.Name))
+	lines = append(lines, fmt.Sprintf("Status: %s", fields.Status.Name))
+	if fields.Assignee != nil {
+		lines = append(lines, fmt.Sprintf("Assignee: %s", fields.Assignee.DisplayName))
+	} else {
+		lines = append(lines, "Assignee: Unassigned")
+	}
+	if fields.Reporter != nil {
+		lines = append(lines, fmt.Sprintf("Reporter: %s", fields.Reporter.DisplayName))
+	}
+	if fields.Updated != "" {
+		lines = append(lines, fmt.Sprintf("Updated: %s", fields.Updated))
+	}
+
+	for _, link := range fields.IssueLinks {
+		if link.OutwardIssue != nil {
+			lines = append(lines, fmt.Sprintf("Link: %s %s (%s, %s)", link.Type.Outward, link.OutwardIssue.Key, link.OutwardIssue.Fields.Summary, link.OutwardIssue.Fields.Status.Name))
+		}
+		if link.InwardIssue != nil {
+			lines = append(lines, fmt.Sprintf("Link: %s %s (%s, %s)", link.Type.Inward, link.InwardIssue.Key, link.InwardIssue.Fields.Summary, link.InwardIssue.Fields.Status.Name))
+		}
+	}
+
+	if description := ADFToText(fields.Description); description != "" {
+		lines = append(lines, "", "Description:", description)
+	}
+
+	if len(fields.Comment.Comments) > 0 {
+		lines = append(lines, "", "Comments:")
+		for _, comment := range fields.Comment.Comments {
+			author := "Unknown"
+			if comment.Author != nil {
+				author = comment.Author.DisplayName
+			}
+			lines = append(lines, fmt.Sprintf("%s: %s", author, ADFToText(comment.Body)))
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// ADFToText converts an Atlassian Document Format document to plain text.
+// Plain string values (as returned by older API versions) are passed through.
+func ADFToText(raw json.RawMessage) string {
+	if len(raw) == 0 || string(raw) == "null" {
+		return ""
+	}
+
+	var plain string
+	if err := json.Unmarshal(raw, &plain); err == nil {
+		return plain
+	}
+
+	var doc adfNode
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return ""
+	}
+
+	var text strings.Builder
+	writeADF(&text, doc, "")
+	return collapseBlankLines(text.String())
+}
+
+func writeADF(text *strings.Builder, node adfNode, indent string) {
+	switch node.Type {
+	case "text":
+		text.WriteString(node.Text)
+	case "hardBreak":
+		text.WriteString("\n")
+	case "mention", "emoji", "status", "date", "inlineCard":
+		for _, key := range []string{"text", "shortName", "url", "timestamp"} {
+			if value, ok := node.Attrs[key].(string); ok && value != "" {
+				text.WriteString(value)
+				break
+			}
+		}
+	case "heading":
+		level := 1
+		if l, ok := node.Attrs["level"].(float64); ok {
+			level = int(l)
+		}
+		text.WriteString(strings.Repeat("#", level) + " ")
+		writeADFChildren(text, node, indent)
+		text.WriteString("\n\n")
+	case "paragraph":
+		writeADFChildren(text, node, indent)
+		text.WriteString("\n\n")
+	case "codeBlock":
+		text.WriteString("```\n")
+		writeADFChildren(text, node, indent)
+		text.WriteString("\n```\n\n")
+	case "bulletList", "orderedList":
+		for i, item := range node.Content {
+			marker := "- "
+			if node.Type == "orderedList" {
+				marker = fmt.Sprintf("%d. ", i+1)
+			}
+			var itemText strings.Builder
+			writeADF(&itemText, item, indent+"  ")
+			text.WriteString(indent + marker + strings.TrimSpace(itemText.String()) + "\n")
+		}
+		text.WriteString("\n")
+	case "tableRow":
+		var cells []string
+		for _, cell := range node.Content {
+			var cellText strings.Builder
+			writeADFChildren(&cellText, cell, indent)
+			cells = append(cells, strings.Join(strings.Fields(cellText.String()), " "))
+		}
+		text.WriteString("| " + strings.Join(cells, " | ") + " |\n")
+	default:
+		writeADFChildren(text, node, indent)
+	}
+}
+
+func writeADFChildren(text *strings.Builder, node adfNode, indent string) {
+	for _, child := range node.Content {
+		writeADF(text, child, indent)
+	}
+}
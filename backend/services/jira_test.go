@@ -0,0 +1,129 @@
+package services
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const jiraSearchStub = `{
+	"issues": [{
+		"id": "10001",
+		"key": "OPS-12",
+		"fields": {
+			"summary": "Deploys fail on Mondays",
+			"updated": "2024-03-04T09:00:00.000+0000",
+			"status": {"name": "In Progress"},
+			"issuetype": {"name": "Bug"},
+			"project": {"key": "OPS", "name": "Operations"},
+			"assignee": {"accountId": "1", "displayName": "Ada Lovelace"},
+			"reporter": null,
+			"description": {"type": "doc", "content": [
+				{"type": "heading", "attrs": {"level": 2}, "content": [{"type": "text", "text": "Steps"}]},
+				{"type": "bulletList", "content": [
+					{"type": "listItem", "content": [{"type": "paragraph", "content": [{"type": "text", "text": "Merge on Friday"}]}]},
+					{"type": "listItem", "content": [{"type": "paragraph", "content": [{"type": "text", "text": "Deploy on Monday"}]}]}
+				]}
+			]},
+			"comment": {"comments": [
+				{"id": "1", "author": {"displayName": "Grace Hopper"}, "body": "Plain text from the v2 API", "created": "2024-03-04"}
+			]},
+			"issuelinks": [{
+				"type": {"name": "Blocks", "inward": "is blocked by", "outward": "blocks"},
+				"outwardIssue": {"key": "OPS-13", "fields": {"summary": "Release 2.0", "status": {"name": "To Do"}}}
+			}]
+		}
+	}],
+	"isLast": true
+}`
+
+func TestJiraSearchIssues(t *testing.T) {
+	var gotPath, gotJQL, gotAuth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotJQL = r.URL.Query().Get("jql")
+		gotAuth = r.Header.Get("Authorization")
+		w.Write([]byte(jiraSearchStub))
+	}))
+	defer server.Close()
+
+	js := NewJiraService()
+	js.APIBaseURL = server.URL
+	jql := BuildJQL("deploys", []string{"OPS"})
+	response, err := js.SearchIssues("token", "cloud-1", jql, 5)
+	if err != nil {
+		t.Fatalf("SearchIssues: %v", err)
+	}
+
+	if gotPath != "/ex/jira/cloud-1/rest/api/3/search/jql" {
+		t.Errorf("path = %q", gotPath)
+	}
+	if gotJQL != jql {
+		t.Errorf("jql = %q, want %q", gotJQL, jql)
+	}
+	if gotAuth != "Bearer token" {
+		t.Errorf("Authorization = %q", gotAuth)
+	}
+	if len(response.Issues) != 1 || response.Issues[0].Key != "OPS-12" {
+		t.Fatalf("issues = %+v", response.Issues)
+	}
+
+	text := js.FormatIssue(response.Issues[0])
+	for _, want := range []string{
+		"Issue: OPS-12 - Deploys fail on Mondays",
+		"Status: In Progress",
+		"Assignee: Ada Lovelace",
+		"Link: blocks OPS-13 (Release 2.0, To Do)",
+		"## Steps",
+		"- Merge on Friday\n- Deploy on Monday",
+		"Grace Hopper: Plain text from the v2 API",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("FormatIssue is missing %q:\n%s", want, text)
+		}
+	}
+}
+
+func TestJiraSearchIssuesUnauthorized(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	js := NewJiraService()
+	js.APIBaseURL = server.URL
+	_, err := js.SearchIssues("expired", "cloud-1", BuildJQL("deploys", nil), 5)
+	if !IsUnauthorized(err) {
+		t.Fatalf("err = %v, want an unauthorized error", err)
+	}
+}
+
+func TestBuildJQL(t *testing.T) {
+	tests := []struct {
+		query    string
+		projects []string
+		want     string
+	}{
+		{"deploys", nil, `text ~ "deploys" ORDER BY updated DESC`},
+		{`say "hi" \ bye`, nil, `text ~ "say \"hi\" \\ bye" ORDER BY updated DESC`},
+		{"deploys", []string{"OPS", `W"EB`}, `text ~ "deploys" AND project in ("OPS", "WEB") ORDER BY updated DESC`},
+	}
+	for _, test := range tests {
+		if got := BuildJQL(test.query, test.projects); got != test.want {
+			t.Errorf("BuildJQL(%q, %q) = %q, want %q", test.query, test.projects, got, test.want)
+		}
+	}
+}
+
+func TestJiraSites(t *testing.T) {
+	sites := []ConfluenceResource{
+		{ID: "1", Scopes: []string{"read:confluence-content.all"}},
+		{ID: "2", Scopes: []string{"read:jira-work"}},
+		{ID: "3"},
+	}
+	jiraSites := JiraSites(sites)
+	if len(jiraSites) != 1 || jiraSites[0].ID != "2" {
+		t.Errorf("JiraSites = %+v, want only site 2", jiraSites)
+	}
+}
@@ -0,0 +1,555 @@
+package services
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// htmlNode is a minimal DOM node built from Confluence storage format or view HTML
+type htmlNode struct {
+	Name     string // Local element name, prefixed with the namespace for macros (e.g. "ac:structured-macro")
+	Attrs    map[string]string
+	Children []*htmlNode
+	Text     string // Set on text nodes only
+}
+
+func (n *htmlNode) isText() bool {
+	return n.Name == ""
+}
+
+// child returns the first direct child with the given name
+func (n *htmlNode) child(name string) *htmlNode {
+	for _, c := range n.Children {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// macroParameter returns the value of <ac:parameter ac:name="name">
+func (n *htmlNode) macroParameter(name string) string {
+	for _, c := range n.Children {
+		if c.Name == "ac:parameter" && c.Attrs["ac:name"] == name {
+			return strings.TrimSpace(c.textContent())
+		}
+	}
+	return ""
+}
+
+func (n *htmlNode) textContent() string {
+	if n.isText() {
+		return n.Text
+	}
+	var text strings.Builder
+	for _, c := range n.Children {
+		text.WriteString(c.textContent())
+	}
+	return text.String()
+}
+
+func (n *htmlNode) hasClass(class string) bool {
+	for _, c := range strings.Fields(n.Attrs["class"]) {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+// ConfluenceToMarkdown converts Confluence storage format or rendered view HTML
+// to Markdown. Headings, lists, tables, code blocks and info panels are kept,
+// and common macros are unwrapped. Content that cannot be parsed falls back to
+// ExtractPlainText.
+func ConfluenceToMarkdown(content string) string {
+	root, err := parseHTMLTree(content)
+	if err != nil {
+		return ExtractPlainText(content)
+	}
+
+	var md markdownWriter
+	md.blockChildren(root)
+	return md.String()
+}
+
+// HTMLToMarkdown converts a standalone HTML page to Markdown. The document
+// head, scripts and styles are dropped.
+func HTMLToMarkdown(content string) string {
+	return ConfluenceToMarkdown(stripDoctype(content))
+}
+
+// stripDoctype removes the doctype, since the decoder wraps content in its own root element
+func stripDoctype(content string) string {
+	if i := strings.Index(strings.ToLower(content), "<!doctype"); i >= 0 {
+		if end := strings.Index(content[i:], ">"); end >= 0 {
+			content = content[:i] + content[i+end+1:]
+		}
+	}
+	return content
+}
+
+// htmlVoidElements is xml.HTMLAutoClose without "link", which would otherwise
+// also auto-close Confluence's <ac:link> elements
+var htmlVoidElements = []string{"basefont", "br", "area", "img", "param", "hr", "input", "col", "frame", "isindex", "base", "meta"}
+
+func parseHTMLTree(content string) (*htmlNode, error) {
+	// Declare the Confluence prefixes so namespaced elements resolve to "ac:" and "ri:"
+	decoder := xml.NewDecoder(strings.NewReader(`<root xmlns:ac="ac" xmlns:ri="ri">` + content + "</root>"))
+	decoder.Strict = false
+	decoder.AutoClose = htmlVoidElements
+	decoder.Entity = xml.HTMLEntity
+
+	root := &htmlNode{Name: "root"}
+	stack := []*htmlNode{root}
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		parent := stack[len(stack)-1]
+		switch t := token.(type) {
+		case xml.StartElement:
+			node := &htmlNode{Name: qualifiedName(t.Name), Attrs: make(map[string]string)}
+			for _, attr := range t.Attr {
+				node.Attrs[qualifiedName(attr.Name)] = attr.Value
+			}
+			parent.Children = append(parent.Children, node)
+			stack = append(stack, node)
+		case xml.EndElement:
+			// Pop back to the matching element, tolerating unclosed tags
+			name := qualifiedName(t.Name)
+			for i := len(stack) - 1; i > 0; i-- {
+				if stack[i].Name == name {
+					stack = stack[:i]
+					break
+				}
+			}
+		case xml.CharData:
+			parent.Children = append(parent.Children, &htmlNode{Text: string(t)})
+		}
+	}
+
+	return root, nil
+}
+
+func qualifiedName(name xml.Name) string {
+	local := strings.ToLower(name.Local)
+	if name.Space != "" {
+		return strings.ToLower(name.Space) + ":" + local
+	}
+	return local
+}
+
+// markdownWriter accumulates Markdown blocks separated by blank lines
+type markdownWriter struct {
+	blocks []string
+}
+
+func (md *markdownWriter) add(block string) {
+	if block = strings.TrimRight(block, " \n"); strings.TrimSpace(block) != "" {
+		md.blocks = append(md.blocks, block)
+	}
+}
+
+func (md *markdownWriter) String() string {
+	return strings.Join(md.blocks, "\n\n")
+}
+
+// blockChildren renders children as blocks, grouping runs of inline content into paragraphs
+func (md *markdownWriter) blockChildren(n *htmlNode) {
+	var inline []*htmlNode
+	flush := func() {
+		if len(inline) > 0 {
+			md.add(renderInline(inline))
+			inline = nil
+		}
+	}
+
+	for _, c := range n.Children {
+		if c.isText() || isInlineElement(c) {
+			inline = append(inline, c)
+			continue
+		}
+		flush()
+		md.block(c)
+	}
+	flush()
+}
+
+func (md *markdownWriter) block(n *htmlNode) {
+	switch n.Name {
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		level := int(n.Name[1] - '0')
+		md.add(strings.Repeat("#", level) + " " + renderInline(n.Children))
+	case "p":
+		md.add(renderInline(n.Children))
+	case "ul", "ol":
+		md.add(renderList(n, 0))
+	case "table":
+		md.add(renderTable(n))
+	case "pre":
+		md.add(codeFence(n.Attrs["data-language"], n.textContent()))
+	case "blockquote":
+		var inner markdownWriter
+		inner.blockChildren(n)
+		md.add(quote(inner.String()))
+	case "hr":
+		md.add("---")
+	case "script", "style", "head", "colgroup", "ac:parameter":
+		// Not content
+	case "ac:structured-macro", "ac:macro":
+		md.macro(n)
+	case "ac:task-list":
+		md.add(renderTaskList(n))
+	case "ac:layout", "ac:layout-section", "ac:layout-cell", "ac:rich-text-body":
+		md.blockChildren(n)
+	case "div", "span":
+		md.viewMacro(n)
+	default:
+		md.blockChildren(n)
+	}
+}
+
+// macro unwraps a storage-format macro
+func (md *markdownWriter) macro(n *htmlNode) {
+	name := n.Attrs["ac:name"]
+	switch name {
+	case "code", "noformat":
+		body := n.child("ac:plain-text-body")
+		if body != nil {
+			md.add(codeFence(n.macroParameter("language"), body.textContent()))
+		}
+	case "info", "note", "warning", "tip", "panel":
+		var inner markdownWriter
+		if title := n.macroParameter("title"); title != "" {
+			inner.add("**" + title + "**")
+		}
+		if body := n.child("ac:rich-text-body"); body != nil {
+			inner.blockChildren(body)
+		}
+		label := strings.ToUpper(name[:1]) + name[1:]
+		md.add(quote("**" + label + ":** " + inner.String()))
+	case "status":
+		md.add(renderInline([]*htmlNode{n}))
+	case "expand":
+		if title := n.macroParameter("title"); title != "" {
+			md.add("**" + title + "**")
+		}
+		if body := n.child("ac:rich-text-body"); body != nil {
+			md.blockChildren(body)
+		}
+	case "toc", "children", "anchor", "recently-updated", "pagetree", "contentbylabel":
+		// Navigation macros carry no content of their own
+	default:
+		if body := n.child("ac:rich-text-body"); body != nil {
+			md.blockChildren(body)
+		} else if body := n.child("ac:plain-text-body"); body != nil {
+			md.add(body.textContent())
+		}
+	}
+}
+
+// viewMacro recognizes the rendered form of common macros in view HTML
+func (md *markdownWriter) viewMacro(n *htmlNode) {
+	switch {
+	case n.hasClass("confluence-information-macro"):
+		label := "Info"
+		for _, kind := range []string{"note", "warning", "tip"} {
+			if n.hasClass("confluence-information-macro-" + kind) {
+				label = strings.ToUpper(kind[:1]) + kind[1:]
+			}
+		}
+		var inner markdownWriter
+		inner.blockChildren(n)
+		md.add(quote("**" + label + ":** " + inner.String()))
+	case n.hasClass("code") && n.hasClass("panel"):
+		var pre *htmlNode
+		findFirst(n, "pre", &pre)
+		if pre != nil {
+			md.add(codeFence(codeLanguage(pre.Attrs["data-syntaxhighlighter-params"]), pre.textContent()))
+		}
+	case n.hasClass("toc-macro"):
+		// Rendered table of contents
+	default:
+		md.blockChildren(n)
+	}
+}
+
+func findFirst(n *htmlNode, name string, found **htmlNode) {
+	for _, c := range n.Children {
+		if *found != nil {
+			return
+		}
+		if c.Name == name {
+			*found = c
+			return
+		}
+		findFirst(c, name, found)
+	}
+}
+
+// findByID returns the first element with the given id attribute
+func findByID(n *htmlNode, id string) *htmlNode {
+	for _, c := range n.Children {
+		if c.Attrs["id"] == id {
+			return c
+		}
+		if found := findByID(c, id); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+var syntaxBrush = regexp.MustCompile(`brush:\s*([\w+#-]+)`)
+
+func codeLanguage(params string) string {
+	if m := syntaxBrush.FindStringSubmatch(params); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+func isInlineElement(n *htmlNode) bool {
+	switch n.Name {
+	case "span":
+		return n.hasClass("status-macro") || !containsBlock(n)
+	case "a", "b", "strong", "i", "em", "u", "s", "del", "code", "br", "sub", "sup",
+		"img", "ac:link", "ac:image", "ac:emoticon", "ri:user", "time", "ac:placeholder":
+		return true
+	case "ac:structured-macro", "ac:macro":
+		return n.Attrs["ac:name"] == "status" || n.Attrs["ac:name"] == "jira"
+	}
+	return false
+}
+
+func containsBlock(n *htmlNode) bool {
+	for _, c := range n.Children {
+		if !c.isText() && !isInlineElement(c) {
+			return true
+		}
+	}
+	return false
+}
+
+var whitespaceRun = regexp.MustCompile(`[ \t\r\n]+`)
+
+// renderInline renders inline nodes on a single line, keeping explicit <br> breaks
+func renderInline(nodes []*htmlNode) string {
+	var text strings.Builder
+	for _, n := range nodes {
+		text.WriteString(inlineNode(n))
+	}
+
+	lines := strings.Split(text.String(), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(whitespaceRun.ReplaceAllString(line, " "))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func inlineNode(n *htmlNode) string {
+	if n.isText() {
+		return n.Text
+	}
+
+	inner := func() string {
+		return strings.TrimSpace(renderInline(n.Children))
+	}
+
+	switch n.Name {
+	case "br":
+		return "\n"
+	case "b", "strong":
+		return wrapInline("**", inner())
+	case "i", "em":
+		return wrapInline("*", inner())
+	case "s", "del":
+		return wrapInline("~~", inner())
+	case "code":
+		return wrapInline("`", n.textContent())
+	case "a":
+		text := inner()
+		if href := n.Attrs["href"]; href != "" && text != "" && !strings.HasPrefix(href, "#") {
+			return fmt.Sprintf("[%s](%s)", text, href)
+		}
+		return text
+	case "ac:link":
+		if body := n.child("ac:link-body"); body != nil {
+			return body.textContent()
+		}
+		if body := n.child("ac:plain-text-link-body"); body != nil {
+			return body.textContent()
+		}
+		if page := n.child("ri:page"); page != nil {
+			return "[[" + page.Attrs["ri:content-title"] + "]]"
+		}
+		if attachment := n.child("ri:attachment"); attachment != nil {
+			return "[[" + attachment.Attrs["ri:filename"] + "]]"
+		}
+		if user := n.child("ri:user"); user != nil {
+			return "@user"
+		}
+		return inner()
+	case "ac:image", "img":
+		if alt := n.Attrs["alt"]; alt != "" {
+			return "[image: " + alt + "]"
+		}
+		return ""
+	case "ac:emoticon", "ac:parameter", "script", "style":
+		return ""
+	case "time":
+		if datetime := n.Attrs["datetime"]; datetime != "" {
+			return datetime
+		}
+		return inner()
+	case "ac:structured-macro", "ac:macro":
+		switch n.Attrs["ac:name"] {
+		case "status":
+			return "[" + strings.ToUpper(n.macroParameter("title")) + "]"
+		case "jira":
+			return n.macroParameter("key")
+		}
+		return inner()
+	case "span":
+		if n.hasClass("status-macro") {
+			return "[" + strings.ToUpper(strings.TrimSpace(n.textContent())) + "]"
+		}
+		return renderInline(n.Children)
+	}
+
+	return renderInline(n.Children)
+}
+
+func wrapInline(marker, text string) string {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return ""
+	}
+	return marker + text + marker
+}
+
+func renderList(n *htmlNode, depth int) string {
+	var lines []string
+	index := 1
+	for _, item := range n.Children {
+		if item.Name != "li" {
+			continue
+		}
+
+		marker := "- "
+		if n.Name == "ol" {
+			marker = fmt.Sprintf("%d. ", index)
+			index++
+		}
+
+		var inline []*htmlNode
+		var nested []string
+		for _, c := range item.Children {
+			switch {
+			case c.Name == "ul" || c.Name == "ol":
+				nested = append(nested, renderList(c, depth+1))
+			case c.Name == "p" || c.Name == "div":
+				inline = append(inline, c.Children...)
+				inline = append(inline, &htmlNode{Text: " "})
+			default:
+				inline = append(inline, c)
+			}
+		}
+
+		indent := strings.Repeat("  ", depth)
+		text := strings.ReplaceAll(renderInline(inline), "\n", "\n"+indent+"  ")
+		lines = append(lines, indent+marker+text)
+		lines = append(lines, nested...)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func renderTaskList(n *htmlNode) string {
+	var lines []string
+	for _, task := range n.Children {
+		if task.Name != "ac:task" {
+			continue
+		}
+		box := "[ ]"
+		if status := task.child("ac:task-status"); status != nil && strings.TrimSpace(status.textContent()) == "complete" {
+			box = "[x]"
+		}
+		body := ""
+		if b := task.child("ac:task-body"); b != nil {
+			body = renderInline(b.Children)
+		}
+		lines = append(lines, "- "+box+" "+body)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func renderTable(n *htmlNode) string {
+	var rows [][]string
+	var collect func(*htmlNode)
+	collect = func(node *htmlNode) {
+		for _, c := range node.Children {
+			switch c.Name {
+			case "tr":
+				var row []string
+				for _, cell := range c.Children {
+					if cell.Name == "td" || cell.Name == "th" {
+						var inner markdownWriter
+						inner.blockChildren(cell)
+						text := strings.ReplaceAll(inner.String(), "\n", " ")
+						row = append(row, strings.ReplaceAll(text, "|", "\\|"))
+					}
+				}
+				if len(row) > 0 {
+					rows = append(rows, row)
+				}
+			case "thead", "tbody", "tfoot":
+				collect(c)
+			}
+		}
+	}
+	collect(n)
+
+	if len(rows) == 0 {
+		return ""
+	}
+
+	width := 0
+	for _, row := range rows {
+		if len(row) > width {
+			width = len(row)
+		}
+	}
+
+	var lines []string
+	for i, row := range rows {
+		for len(row) < width {
+			row = append(row, "")
+		}
+		lines = append(lines, "| "+strings.Join(row, " | ")+" |")
+		if i == 0 {
+			lines = append(lines, "|"+strings.Repeat(" --- |", width))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func codeFence(language, code string) string {
+	return "```" + language + "\n" + strings.Trim(code, "\n") + "\n```"
+}
+
+func quote(text string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight("> "+line, " ")
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,85 @@
+package services
+
+import "testing"
+
+func TestConfluenceToMarkdown(t *testing.T) {
+	tests := []struct {
+		name string
+		html string
+		want string
+	}{
+		{
+			name: "headings and paragraphs",
+			html: `<h1>Deploys</h1><p>Ship on <strong>Tuesdays</strong>, not <em>Fridays</em>.</p><h3>Rollback</h3><p>See <a href="https://wiki.example.com/rollback">the runbook</a>.</p>`,
+			want: "# Deploys\n\nShip on **Tuesdays**, not *Fridays*.\n\n### Rollback\n\nSee [the runbook](https://wiki.example.com/rollback).",
+		},
+		{
+			name: "line breaks and inline code",
+			html: `<p>Run <code>make deploy</code><br/>then   wait</p>`,
+			want: "Run `make deploy`\nthen wait",
+		},
+		{
+			name: "table",
+			html: `<table><tbody><tr><th>Service</th><th>Owner</th></tr><tr><td><p>api</p></td><td>Ada | Grace</td></tr><tr><td>web</td></tr></tbody></table>`,
+			want: "| Service | Owner |\n| --- | --- |\n| api | Ada \\| Grace |\n| web |  |",
+		},
+		{
+			name: "nested lists",
+			html: `<ul><li>Build<ol><li>Test</li><li><p>Package</p></li></ol></li><li>Release</li></ul>`,
+			want: "- Build\n  1. Test\n  2. Package\n- Release",
+		},
+		{
+			name: "task list",
+			html: `<ac:task-list><ac:task><ac:task-status>complete</ac:task-status><ac:task-body>Tag the release</ac:task-body></ac:task><ac:task><ac:task-status>incomplete</ac:task-status><ac:task-body>Announce it</ac:task-body></ac:task></ac:task-list>`,
+			want: "- [x] Tag the release\n- [ ] Announce it",
+		},
+		{
+			name: "code macro",
+			html: `<ac:structured-macro ac:name="code"><ac:parameter ac:name="language">bash</ac:parameter><ac:plain-text-body><![CDATA[
+kubectl rollout undo deploy/api
+]]></ac:plain-text-body></ac:structured-macro>`,
+			want: "```bash\nkubectl rollout undo deploy/api\n```",
+		},
+		{
+			name: "status macro",
+			html: `<p>State: <ac:structured-macro ac:name="status"><ac:parameter ac:name="title">In progress</ac:parameter><ac:parameter ac:name="colour">Yellow</ac:parameter></ac:structured-macro></p>`,
+			want: "State: [IN PROGRESS]",
+		},
+		{
+			name: "info macro",
+			html: `<ac:structured-macro ac:name="warning"><ac:parameter ac:name="title">Freeze</ac:parameter><ac:rich-text-body><p>No deploys this week.</p><p>Ask Ada first.</p></ac:rich-text-body></ac:structured-macro>`,
+			want: "> **Warning:** **Freeze**\n>\n> No deploys this week.\n>\n> Ask Ada first.",
+		},
+		{
+			name: "navigation macros and links",
+			html: `<ac:structured-macro ac:name="toc"/><p>See <ac:link><ri:page ri:content-title="Rollback"/></ac:link> and JIRA <ac:structured-macro ac:name="jira"><ac:parameter ac:name="key">OPS-12</ac:parameter></ac:structured-macro></p>`,
+			want: "See [[Rollback]] and JIRA OPS-12",
+		},
+		{
+			name: "rendered view macros",
+			html: `<div class="confluence-information-macro confluence-information-macro-note"><div class="confluence-information-macro-body"><p>Staging is shared.</p></div></div><div class="code panel pdl"><div class="codeContent"><pre data-syntaxhighlighter-params="brush: go; gutter: false">fmt.Println("hi")</pre></div></div><p>Build is <span class="status-macro aui-lozenge">done</span></p>`,
+			want: "> **Note:** Staging is shared.\n\n```go\nfmt.Println(\"hi\")\n```\n\nBuild is [DONE]",
+		},
+		{
+			name: "unclosed tags",
+			html: `<p>Unclosed <b>bold</p><p>Next`,
+			want: "Unclosed **bold**\n\nNext",
+		},
+	}
+
+	for _, test := range tests {
+		if got := ConfluenceToMarkdown(test.html); got != test.want {
+			t.Errorf("%s:\ngot:\n%s\nwant:\n%s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestHTMLToMarkdownDropsPageChrome(t *testing.T) {
+	page := `<!DOCTYPE html><html><head><title>Runbook</title><style>p { color: red }</style></head>
+<body><script>track()</script><h2>Restarts</h2><p>Restart one node at a time.</p></body></html>`
+
+	want := "## Restarts\n\nRestart one node at a time."
+	if got := HTMLToMarkdown(page); got != want {
+		t.Errorf("HTMLToMarkdown = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,453 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// microsoftScopes covers Outlook mail, Teams chats and channels, and files in
+// OneDrive and SharePoint
+var microsoftScopes = []string{
+	"offline_access",
+	"User.Read",
+	"Mail.Read",
+	"Chat.Read",
+	"ChannelMessage.Read.All",
+	"Files.Read.All",
+	"Sites.Read.All",
+}
+
+// MicrosoftService talks to Microsoft Graph. One Azure AD token serves the
+// Outlook, Teams and SharePoint/OneDrive searches.
+type MicrosoftService struct {
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Tenant       string // "common", "organizations" or a tenant ID
+	LoginBaseURL string // Overridable for tests against a local stub
+	APIBaseURL   string // Overridable for tests against a local stub
+}
+
+type MicrosoftOAuthResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	Scope        string `json:"scope"`
+}
+
+// GraphSearchHit is a hit from the Microsoft Search API. Resource is decoded
+// according to the entity type that was searched.
+type GraphSearchHit struct {
+	HitID    string          `json:"hitId"`
+	Rank     int             `json:"rank"`
+	Summary  string          `json:"summary"`
+	Resource json.RawMessage `json:"resource"`
+}
+
+type graphSearchResponse struct {
+	Value []struct {
+		HitsContainers []struct {
+			Hits                 []GraphSearchHit `json:"hits"`
+			Total                int              `json:"total"`
+			MoreResultsAvailable bool             `json:"moreResultsAvailable"`
+		} `json:"hitsContainers"`
+	} `json:"value"`
+}
+
+type GraphEmailAddress struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+}
+
+type GraphRecipient struct {
+	EmailAddress GraphEmailAddress `json:"emailAddress"`
+}
+
+type GraphItemBody struct {
+	ContentType string `json:"contentType"` // "text" or "html"
+	Content     string `json:"content"`
+}
+
+// GraphMessage is an Outlook mail message
+type GraphMessage struct {
+	ID               string           `json:"id"`
+	Subject          string           `json:"subject"`
+	BodyPreview      string           `json:"bodyPreview"`
+	Body             GraphItemBody    `json:"body"`
+	From             GraphRecipient   `json:"from"`
+	ToRecipients     []GraphRecipient `json:"toRecipients"`
+	ReceivedDateTime string           `json:"receivedDateTime"`
+	WebLink          string           `json:"webLink"`
+	ConversationID   string           `json:"conversationId"`
+}
+
+// GraphChatMessage is a Teams message in a chat or a channel
+type GraphChatMessage struct {
+	ID              string        `json:"id"`
+	Subject         string        `json:"subject"`
+	Body            GraphItemBody `json:"body"`
+	CreatedDateTime string        `json:"createdDateTime"`
+	WebURL          string        `json:"webUrl"`
+	ChatID          string        `json:"chatId"`
+	ReplyToID       string        `json:"replyToId"`
+	ChannelIdentity *struct {
+		TeamID    string `json:"teamId"`
+		ChannelID string `json:"channelId"`
+	} `json:"channelIdentity"`
+	From struct {
+		User *struct {
+			DisplayName string `json:"displayName"`
+		} `json:"user"`
+		EmailAddress *GraphEmailAddress `json:"emailAddress"`
+	} `json:"from"`
+}
+
+// GraphDriveItem is a file in OneDrive or a SharePoint document library
+type GraphDriveItem struct {
+	ID                   string `json:"id"`
+	Name                 string `json:"name"`
+	WebURL               string `json:"webUrl"`
+	Size                 int64  `json:"size"`
+	LastModifiedDateTime string `json:"lastModifiedDateTime"`
+	File                 *struct {
+		MimeType string `json:"mimeType"`
+	} `json:"file"`
+	LastModifiedBy struct {
+		User struct {
+			DisplayName string `json:"displayName"`
+		} `json:"user"`
+	} `json:"lastModifiedBy"`
+	ParentReference struct {
+		DriveID string `json:"driveId"`
+		SiteID  string `json:"siteId"`
+	} `json:"parentReference"`
+}
+
+func NewMicrosoftService(clientID, clientSecret, redirectURL, tenant string) *MicrosoftService {
+	if tenant == "" {
+		tenant = "common"
+	}
+	return &MicrosoftService{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+		Tenant:       tenant,
+		LoginBaseURL: "https://login.microsoftonline.com",
+		APIBaseURL:   "https://graph.microsoft.com",
+	}
+}
+
+func (ms *MicrosoftService) GetAuthURL(state, codeChallenge string) string {
+	baseURL := fmt.Sprintf("%s/%s/oauth2/v2.0/authorize", ms.LoginBaseURL, url.PathEscape(ms.Tenant))
+	params := url.Values{}
+	params.Add("client_id", ms.ClientID)
+	params.Add("redirect_uri", ms.RedirectURL)
+	params.Add("response_type", "code")
+	params.Add("response_mode", "query")
+	params.Add("scope", strings.Join(microsoftScopes, " "))
+	params.Add("state", state)
+	if codeChallenge != "" {
+		params.Add("code_challenge", codeChallenge)
+		params.Add("code_challenge_method", "S256")
+	}
+
+	return fmt.Sprintf("%s?%s", baseURL, params.Encode())
+}
+
+func (ms *MicrosoftService) ExchangeCodeForToken(code, codeVerifier string) (*MicrosoftOAuthResponse, error) {
+	tokenURL := fmt.Sprintf("%s/%s/oauth2/v2.0/token", ms.LoginBaseURL, url.PathEscape(ms.Tenant))
+
+	data := url.Values{}
+	data.Set("client_id", ms.ClientID)
+	data.Set("client_secret", ms.ClientSecret)
+	data.Set("code", code)
+	if codeVerifier != "" {
+		data.Set("code_verifier", codeVerifier)
+	}
+	data.Set("grant_type", "authorization_code")
+	data.Set("redirect_uri", ms.RedirectURL)
+	data.Set("scope", strings.Join(microsoftScopes, " "))
+
+	req, err := http.NewRequest("POST", tokenURL, strings.NewReader(data.Encode()))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to exchange code for token: %s", resp.Status)
+	}
+
+	var tokenResponse MicrosoftOAuthResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResponse); err != nil {
+		return nil, err
+	}
+
+	return &tokenResponse, nil
+}
+
+// Search runs a Microsoft Search API query over one entity type, such as
+// "message", "chatMessage" or "driveItem"
+func (ms *MicrosoftService) Search(accessToken, entityType, query string, size int) ([]GraphSearchHit, error) {
+	if size == 0 {
+		size = 10
+	}
+
+	body := map[string]interface{}{
+		"requests": []map[string]interface{}{{
+			"entityTypes": []string{entityType},
+			"query":       map[string]string{"queryString": query},
+			"from":        0,
+			"size":        size,
+		}},
+	}
+
+	var searchResponse graphSearchResponse
+	if err := ms.do(accessToken, "POST", "/v1.0/search/query", body, &searchResponse); err != nil {
+		return nil, fmt.Errorf("failed to search Microsoft 365 %s: %v", entityType, err)
+	}
+
+	var hits []GraphSearchHit
+	for _, value := range searchResponse.Value {
+		for _, container := range value.HitsContainers {
+			hits = append(hits, container.Hits...)
+		}
+	}
+
+	return hits, nil
+}
+
+// SearchMessages searches Outlook mail
+func (ms *MicrosoftService) SearchMessages(accessToken, query string, size int) ([]GraphMessage, []string, error) {
+	hits, err := ms.Search(accessToken, "message", query, size)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var messages []GraphMessage
+	var summaries []string
+	for _, hit := range hits {
+		var message GraphMessage
+		if err := json.Unmarshal(hit.Resource, &message); err != nil {
+			continue
+		}
+		if message.ID == "" {
+			message.ID = hit.HitID
+		}
+		messages = append(messages, message)
+		summaries = append(summaries, ExtractPlainText(hit.Summary))
+	}
+
+	return messages, summaries, nil
+}
+
+// GetMessage returns a message with its body as plain text
+func (ms *MicrosoftService) GetMessage(accessToken, messageID string) (*GraphMessage, error) {
+	path := fmt.Sprintf("/v1.0/me/messages/%s?$select=%s", url.PathEscape(messageID),
+		url.QueryEscape("id,subject,body,bodyPreview,from,toRecipients,receivedDateTime,webLink,conversationId"))
+
+	var message GraphMessage
+	headers := map[string]string{"Prefer": `outlook.body-content-type="text"`}
+	if err := ms.doWithHeaders(accessToken, "GET", path, nil, headers, &message); err != nil {
+		return nil, fmt.Errorf("failed to get Outlook message: %v", err)
+	}
+
+	return &message, nil
+}
+
+// SearchChatMessages searches Teams chats and channel conversations
+func (ms *MicrosoftService) SearchChatMessages(accessToken, query string, size int) ([]GraphChatMessage, []string, error) {
+	hits, err := ms.Search(accessToken, "chatMessage", query, size)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var messages []GraphChatMessage
+	var summaries []string
+	for _, hit := range hits {
+		var message GraphChatMessage
+		if err := json.Unmarshal(hit.Resource, &message); err != nil {
+			continue
+		}
+		messages = append(messages, message)
+		summaries = append(summaries, ExtractPlainText(hit.Summary))
+	}
+
+	return messages, summaries, nil
+}
+
+// GetChatMessage fetches the full Teams message a search hit refers to
+func (ms *MicrosoftService) GetChatMessage(accessToken string, message GraphChatMessage) (*GraphChatMessage, error) {
+	var path string
+	switch {
+	case message.ChannelIdentity != nil && message.ChannelIdentity.TeamID != "":
+		path = fmt.Sprintf("/v1.0/teams/%s/channels/%s/messages/%s",
+			url.PathEscape(message.ChannelIdentity.TeamID), url.PathEscape(message.ChannelIdentity.ChannelID), url.PathEscape(message.ID))
+	case message.ChatID != "":
+		path = fmt.Sprintf("/v1.0/chats/%s/messages/%s", url.PathEscape(message.ChatID), url.PathEscape(message.ID))
+	default:
+		return nil, fmt.Errorf("Teams message %s has no chat or channel", message.ID)
+	}
+
+	var full GraphChatMessage
+	if err := ms.do(accessToken, "GET", path, nil, &full); err != nil {
+		return nil, fmt.Errorf("failed to get Teams message: %v", err)
+	}
+
+	// Single-message reads don't repeat where the message lives
+	full.ChatID = message.ChatID
+	full.ChannelIdentity = message.ChannelIdentity
+	return &full, nil
+}
+
+// ChatMessageSender returns the display name of a Teams message author
+func (ms *MicrosoftService) ChatMessageSender(message GraphChatMessage) string {
+	if message.From.User != nil && message.From.User.DisplayName != "" {
+		return message.From.User.DisplayName
+	}
+	if message.From.EmailAddress != nil {
+		return message.From.EmailAddress.Name
+	}
+	return "Unknown"
+}
+
+// SearchDriveItems searches files in OneDrive and SharePoint document libraries
+func (ms *MicrosoftService) SearchDriveItems(accessToken, query string, size int) ([]GraphDriveItem, []string, error) {
+	hits, err := ms.Search(accessToken, "driveItem", query, size)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var items []GraphDriveItem
+	var summaries []string
+	for _, hit := range hits {
+		var item GraphDriveItem
+		if err := json.Unmarshal(hit.Resource, &item); err != nil {
+			continue
+		}
+		if item.File == nil {
+			continue // Skip folders
+		}
+		items = append(items, item)
+		summaries = append(summaries, ExtractPlainText(hit.Summary))
+	}
+
+	return items, summaries, nil
+}
+
+// GetDriveItemText downloads a file and extracts its text
+func (ms *MicrosoftService) GetDriveItemText(accessToken string, item GraphDriveItem) (string, error) {
+	mimeType := ""
+	if item.File != nil {
+		mimeType = item.File.MimeType
+	}
+	if !IsSupportedDocument(item.Name, mimeType) {
+		return "", fmt.Errorf("unsupported file type: %s", item.Name)
+	}
+	if item.Size > maxDocumentBytes {
+		return "", fmt.Errorf("file too large: %s", item.Name)
+	}
+
+	fileURL := fmt.Sprintf("%s/v1.0/drives/%s/items/%s/content", ms.APIBaseURL,
+		url.PathEscape(item.ParentReference.DriveID), url.PathEscape(item.ID))
+
+	req, err := http.NewRequest("GET", fileURL, nil)
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Add("Authorization", "Bearer "+accessToken)
+
+	// Graph answers with a redirect to a pre-authenticated download URL
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to download file: %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxDocumentBytes+1))
+	if err != nil {
+		return "", err
+	}
+
+	return ExtractDocumentText(item.Name, mimeType, data)
+}
+
+// FormatDriveItemMetadata describes where a file lives and who changed it last
+func (ms *MicrosoftService) FormatDriveItemMetadata(item GraphDriveItem) string {
+	lines := []string{"File: " + item.Name}
+	if item.ParentReference.SiteID != "" {
+		lines = append(lines, "Location: SharePoint")
+	} else {
+		lines = append(lines, "Location: OneDrive")
+	}
+	if item.LastModifiedBy.User.DisplayName != "" {
+		lines = append(lines, "Modified by: "+item.LastModifiedBy.User.DisplayName)
+	}
+	if modified, err := time.Parse(time.RFC3339, item.LastModifiedDateTime); err == nil {
+		lines = append(lines, "Modified: "+modified.Format("2006-01-02 15:04"))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (ms *MicrosoftService) do(accessToken, method, path string, body interface{}, out interface{}) error {
+	return ms.doWithHeaders(accessToken, method, path, body, nil, out)
+}
+
+func (ms *MicrosoftService) doWithHeaders(accessToken, method, path string, body interface{}, headers map[string]string, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewBuffer(payload)
+	}
+
+	req, err := http.NewRequest(method, ms.APIBaseURL+path, reader)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Add("Authorization", "Bearer "+accessToken)
+	req.Header.Add("Accept", "application/json")
+	if body != nil {
+		req.Header.Add("Content-Type", "application/json")
+	}
+	for key, value := range headers {
+		req.Header.Add(key, value)
+	}
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
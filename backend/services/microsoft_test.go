@@ -0,0 +1,207 @@
+package services
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newTestMicrosoftService(t *testing.T, handler http.HandlerFunc) *MicrosoftService {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	ms := NewMicrosoftService("id", "secret", "", "contoso")
+	ms.APIBaseURL = server.URL
+	ms.LoginBaseURL = server.URL
+	return ms
+}
+
+// searchHits answers a Microsoft Search query after checking it asks for a
+// single entity type
+func searchHits(t *testing.T, w http.ResponseWriter, r *http.Request, entityType, hits string) {
+	var body struct {
+		Requests []struct {
+			EntityTypes []string          `json:"entityTypes"`
+			Query       map[string]string `json:"query"`
+			Size        int               `json:"size"`
+		} `json:"requests"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || len(body.Requests) != 1 {
+		t.Errorf("unexpected search request: %+v (%v)", body, err)
+		return
+	}
+	request := body.Requests[0]
+	if len(request.EntityTypes) != 1 || request.EntityTypes[0] != entityType || request.Size != 5 {
+		t.Errorf("search request = %+v, want %s with size 5", request, entityType)
+	}
+	w.Write([]byte(`{"value": [{"hitsContainers": [{"hits": ` + hits + `}]}]}`))
+}
+
+func TestOutlookSearchAndMessage(t *testing.T) {
+	ms := newTestMicrosoftService(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1.0/search/query":
+			searchHits(t, w, r, "message", `[{"hitId": "m1", "summary": "<c0>Budget</c0> for Q3", "resource": {
+				"subject": "Budget", "from": {"emailAddress": {"name": "Ada", "address": "ada@example.com"}}
+			}}]`)
+		case "/v1.0/me/messages/m1":
+			// HTML bodies would otherwise need converting before indexing
+			if prefer := r.Header.Get("Prefer"); prefer != `outlook.body-content-type="text"` {
+				t.Errorf("Prefer = %q", prefer)
+			}
+			w.Write([]byte(`{"id": "m1", "subject": "Budget", "body": {"contentType": "text", "content": "Q3 budget is approved"}}`))
+		default:
+			t.Errorf("unexpected request %s", r.URL)
+			http.NotFound(w, r)
+		}
+	})
+
+	messages, summaries, err := ms.SearchMessages("token", "budget", 5)
+	if err != nil {
+		t.Fatalf("SearchMessages: %v", err)
+	}
+	if len(messages) != 1 || messages[0].ID != "m1" || messages[0].From.EmailAddress.Address != "ada@example.com" {
+		t.Fatalf("messages = %+v, want the hit ID as the message ID", messages)
+	}
+	if summaries[0] != "Budget for Q3" {
+		t.Errorf("summary = %q, want the highlight markup removed", summaries[0])
+	}
+
+	message, err := ms.GetMessage("token", "m1")
+	if err != nil || message.Body.Content != "Q3 budget is approved" {
+		t.Errorf("GetMessage = %+v, %v", message, err)
+	}
+}
+
+// Chat and channel messages are read from different paths
+func TestTeamsChatAndChannelMessages(t *testing.T) {
+	ms := newTestMicrosoftService(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1.0/search/query":
+			searchHits(t, w, r, "chatMessage", `[
+				{"hitId": "c1", "resource": {"id": "c1", "chatId": "chat1", "from": {"user": {"displayName": "Grace"}}}},
+				{"hitId": "c2", "resource": {"id": "c2", "channelIdentity": {"teamId": "team1", "channelId": "chan1"}, "from": {"emailAddress": {"name": "Deploy bot"}}}}
+			]`)
+		case "/v1.0/chats/chat1/messages/c1":
+			w.Write([]byte(`{"id": "c1", "body": {"contentType": "html", "content": "<p>deploy today</p>"}}`))
+		case "/v1.0/teams/team1/channels/chan1/messages/c2":
+			w.Write([]byte(`{"id": "c2", "body": {"contentType": "text", "content": "deploy finished"}}`))
+		default:
+			t.Errorf("unexpected request %s", r.URL)
+			http.NotFound(w, r)
+		}
+	})
+
+	messages, _, err := ms.SearchChatMessages("token", "deploy", 5)
+	if err != nil {
+		t.Fatalf("SearchChatMessages: %v", err)
+	}
+	if len(messages) != 2 {
+		t.Fatalf("messages = %+v", messages)
+	}
+
+	wantSenders := []string{"Grace", "Deploy bot"}
+	wantBodies := []string{"<p>deploy today</p>", "deploy finished"}
+	for i, message := range messages {
+		if sender := ms.ChatMessageSender(message); sender != wantSenders[i] {
+			t.Errorf("sender of %s = %q, want %q", message.ID, sender, wantSenders[i])
+		}
+		full, err := ms.GetChatMessage("token", message)
+		if err != nil {
+			t.Errorf("GetChatMessage(%s): %v", message.ID, err)
+			continue
+		}
+		if full.Body.Content != wantBodies[i] {
+			t.Errorf("body of %s = %q", message.ID, full.Body.Content)
+		}
+		if full.ChatID != message.ChatID || full.ChannelIdentity != message.ChannelIdentity {
+			t.Errorf("GetChatMessage(%s) lost the chat or channel", message.ID)
+		}
+	}
+
+	if _, err := ms.GetChatMessage("token", GraphChatMessage{ID: "orphan"}); err == nil {
+		t.Errorf("a message without chat or channel was fetched")
+	}
+}
+
+func TestSharePointDriveItems(t *testing.T) {
+	ms := newTestMicrosoftService(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1.0/search/query":
+			searchHits(t, w, r, "driveItem", `[
+				{"hitId": "f1", "resource": {"id": "f1", "name": "plan.txt", "size": 12, "file": {"mimeType": "text/plain"},
+					"lastModifiedDateTime": "2024-03-04T09:30:00Z", "lastModifiedBy": {"user": {"displayName": "Ada"}},
+					"parentReference": {"driveId": "drive1", "siteId": "site1"}}},
+				{"hitId": "d1", "resource": {"id": "d1", "name": "Archive", "folder": {}}}
+			]`)
+		case "/v1.0/drives/drive1/items/f1/content":
+			http.Redirect(w, r, "/download/f1", http.StatusFound)
+		case "/download/f1":
+			w.Write([]byte("Ship in June"))
+		default:
+			t.Errorf("unexpected request %s", r.URL)
+			http.NotFound(w, r)
+		}
+	})
+
+	items, _, err := ms.SearchDriveItems("token", "plan", 5)
+	if err != nil {
+		t.Fatalf("SearchDriveItems: %v", err)
+	}
+	if len(items) != 1 || items[0].ID != "f1" {
+		t.Fatalf("items = %+v, want the folder skipped", items)
+	}
+
+	text, err := ms.GetDriveItemText("token", items[0])
+	if err != nil || !strings.Contains(text, "Ship in June") {
+		t.Errorf("GetDriveItemText = %q, %v, want the redirect followed", text, err)
+	}
+
+	want := "File: plan.txt\nLocation: SharePoint\nModified by: Ada\nModified: 2024-03-04 09:30"
+	if metadata := ms.FormatDriveItemMetadata(items[0]); metadata != want {
+		t.Errorf("FormatDriveItemMetadata = %q, want %q", metadata, want)
+	}
+
+	// Large files are refused before anything is downloaded
+	large := items[0]
+	large.Size = maxDocumentBytes + 1
+	if _, err := ms.GetDriveItemText("token", large); err == nil {
+		t.Errorf("a file over the size limit was read")
+	}
+}
+
+// Missing admin consent surfaces as an error rather than an empty search
+func TestGraphSearchForbidden(t *testing.T) {
+	ms := newTestMicrosoftService(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	})
+
+	if _, _, err := ms.SearchMessages("token", "budget", 5); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("err = %v, want the 403", err)
+	}
+}
+
+func TestMicrosoftExchangeCodeForToken(t *testing.T) {
+	ms := newTestMicrosoftService(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/contoso/oauth2/v2.0/token" {
+			t.Errorf("token request went to %s, want the configured tenant", r.URL.Path)
+			http.NotFound(w, r)
+			return
+		}
+		r.ParseForm()
+		if r.Form.Get("code") != "code1" || r.Form.Get("code_verifier") != "verifier" || r.Form.Get("grant_type") != "authorization_code" || !strings.Contains(r.Form.Get("scope"), "offline_access") {
+			t.Errorf("token request form = %v", r.Form)
+		}
+		w.Write([]byte(`{"access_token": "access", "refresh_token": "refresh", "expires_in": 3600}`))
+	})
+
+	token, err := ms.ExchangeCodeForToken("code1", "verifier")
+	if err != nil {
+		t.Fatalf("ExchangeCodeForToken: %v", err)
+	}
+	if token.AccessToken != "access" || token.RefreshToken != "refresh" || token.ExpiresIn != 3600 {
+		t.Errorf("token = %+v", token)
+	}
+}
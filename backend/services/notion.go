@@ -0,0 +1,520 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// notionVersion pins the Notion API version the types below are written against
+const notionVersion = "2022-06-28"
+
+// notionMaxDepth limits how deep nested blocks (toggles, lists, columns) are read
+const notionMaxDepth = 3
+
+type NotionService struct {
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	APIBaseURL   string // Overridable for tests against a local stub
+}
+
+type NotionOAuthResponse struct {
+	AccessToken   string `json:"access_token"`
+	TokenType     string `json:"token_type"`
+	BotID         string `json:"bot_id"`
+	WorkspaceID   string `json:"workspace_id"`
+	WorkspaceName string `json:"workspace_name"`
+}
+
+type NotionSearchResponse struct {
+	Results    []NotionObject `json:"results"`
+	HasMore    bool           `json:"has_more"`
+	NextCursor string         `json:"next_cursor"`
+}
+
+// NotionObject is a page or a database returned by search or a database query
+type NotionObject struct {
+	Object         string                    `json:"object"` // "page" or "database"
+	ID             string                    `json:"id"`
+	URL            string                    `json:"url"`
+	LastEditedTime string                    `json:"last_edited_time"`
+	Title          []NotionRichText          `json:"title"` // Databases only
+	Properties     map[string]NotionProperty `json:"properties"`
+}
+
+type NotionRichText struct {
+	PlainText string `json:"plain_text"`
+	Href      string `json:"href"`
+}
+
+type NotionProperty struct {
+	Type        string           `json:"type"`
+	Title       []NotionRichText `json:"title"`
+	RichText    []NotionRichText `json:"rich_text"`
+	Number      *float64         `json:"number"`
+	Checkbox    *bool            `json:"checkbox"`
+	URL         string           `json:"url"`
+	Email       string           `json:"email"`
+	PhoneNumber string           `json:"phone_number"`
+	Select      *struct {
+		Name string `json:"name"`
+	} `json:"select"`
+	Status *struct {
+		Name string `json:"name"`
+	} `json:"status"`
+	MultiSelect []struct {
+		Name string `json:"name"`
+	} `json:"multi_select"`
+	Date *struct {
+		Start string `json:"start"`
+		End   string `json:"end"`
+	} `json:"date"`
+	People []struct {
+		Name string `json:"name"`
+	} `json:"people"`
+}
+
+type NotionBlockList struct {
+	Results    []NotionBlock `json:"results"`
+	HasMore    bool          `json:"has_more"`
+	NextCursor string        `json:"next_cursor"`
+}
+
+// NotionBlock keeps the type-specific payload raw; notionBlockContent decodes the common shapes
+type NotionBlock struct {
+	ID          string                     `json:"id"`
+	Type        string                     `json:"type"`
+	HasChildren bool                       `json:"has_children"`
+	Payload     map[string]json.RawMessage `json:"-"`
+}
+
+func (b *NotionBlock) UnmarshalJSON(data []byte) error {
+	type plain NotionBlock
+	if err := json.Unmarshal(data, (*plain)(b)); err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &b.Payload)
+}
+
+// notionBlockContent is the shared shape of most block payloads
+type notionBlockContent struct {
+	RichText []NotionRichText   `json:"rich_text"`
+	Checked  bool               `json:"checked"`
+	Language string             `json:"language"`
+	Title    string             `json:"title"` // child_page and child_database
+	URL      string             `json:"url"`   // bookmark, embed, link_preview
+	Cells    [][]NotionRichText `json:"cells"` // table_row
+	Icon     *struct {
+		Emoji string `json:"emoji"`
+	} `json:"icon"`
+}
+
+func NewNotionService(clientID, clientSecret, redirectURL string) *NotionService {
+	return &NotionService{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+		APIBaseURL:   "https://api.notion.com",
+	}
+}
+
+func (ns *NotionService) GetAuthURL(state string) string {
+	baseURL := "https://api.notion.com/v1/oauth/authorize"
+	params := url.Values{}
+	params.Add("client_id", ns.ClientID)
+	params.Add("redirect_uri", ns.RedirectURL)
+	params.Add("response_type", "code")
+	params.Add("owner", "user")
+	params.Add("state", state)
+
+	return fmt.Sprintf("%s?%s", baseURL, params.Encode())
+}
+
+func (ns *NotionService) ExchangeCodeForToken(code string) (*NotionOAuthResponse, error) {
+	tokenURL := ns.APIBaseURL + "/v1/oauth/token"
+
+	payload, err := json.Marshal(map[string]string{
+		"grant_type":   "authorization_code",
+		"code":         code,
+		"redirect_uri": ns.RedirectURL,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", tokenURL, bytes.NewBuffer(payload))
+	if err != nil {
+		return nil, err
+	}
+
+	// Notion authenticates the client with HTTP basic auth
+	req.SetBasicAuth(ns.ClientID, ns.ClientSecret)
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Notion-Version", notionVersion)
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to exchange code for token: %s", resp.Status)
+	}
+
+	var tokenResponse NotionOAuthResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResponse); err != nil {
+		return nil, err
+	}
+
+	return &tokenResponse, nil
+}
+
+// Search finds pages and databases shared with the integration
+func (ns *NotionService) Search(accessToken, query string, pageSize int) (*NotionSearchResponse, error) {
+	if pageSize == 0 {
+		pageSize = 10
+	}
+
+	body := map[string]interface{}{
+		"query":     query,
+		"page_size": pageSize,
+		"sort": map[string]string{
+			"direction": "descending",
+			"timestamp": "last_edited_time",
+		},
+	}
+
+	var searchResults NotionSearchResponse
+	if err := ns.do(accessToken, "POST", "/v1/search", body, &searchResults); err != nil {
+		return nil, fmt.Errorf("failed to search Notion: %v", err)
+	}
+
+	return &searchResults, nil
+}
+
+// GetBlockChildren returns all direct children of a block or page
+func (ns *NotionService) GetBlockChildren(accessToken, blockID string) ([]NotionBlock, error) {
+	var blocks []NotionBlock
+	cursor := ""
+
+	for {
+		path := fmt.Sprintf("/v1/blocks/%s/children?page_size=100", url.PathEscape(blockID))
+		if cursor != "" {
+			path += "&start_cursor=" + url.QueryEscape(cursor)
+		}
+
+		var page NotionBlockList
+		if err := ns.do(accessToken, "GET", path, nil, &page); err != nil {
+			return nil, fmt.Errorf("failed to get Notion blocks: %v", err)
+		}
+		blocks = append(blocks, page.Results...)
+
+		if !page.HasMore || page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+
+	return blocks, nil
+}
+
+// QueryDatabase returns the first rows of a database
+func (ns *NotionService) QueryDatabase(accessToken, databaseID string, pageSize int) ([]NotionObject, error) {
+	if pageSize == 0 {
+		pageSize = 20
+	}
+
+	var rows NotionSearchResponse
+	path := fmt.Sprintf("/v1/databases/%s/query", url.PathEscape(databaseID))
+	if err := ns.do(accessToken, "POST", path, map[string]interface{}{"page_size": pageSize}, &rows); err != nil {
+		return nil, fmt.Errorf("failed to query Notion database: %v", err)
+	}
+
+	return rows.Results, nil
+}
+
+// ObjectTitle returns the title of a page or database
+func (ns *NotionService) ObjectTitle(object NotionObject) string {
+	if object.Object == "database" {
+		return richTextString(object.Title)
+	}
+	for _, property := range object.Properties {
+		if property.Type == "title" {
+			return richTextString(property.Title)
+		}
+	}
+	return "Untitled"
+}
+
+// RenderObject converts a page's block tree, or a database's rows, into
+// structured Markdown-like text
+func (ns *NotionService) RenderObject(accessToken string, object NotionObject) (string, error) {
+	if object.Object == "database" {
+		rows, err := ns.QueryDatabase(accessToken, object.ID, 20)
+		if err != nil {
+			return "", err
+		}
+		return ns.renderRows(rows), nil
+	}
+
+	var lines []string
+	if properties := ns.renderProperties(object); properties != "" {
+		lines = append(lines, properties, "")
+	}
+
+	body, err := ns.renderBlocks(accessToken, object.ID, 0)
+	if err != nil {
+		return "", err
+	}
+	lines = append(lines, body)
+
+	return strings.TrimSpace(strings.Join(lines, "\n")), nil
+}
+
+func (ns *NotionService) renderBlocks(accessToken, blockID string, depth int) (string, error) {
+	blocks, err := ns.GetBlockChildren(accessToken, blockID)
+	if err != nil {
+		return "", err
+	}
+
+	indent := strings.Repeat("  ", depth)
+	var lines []string
+	numbered := 0
+
+	for _, block := range blocks {
+		var content notionBlockContent
+		if raw, ok := block.Payload[block.Type]; ok {
+			json.Unmarshal(raw, &content)
+		}
+		text := richTextString(content.RichText)
+
+		if block.Type == "numbered_list_item" {
+			numbered++
+		} else {
+			numbered = 0
+		}
+
+		switch block.Type {
+		case "heading_1":
+			lines = append(lines, "", "# "+text)
+		case "heading_2":
+			lines = append(lines, "", "## "+text)
+		case "heading_3":
+			lines = append(lines, "", "### "+text)
+		case "paragraph":
+			lines = append(lines, indent+text)
+		case "bulleted_list_item", "toggle":
+			lines = append(lines, indent+"- "+text)
+		case "numbered_list_item":
+			lines = append(lines, fmt.Sprintf("%s%d. %s", indent, numbered, text))
+		case "to_do":
+			box := "[ ]"
+			if content.Checked {
+				box = "[x]"
+			}
+			lines = append(lines, indent+"- "+box+" "+text)
+		case "quote":
+			lines = append(lines, indent+"> "+text)
+		case "callout":
+			icon := ""
+			if content.Icon != nil && content.Icon.Emoji != "" {
+				icon = content.Icon.Emoji + " "
+			}
+			lines = append(lines, indent+"> "+icon+text)
+		case "code":
+			lines = append(lines, "```"+content.Language, text, "```")
+		case "divider":
+			lines = append(lines, "---")
+		case "bookmark", "embed", "link_preview":
+			lines = append(lines, indent+content.URL)
+		case "child_page":
+			lines = append(lines, indent+"[Sub-page: "+content.Title+"]")
+		case "child_database":
+			lines = append(lines, indent+"Database: "+content.Title)
+			if rows, err := ns.QueryDatabase(accessToken, block.ID, 20); err == nil {
+				lines = append(lines, ns.renderRows(rows))
+			}
+			continue
+		case "table":
+			table, err := ns.renderTable(accessToken, block.ID)
+			if err == nil {
+				lines = append(lines, table)
+			}
+			continue
+		default:
+			if text != "" {
+				lines = append(lines, indent+text)
+			}
+		}
+
+		// Toggles, list items and layout blocks keep their content in children
+		if block.HasChildren && depth < notionMaxDepth && block.Type != "child_page" {
+			children, err := ns.renderBlocks(accessToken, block.ID, depth+1)
+			if err == nil && children != "" {
+				lines = append(lines, children)
+			}
+		}
+	}
+
+	return strings.Trim(strings.Join(lines, "\n"), "\n"), nil
+}
+
+func (ns *NotionService) renderTable(accessToken, tableID string) (string, error) {
+	rows, err := ns.GetBlockChildren(accessToken, tableID)
+	if err != nil {
+		return "", err
+	}
+
+	var lines []string
+	for i, row := range rows {
+		var content notionBlockContent
+		if raw, ok := row.Payload["table_row"]; ok {
+			json.Unmarshal(raw, &content)
+		}
+
+		var cells []string
+		for _, cell := range content.Cells {
+			cells = append(cells, strings.ReplaceAll(richTextString(cell), "|", "\\|"))
+		}
+		lines = append(lines, "| "+strings.Join(cells, " | ")+" |")
+		if i == 0 {
+			lines = append(lines, "|"+strings.Repeat(" --- |", len(cells)))
+		}
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
+
+// renderRows lists database rows with their properties
+func (ns *NotionService) renderRows(rows []NotionObject) string {
+	var lines []string
+	for _, row := range rows {
+		line := "- " + ns.ObjectTitle(row)
+		if properties := ns.renderProperties(row); properties != "" {
+			line += " (" + strings.ReplaceAll(properties, "\n", "; ") + ")"
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// renderProperties formats every non-title property as "Name: value"
+func (ns *NotionService) renderProperties(object NotionObject) string {
+	var names []string
+	for name, property := range object.Properties {
+		if property.Type != "title" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var lines []string
+	for _, name := range names {
+		if value := propertyString(object.Properties[name]); value != "" {
+			lines = append(lines, name+": "+value)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func propertyString(property NotionProperty) string {
+	switch property.Type {
+	case "title":
+		return richTextString(property.Title)
+	case "rich_text":
+		return richTextString(property.RichText)
+	case "number":
+		if property.Number != nil {
+			return fmt.Sprintf("%g", *property.Number)
+		}
+	case "checkbox":
+		if property.Checkbox != nil {
+			return fmt.Sprintf("%t", *property.Checkbox)
+		}
+	case "select":
+		if property.Select != nil {
+			return property.Select.Name
+		}
+	case "status":
+		if property.Status != nil {
+			return property.Status.Name
+		}
+	case "multi_select":
+		var names []string
+		for _, option := range property.MultiSelect {
+			names = append(names, option.Name)
+		}
+		return strings.Join(names, ", ")
+	case "date":
+		if property.Date != nil {
+			if property.Date.End != "" {
+				return property.Date.Start + " to " + property.Date.End
+			}
+			return property.Date.Start
+		}
+	case "people":
+		var names []string
+		for _, person := range property.People {
+			names = append(names, person.Name)
+		}
+		return strings.Join(names, ", ")
+	case "url":
+		return property.URL
+	case "email":
+		return property.Email
+	case "phone_number":
+		return property.PhoneNumber
+	}
+	return ""
+}
+
+func richTextString(parts []NotionRichText) string {
+	var text strings.Builder
+	for _, part := range parts {
+		text.WriteString(part.PlainText)
+	}
+	return text.String()
+}
+
+func (ns *NotionService) do(accessToken, method, path string, body interface{}, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewBuffer(payload)
+	}
+
+	req, err := http.NewRequest(method, ns.APIBaseURL+path, reader)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Add("Authorization", "Bearer "+accessToken)
+	req.Header.Add("Notion-Version", notionVersion)
+	if body != nil {
+		req.Header.Add("Content-Type", "application/json")
+	}
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
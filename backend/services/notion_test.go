@@ -0,0 +1,155 @@
+package services
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newTestNotionService(t *testing.T, handler http.HandlerFunc) *NotionService {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if version := r.Header.Get("Notion-Version"); version != notionVersion {
+			t.Errorf("%s: Notion-Version = %q", r.URL.Path, version)
+		}
+		handler(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	ns := NewNotionService("id", "secret", "")
+	ns.APIBaseURL = server.URL
+	return ns
+}
+
+func TestNotionSearch(t *testing.T) {
+	ns := newTestNotionService(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/v1/search" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		}
+		var body struct {
+			Query    string            `json:"query"`
+			PageSize int               `json:"page_size"`
+			Sort     map[string]string `json:"sort"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		if body.Query != "roadmap" || body.PageSize != 5 || body.Sort["timestamp"] != "last_edited_time" {
+			t.Errorf("search body = %+v", body)
+		}
+		w.Write([]byte(`{"results": [
+			{"object": "page", "id": "p1", "properties": {"Name": {"type": "title", "title": [{"plain_text": "Q3 "}, {"plain_text": "roadmap"}]}}},
+			{"object": "database", "id": "db1", "title": [{"plain_text": "Launches"}]}
+		]}`))
+	})
+
+	results, err := ns.Search("token", "roadmap", 5)
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if len(results.Results) != 2 {
+		t.Fatalf("results = %+v", results.Results)
+	}
+	if title := ns.ObjectTitle(results.Results[0]); title != "Q3 roadmap" {
+		t.Errorf("page title = %q", title)
+	}
+	if title := ns.ObjectTitle(results.Results[1]); title != "Launches" {
+		t.Errorf("database title = %q", title)
+	}
+}
+
+// A page is read block by block, following cursors and nested children
+func TestNotionRenderPage(t *testing.T) {
+	ns := newTestNotionService(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/blocks/p1/children":
+			if r.URL.Query().Get("start_cursor") == "" {
+				w.Write([]byte(`{"has_more": true, "next_cursor": "c2", "results": [
+					{"id": "b1", "type": "heading_1", "heading_1": {"rich_text": [{"plain_text": "Plan"}]}},
+					{"id": "b2", "type": "numbered_list_item", "numbered_list_item": {"rich_text": [{"plain_text": "Design"}]}},
+					{"id": "b3", "type": "numbered_list_item", "numbered_list_item": {"rich_text": [{"plain_text": "Build"}]}},
+					{"id": "b4", "type": "to_do", "to_do": {"rich_text": [{"plain_text": "Review"}], "checked": true}}
+				]}`))
+				return
+			}
+			if cursor := r.URL.Query().Get("start_cursor"); cursor != "c2" {
+				t.Errorf("start_cursor = %q", cursor)
+			}
+			w.Write([]byte(`{"results": [
+				{"id": "t1", "type": "toggle", "has_children": true, "toggle": {"rich_text": [{"plain_text": "Risks"}]}},
+				{"id": "tbl", "type": "table", "has_children": true, "table": {}}
+			]}`))
+		case "/v1/blocks/t1/children":
+			w.Write([]byte(`{"results": [
+				{"id": "t2", "type": "paragraph", "paragraph": {"rich_text": [{"plain_text": "Hiring is slow"}]}}
+			]}`))
+		case "/v1/blocks/tbl/children":
+			w.Write([]byte(`{"results": [
+				{"id": "r1", "type": "table_row", "table_row": {"cells": [[{"plain_text": "Owner"}], [{"plain_text": "Date"}]]}},
+				{"id": "r2", "type": "table_row", "table_row": {"cells": [[{"plain_text": "Ada|Grace"}], [{"plain_text": "June"}]]}}
+			]}`))
+		default:
+			t.Errorf("unexpected request %s", r.URL)
+			http.NotFound(w, r)
+		}
+	})
+
+	page := NotionObject{Object: "page", ID: "p1", Properties: map[string]NotionProperty{
+		"Name": {Type: "title", Title: []NotionRichText{{PlainText: "Roadmap"}}},
+		"Status": {Type: "status", Status: &struct {
+			Name string `json:"name"`
+		}{Name: "In progress"}},
+	}}
+	text, err := ns.RenderObject("token", page)
+	if err != nil {
+		t.Fatalf("RenderObject: %v", err)
+	}
+
+	want := strings.Join([]string{
+		"Status: In progress",
+		"",
+		"# Plan",
+		"1. Design",
+		"2. Build",
+		"- [x] Review",
+		"- Risks",
+		"  Hiring is slow",
+		"| Owner | Date |",
+		"| --- | --- |",
+		`| Ada\|Grace | June |`,
+	}, "\n")
+	if text != want {
+		t.Errorf("RenderObject =\n%s\nwant\n%s", text, want)
+	}
+}
+
+func TestNotionRenderDatabase(t *testing.T) {
+	ns := newTestNotionService(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/v1/databases/db1/query" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		}
+		w.Write([]byte(`{"results": [{"object": "page", "id": "row1", "properties": {
+			"Name": {"type": "title", "title": [{"plain_text": "Mobile app"}]},
+			"Launch": {"type": "date", "date": {"start": "2024-06-01"}},
+			"Owners": {"type": "people", "people": [{"name": "Ada"}, {"name": "Grace"}]}
+		}}]}`))
+	})
+
+	text, err := ns.RenderObject("token", NotionObject{Object: "database", ID: "db1"})
+	if err != nil {
+		t.Fatalf("RenderObject: %v", err)
+	}
+	if want := "- Mobile app (Launch: 2024-06-01; Owners: Ada, Grace)"; text != want {
+		t.Errorf("RenderObject = %q, want %q", text, want)
+	}
+}
+
+// Pages the integration can't read fail instead of rendering as empty
+func TestNotionRenderUnshared(t *testing.T) {
+	ns := newTestNotionService(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+
+	if _, err := ns.RenderObject("token", NotionObject{Object: "page", ID: "p1"}); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("err = %v, want the 404", err)
+	}
+}
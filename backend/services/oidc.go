@@ -0,0 +1,533 @@
+package services
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// oidcDiscoveryTTL is how long the provider's metadata is reused
+	oidcDiscoveryTTL = 24 * time.Hour
+	// oidcKeysTTL is how long signing keys are reused before checking for rotation
+	oidcKeysTTL = time.Hour
+	// oidcKeysMinRefresh stops tokens with unknown key IDs from making us
+	// fetch the key set on every request
+	oidcKeysMinRefresh = time.Minute
+	// oidcClockSkew tolerates small clock differences with the provider
+	oidcClockSkew = time.Minute
+)
+
+// OIDCDiscovery is the provider metadata from /.well-known/openid-configuration
+type OIDCDiscovery struct {
+	Issuer                   string   `json:"issuer"`
+	AuthorizationEndpoint    string   `json:"authorization_endpoint"`
+	TokenEndpoint            string   `json:"token_endpoint"`
+	UserinfoEndpoint         string   `json:"userinfo_endpoint"`
+	JWKSURI                  string   `json:"jwks_uri"`
+	TokenEndpointAuthMethods []string `json:"token_endpoint_auth_methods_supported"`
+}
+
+// OIDCTokenResponse is the token endpoint's answer to an authorization code
+type OIDCTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	IDToken     string `json:"id_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// OIDCClaims are the validated claims of an ID token
+type OIDCClaims struct {
+	Issuer            string   `json:"iss"`
+	Subject           string   `json:"sub"`
+	Audience          audience `json:"aud"`
+	AuthorizedParty   string   `json:"azp"`
+	Expiry            float64  `json:"exp"`
+	IssuedAt          float64  `json:"iat"`
+	Nonce             string   `json:"nonce"`
+	Email             string   `json:"email"`
+	Name              string   `json:"name"`
+	PreferredUsername string   `json:"preferred_username"`
+
+	// Raw holds every claim, for provider-specific ones like groups
+	Raw map[string]interface{} `json:"-"`
+}
+
+// audience is the aud claim, which may be a string or an array of strings
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return errors.New("aud must be a string or an array of strings")
+	}
+	*a = multiple
+	return nil
+}
+
+// VerifiedEmail returns the email claim when the provider has verified the
+// address, and "" otherwise. Some providers send email_verified as a string.
+func VerifiedEmail(claims map[string]interface{}) string {
+	email, _ := claims["email"].(string)
+	switch verified := claims["email_verified"].(type) {
+	case bool:
+		if verified {
+			return email
+		}
+	case string:
+		if verified == "true" {
+			return email
+		}
+	}
+	return ""
+}
+
+// StringListClaim reads a claim holding a string or an array of strings, as
+// providers differ in how they send groups
+func StringListClaim(claims map[string]interface{}, name string) []string {
+	switch value := claims[name].(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		var values []string
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// OIDCService signs users in with an OpenID Connect provider using the
+// authorization code flow with PKCE. The provider's metadata and signing keys
+// are discovered from the issuer and cached.
+type OIDCService struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	Client       *http.Client
+
+	mu           sync.Mutex
+	discovery    *OIDCDiscovery
+	discoveredAt time.Time
+	keys         map[string]*rsa.PublicKey
+	keysFetched  time.Time
+}
+
+func NewOIDCService(issuer, clientID, clientSecret, redirectURL string, scopes []string) *OIDCService {
+	return &OIDCService{
+		Issuer:       strings.TrimSuffix(issuer, "/"),
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+		Scopes:       scopes,
+		Client:       &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Discover returns the provider's metadata, fetching it when not cached
+func (oc *OIDCService) Discover() (*OIDCDiscovery, error) {
+	oc.mu.Lock()
+	defer oc.mu.Unlock()
+	return oc.discover()
+}
+
+// discover fetches the provider metadata unless cached. Callers hold mu.
+func (oc *OIDCService) discover() (*OIDCDiscovery, error) {
+	if oc.discovery != nil && time.Since(oc.discoveredAt) < oidcDiscoveryTTL {
+		return oc.discovery, nil
+	}
+
+	var discovery OIDCDiscovery
+	if err := oc.getJSON(oc.Issuer+"/.well-known/openid-configuration", "", &discovery); err != nil {
+		return nil, fmt.Errorf("failed to discover OIDC provider: %w", err)
+	}
+	// The metadata must be about the issuer we trust, or a compromised
+	// document could point us at someone else's keys (OIDC Discovery 4.3)
+	if strings.TrimSuffix(discovery.Issuer, "/") != oc.Issuer {
+		return nil, fmt.Errorf("OIDC discovery returned issuer %q, expected %q", discovery.Issuer, oc.Issuer)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, errors.New("OIDC discovery document is missing endpoints")
+	}
+
+	oc.discovery = &discovery
+	oc.discoveredAt = time.Now()
+	return oc.discovery, nil
+}
+
+// GetAuthURL returns the provider's login URL. nonce is echoed in the ID
+// token and codeChallenge is the S256 PKCE challenge.
+func (oc *OIDCService) GetAuthURL(state, nonce, codeChallenge string) (string, error) {
+	discovery, err := oc.Discover()
+	if err != nil {
+		return "", err
+	}
+
+	params := url.Values{}
+	params.Add("client_id", oc.ClientID)
+	params.Add("redirect_uri", oc.RedirectURL)
+	params.Add("response_type", "code")
+	params.Add("scope", strings.Join(oc.Scopes, " "))
+	params.Add("state", state)
+	params.Add("nonce", nonce)
+	params.Add("code_challenge", codeChallenge)
+	params.Add("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return discovery.AuthorizationEndpoint + separator + params.Encode(), nil
+}
+
+// ExchangeCodeForToken trades the authorization code for tokens
+func (oc *OIDCService) ExchangeCodeForToken(code, codeVerifier string) (*OIDCTokenResponse, error) {
+	discovery, err := oc.Discover()
+	if err != nil {
+		return nil, err
+	}
+
+	data := url.Values{}
+	data.Set("grant_type", "authorization_code")
+	data.Set("code", code)
+	data.Set("redirect_uri", oc.RedirectURL)
+	data.Set("code_verifier", codeVerifier)
+
+	// client_secret_basic is the default; use client_secret_post only when
+	// that's all the provider supports
+	useBasic := true
+	if len(discovery.TokenEndpointAuthMethods) > 0 {
+		useBasic = containsString(discovery.TokenEndpointAuthMethods, "client_secret_basic") ||
+			!containsString(discovery.TokenEndpointAuthMethods, "client_secret_post")
+	}
+	if !useBasic {
+		data.Set("client_id", oc.ClientID)
+		data.Set("client_secret", oc.ClientSecret)
+	}
+
+	req, err := http.NewRequest("POST", discovery.TokenEndpoint, strings.NewReader(data.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if useBasic {
+		req.SetBasicAuth(url.QueryEscape(oc.ClientID), url.QueryEscape(oc.ClientSecret))
+	}
+
+	resp, err := oc.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to exchange code for token: %w", newStatusError(resp))
+	}
+
+	var tokenResponse OIDCTokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResponse); err != nil {
+		return nil, err
+	}
+	if tokenResponse.IDToken == "" {
+		return nil, errors.New("token response has no id_token; is the openid scope requested?")
+	}
+	return &tokenResponse, nil
+}
+
+// VerifyIDToken checks an ID token's RS256 signature against the provider's
+// keys and validates its issuer, audience, lifetime and nonce
+func (oc *OIDCService) VerifyIDToken(rawIDToken, nonce string) (*OIDCClaims, error) {
+	parts := strings.Split(rawIDToken, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed ID token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed ID token header: %v", err)
+	}
+	// Only accept the algorithm we verify with, so "none" or an HMAC keyed
+	// with the public key can't slip through
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("unsupported ID token algorithm %q", header.Alg)
+	}
+
+	key, err := oc.signingKey(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed ID token signature")
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return nil, errors.New("invalid ID token signature")
+	}
+
+	var claims OIDCClaims
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed ID token claims: %v", err)
+	}
+	if err := decodeJWTPart(parts[1], &claims.Raw); err != nil {
+		return nil, fmt.Errorf("malformed ID token claims: %v", err)
+	}
+
+	now := time.Now()
+	switch {
+	case strings.TrimSuffix(claims.Issuer, "/") != oc.Issuer:
+		return nil, fmt.Errorf("ID token issued by %q, expected %q", claims.Issuer, oc.Issuer)
+	case !containsString(claims.Audience, oc.ClientID):
+		return nil, errors.New("ID token is for another client")
+	case len(claims.Audience) > 1 && claims.AuthorizedParty != oc.ClientID:
+		return nil, errors.New("ID token was not issued to this client")
+	case claims.Subject == "":
+		return nil, errors.New("ID token has no subject")
+	case claims.Expiry == 0 || now.After(unixTime(claims.Expiry).Add(oidcClockSkew)):
+		return nil, errors.New("ID token expired")
+	case unixTime(claims.IssuedAt).After(now.Add(oidcClockSkew)):
+		return nil, errors.New("ID token issued in the future")
+	case nonce == "" || subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1:
+		return nil, errors.New("ID token nonce doesn't match")
+	}
+
+	return &claims, nil
+}
+
+// UserInfo fetches the signed-in user's claims from the userinfo endpoint,
+// for providers that leave groups out of the ID token
+func (oc *OIDCService) UserInfo(accessToken string) (map[string]interface{}, error) {
+	discovery, err := oc.Discover()
+	if err != nil {
+		return nil, err
+	}
+	if discovery.UserinfoEndpoint == "" {
+		return nil, errors.New("OIDC provider has no userinfo endpoint")
+	}
+
+	var claims map[string]interface{}
+	if err := oc.getJSON(discovery.UserinfoEndpoint, accessToken, &claims); err != nil {
+		return nil, fmt.Errorf("failed to get user info: %w", err)
+	}
+	return claims, nil
+}
+
+// signingKey returns the provider's RSA key with the given ID, refetching the
+// key set when the key is unknown, as it is after a rotation
+func (oc *OIDCService) signingKey(kid string) (*rsa.PublicKey, error) {
+	oc.mu.Lock()
+	defer oc.mu.Unlock()
+
+	if key := oc.cachedKey(kid); key != nil && time.Since(oc.keysFetched) < oidcKeysTTL {
+		return key, nil
+	}
+	if time.Since(oc.keysFetched) < oidcKeysMinRefresh {
+		if key := oc.cachedKey(kid); key != nil {
+			return key, nil
+		}
+		return nil, fmt.Errorf("unknown ID token signing key %q", kid)
+	}
+
+	discovery, err := oc.discover()
+	if err != nil {
+		return nil, err
+	}
+	var keySet struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := oc.getJSON(discovery.JWKSURI, "", &keySet); err != nil {
+		return nil, fmt.Errorf("failed to fetch OIDC signing keys: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, jwk := range keySet.Keys {
+		if jwk.Kty != "RSA" || (jwk.Use != "" && jwk.Use != "sig") {
+			continue
+		}
+		key, err := parseRSAJWK(jwk)
+		if err != nil {
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	oc.keys = keys
+	oc.keysFetched = time.Now()
+
+	if key := oc.cachedKey(kid); key != nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown ID token signing key %q", kid)
+}
+
+// cachedKey looks a key up by ID; a token without one may use the only key.
+// Callers hold mu.
+func (oc *OIDCService) cachedKey(kid string) *rsa.PublicKey {
+	if key, ok := oc.keys[kid]; ok {
+		return key
+	}
+	if kid == "" && len(oc.keys) == 1 {
+		for _, key := range oc.keys {
+			return key
+		}
+	}
+	return nil
+}
+
+func (oc *OIDCService) getJSON(endpoint, accessToken string, v interface{}) error {
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+
+	resp, err := oc.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return newStatusError(resp)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func parseRSAJWK(jwk jsonWebKey) (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+	if err != nil {
+		return nil, err
+	}
+	exponent := new(big.Int).SetBytes(e)
+	if !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > 1<<31-1 {
+		return nil, errors.New("unsupported RSA exponent")
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(part, "="))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func unixTime(seconds float64) time.Time {
+	return time.Unix(int64(seconds), 0)
+}
+
+// OIDCGroupMapping turns the groups an identity provider reports into the
+// user's tenant and role
+type OIDCGroupMapping struct {
+	AllowedGroups []string          // Groups allowed to sign in; empty allows everyone
+	AdminGroups   []string          // Groups whose members are admins
+	TenantGroups  map[string]string // Group -> tenant; empty puts everyone in DefaultTenant
+	// UnmappedTenant is the tenant of users in no tenant group when
+	// TenantGroups is set; empty refuses them
+	UnmappedTenant string
+}
+
+// Resolve returns the tenant and role for a user's groups, or an error when
+// the user may not sign in
+func (m OIDCGroupMapping) Resolve(groups []string) (tenant, role string, err error) {
+	if len(m.AllowedGroups) > 0 && !intersects(groups, m.AllowedGroups) {
+		return "", "", errors.New("you are not in a group that may use this app")
+	}
+
+	tenants := map[string]bool{}
+	for _, group := range groups {
+		if tenant, ok := m.TenantGroups[group]; ok {
+			tenants[tenant] = true
+		}
+	}
+	switch len(tenants) {
+	case 0:
+		// Falling back to DefaultTenant, whose admins manage every tenant,
+		// would let anyone the provider signs in into it
+		switch {
+		case len(m.TenantGroups) == 0:
+			tenant = DefaultTenant
+		case m.UnmappedTenant != "":
+			tenant = m.UnmappedTenant
+		default:
+			return "", "", errors.New("your groups don't belong to any tenant")
+		}
+	case 1:
+		for t := range tenants {
+			tenant = t
+		}
+	default:
+		names := make([]string, 0, len(tenants))
+		for t := range tenants {
+			names = append(names, t)
+		}
+		sort.Strings(names)
+		return "", "", fmt.Errorf("your groups map to several tenants (%s)", strings.Join(names, ", "))
+	}
+
+	role = RoleMember
+	if intersects(groups, m.AdminGroups) {
+		role = RoleAdmin
+	}
+	return tenant, role, nil
+}
+
+func intersects(a, b []string) bool {
+	for _, value := range a {
+		if containsString(b, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
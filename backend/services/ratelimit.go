@@ -0,0 +1,188 @@
+package services
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// RateLimit is a token bucket: a client may make Burst requests at once,
+// and the bucket refills at Rate requests per second
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// RateLimitStore keeps the buckets and usage counters behind RateLimiter.
+// The in-memory store suits a single backend; several backends need a shared
+// one (Redis, a database, ...) implementing the same methods atomically.
+type RateLimitStore interface {
+	// Take removes a token from key's bucket. When the bucket is empty it
+	// returns false and how long until a token is available.
+	Take(key string, limit RateLimit, now time.Time) (bool, time.Duration)
+	// AddUsage adds amount to key's usage in period and returns the new total.
+	// Usage from earlier periods is dropped.
+	AddUsage(key, period string, amount int) int
+	// Usage returns key's usage in period
+	Usage(key, period string) int
+}
+
+// memoryPruneInterval is how often the in-memory store forgets full buckets
+const memoryPruneInterval = time.Minute
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+type periodUsage struct {
+	period string
+	amount int
+}
+
+// MemoryRateLimitStore keeps rate limits in the backend's memory
+type MemoryRateLimitStore struct {
+	mu          sync.Mutex
+	buckets     map[string]*tokenBucket
+	usage       map[string]*periodUsage
+	usagePeriod string // Latest period usage was added in
+	lastPruned  time.Time
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{
+		buckets: make(map[string]*tokenBucket),
+		usage:   make(map[string]*periodUsage),
+	}
+}
+
+func (ms *MemoryRateLimitStore) Take(key string, limit RateLimit, now time.Time) (bool, time.Duration) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if now.Sub(ms.lastPruned) >= memoryPruneInterval {
+		ms.prune(limit, now)
+	}
+
+	bucket, ok := ms.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(limit.Burst), updated: now}
+		ms.buckets[key] = bucket
+	}
+	bucket.tokens = refill(bucket, limit, now)
+	bucket.updated = now
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+	wait := (1 - bucket.tokens) / limit.Rate
+	return false, time.Duration(math.Ceil(wait * float64(time.Second)))
+}
+
+func (ms *MemoryRateLimitStore) AddUsage(key, period string, amount int) int {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	// Once a new period starts the earlier ones' counters are never read again
+	if period != ms.usagePeriod {
+		ms.pruneUsage(period)
+	}
+
+	usage, ok := ms.usage[key]
+	if !ok || usage.period != period {
+		usage = &periodUsage{period: period}
+		ms.usage[key] = usage
+	}
+	usage.amount += amount
+	return usage.amount
+}
+
+func (ms *MemoryRateLimitStore) Usage(key, period string) int {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if usage, ok := ms.usage[key]; ok && usage.period == period {
+		return usage.amount
+	}
+	return 0
+}
+
+// prune drops buckets that have refilled completely, which are no different
+// from new ones. Callers hold mu.
+func (ms *MemoryRateLimitStore) prune(limit RateLimit, now time.Time) {
+	ms.lastPruned = now
+	for key, bucket := range ms.buckets {
+		if refill(bucket, limit, now) >= float64(limit.Burst) {
+			delete(ms.buckets, key)
+		}
+	}
+}
+
+// pruneUsage drops the usage of periods other than period. Callers hold mu.
+func (ms *MemoryRateLimitStore) pruneUsage(period string) {
+	ms.usagePeriod = period
+	for key, usage := range ms.usage {
+		if usage.period != period {
+			delete(ms.usage, key)
+		}
+	}
+}
+
+func refill(bucket *tokenBucket, limit RateLimit, now time.Time) float64 {
+	tokens := bucket.tokens + now.Sub(bucket.updated).Seconds()*limit.Rate
+	return math.Min(tokens, float64(limit.Burst))
+}
+
+// RateLimiter limits how often clients may make expensive requests and how
+// many OpenAI tokens they may use per day. Clients are identified by keys
+// such as a user or API key ID.
+type RateLimiter struct {
+	store       RateLimitStore
+	limit       RateLimit
+	dailyTokens int
+}
+
+// NewRateLimiter creates a limiter. A zero rate turns off request limiting and
+// zero dailyTokens turns off the token quota.
+func NewRateLimiter(store RateLimitStore, limit RateLimit, dailyTokens int) *RateLimiter {
+	if limit.Burst < 1 {
+		limit.Burst = 1
+	}
+	return &RateLimiter{store: store, limit: limit, dailyTokens: dailyTokens}
+}
+
+// Allow counts a request by key, returning false and how long to wait when
+// key is over the limit
+func (rl *RateLimiter) Allow(key string) (bool, time.Duration) {
+	if rl.limit.Rate <= 0 {
+		return true, 0
+	}
+	return rl.store.Take(key, rl.limit, time.Now())
+}
+
+// QuotaExceeded reports whether key has used up today's tokens, and how long
+// until the quota resets at midnight UTC
+func (rl *RateLimiter) QuotaExceeded(key string) (bool, time.Duration) {
+	if rl.dailyTokens <= 0 {
+		return false, 0
+	}
+	now := time.Now().UTC()
+	if rl.store.Usage(key, quotaPeriod(now)) < rl.dailyTokens {
+		return false, 0
+	}
+	midnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+	return true, midnight.Sub(now)
+}
+
+// RecordUsage counts the tokens of an OpenAI call against key's quota
+func (rl *RateLimiter) RecordUsage(key string, usage OpenAIUsage) {
+	if rl.dailyTokens <= 0 || usage.TotalTokens <= 0 {
+		return
+	}
+	rl.store.AddUsage(key, quotaPeriod(time.Now().UTC()), usage.TotalTokens)
+}
+
+func quotaPeriod(now time.Time) string {
+	return now.Format("2006-01-02")
+}
@@ -0,0 +1,49 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryRateLimitStoreTake(t *testing.T) {
+	store := NewMemoryRateLimitStore()
+	limit := RateLimit{Rate: 1, Burst: 2}
+	now := time.Date(2024, 3, 4, 9, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := store.Take("ip:a", limit, now); !ok {
+			t.Fatalf("request %d within the burst was refused", i+1)
+		}
+	}
+	ok, wait := store.Take("ip:a", limit, now)
+	if ok || wait != time.Second {
+		t.Errorf("Take over the burst = %t, %v; want refused for 1s", ok, wait)
+	}
+	if ok, _ := store.Take("ip:b", limit, now); !ok {
+		t.Errorf("another client was refused")
+	}
+	if ok, _ := store.Take("ip:a", limit, now.Add(time.Second)); !ok {
+		t.Errorf("request after the refill was refused")
+	}
+}
+
+func TestMemoryRateLimitStorePrunesOldUsage(t *testing.T) {
+	store := NewMemoryRateLimitStore()
+	store.AddUsage("user:a", "2024-03-04", 100)
+	store.AddUsage("user:b", "2024-03-04", 50)
+	if total := store.AddUsage("user:a", "2024-03-04", 20); total != 120 {
+		t.Errorf("total = %d, want 120", total)
+	}
+
+	// The next day's first usage drops every counter of the day before
+	store.AddUsage("user:a", "2024-03-05", 10)
+	if n := len(store.usage); n != 1 {
+		t.Errorf("%d usage counters kept, want only today's", n)
+	}
+	if used := store.Usage("user:a", "2024-03-05"); used != 10 {
+		t.Errorf("today's usage = %d, want 10", used)
+	}
+	if used := store.Usage("user:b", "2024-03-04"); used != 0 {
+		t.Errorf("yesterday's usage = %d, want it dropped", used)
+	}
+}
@@ -0,0 +1,506 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Detector names, as used in REDACTION_DETECTORS
+const (
+	DetectorSecrets     = "secrets"      // Known key formats, private keys and "password: ..." values
+	DetectorHighEntropy = "high_entropy" // Random-looking strings such as unknown API keys
+	DetectorEmails      = "emails"
+	DetectorPhones      = "phones"
+	DetectorCreditCards = "credit_cards"
+	DetectorIBANs       = "ibans"
+)
+
+// Detector finds sensitive values in text
+type Detector interface {
+	// Kind names the values in placeholders, e.g. "EMAIL" for [EMAIL_1]
+	Kind() string
+	// Find returns the byte ranges of the values found in text
+	Find(text string) [][2]int
+}
+
+// regexDetector reports the matches of pattern, or of its first group when it
+// has one, that valid accepts
+type regexDetector struct {
+	kind    string
+	pattern *regexp.Regexp
+	valid   func(value string) bool
+}
+
+func (d *regexDetector) Kind() string { return d.kind }
+
+func (d *regexDetector) Find(text string) [][2]int {
+	var found [][2]int
+	for _, match := range d.pattern.FindAllStringSubmatchIndex(text, -1) {
+		start, end := match[0], match[1]
+		if len(match) > 2 && match[2] >= 0 {
+			start, end = match[2], match[3]
+		}
+		if d.valid == nil || d.valid(text[start:end]) {
+			found = append(found, [2]int{start, end})
+		}
+	}
+	return found
+}
+
+// entropyDetector reports long tokens whose characters are too random to be
+// words or identifiers, which catches keys in formats nobody listed
+type entropyDetector struct {
+	minLength  int
+	minEntropy float64 // Bits per character
+}
+
+// entropyTokenPattern leaves out "/" so URL paths aren't taken for keys
+var entropyTokenPattern = regexp.MustCompile(`[A-Za-z0-9+=_\-]+`)
+
+func (d *entropyDetector) Kind() string { return "SECRET" }
+
+func (d *entropyDetector) Find(text string) [][2]int {
+	var found [][2]int
+	for _, match := range entropyTokenPattern.FindAllStringIndex(text, -1) {
+		token := text[match[0]:match[1]]
+		if len(token) >= d.minLength && hasLettersAndDigits(token) && shannonEntropy(token) >= d.minEntropy {
+			found = append(found, [2]int{match[0], match[1]})
+		}
+	}
+	return found
+}
+
+var secretPatterns = []string{
+	`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`,
+	`\bAKIA[0-9A-Z]{16}\b`,                                            // AWS access key ID
+	`\bgh[pousr]_[A-Za-z0-9]{36,}\b`,                                  // GitHub token
+	`\bgithub_pat_[A-Za-z0-9_]{22,}\b`,                                // GitHub fine-grained token
+	`\bxox[abposr]-[A-Za-z0-9-]{10,}\b`,                               // Slack token
+	`\bsk-[A-Za-z0-9_-]{20,}\b`,                                       // OpenAI and similar keys
+	`\bAIza[0-9A-Za-z_-]{35}\b`,                                       // Google API key
+	`\b(?:sk|rk)_live_[0-9A-Za-z]{16,}\b`,                             // Stripe key
+	`\brck_[0-9a-f]{16}[A-Za-z0-9_-]{20,}`,                            // The chatbot's own API keys
+	`\beyJ[A-Za-z0-9_-]{8,}\.eyJ[A-Za-z0-9_-]{8,}\.[A-Za-z0-9_-]{8,}`, // JWT
+}
+
+// credentialValuePattern finds the value in "password: hunter2",
+// "api_key=..." and the like
+var credentialValuePattern = regexp.MustCompile(`(?i)\b(?:password|passwd|pwd|secret|api[_-]?key|access[_-]?token|auth[_-]?token|client[_-]?secret)\b["']?\s*[:=]\s*["']?([^\s"',;]{4,})`)
+
+// NewDetector returns the named detector
+func NewDetector(name string) (Detector, error) {
+	switch name {
+	case DetectorSecrets:
+		return &multiDetector{kind: "SECRET", detectors: []Detector{
+			&regexDetector{kind: "SECRET", pattern: regexp.MustCompile(strings.Join(secretPatterns, "|"))},
+			&regexDetector{kind: "SECRET", pattern: credentialValuePattern},
+		}}, nil
+	case DetectorHighEntropy:
+		return &entropyDetector{minLength: 24, minEntropy: 4.0}, nil
+	case DetectorEmails:
+		return &regexDetector{kind: "EMAIL", pattern: regexp.MustCompile(`\b[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}\b`)}, nil
+	case DetectorPhones:
+		return phoneDetector{}, nil
+	case DetectorCreditCards:
+		return &regexDetector{
+			kind:    "CREDIT_CARD",
+			pattern: regexp.MustCompile(`\b(?:\d[ \-]?){12,18}\d\b`),
+			valid:   validCardNumber,
+		}, nil
+	case DetectorIBANs:
+		return &regexDetector{
+			kind:    "IBAN",
+			pattern: regexp.MustCompile(`\b[A-Z]{2}\d{2}(?: ?[A-Z0-9]){11,30}\b`),
+			valid:   validIBAN,
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown redaction detector %q", name)
+}
+
+// DetectorNames lists every detector, in the order they run
+var DetectorNames = []string{DetectorSecrets, DetectorHighEntropy, DetectorEmails, DetectorCreditCards, DetectorIBANs, DetectorPhones}
+
+// phonePattern only takes numbers written the way phone numbers are: with a
+// country code, with the area code in parentheses, grouped as 555-123-4567
+// or as a national number starting with 0
+var phonePattern = regexp.MustCompile(`\+\d{1,3}(?:[ .\-]?\(\d{1,4}\))?(?:[ .\-]?\d{2,5}){2,5}` +
+	`|\(\d{2,5}\)[ .\-]?\d{3,4}[ .\-]?\d{3,4}` +
+	`|\b\d{3}[ .\-]\d{3}[ .\-]\d{4}` +
+	`|\b0\d{1,4}[ /\-]\d{2,4}(?:[ \-]\d{2,4}){0,3}`)
+
+// phoneDetector reports phone numbers, leaving alone numbers that run on
+// into a longer one, such as IP addresses, dates, times and versions
+type phoneDetector struct{}
+
+func (phoneDetector) Kind() string { return "PHONE" }
+
+func (phoneDetector) Find(text string) [][2]int {
+	var found [][2]int
+	for _, match := range phonePattern.FindAllStringIndex(text, -1) {
+		start, end := match[0], match[1]
+		value := text[start:end]
+		if !between(countDigits(value), 9, 15) || !consistentSeparators(value) ||
+			runsOn(text[:start], true) || runsOn(text[end:], false) {
+			continue
+		}
+		found = append(found, [2]int{start, end})
+	}
+	return found
+}
+
+// runsOn reports whether the text next to a match continues the number: with
+// a letter or digit, with a separator and a digit as in 10.0.0.1 or 12:30, or
+// with a dot, dash or slash and a letter as in PR-555-123-4567
+func runsOn(text string, before bool) bool {
+	next := func(i int) byte {
+		if before {
+			i = len(text) - 1 - i
+		}
+		if i < 0 || i >= len(text) {
+			return 0
+		}
+		return text[i]
+	}
+	isDigit := func(c byte) bool { return c >= '0' && c <= '9' }
+	isWord := func(c byte) bool { return isDigit(c) || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c == '_' }
+	switch c := next(0); {
+	case isWord(c):
+		return true
+	case c == ':':
+		return isDigit(next(1))
+	case c == '.' || c == '-' || c == '/':
+		return isWord(next(1))
+	}
+	return false
+}
+
+// consistentSeparators rejects numbers mixing dots with dashes or slashes
+// between their digit groups, as dates and versions do
+func consistentSeparators(value string) bool {
+	return !(strings.Contains(value, ".") && strings.ContainsAny(value, "-/"))
+}
+
+type multiDetector struct {
+	kind      string
+	detectors []Detector
+}
+
+func (d *multiDetector) Kind() string { return d.kind }
+
+func (d *multiDetector) Find(text string) [][2]int {
+	var found [][2]int
+	for _, detector := range d.detectors {
+		found = append(found, detector.Find(text)...)
+	}
+	return found
+}
+
+// Redactor replaces sensitive values in content before it goes to the LLM.
+// Each source can use its own detectors, e.g. only secrets for GitHub code.
+type Redactor struct {
+	defaults []Detector
+	sources  map[string][]Detector
+	restore  bool
+}
+
+// NewRedactor creates a redactor running defaults on every source except those
+// in sources, which map a source to its own detector names. "none" turns
+// redaction off. restore puts the original values the asking user may see
+// back into answers.
+func NewRedactor(defaults []string, sources map[string][]string, restore bool) (*Redactor, error) {
+	redactor := &Redactor{sources: make(map[string][]Detector), restore: restore}
+	var err error
+	if redactor.defaults, err = newDetectors(defaults); err != nil {
+		return nil, err
+	}
+	for source, names := range sources {
+		if redactor.sources[sourceKey(source)], err = newDetectors(names); err != nil {
+			return nil, fmt.Errorf("%s: %v", source, err)
+		}
+	}
+	return redactor, nil
+}
+
+// sourceKey lets sources such as "slack-export" be configured through
+// environment variables, which can't contain hyphens
+func sourceKey(source string) string {
+	return strings.ReplaceAll(strings.ToLower(source), "-", "_")
+}
+
+func newDetectors(names []string) ([]Detector, error) {
+	var detectors []Detector
+	for _, name := range DetectorNames {
+		if !containsString(names, name) {
+			continue
+		}
+		detector, err := NewDetector(name)
+		if err != nil {
+			return nil, err
+		}
+		detectors = append(detectors, detector)
+	}
+	for _, name := range names {
+		if name != "none" && !containsString(DetectorNames, name) {
+			return nil, fmt.Errorf("unknown redaction detector %q, expected one of %s or none", name, strings.Join(DetectorNames, ", "))
+		}
+	}
+	return detectors, nil
+}
+
+// NewRedaction starts redacting one request. A nil Redactor redacts nothing.
+func (r *Redactor) NewRedaction() *Redaction {
+	return &Redaction{
+		redactor:     r,
+		originals:    make(map[string]string),
+		placeholders: make(map[string]string),
+		counts:       make(map[string]int),
+		readable:     make(map[string]bool),
+	}
+}
+
+// Redaction replaces the values found in one request's content with
+// placeholders such as [EMAIL_1]. The same value always gets the same
+// placeholder, so the model can still tell people apart. The values never
+// leave the backend and are forgotten with the request.
+type Redaction struct {
+	redactor     *Redactor
+	originals    map[string]string // Placeholder -> value
+	placeholders map[string]string // Value -> placeholder
+	counts       map[string]int    // Placeholders handed out per kind
+	readable     map[string]bool   // Placeholders of values found in results that aren't shared
+}
+
+// Count returns how many different values were replaced
+func (rd *Redaction) Count() int {
+	return len(rd.originals)
+}
+
+// RedactResults returns copies of results with their titles and content
+// redacted, using the detectors of each result's source
+func (rd *Redaction) RedactResults(results []SearchResult) []SearchResult {
+	if rd.redactor == nil {
+		return results
+	}
+	redacted := make([]SearchResult, len(results))
+	for i, result := range results {
+		detectors, ok := rd.redactor.sources[sourceKey(result.Source)]
+		if !ok {
+			detectors = rd.redactor.defaults
+		}
+		result.Title = rd.redact(result.Title, detectors, result.Shared)
+		result.Content = rd.redact(result.Content, detectors, result.Shared)
+		redacted[i] = result
+	}
+	return redacted
+}
+
+func (rd *Redaction) redact(text string, detectors []Detector, shared bool) string {
+	type span struct {
+		start, end int
+		kind       string
+	}
+	var spans []span
+	for _, detector := range detectors {
+		for _, found := range detector.Find(text) {
+			spans = append(spans, span{found[0], found[1], detector.Kind()})
+		}
+	}
+	if len(spans) == 0 {
+		return text
+	}
+
+	// Where matches overlap, the one starting first wins, then the longer one
+	sort.SliceStable(spans, func(i, j int) bool {
+		if spans[i].start != spans[j].start {
+			return spans[i].start < spans[j].start
+		}
+		return spans[i].end > spans[j].end
+	})
+	var out strings.Builder
+	last := 0
+	for _, s := range spans {
+		if s.start < last {
+			continue
+		}
+		out.WriteString(text[last:s.start])
+		placeholder := rd.placeholder(text[s.start:s.end], s.kind)
+		if !shared {
+			rd.readable[placeholder] = true
+		}
+		out.WriteString(placeholder)
+		last = s.end
+	}
+	out.WriteString(text[last:])
+	return out.String()
+}
+
+func (rd *Redaction) placeholder(value, kind string) string {
+	if placeholder, ok := rd.placeholders[value]; ok {
+		return placeholder
+	}
+	rd.counts[kind]++
+	placeholder := fmt.Sprintf("[%s_%d]", kind, rd.counts[kind])
+	rd.placeholders[value] = placeholder
+	rd.originals[placeholder] = value
+	return placeholder
+}
+
+var placeholderPattern = regexp.MustCompile(`\[[A-Z_]+_\d+\]`)
+
+// Restore puts back in text the original values the asking user may see:
+// those found in results they reached with their own access. Values found
+// only in shared results stay masked, as do all of them when the redactor was
+// configured to keep them out of answers.
+func (rd *Redaction) Restore(text string) string {
+	if rd.redactor == nil || !rd.redactor.restore || len(rd.readable) == 0 {
+		return text
+	}
+	return placeholderPattern.ReplaceAllStringFunc(text, func(placeholder string) string {
+		if original, ok := rd.originals[placeholder]; ok && rd.readable[placeholder] {
+			return original
+		}
+		return placeholder
+	})
+}
+
+// Mask replaces the values redacted so far with their placeholders again, so
+// a restored answer can be kept without them
+func (rd *Redaction) Mask(text string) string {
+	if len(rd.placeholders) == 0 {
+		return text
+	}
+	// Longer values first, so a value containing another is masked whole
+	values := make([]string, 0, len(rd.placeholders))
+	for value := range rd.placeholders {
+		values = append(values, value)
+	}
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+	pairs := make([]string, 0, 2*len(values))
+	for _, value := range values {
+		pairs = append(pairs, value, rd.placeholders[value])
+	}
+	return strings.NewReplacer(pairs...).Replace(text)
+}
+
+// maxPlaceholderLength bounds how much streamed text is held back waiting for
+// the end of a placeholder
+const maxPlaceholderLength = 24
+
+// StreamRestorer restores placeholders in an answer that arrives in chunks,
+// where a placeholder may be split between chunks
+type StreamRestorer struct {
+	redaction *Redaction
+	pending   string
+}
+
+func (rd *Redaction) NewStreamRestorer() *StreamRestorer {
+	return &StreamRestorer{redaction: rd}
+}
+
+// Write takes the next chunk and returns the text that can be shown so far
+func (sr *StreamRestorer) Write(chunk string) string {
+	text := sr.pending + chunk
+	sr.pending = ""
+	// Hold back a trailing "[EMAIL_" until we know whether it's a placeholder
+	if open := strings.LastIndex(text, "["); open >= 0 && !strings.Contains(text[open:], "]") &&
+		len(text)-open < maxPlaceholderLength && isPlaceholderPrefix(text[open+1:]) {
+		sr.pending = text[open:]
+		text = text[:open]
+	}
+	return sr.redaction.Restore(text)
+}
+
+// Flush returns whatever was held back, once the answer is complete
+func (sr *StreamRestorer) Flush() string {
+	text := sr.pending
+	sr.pending = ""
+	return sr.redaction.Restore(text)
+}
+
+func isPlaceholderPrefix(text string) bool {
+	for _, r := range text {
+		if !(r >= 'A' && r <= 'Z') && !unicode.IsDigit(r) && r != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+func shannonEntropy(text string) float64 {
+	counts := make(map[rune]int)
+	for _, r := range text {
+		counts[r]++
+	}
+	var entropy float64
+	length := float64(len(text))
+	for _, count := range counts {
+		p := float64(count) / length
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
+
+func hasLettersAndDigits(text string) bool {
+	return strings.IndexFunc(text, unicode.IsLetter) >= 0 && strings.IndexFunc(text, unicode.IsDigit) >= 0
+}
+
+func countDigits(text string) int {
+	count := 0
+	for _, r := range text {
+		if r >= '0' && r <= '9' {
+			count++
+		}
+	}
+	return count
+}
+
+func between(value, min, max int) bool {
+	return value >= min && value <= max
+}
+
+// validCardNumber checks the length and Luhn checksum of a card number
+func validCardNumber(value string) bool {
+	var digits []int
+	for _, r := range value {
+		if r >= '0' && r <= '9' {
+			digits = append(digits, int(r-'0'))
+		}
+	}
+	if !between(len(digits), 13, 19) {
+		return false
+	}
+	sum := 0
+	for i := range digits {
+		digit := digits[len(digits)-1-i]
+		if i%2 == 1 {
+			if digit *= 2; digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+	}
+	return sum%10 == 0
+}
+
+// validIBAN checks an IBAN's mod-97 checksum
+func validIBAN(value string) bool {
+	iban := strings.ReplaceAll(value, " ", "")
+	if !between(len(iban), 15, 34) {
+		return false
+	}
+	var numeric strings.Builder
+	for _, r := range iban[4:] + iban[:4] {
+		if r >= 'A' && r <= 'Z' {
+			fmt.Fprintf(&numeric, "%d", r-'A'+10)
+		} else {
+			numeric.WriteRune(r)
+		}
+	}
+	n, ok := new(big.Int).SetString(numeric.String(), 10)
+	return ok && new(big.Int).Mod(n, big.NewInt(97)).Int64() == 1
+}
@@ -0,0 +1,73 @@
+package services
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPhoneDetector(t *testing.T) {
+	tests := []struct {
+		text string
+		want string // The phone number found, or "" for none
+	}{
+		{"Call +1 (555) 123-4567 after nine", "+1 (555) 123-4567"},
+		{"London office: +44 20 7946 0958.", "+44 20 7946 0958"},
+		{"cell 555-123-4567", "555-123-4567"},
+		{"desk 555.123.4567", "555.123.4567"},
+		{"(030) 1234 5678 is the switchboard", "(030) 1234 5678"},
+		{"Berlin: 030 1234 5678", "030 1234 5678"},
+
+		{"the gateway is 192.168.100.200", ""},
+		{"ping 10.0.0.1 and 10.200.300.4000", ""},
+		{"deployed 2024-03-04T10:30:00Z", ""},
+		{"between 2024-03-04 and 2024-12-31", ""},
+		{"at 12:30:45 on 04.03.2024", ""},
+		{"upgrade to v1.22.333 or 2.100.4000", ""},
+		{"build 20240304.1234.5678", ""},
+		{"order 123456789012", ""},
+		{"see PR-555-123-4567", ""},
+	}
+
+	for _, test := range tests {
+		var found []string
+		for _, match := range (phoneDetector{}).Find(test.text) {
+			found = append(found, test.text[match[0]:match[1]])
+		}
+		switch {
+		case test.want == "" && len(found) > 0:
+			t.Errorf("%q: found phone numbers %q", test.text, found)
+		case test.want != "" && (len(found) != 1 || found[0] != test.want):
+			t.Errorf("%q: found %q, want %q", test.text, found, test.want)
+		}
+	}
+}
+
+func TestRedactionRestoresValuesTheUserMaySee(t *testing.T) {
+	redactor, err := NewRedactor([]string{DetectorEmails}, nil, true)
+	if err != nil {
+		t.Fatalf("NewRedactor: %v", err)
+	}
+	redaction := redactor.NewRedaction()
+	redacted := redaction.RedactResults([]SearchResult{
+		{Source: "gmail", Content: "From ada@acme.test"},
+		{Source: "imap", Content: "From ada@acme.test and grace@acme.test", Shared: true},
+	})
+	if strings.Contains(redacted[0].Content+redacted[1].Content, "@") {
+		t.Fatalf("values reached the model: %+v", redacted)
+	}
+
+	answer := "Ask [EMAIL_1] or [EMAIL_2]"
+	if got := redaction.Restore(answer); got != "Ask ada@acme.test or [EMAIL_2]" {
+		t.Errorf("Restore = %q, want only the user's own result's value back", got)
+	}
+	if got := redaction.Mask("Ask ada@acme.test or [EMAIL_2]"); got != answer {
+		t.Errorf("Mask = %q, want %q", got, answer)
+	}
+
+	off, _ := NewRedactor([]string{DetectorEmails}, nil, false)
+	redaction = off.NewRedaction()
+	redaction.RedactResults([]SearchResult{{Source: "gmail", Content: "From ada@acme.test"}})
+	if got := redaction.Restore("Ask [EMAIL_1]"); got != "Ask [EMAIL_1]" {
+		t.Errorf("Restore = %q with restoring turned off", got)
+	}
+}
@@ -0,0 +1,687 @@
+package services
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Built-in connector definitions, loadable by name
+//
+//go:embed connectors/*.json
+var builtinConnectors embed.FS
+
+// maxRateLimitWait is how long a search waits for a rate limit to reset
+// before giving up
+const maxRateLimitWait = 10 * time.Second
+
+// RESTConnectorDefinition describes a search API declaratively so new tools
+// can be connected without Go code. String values may contain {{variable}}
+// placeholders (see RESTConnector) and, for fields, {{$.json.path}}
+// placeholders evaluated against each result item.
+type RESTConnectorDefinition struct {
+	Name        string   `json:"name"`
+	DisplayName string   `json:"display_name"`
+	BaseURL     string   `json:"base_url"`
+	Required    []string `json:"required"` // Variables that must be set for the connector to load
+
+	Auth struct {
+		Type     string `json:"type"` // "header", "basic", "query" or "none"
+		Header   string `json:"header"`
+		Value    string `json:"value"` // Header value, or query parameter value
+		Param    string `json:"param"`
+		Username string `json:"username"`
+		Password string `json:"password"`
+	} `json:"auth"`
+
+	Search struct {
+		Method     string            `json:"method"`
+		Path       string            `json:"path"`
+		Query      map[string]string `json:"query"`
+		Headers    map[string]string `json:"headers"`
+		Body       json.RawMessage   `json:"body"`   // JSON template, e.g. a GraphQL request
+		Items      string            `json:"items"`  // JSONPath to the result array
+		Errors     string            `json:"errors"` // JSONPath to error messages in a 200 response (GraphQL)
+		PageSize   int               `json:"page_size"`
+		MaxResults int               `json:"max_results"`
+	} `json:"search"`
+
+	// Field values are a JSONPath ("$.subject") or a template ("{{$.key}}: {{$.title}}")
+	Fields struct {
+		ID         string            `json:"id"`
+		Title      string            `json:"title"`
+		Body       string            `json:"body"`
+		BodyFormat string            `json:"body_format"` // "text", "markdown" or "html"
+		URL        string            `json:"url"`
+		Timestamp  string            `json:"timestamp"`
+		Metadata   map[string]string `json:"metadata"`
+	} `json:"fields"`
+
+	Pagination struct {
+		Style       string `json:"style"`      // "none", "page", "offset", "cursor", "next_url" or "link_header"
+		Param       string `json:"param"`      // Query parameter for the page number, offset or cursor
+		SizeParam   string `json:"size_param"` // Query parameter for the page size
+		StartPage   int    `json:"start_page"`
+		CursorPath  string `json:"cursor_path"`   // JSONPath to the next cursor
+		HasMorePath string `json:"has_more_path"` // JSONPath to a "more results" flag
+		NextURLPath string `json:"next_url_path"` // JSONPath to the next page URL
+		MaxPages    int    `json:"max_pages"`
+	} `json:"pagination"`
+
+	RateLimit struct {
+		RemainingHeader  string `json:"remaining_header"`
+		ResetHeader      string `json:"reset_header"`
+		ResetFormat      string `json:"reset_format"` // "unix", "unix_ms" or "seconds" (until reset)
+		RetryAfterHeader string `json:"retry_after_header"`
+	} `json:"rate_limit"`
+}
+
+// RESTConnector searches one API described by a RESTConnectorDefinition.
+// Variables fill {{name}} placeholders; {{query}}, {{cursor}}, {{page}},
+// {{offset}} and {{page_size}} are set for each request.
+type RESTConnector struct {
+	Definition RESTConnectorDefinition
+	Variables  map[string]string
+	Client     *http.Client
+
+	mu           sync.Mutex
+	blockedUntil time.Time // Set when the API reports its rate limit is used up
+}
+
+// RESTItem is one search result mapped through the definition's fields
+type RESTItem struct {
+	ID        string
+	Title     string
+	Body      string
+	URL       string
+	Timestamp string
+	Metadata  map[string]string
+}
+
+// LoadRESTConnectorDefinition reads a built-in definition by name ("linear")
+// or a JSON definition file by path
+func LoadRESTConnectorDefinition(nameOrPath string) (RESTConnectorDefinition, error) {
+	var definition RESTConnectorDefinition
+
+	data, err := builtinConnectors.ReadFile("connectors/" + nameOrPath + ".json")
+	if err != nil {
+		data, err = os.ReadFile(nameOrPath)
+		if err != nil {
+			return definition, fmt.Errorf("no built-in connector or definition file named %q", nameOrPath)
+		}
+	}
+
+	if err := json.Unmarshal(data, &definition); err != nil {
+		return definition, fmt.Errorf("invalid connector definition %s: %v", nameOrPath, err)
+	}
+	if definition.Name == "" || definition.Search.Items == "" {
+		return definition, fmt.Errorf("connector definition %s needs a name and search.items", nameOrPath)
+	}
+	if definition.DisplayName == "" {
+		definition.DisplayName = definition.Name
+	}
+	return definition, nil
+}
+
+func NewRESTConnector(definition RESTConnectorDefinition, variables map[string]string) (*RESTConnector, error) {
+	for _, name := range definition.Required {
+		if variables[name] == "" {
+			return nil, fmt.Errorf("connector %s needs the %s variable (REST_%s)", definition.Name, name, strings.ToUpper(name))
+		}
+	}
+
+	if definition.Search.Method == "" {
+		definition.Search.Method = "GET"
+	}
+	if definition.Search.PageSize == 0 {
+		definition.Search.PageSize = 20
+	}
+	if definition.Search.MaxResults == 0 {
+		definition.Search.MaxResults = 20
+	}
+	if definition.Pagination.MaxPages == 0 {
+		definition.Pagination.MaxPages = 3
+	}
+	if definition.RateLimit.RetryAfterHeader == "" {
+		definition.RateLimit.RetryAfterHeader = "Retry-After"
+	}
+
+	return &RESTConnector{
+		Definition: definition,
+		Variables:  variables,
+		Client:     &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// Search runs the search request, following pagination until limit items
+// are found or the definition's page limit is reached
+func (rc *RESTConnector) Search(query string, limit int) ([]RESTItem, error) {
+	def := &rc.Definition
+	if limit <= 0 || limit > def.Search.MaxResults {
+		limit = def.Search.MaxResults
+	}
+
+	vars := map[string]string{
+		"query":     query,
+		"page_size": strconv.Itoa(def.Search.PageSize),
+		"page":      strconv.Itoa(def.Pagination.StartPage),
+		"offset":    "0",
+		"cursor":    "",
+	}
+	for name, value := range rc.Variables {
+		vars[name] = value
+	}
+
+	var items []RESTItem
+	nextURL := ""
+	for page := 0; page < def.Pagination.MaxPages && len(items) < limit; page++ {
+		data, header, err := rc.request(nextURL, vars)
+		if err != nil {
+			return nil, err
+		}
+
+		pageItems := evaluateJSONPath(data, def.Search.Items)
+		if len(pageItems) == 1 {
+			// The path may point at the array itself rather than its elements
+			if array, ok := pageItems[0].([]interface{}); ok {
+				pageItems = array
+			}
+		}
+		for _, item := range pageItems {
+			items = append(items, rc.mapItem(item, vars))
+		}
+
+		// Work out the next page, stopping when there is none
+		if len(pageItems) == 0 {
+			break
+		}
+		if def.Pagination.HasMorePath != "" && !jsonPathTruthy(data, def.Pagination.HasMorePath) {
+			break
+		}
+		switch def.Pagination.Style {
+		case "page":
+			current, _ := strconv.Atoi(vars["page"])
+			vars["page"] = strconv.Itoa(current + 1)
+		case "offset":
+			current, _ := strconv.Atoi(vars["offset"])
+			vars["offset"] = strconv.Itoa(current + len(pageItems))
+		case "cursor":
+			vars["cursor"] = jsonPathString(data, def.Pagination.CursorPath)
+			if vars["cursor"] == "" {
+				return truncateRESTItems(items, limit), nil
+			}
+		case "next_url":
+			nextURL = jsonPathString(data, def.Pagination.NextURLPath)
+			if nextURL == "" {
+				return truncateRESTItems(items, limit), nil
+			}
+		case "link_header":
+			nextURL = nextLinkHeader(header.Get("Link"))
+			if nextURL == "" {
+				return truncateRESTItems(items, limit), nil
+			}
+		default:
+			return truncateRESTItems(items, limit), nil
+		}
+	}
+
+	return truncateRESTItems(items, limit), nil
+}
+
+func truncateRESTItems(items []RESTItem, limit int) []RESTItem {
+	if len(items) > limit {
+		return items[:limit]
+	}
+	return items
+}
+
+// FormatMetadata renders an item's metadata as "Key: value" lines in a stable order
+func (item RESTItem) FormatMetadata() string {
+	keys := make([]string, 0, len(item.Metadata))
+	for key, value := range item.Metadata {
+		if value != "" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var lines []string
+	if item.Timestamp != "" {
+		lines = append(lines, "Updated: "+item.Timestamp)
+	}
+	for _, key := range keys {
+		lines = append(lines, fmt.Sprintf("%s: %s", strings.Title(strings.ReplaceAll(key, "_", " ")), item.Metadata[key]))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (rc *RESTConnector) mapItem(item interface{}, vars map[string]string) RESTItem {
+	fields := rc.Definition.Fields
+	mapped := RESTItem{
+		ID:        renderRESTField(fields.ID, item, vars),
+		Title:     renderRESTField(fields.Title, item, vars),
+		Body:      renderRESTField(fields.Body, item, vars),
+		URL:       renderRESTField(fields.URL, item, vars),
+		Timestamp: renderRESTField(fields.Timestamp, item, vars),
+		Metadata:  map[string]string{},
+	}
+	for key, field := range fields.Metadata {
+		mapped.Metadata[key] = renderRESTField(field, item, vars)
+	}
+
+	if fields.BodyFormat == "html" {
+		mapped.Body = HTMLToMarkdown(mapped.Body)
+	}
+	if mapped.Title == "" {
+		mapped.Title = mapped.ID
+	}
+	return mapped
+}
+
+// request sends one search request, or fetches nextURL when the API handed
+// back a full next page URL
+func (rc *RESTConnector) request(nextURL string, vars map[string]string) (interface{}, http.Header, error) {
+	def := &rc.Definition
+
+	if err := rc.waitForRateLimit(); err != nil {
+		return nil, nil, err
+	}
+
+	method := def.Search.Method
+	var requestURL string
+	var body []byte
+	if nextURL != "" {
+		method = "GET"
+		requestURL = nextURL
+	} else {
+		endpoint, err := url.Parse(renderTemplate(def.BaseURL+def.Search.Path, nil, vars))
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid search URL: %v", err)
+		}
+		params := endpoint.Query()
+		for name, value := range def.Search.Query {
+			params.Set(name, renderTemplate(value, nil, vars))
+		}
+		if def.Pagination.SizeParam != "" {
+			params.Set(def.Pagination.SizeParam, vars["page_size"])
+		}
+		switch def.Pagination.Style {
+		case "page":
+			params.Set(def.Pagination.Param, vars["page"])
+		case "offset":
+			params.Set(def.Pagination.Param, vars["offset"])
+		case "cursor":
+			if def.Pagination.Param != "" && vars["cursor"] != "" {
+				params.Set(def.Pagination.Param, vars["cursor"])
+			}
+		}
+		if def.Auth.Type == "query" {
+			params.Set(def.Auth.Param, renderTemplate(def.Auth.Value, nil, vars))
+		}
+		endpoint.RawQuery = params.Encode()
+		requestURL = endpoint.String()
+
+		if len(def.Search.Body) > 0 {
+			var template interface{}
+			if err := json.Unmarshal(def.Search.Body, &template); err != nil {
+				return nil, nil, fmt.Errorf("invalid search body template: %v", err)
+			}
+			rendered, err := json.Marshal(renderJSONTemplate(template, vars))
+			if err != nil {
+				return nil, nil, err
+			}
+			body = rendered
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		var reader io.Reader
+		if body != nil {
+			reader = bytes.NewReader(body)
+		}
+		req, err := http.NewRequest(method, requestURL, reader)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		req.Header.Add("Accept", "application/json")
+		if body != nil {
+			req.Header.Add("Content-Type", "application/json")
+		}
+		for name, value := range def.Search.Headers {
+			req.Header.Set(name, renderTemplate(value, nil, vars))
+		}
+		switch def.Auth.Type {
+		case "header":
+			req.Header.Set(def.Auth.Header, renderTemplate(def.Auth.Value, nil, vars))
+		case "basic":
+			req.SetBasicAuth(renderTemplate(def.Auth.Username, nil, vars), renderTemplate(def.Auth.Password, nil, vars))
+		}
+
+		resp, err := rc.Client.Do(req)
+		if err != nil {
+			return nil, nil, err
+		}
+		rc.recordRateLimit(resp.Header)
+
+		if resp.StatusCode == http.StatusTooManyRequests {
+			resp.Body.Close()
+			wait := parseRetryAfter(resp.Header.Get(def.RateLimit.RetryAfterHeader))
+			if attempt > 0 || wait > maxRateLimitWait {
+				return nil, nil, fmt.Errorf("%s rate limit exceeded", def.DisplayName)
+			}
+			time.Sleep(wait)
+			continue
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, nil, fmt.Errorf("failed to search %s: %s", def.DisplayName, resp.Status)
+		}
+
+		decoder := json.NewDecoder(resp.Body)
+		decoder.UseNumber() // Keep numeric IDs exactly as sent
+		var data interface{}
+		err = decoder.Decode(&data)
+		resp.Body.Close()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to decode %s response: %v", def.DisplayName, err)
+		}
+
+		if def.Search.Errors != "" {
+			if messages := evaluateJSONPath(data, def.Search.Errors); len(messages) > 0 {
+				return nil, nil, fmt.Errorf("%s search failed: %s", def.DisplayName, jsonValueString(messages))
+			}
+		}
+		return data, resp.Header, nil
+	}
+}
+
+// waitForRateLimit sleeps until a short rate limit window resets, or fails
+// fast when the reset is too far away
+func (rc *RESTConnector) waitForRateLimit() error {
+	rc.mu.Lock()
+	wait := time.Until(rc.blockedUntil)
+	rc.mu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+	if wait > maxRateLimitWait {
+		return fmt.Errorf("%s rate limit exceeded, resets in %s", rc.Definition.DisplayName, wait.Round(time.Second))
+	}
+	time.Sleep(wait)
+	return nil
+}
+
+func (rc *RESTConnector) recordRateLimit(header http.Header) {
+	limits := rc.Definition.RateLimit
+	if limits.RemainingHeader == "" || limits.ResetHeader == "" {
+		return
+	}
+	remaining, err := strconv.Atoi(strings.TrimSpace(header.Get(limits.RemainingHeader)))
+	if err != nil || remaining > 0 {
+		return
+	}
+	reset, err := strconv.ParseFloat(strings.TrimSpace(header.Get(limits.ResetHeader)), 64)
+	if err != nil {
+		return
+	}
+
+	var resetAt time.Time
+	switch limits.ResetFormat {
+	case "unix_ms":
+		resetAt = time.UnixMilli(int64(reset))
+	case "seconds":
+		resetAt = time.Now().Add(time.Duration(reset * float64(time.Second)))
+	default:
+		resetAt = time.Unix(int64(reset), 0)
+	}
+
+	rc.mu.Lock()
+	rc.blockedUntil = resetAt
+	rc.mu.Unlock()
+}
+
+// parseRetryAfter reads a Retry-After value in seconds or as an HTTP date
+func parseRetryAfter(value string) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Second
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date)
+	}
+	return time.Second
+}
+
+var linkHeaderNext = regexp.MustCompile(`<([^>]+)>\s*;[^,]*rel="?next"?`)
+
+// nextLinkHeader returns the rel="next" URL of an RFC 8288 Link header
+func nextLinkHeader(value string) string {
+	if match := linkHeaderNext.FindStringSubmatch(value); match != nil {
+		return match[1]
+	}
+	return ""
+}
+
+// Templates
+
+var templatePlaceholder = regexp.MustCompile(`\{\{\s*([^}]+?)\s*\}\}`)
+
+// renderRESTField evaluates a field: a bare JSONPath, or a template
+func renderRESTField(field string, item interface{}, vars map[string]string) string {
+	if strings.HasPrefix(field, "$") {
+		return jsonPathString(item, field)
+	}
+	return renderTemplate(field, item, vars)
+}
+
+// renderTemplate replaces {{name}} with a variable and {{$.path}} with a value
+// from item
+func renderTemplate(template string, item interface{}, vars map[string]string) string {
+	return templatePlaceholder.ReplaceAllStringFunc(template, func(placeholder string) string {
+		name := templatePlaceholder.FindStringSubmatch(placeholder)[1]
+		if strings.HasPrefix(name, "$") {
+			return jsonPathString(item, name)
+		}
+		return vars[name]
+	})
+}
+
+// renderJSONTemplate fills placeholders in every string of a decoded JSON
+// template. A string that is only a placeholder for an empty value becomes
+// null, so optional GraphQL variables such as cursors are left out.
+func renderJSONTemplate(value interface{}, vars map[string]string) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		rendered := make(map[string]interface{}, len(v))
+		for key, child := range v {
+			rendered[key] = renderJSONTemplate(child, vars)
+		}
+		return rendered
+	case []interface{}:
+		rendered := make([]interface{}, len(v))
+		for i, child := range v {
+			rendered[i] = renderJSONTemplate(child, vars)
+		}
+		return rendered
+	case string:
+		rendered := renderTemplate(v, nil, vars)
+		if rendered == "" && templatePlaceholder.MatchString(v) {
+			return nil
+		}
+		return rendered
+	default:
+		return v
+	}
+}
+
+// JSONPath
+
+// evaluateJSONPath supports the subset of JSONPath connector definitions
+// need: $, .name, ['name'], [n], [*] and .*
+func evaluateJSONPath(data interface{}, path string) []interface{} {
+	path = strings.TrimSpace(path)
+	if !strings.HasPrefix(path, "$") {
+		return nil
+	}
+	path = path[1:]
+
+	current := []interface{}{data}
+	for path != "" && len(current) > 0 {
+		var next []interface{}
+		switch {
+		case strings.HasPrefix(path, "[*]") || strings.HasPrefix(path, ".*"):
+			if strings.HasPrefix(path, "[*]") {
+				path = path[3:]
+			} else {
+				path = path[2:]
+			}
+			for _, value := range current {
+				switch v := value.(type) {
+				case []interface{}:
+					next = append(next, v...)
+				case map[string]interface{}:
+					keys := make([]string, 0, len(v))
+					for key := range v {
+						keys = append(keys, key)
+					}
+					sort.Strings(keys)
+					for _, key := range keys {
+						next = append(next, v[key])
+					}
+				}
+			}
+		case strings.HasPrefix(path, "['") || strings.HasPrefix(path, "[\""):
+			end := strings.Index(path[2:], string(path[1])+"]")
+			if end < 0 {
+				return nil
+			}
+			key := path[2 : 2+end]
+			path = path[2+end+2:]
+			next = jsonPathChildren(current, key)
+		case strings.HasPrefix(path, "["):
+			end := strings.Index(path, "]")
+			if end < 0 {
+				return nil
+			}
+			index, err := strconv.Atoi(path[1:end])
+			if err != nil {
+				return nil
+			}
+			path = path[end+1:]
+			for _, value := range current {
+				if array, ok := value.([]interface{}); ok {
+					// Negative indexes count from the end of each array
+					i := index
+					if i < 0 {
+						i += len(array)
+					}
+					if i >= 0 && i < len(array) {
+						next = append(next, array[i])
+					}
+				}
+			}
+		case strings.HasPrefix(path, "."):
+			path = path[1:]
+			end := strings.IndexAny(path, ".[")
+			if end < 0 {
+				end = len(path)
+			}
+			key := path[:end]
+			path = path[end:]
+			next = jsonPathChildren(current, key)
+		default:
+			return nil
+		}
+		current = next
+	}
+
+	// Missing values and JSON nulls count as absent
+	var values []interface{}
+	for _, value := range current {
+		if value != nil {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+func jsonPathChildren(values []interface{}, key string) []interface{} {
+	var children []interface{}
+	for _, value := range values {
+		if object, ok := value.(map[string]interface{}); ok {
+			if child, ok := object[key]; ok {
+				children = append(children, child)
+			}
+		}
+	}
+	return children
+}
+
+// jsonPathString evaluates path and joins the matches as text
+func jsonPathString(data interface{}, path string) string {
+	return jsonValueString(evaluateJSONPath(data, path))
+}
+
+func jsonPathTruthy(data interface{}, path string) bool {
+	for _, value := range evaluateJSONPath(data, path) {
+		switch v := value.(type) {
+		case bool:
+			if v {
+				return true
+			}
+		case string:
+			if v != "" && v != "false" {
+				return true
+			}
+		case json.Number:
+			if v.String() != "0" {
+				return true
+			}
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+func jsonValueString(values []interface{}) string {
+	var parts []string
+	for _, value := range values {
+		switch v := value.(type) {
+		case string:
+			parts = append(parts, v)
+		case json.Number:
+			parts = append(parts, v.String())
+		case bool:
+			parts = append(parts, strconv.FormatBool(v))
+		case []interface{}:
+			if text := jsonValueString(v); text != "" {
+				parts = append(parts, text)
+			}
+		case map[string]interface{}:
+			if message, ok := v["message"].(string); ok {
+				parts = append(parts, message) // Error objects
+			} else if encoded, err := json.Marshal(v); err == nil {
+				parts = append(parts, string(encoded))
+			}
+		}
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,543 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestRESTConnector loads a definition pointed at a stub API
+func newTestRESTConnector(t *testing.T, definition string, variables map[string]string, handler http.HandlerFunc) *RESTConnector {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	var def RESTConnectorDefinition
+	if err := json.Unmarshal([]byte(definition), &def); err != nil {
+		t.Fatal(err)
+	}
+	def.BaseURL = server.URL
+	rc, err := NewRESTConnector(def, variables)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rc
+}
+
+// itemIDs joins the IDs of search results
+func itemIDs(items []RESTItem) string {
+	var ids []string
+	for _, item := range items {
+		ids = append(ids, item.ID)
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestEvaluateJSONPath(t *testing.T) {
+	decoder := json.NewDecoder(strings.NewReader(`{
+		"id": 12345678901234567890,
+		"issue": {"title": "Login fails", "assignee": null, "open": true},
+		"odd key": {"v": "spaced"},
+		"dotted.key": "dotted",
+		"items": [{"name": "a", "note": "x"}, {"name": "b", "note": null}, {"name": "c"}],
+		"lists": [[1, 2, 3], [4, 5], []],
+		"counts": {"b": 2, "a": 1}
+	}`))
+	decoder.UseNumber()
+	var data interface{}
+	if err := decoder.Decode(&data); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"$.issue.title", "Login fails"},
+		{"$.issue.open", "true"},
+		{"$.id", "12345678901234567890"},
+		{"$.items[*].name", "a, b, c"},
+		{"$.items[1].name", "b"},
+		{"$.items[-1].name", "c"},
+		// Negative indexes count from the end of every array they apply to
+		{"$.lists[*][-1]", "3, 5"},
+		{"$.lists[*][0]", "1, 4"},
+		{"$.items[5].name", ""},
+		{"$['odd key'].v", "spaced"},
+		{`$["dotted.key"]`, "dotted"},
+		{"$.counts.*", "1, 2"},
+		// Nulls and missing values are absent
+		{"$.issue.assignee", ""},
+		{"$.items[*].note", "x"},
+		{"$.missing.deeper", ""},
+		// Invalid paths match nothing
+		{"issue.title", ""},
+		{"$.items[x]", ""},
+		{"$['unterminated", ""},
+	}
+	for _, test := range tests {
+		if got := jsonPathString(data, test.path); got != test.want {
+			t.Errorf("%s = %q, want %q", test.path, got, test.want)
+		}
+	}
+
+	if values := evaluateJSONPath(data, "$.items[*].note"); len(values) != 1 {
+		t.Errorf("$.items[*].note = %v, want the null and the missing note dropped", values)
+	}
+	if !jsonPathTruthy(data, "$.issue.open") || jsonPathTruthy(data, "$.issue.assignee") {
+		t.Errorf("jsonPathTruthy doesn't follow the values")
+	}
+}
+
+func TestRESTFieldTemplates(t *testing.T) {
+	item := map[string]interface{}{"key": "ENG-1", "title": "Login fails", "body": "<p>Steps</p><ul><li>Open</li></ul>"}
+	rc := &RESTConnector{}
+	rc.Definition.Fields.ID = "$.key"
+	rc.Definition.Fields.Title = "{{$.key}}: {{ $.title }}"
+	rc.Definition.Fields.Body = "$.body"
+	rc.Definition.Fields.BodyFormat = "html"
+	rc.Definition.Fields.URL = "https://{{site}}/browse/{{$.key}}"
+
+	mapped := rc.mapItem(item, map[string]string{"site": "tracker.example.com"})
+	if mapped.ID != "ENG-1" || mapped.Title != "ENG-1: Login fails" || mapped.URL != "https://tracker.example.com/browse/ENG-1" {
+		t.Errorf("mapped = %+v", mapped)
+	}
+	if mapped.Body != "Steps\n\n- Open" {
+		t.Errorf("body = %q, want the HTML converted to Markdown", mapped.Body)
+	}
+}
+
+func TestRESTPagination(t *testing.T) {
+	pages := [][]string{{"a", "b"}, {"c"}}
+	itemsJSON := func(page int) string {
+		if page >= len(pages) {
+			return "[]"
+		}
+		var items []string
+		for _, id := range pages[page] {
+			items = append(items, fmt.Sprintf(`{"id": %q}`, id))
+		}
+		return "[" + strings.Join(items, ",") + "]"
+	}
+
+	tests := []struct {
+		style      string
+		pagination string
+		// page returns which page a request asks for
+		page func(r *http.Request) int
+		// respond writes the page, with whatever points at the next one
+		respond func(w http.ResponseWriter, r *http.Request, serverURL string, page int)
+		want    string
+	}{
+		{
+			style:      "page",
+			pagination: `{"style": "page", "param": "p", "start_page": 1}`,
+			page: func(r *http.Request) int {
+				var page int
+				fmt.Sscan(r.URL.Query().Get("p"), &page)
+				return page - 1
+			},
+			want: "a,b,c",
+		},
+		{
+			style:      "offset",
+			pagination: `{"style": "offset", "param": "offset", "size_param": "limit"}`,
+			page: func(r *http.Request) int {
+				if r.URL.Query().Get("limit") != "2" {
+					return -1
+				}
+				switch r.URL.Query().Get("offset") {
+				case "0":
+					return 0
+				case "2":
+					return 1
+				}
+				return len(pages) // Past the last result
+			},
+			want: "a,b,c",
+		},
+		{
+			style:      "cursor",
+			pagination: `{"style": "cursor", "param": "after", "cursor_path": "$.next"}`,
+			page: func(r *http.Request) int {
+				return map[string]int{"": 0, "cur-1": 1}[r.URL.Query().Get("after")]
+			},
+			respond: func(w http.ResponseWriter, r *http.Request, serverURL string, page int) {
+				next := map[int]string{0: `"cur-1"`}[page]
+				if next == "" {
+					next = "null"
+				}
+				fmt.Fprintf(w, `{"results": %s, "next": %s}`, itemsJSON(page), next)
+			},
+			want: "a,b,c",
+		},
+		{
+			style:      "next_url",
+			pagination: `{"style": "next_url", "next_url_path": "$.next"}`,
+			page: func(r *http.Request) int {
+				if r.URL.Path == "/search/page-2" {
+					return 1
+				}
+				return 0
+			},
+			respond: func(w http.ResponseWriter, r *http.Request, serverURL string, page int) {
+				next := "null"
+				if page == 0 {
+					next = fmt.Sprintf("%q", serverURL+"/search/page-2")
+				}
+				fmt.Fprintf(w, `{"results": %s, "next": %s}`, itemsJSON(page), next)
+			},
+			want: "a,b,c",
+		},
+		{
+			style:      "link_header",
+			pagination: `{"style": "link_header"}`,
+			page: func(r *http.Request) int {
+				if r.URL.Query().Get("page") == "2" {
+					return 1
+				}
+				return 0
+			},
+			respond: func(w http.ResponseWriter, r *http.Request, serverURL string, page int) {
+				if page == 0 {
+					w.Header().Set("Link", fmt.Sprintf(`<%s/search?page=2>; rel="next", <%s/search?page=9>; rel="last"`, serverURL, serverURL))
+				}
+				fmt.Fprintf(w, `{"results": %s}`, itemsJSON(page))
+			},
+			want: "a,b,c",
+		},
+		{
+			style:      "none",
+			pagination: `{"style": "none"}`,
+			page:       func(r *http.Request) int { return 0 },
+			want:       "a,b",
+		},
+		{
+			// A "more results" flag stops paging even when a cursor is sent
+			style:      "has_more",
+			pagination: `{"style": "cursor", "param": "after", "cursor_path": "$.next", "has_more_path": "$.more"}`,
+			page:       func(r *http.Request) int { return 0 },
+			respond: func(w http.ResponseWriter, r *http.Request, serverURL string, page int) {
+				fmt.Fprintf(w, `{"results": %s, "next": "cur-1", "more": false}`, itemsJSON(page))
+			},
+			want: "a,b",
+		},
+	}
+
+	for _, test := range tests {
+		var server string
+		rc := newTestRESTConnector(t, `{"name": "tracker",
+			"search": {"path": "/search", "query": {"q": "{{query}}"}, "items": "$.results", "page_size": 2},
+			"fields": {"id": "$.id"},
+			"pagination": `+test.pagination+`}`, nil, func(w http.ResponseWriter, r *http.Request) {
+			page := test.page(r)
+			if page < 0 {
+				t.Errorf("%s: unexpected request %s", test.style, r.URL)
+				http.NotFound(w, r)
+				return
+			}
+			if test.respond != nil {
+				test.respond(w, r, server, page)
+				return
+			}
+			fmt.Fprintf(w, `{"results": %s}`, itemsJSON(page))
+		})
+		server = strings.TrimSuffix(rc.Definition.BaseURL, "/")
+
+		items, err := rc.Search("login", 10)
+		if err != nil {
+			t.Errorf("%s: Search: %v", test.style, err)
+			continue
+		}
+		if got := itemIDs(items); got != test.want {
+			t.Errorf("%s: found %s, want %s", test.style, got, test.want)
+		}
+	}
+}
+
+// Paging stops once the limit is reached, and the limit is capped by max_results
+func TestRESTSearchLimit(t *testing.T) {
+	var requests int32
+	rc := newTestRESTConnector(t, `{"name": "tracker",
+		"search": {"path": "/search", "items": "$.results", "page_size": 2, "max_results": 3},
+		"fields": {"id": "$.id"},
+		"pagination": {"style": "page", "param": "page", "max_pages": 5}}`, nil, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		page := r.URL.Query().Get("page")
+		fmt.Fprintf(w, `{"results": [{"id": "%[1]s-1"}, {"id": "%[1]s-2"}]}`, page)
+	})
+
+	items, err := rc.Search("login", 10)
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if got := itemIDs(items); got != "0-1,0-2,1-1" {
+		t.Errorf("found %s, want three results", got)
+	}
+	if requests != 2 {
+		t.Errorf("made %d requests, want paging to stop at the limit", requests)
+	}
+}
+
+func TestRESTRetryAfter(t *testing.T) {
+	definition := `{"name": "tracker", "display_name": "Tracker",
+		"search": {"path": "/search", "items": "$.results"},
+		"fields": {"id": "$.id"}}`
+
+	tests := []struct {
+		name       string
+		retryAfter []string // Retry-After of each 429 before a success
+		wantErr    bool
+		requests   int32
+	}{
+		{"retried once", []string{"0"}, false, 2},
+		{"limited twice", []string{"0", "0"}, true, 2},
+		{"wait too long", []string{"60"}, true, 1},
+	}
+	for _, test := range tests {
+		var requests int32
+		rc := newTestRESTConnector(t, definition, nil, func(w http.ResponseWriter, r *http.Request) {
+			n := int(atomic.AddInt32(&requests, 1))
+			if n <= len(test.retryAfter) {
+				w.Header().Set("Retry-After", test.retryAfter[n-1])
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			w.Write([]byte(`{"results": [{"id": "1"}]}`))
+		})
+
+		items, err := rc.Search("login", 5)
+		if test.wantErr {
+			if err == nil || !strings.Contains(err.Error(), "Tracker rate limit exceeded") {
+				t.Errorf("%s: err = %v, want the rate limit error", test.name, err)
+			}
+		} else if err != nil || len(items) != 1 {
+			t.Errorf("%s: Search = %+v, %v", test.name, items, err)
+		}
+		if requests != test.requests {
+			t.Errorf("%s: made %d requests, want %d", test.name, requests, test.requests)
+		}
+	}
+}
+
+// An exhausted rate limit blocks further searches until it resets
+func TestRESTRateLimitHeaders(t *testing.T) {
+	var requests int32
+	reset := "3600"
+	rc := newTestRESTConnector(t, `{"name": "tracker", "display_name": "Tracker",
+		"search": {"path": "/search", "items": "$.results"},
+		"fields": {"id": "$.id"},
+		"rate_limit": {"remaining_header": "X-Remaining", "reset_header": "X-Reset", "reset_format": "seconds"}}`, nil, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Header().Set("X-Remaining", "0")
+		w.Header().Set("X-Reset", reset)
+		w.Write([]byte(`{"results": [{"id": "1"}]}`))
+	})
+
+	if _, err := rc.Search("login", 5); err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	_, err := rc.Search("login", 5)
+	if err == nil || !strings.Contains(err.Error(), "resets in 1h0m0s") {
+		t.Errorf("err = %v, want the search refused until the reset", err)
+	}
+	if requests != 1 {
+		t.Errorf("made %d requests, want none while rate limited", requests)
+	}
+
+	// A reset within the wait limit is waited for
+	reset = "0"
+	rc.blockedUntil = time.Now().Add(50 * time.Millisecond)
+	if _, err := rc.Search("login", 5); err != nil {
+		t.Errorf("Search after a short wait: %v", err)
+	}
+	if requests != 2 {
+		t.Errorf("made %d requests, want the search sent after the reset", requests)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"", "1s"},
+		{"30", "30s"},
+		{"soon", "1s"},
+		{"Thu, 01 Jan 1970 00:00:00 GMT", "negative"},
+	}
+	for _, test := range tests {
+		got := parseRetryAfter(test.value)
+		if test.want == "negative" {
+			if got >= 0 {
+				t.Errorf("parseRetryAfter(%q) = %s, want a past date", test.value, got)
+			}
+			continue
+		}
+		if got.String() != test.want {
+			t.Errorf("parseRetryAfter(%q) = %s, want %s", test.value, got, test.want)
+		}
+	}
+}
+
+func TestLinearConnector(t *testing.T) {
+	definition, err := LoadRESTConnectorDefinition("linear")
+	if err != nil {
+		t.Fatalf("LoadRESTConnectorDefinition: %v", err)
+	}
+	if definition.DisplayName != "Linear" {
+		t.Errorf("display name = %q", definition.DisplayName)
+	}
+	if _, err := NewRESTConnector(definition, nil); err == nil || !strings.Contains(err.Error(), "REST_LINEAR_API_KEY") {
+		t.Errorf("err = %v, want the missing API key named", err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/graphql" || r.Header.Get("Authorization") != "lin_key" {
+			t.Errorf("unexpected request %s %s (%q)", r.Method, r.URL, r.Header.Get("Authorization"))
+		}
+		var body struct {
+			Query     string                 `json:"query"`
+			Variables map[string]interface{} `json:"variables"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("decoding request: %v", err)
+			return
+		}
+		if body.Variables["term"] != "login" || !strings.Contains(body.Query, "searchIssues") {
+			t.Errorf("request body = %+v", body)
+		}
+
+		// The first page has no cursor, sent as null rather than ""
+		if after, ok := body.Variables["after"]; !ok || after == nil {
+			w.Write([]byte(`{"data": {"searchIssues": {"nodes": [{"identifier": "ENG-1", "title": "Login fails",
+				"description": "Steps to reproduce", "url": "https://linear.app/acme/issue/ENG-1", "updatedAt": "2024-03-04T09:30:00Z",
+				"priorityLabel": "High", "state": {"name": "In Progress"}, "assignee": null, "team": {"name": "Eng"},
+				"labels": {"nodes": [{"name": "bug"}, {"name": "auth"}]}}],
+				"pageInfo": {"hasNextPage": true, "endCursor": "cur-1"}}}}`))
+			return
+		}
+		if body.Variables["after"] != "cur-1" {
+			t.Errorf("after = %v, want the end cursor", body.Variables["after"])
+		}
+		w.Write([]byte(`{"data": {"searchIssues": {"nodes": [{"identifier": "ENG-2", "title": "Logout fails"}],
+			"pageInfo": {"hasNextPage": false, "endCursor": null}}}}`))
+	}))
+	defer server.Close()
+
+	definition.BaseURL = server.URL
+	rc, err := NewRESTConnector(definition, map[string]string{"linear_api_key": "lin_key"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	items, err := rc.Search("login", 10)
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if got := itemIDs(items); got != "ENG-1,ENG-2" {
+		t.Fatalf("found %s", got)
+	}
+	issue := items[0]
+	if issue.Title != "ENG-1: Login fails" || issue.URL != "https://linear.app/acme/issue/ENG-1" || issue.Body != "Steps to reproduce" {
+		t.Errorf("issue = %+v", issue)
+	}
+	want := "Updated: 2024-03-04T09:30:00Z\nLabels: bug, auth\nPriority: High\nStatus: In Progress\nTeam: Eng"
+	if metadata := issue.FormatMetadata(); metadata != want {
+		t.Errorf("metadata = %q, want %q", metadata, want)
+	}
+}
+
+// GraphQL errors come back with a 200 and are surfaced as search errors
+func TestLinearConnectorErrors(t *testing.T) {
+	definition, err := LoadRESTConnectorDefinition("linear")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"errors": [{"message": "Authentication required"}], "data": null}`))
+	}))
+	defer server.Close()
+
+	definition.BaseURL = server.URL
+	rc, err := NewRESTConnector(definition, map[string]string{"linear_api_key": "expired"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rc.Search("login", 10); err == nil || !strings.Contains(err.Error(), "Linear search failed: Authentication required") {
+		t.Errorf("err = %v, want the GraphQL error", err)
+	}
+}
+
+func TestZendeskConnector(t *testing.T) {
+	definition, err := LoadRESTConnectorDefinition("zendesk")
+	if err != nil {
+		t.Fatalf("LoadRESTConnectorDefinition: %v", err)
+	}
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); !ok || user != "ada@example.com/token" || password != "zd_token" {
+			t.Errorf("basic auth = %q, %q", user, password)
+		}
+		if r.URL.Query().Get("page") == "2" {
+			w.Write([]byte(`{"results": [{"id": 13, "subject": "Scanner offline"}], "next_page": null}`))
+			return
+		}
+		query := r.URL.Query()
+		if query.Get("query") != "type:ticket printer" || query.Get("per_page") != "20" || query.Get("sort_by") != "updated_at" {
+			t.Errorf("search query = %s", r.URL.RawQuery)
+		}
+		fmt.Fprintf(w, `{"results": [{"id": 12, "subject": "Printer jammed", "description": "Paper stuck",
+			"updated_at": "2024-03-04T09:30:00Z", "status": "open", "priority": null, "type": "incident",
+			"tags": ["printer", "office"]}], "next_page": "%s/api/v2/search.json?page=2"}`, server.URL)
+	}))
+	defer server.Close()
+
+	definition.BaseURL = server.URL
+	rc, err := NewRESTConnector(definition, map[string]string{
+		"zendesk_subdomain": "acme", "zendesk_email": "ada@example.com", "zendesk_api_token": "zd_token",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	items, err := rc.Search("printer", 10)
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if got := itemIDs(items); got != "12,13" {
+		t.Fatalf("found %s", got)
+	}
+	ticket := items[0]
+	if ticket.Title != "#12: Printer jammed" || ticket.URL != "https://acme.zendesk.com/agent/tickets/12" {
+		t.Errorf("ticket = %+v", ticket)
+	}
+	want := "Updated: 2024-03-04T09:30:00Z\nStatus: open\nTags: printer, office\nType: incident"
+	if metadata := ticket.FormatMetadata(); metadata != want {
+		t.Errorf("metadata = %q, want %q", metadata, want)
+	}
+}
+
+func TestLoadRESTConnectorDefinitionFile(t *testing.T) {
+	if _, err := LoadRESTConnectorDefinition("jira-cloud"); err == nil {
+		t.Errorf("loaded a connector that doesn't exist")
+	}
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"wiki.json":    `{"name": "wiki", "search": {"path": "/search", "items": "$.hits"}}`,
+		"invalid.json": `{"name": "wiki"}`,
+	})
+	definition, err := LoadRESTConnectorDefinition(dir + "/wiki.json")
+	if err != nil || definition.DisplayName != "wiki" {
+		t.Errorf("LoadRESTConnectorDefinition = %+v, %v, want the display name defaulted", definition, err)
+	}
+	if _, err := LoadRESTConnectorDefinition(dir + "/invalid.json"); err == nil {
+		t.Errorf("loaded a definition without search.items")
+	}
+}
@@ -177,4 +177,107 @@ func sortSentencesByScore(sentences []scoredSentence) {
 			}
 		}
 	}
-}
\ No newline at end of file
+}
+// MarkdownSection is a run of Markdown text under one heading
+type MarkdownSection struct {
+	Headings []string // Heading path from the top level down to this section
+	Text     string   // Section body including its heading line
+}
+
+var markdownHeading = regexp.MustCompile(`^(#{1,6})\s+(.*)$`)
+
+// SplitMarkdownSections splits Markdown on heading boundaries, ignoring
+// headings inside fenced code blocks
+func SplitMarkdownSections(markdown string) []MarkdownSection {
+	var sections []MarkdownSection
+	var path []string
+	var levels []int
+	var current strings.Builder
+	inFence := false
+
+	flush := func() {
+		if text := strings.TrimSpace(current.String()); text != "" {
+			sections = append(sections, MarkdownSection{
+				Headings: append([]string(nil), path...),
+				Text:     text,
+			})
+		}
+		current.Reset()
+	}
+
+	for _, line := range strings.Split(markdown, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			inFence = !inFence
+		}
+
+		if match := markdownHeading.FindStringSubmatch(line); match != nil && !inFence {
+			flush()
+			level := len(match[1])
+			for len(levels) > 0 && levels[len(levels)-1] >= level {
+				levels = levels[:len(levels)-1]
+				path = path[:len(path)-1]
+			}
+			levels = append(levels, level)
+			path = append(path, strings.TrimSpace(match[2]))
+		}
+
+		current.WriteString(line)
+		current.WriteString("\n")
+	}
+	flush()
+
+	return sections
+}
+
+// ExtractRelevantMarkdown picks the Markdown sections that best match the query
+// and returns them in document order, keeping their structure intact. Sections
+// too long to fit are reduced with ExtractRelevantSections.
+func ExtractRelevantMarkdown(markdown, query string, maxChars int) string {
+	if len(markdown) <= maxChars {
+		return markdown
+	}
+
+	sections := SplitMarkdownSections(markdown)
+	keywords := extractQueryKeywords(query)
+	if len(sections) <= 1 || len(keywords) == 0 {
+		return ExtractRelevantSections(markdown, query, maxChars)
+	}
+
+	var scored []scoredSentence
+	for i, section := range sections {
+		// Headings count double so a matching heading pulls in its section
+		headingText := strings.Join(section.Headings, " ")
+		score := scoreSentence(section.Text, keywords) + 2*scoreSentence(headingText+" "+headingText, keywords)
+		scored = append(scored, scoredSentence{Text: section.Text, Score: score, Index: i})
+	}
+	sortSentencesByScore(scored)
+
+	selected := make(map[int]string)
+	totalChars := 0
+	for _, section := range scored {
+		if section.Score <= 0 && len(selected) > 0 {
+			break
+		}
+
+		text := section.Text
+		remaining := maxChars - totalChars
+		if remaining < 200 {
+			break
+		}
+		if len(text) > remaining {
+			text = ExtractRelevantSections(text, query, remaining)
+		}
+
+		selected[section.Index] = text
+		totalChars += len(text) + 2
+	}
+
+	var parts []string
+	for i := range sections {
+		if text, ok := selected[i]; ok {
+			parts = append(parts, text)
+		}
+	}
+
+	return strings.Join(parts, "\n\n")
+}
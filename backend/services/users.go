@@ -0,0 +1,516 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultTenant owns everything created without a signed-in user, and the
+// documents of deployment-wide connectors such as local files and the crawler
+const DefaultTenant = "default"
+
+const (
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+)
+
+// pbkdf2Iterations follows OWASP's recommendation for PBKDF2-HMAC-SHA256
+const pbkdf2Iterations = 600000
+
+var (
+	ErrInvalidCredentials = errors.New("invalid email or password")
+	ErrUserExists         = errors.New("a user with this email already exists")
+	ErrUserNotFound       = errors.New("user not found")
+	ErrLocalAccount       = errors.New("this email belongs to a password account")
+)
+
+// User is an account of the chatbot. Local users sign in with a password;
+// OIDC users are created on their first login. Service accounts never sign
+// in: tools and scripts act as them with API keys.
+type User struct {
+	ID           string            `json:"id"`
+	Tenant       string            `json:"tenant"`
+	Email        string            `json:"email"` // Empty for service accounts
+	Name         string            `json:"name"`
+	Role         string            `json:"role"`
+	Service      bool              `json:"service,omitempty"`
+	PasswordHash string            `json:"password_hash,omitempty"`
+	Issuer       string            `json:"issuer,omitempty"` // OIDC issuer and subject of external users
+	Subject      string            `json:"subject,omitempty"`
+	Groups       []string          `json:"groups,omitempty"`
+	Settings     map[string]string `json:"settings,omitempty"`
+	Created      time.Time         `json:"created"`
+	LastLogin    time.Time         `json:"last_login,omitempty"`
+}
+
+// IsAdmin reports whether the user administers their tenant
+func (u *User) IsAdmin() bool {
+	return u.Role == RoleAdmin
+}
+
+type userSession struct {
+	UserID  string    `json:"user_id"`
+	Expires time.Time `json:"expires"`
+}
+
+// UserStore keeps users and their login sessions, persisted as JSON.
+// Sessions are stored as hashes of the cookie value.
+type UserStore struct {
+	SessionTTL time.Duration
+
+	path     string
+	mu       sync.Mutex
+	users    map[string]*User
+	sessions map[string]*userSession
+}
+
+type userStoreFile struct {
+	Users    []*User                 `json:"users"`
+	Sessions map[string]*userSession `json:"sessions"`
+}
+
+// NewUserStore opens the store at path; an empty path keeps it in memory
+func NewUserStore(path string, sessionTTL time.Duration) (*UserStore, error) {
+	if sessionTTL == 0 {
+		sessionTTL = 7 * 24 * time.Hour
+	}
+	store := &UserStore{
+		SessionTTL: sessionTTL,
+		path:       path,
+		users:      make(map[string]*User),
+		sessions:   make(map[string]*userSession),
+	}
+
+	if path == "" {
+		return store, nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read users: %v", err)
+	}
+
+	var file userStoreFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse users: %v", err)
+	}
+	for _, user := range file.Users {
+		store.users[user.ID] = user
+	}
+	if file.Sessions != nil {
+		store.sessions = file.Sessions
+	}
+	return store, nil
+}
+
+// Count returns the number of users
+func (us *UserStore) Count() int {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+	return len(us.users)
+}
+
+// CreateLocalUser adds a user who signs in with a password
+func (us *UserStore) CreateLocalUser(tenant, email, name, password, role string) (*User, error) {
+	email = normalizeEmail(email)
+	if email == "" || tenant == "" {
+		return nil, errors.New("email and tenant are required")
+	}
+	if len(password) < 8 {
+		return nil, errors.New("password must be at least 8 characters")
+	}
+	if role != RoleAdmin {
+		role = RoleMember
+	}
+
+	hash, err := hashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	us.mu.Lock()
+	defer us.mu.Unlock()
+
+	if us.findByEmail(email) != nil {
+		return nil, ErrUserExists
+	}
+	user := &User{
+		Tenant:       tenant,
+		Email:        email,
+		Name:         name,
+		Role:         role,
+		PasswordHash: hash,
+		Created:      time.Now().UTC(),
+	}
+	if user.ID, err = randomID(); err != nil {
+		return nil, err
+	}
+	us.users[user.ID] = user
+
+	return copyUser(user), us.save()
+}
+
+// CreateServiceAccount adds an account for tools and scripts, which act as
+// it with API keys and connect their own accounts for it
+func (us *UserStore) CreateServiceAccount(tenant, name, role string) (*User, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || tenant == "" {
+		return nil, errors.New("name and tenant are required")
+	}
+	if role != RoleAdmin {
+		role = RoleMember
+	}
+
+	id, err := randomID()
+	if err != nil {
+		return nil, err
+	}
+	user := &User{
+		ID:      id,
+		Tenant:  tenant,
+		Name:    name,
+		Role:    role,
+		Service: true,
+		Created: time.Now().UTC(),
+	}
+
+	us.mu.Lock()
+	defer us.mu.Unlock()
+	us.users[id] = user
+	return copyUser(user), us.save()
+}
+
+// Authenticate checks a local user's password
+func (us *UserStore) Authenticate(email, password string) (*User, error) {
+	us.mu.Lock()
+	user := us.findByEmail(normalizeEmail(email))
+	hash := ""
+	if user != nil {
+		hash = user.PasswordHash
+	}
+	us.mu.Unlock()
+
+	// Check a hash even for unknown emails so timing doesn't reveal which exist
+	if user == nil || hash == "" {
+		checkPassword(password, dummyPasswordHash)
+		return nil, ErrInvalidCredentials
+	}
+	if !checkPassword(password, hash) {
+		return nil, ErrInvalidCredentials
+	}
+	return copyUser(user), nil
+}
+
+// UpsertExternalUser creates or updates the user for an OIDC identity. The
+// identity provider's email, name and groups win over stored values.
+func (us *UserStore) UpsertExternalUser(issuer, subject, email, name, tenant, role string, groups []string) (*User, error) {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+
+	var user *User
+	for _, candidate := range us.users {
+		if candidate.Issuer == issuer && candidate.Subject == subject {
+			user = candidate
+			break
+		}
+	}
+	if user == nil {
+		if existing := us.findByEmail(normalizeEmail(email)); existing != nil && existing.Issuer == "" {
+			return nil, ErrLocalAccount
+		}
+		id, err := randomID()
+		if err != nil {
+			return nil, err
+		}
+		user = &User{ID: id, Issuer: issuer, Subject: subject, Created: time.Now().UTC()}
+		us.users[id] = user
+	}
+
+	user.Email = normalizeEmail(email)
+	user.Name = name
+	user.Tenant = tenant
+	user.Role = role
+	user.Groups = groups
+	return copyUser(user), us.save()
+}
+
+// Get returns a user by ID
+func (us *UserStore) Get(id string) (*User, bool) {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+
+	user, ok := us.users[id]
+	if !ok {
+		return nil, false
+	}
+	return copyUser(user), true
+}
+
+// List returns a tenant's users
+func (us *UserStore) List(tenant string) []*User {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+
+	users := []*User{}
+	for _, user := range us.users {
+		if user.Tenant == tenant {
+			users = append(users, copyUser(user))
+		}
+	}
+	// Service accounts, which have no email, come after people
+	sort.Slice(users, func(i, j int) bool {
+		if users[i].Service != users[j].Service {
+			return users[j].Service
+		}
+		if users[i].Email != users[j].Email {
+			return users[i].Email < users[j].Email
+		}
+		return users[i].Name < users[j].Name
+	})
+	return users
+}
+
+// Delete removes a user of a tenant and signs them out everywhere
+func (us *UserStore) Delete(tenant, id string) error {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+
+	user, ok := us.users[id]
+	if !ok || user.Tenant != tenant {
+		return ErrUserNotFound
+	}
+	delete(us.users, id)
+	for key, session := range us.sessions {
+		if session.UserID == id {
+			delete(us.sessions, key)
+		}
+	}
+	return us.save()
+}
+
+// UpdateSettings merges settings into a user's settings; empty values remove keys
+func (us *UserStore) UpdateSettings(id string, settings map[string]string) (map[string]string, error) {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+
+	user, ok := us.users[id]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	if user.Settings == nil {
+		user.Settings = map[string]string{}
+	}
+	for key, value := range settings {
+		if value == "" {
+			delete(user.Settings, key)
+		} else {
+			user.Settings[key] = value
+		}
+	}
+
+	updated := make(map[string]string, len(user.Settings))
+	for key, value := range user.Settings {
+		updated[key] = value
+	}
+	return updated, us.save()
+}
+
+// StartSession signs a user in on the browser session with the given ID
+func (us *UserStore) StartSession(session, userID string) error {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+
+	user, ok := us.users[userID]
+	if !ok {
+		return ErrUserNotFound
+	}
+	now := time.Now().UTC()
+	user.LastLogin = now
+
+	// Drop expired sessions while we're here
+	for key, existing := range us.sessions {
+		if now.After(existing.Expires) {
+			delete(us.sessions, key)
+		}
+	}
+	us.sessions[hashSession(session)] = &userSession{UserID: userID, Expires: now.Add(us.SessionTTL)}
+	return us.save()
+}
+
+// SessionUser returns the user signed in on a browser session
+func (us *UserStore) SessionUser(session string) (*User, bool) {
+	if session == "" {
+		return nil, false
+	}
+
+	us.mu.Lock()
+	defer us.mu.Unlock()
+
+	existing, ok := us.sessions[hashSession(session)]
+	if !ok || time.Now().After(existing.Expires) {
+		return nil, false
+	}
+	user, ok := us.users[existing.UserID]
+	if !ok {
+		return nil, false
+	}
+	return copyUser(user), true
+}
+
+// EndSession signs a browser session out
+func (us *UserStore) EndSession(session string) error {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+
+	delete(us.sessions, hashSession(session))
+	return us.save()
+}
+
+// findByEmail looks a user up by normalized email. Callers hold mu.
+func (us *UserStore) findByEmail(email string) *User {
+	if email == "" {
+		return nil // Service accounts have no email
+	}
+	for _, user := range us.users {
+		if user.Email == email {
+			return user
+		}
+	}
+	return nil
+}
+
+// save writes the store atomically. Callers hold mu.
+func (us *UserStore) save() error {
+	if us.path == "" {
+		return nil
+	}
+
+	file := userStoreFile{Sessions: us.sessions}
+	for _, user := range us.users {
+		file.Users = append(file.Users, user)
+	}
+	sort.Slice(file.Users, func(i, j int) bool { return file.Users[i].ID < file.Users[j].ID })
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(us.path), 0o700); err != nil {
+		return err
+	}
+	tmp := us.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, us.path)
+}
+
+func copyUser(user *User) *User {
+	copied := *user
+	copied.Groups = append([]string(nil), user.Groups...)
+	copied.Settings = make(map[string]string, len(user.Settings))
+	for key, value := range user.Settings {
+		copied.Settings[key] = value
+	}
+	return &copied
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func hashSession(session string) string {
+	sum := sha256.Sum256([]byte(session))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func randomID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// Password hashing
+
+// dummyPasswordHash is checked for unknown emails so they take as long as known ones
+var dummyPasswordHash, _ = hashPassword("not a real password")
+
+// hashPassword returns "pbkdf2-sha256$iterations$salt$hash"
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := pbkdf2SHA256([]byte(password), salt, pbkdf2Iterations, 32)
+	return fmt.Sprintf("pbkdf2-sha256$%d$%s$%s", pbkdf2Iterations,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func checkPassword(password, encoded string) bool {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 4 || parts[0] != "pbkdf2-sha256" {
+		return false
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations < 1 {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	expected, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+
+	key := pbkdf2SHA256([]byte(password), salt, iterations, len(expected))
+	return subtle.ConstantTimeCompare(key, expected) == 1
+}
+
+// pbkdf2SHA256 derives a key with PBKDF2-HMAC-SHA256 (RFC 8018)
+func pbkdf2SHA256(password, salt []byte, iterations, keyLength int) []byte {
+	prf := hmac.New(sha256.New, password)
+	hashLength := prf.Size()
+	blocks := (keyLength + hashLength - 1) / hashLength
+
+	key := make([]byte, 0, blocks*hashLength)
+	u := make([]byte, hashLength)
+	for block := 1; block <= blocks; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		prf.Write([]byte{byte(block >> 24), byte(block >> 16), byte(block >> 8), byte(block)})
+		u = prf.Sum(u[:0])
+
+		t := make([]byte, hashLength)
+		copy(t, u)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLength]
+}
@@ -0,0 +1,256 @@
+package services
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// vaultSessionTTL drops credentials of anonymous browser sessions that
+// haven't used or connected anything for this long. Signed-in users' and
+// service accounts' credentials are kept until they're removed.
+const vaultSessionTTL = 30 * 24 * time.Hour
+
+// userOwnerPrefix marks vault owners that are users rather than browser sessions
+const userOwnerPrefix = "user:"
+
+// UserOwner returns the vault owner holding a user's credentials
+func UserOwner(userID string) string {
+	return userOwnerPrefix + userID
+}
+
+// StoredToken is an OAuth credential kept in the vault
+type StoredToken struct {
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	TokenType    string    `json:"token_type,omitempty"`
+	Expiry       time.Time `json:"expiry,omitempty"` // Zero when the provider didn't say
+}
+
+// NewStoredToken builds a StoredToken from a token response's expires_in
+func NewStoredToken(accessToken, refreshToken, tokenType string, expiresIn int) StoredToken {
+	token := StoredToken{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    tokenType,
+	}
+	if expiresIn > 0 {
+		token.Expiry = time.Now().Add(time.Duration(expiresIn) * time.Second)
+	}
+	return token
+}
+
+// TokenVault keeps OAuth tokens server-side, encrypted with AES-GCM and
+// keyed by user or browser session, so the browser only ever holds a session
+// cookie. Session keys are stored as hashes, so the vault file alone can't be
+// used to impersonate a browser.
+type TokenVault struct {
+	Path string // File the vault is persisted to; empty keeps it in memory
+
+	aead    cipher.AEAD
+	mu      sync.Mutex
+	entries map[string]*vaultSession // session hash -> credentials
+}
+
+type vaultSession struct {
+	Tokens  map[string]string `json:"tokens"` // provider -> base64(nonce + sealed StoredToken)
+	Updated time.Time         `json:"updated"`
+	User    bool              `json:"user,omitempty"` // Owned by a user, so it never expires
+}
+
+// NewTokenVault opens the vault at path with a base64-encoded 32-byte key.
+// Without a key an ephemeral one is generated, and tokens don't survive a
+// restart.
+func NewTokenVault(path, encodedKey string) (*TokenVault, error) {
+	var key []byte
+	if encodedKey == "" {
+		log.Println("Warning: VAULT_KEY not set, connected accounts will be lost on restart")
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		path = "" // Nothing written with a throwaway key could be read back
+	} else {
+		var err error
+		key, err = base64.StdEncoding.DecodeString(encodedKey)
+		if err != nil || len(key) != 32 {
+			return nil, errors.New("VAULT_KEY must be 32 bytes, base64-encoded")
+		}
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	vault := &TokenVault{
+		Path:    path,
+		aead:    aead,
+		entries: make(map[string]*vaultSession),
+	}
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if err == nil {
+			if err := json.Unmarshal(data, &vault.entries); err != nil {
+				return nil, fmt.Errorf("failed to read token vault: %v", err)
+			}
+		}
+	}
+
+	return vault, nil
+}
+
+// Put stores a provider's token for a session
+func (tv *TokenVault) Put(session, provider string, token StoredToken) error {
+	plaintext, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, tv.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	key := sessionKey(session)
+	// Binding the ciphertext to its session and provider stops it being
+	// copied to another entry in the file
+	sealed := tv.aead.Seal(nonce, nonce, plaintext, []byte(key+"/"+provider))
+
+	tv.mu.Lock()
+	defer tv.mu.Unlock()
+
+	entry, ok := tv.entries[key]
+	if !ok {
+		entry = &vaultSession{Tokens: make(map[string]string)}
+		tv.entries[key] = entry
+	}
+	entry.Tokens[provider] = base64.StdEncoding.EncodeToString(sealed)
+	entry.Updated = time.Now().UTC()
+	entry.User = strings.HasPrefix(session, userOwnerPrefix)
+
+	return tv.save()
+}
+
+// Get returns a session's token for a provider
+func (tv *TokenVault) Get(session, provider string) (StoredToken, bool) {
+	if session == "" {
+		return StoredToken{}, false
+	}
+	key := sessionKey(session)
+
+	tv.mu.Lock()
+	entry, ok := tv.entries[key]
+	var encoded string
+	if ok {
+		encoded, ok = entry.Tokens[provider]
+		// A session in use isn't stale; this is persisted with the next save
+		entry.Updated = time.Now().UTC()
+	}
+	tv.mu.Unlock()
+	if !ok {
+		return StoredToken{}, false
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < tv.aead.NonceSize() {
+		return StoredToken{}, false
+	}
+	nonce, ciphertext := sealed[:tv.aead.NonceSize()], sealed[tv.aead.NonceSize():]
+	plaintext, err := tv.aead.Open(nil, nonce, ciphertext, []byte(key+"/"+provider))
+	if err != nil {
+		log.Printf("Failed to decrypt %s token: %v", provider, err)
+		return StoredToken{}, false
+	}
+
+	var token StoredToken
+	if err := json.Unmarshal(plaintext, &token); err != nil {
+		return StoredToken{}, false
+	}
+	return token, true
+}
+
+// Delete removes a session's token for a provider
+func (tv *TokenVault) Delete(session, provider string) error {
+	tv.mu.Lock()
+	defer tv.mu.Unlock()
+
+	key := sessionKey(session)
+	entry, ok := tv.entries[key]
+	if !ok {
+		return nil
+	}
+	delete(entry.Tokens, provider)
+	if len(entry.Tokens) == 0 {
+		delete(tv.entries, key)
+	}
+	return tv.save()
+}
+
+// Providers lists the providers a session has connected
+func (tv *TokenVault) Providers(session string) []string {
+	tv.mu.Lock()
+	defer tv.mu.Unlock()
+
+	providers := []string{}
+	if entry, ok := tv.entries[sessionKey(session)]; ok {
+		for provider := range entry.Tokens {
+			providers = append(providers, provider)
+		}
+	}
+	sort.Strings(providers)
+	return providers
+}
+
+// save writes the vault atomically, dropping stale anonymous sessions.
+// Callers hold mu.
+func (tv *TokenVault) save() error {
+	cutoff := time.Now().Add(-vaultSessionTTL)
+	for key, entry := range tv.entries {
+		if !entry.User && entry.Updated.Before(cutoff) {
+			delete(tv.entries, key)
+		}
+	}
+
+	if tv.Path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(tv.entries)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(tv.Path), 0o700); err != nil {
+		return err
+	}
+	tmp := tv.Path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, tv.Path)
+}
+
+func sessionKey(session string) string {
+	sum := sha256.Sum256([]byte(session))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,126 @@
+package services
+
+import (
+	"encoding/base64"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testVaultKey = base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+
+func TestVaultIsolatesOwners(t *testing.T) {
+	vault, err := NewTokenVault("", "")
+	if err != nil {
+		t.Fatalf("NewTokenVault: %v", err)
+	}
+	alice, bob := UserOwner("alice"), UserOwner("bob")
+	if err := vault.Put(alice, "github", StoredToken{AccessToken: "alice-token"}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	if token, ok := vault.Get(alice, "github"); !ok || token.AccessToken != "alice-token" {
+		t.Errorf("alice's token = %+v, %t", token, ok)
+	}
+	for _, owner := range []string{bob, "alice", "session-a", ""} {
+		if token, ok := vault.Get(owner, "github"); ok {
+			t.Errorf("%q read alice's token %+v", owner, token)
+		}
+		if providers := vault.Providers(owner); len(providers) != 0 {
+			t.Errorf("%q sees alice's providers %v", owner, providers)
+		}
+	}
+
+	// Ciphertext copied into another owner's entry doesn't decrypt there
+	if err := vault.Put(bob, "github", StoredToken{AccessToken: "bob-token"}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	vault.entries[sessionKey(bob)].Tokens["github"] = vault.entries[sessionKey(alice)].Tokens["github"]
+	if token, ok := vault.Get(bob, "github"); ok {
+		t.Errorf("bob decrypted alice's copied token %+v", token)
+	}
+
+	if err := vault.Delete(bob, "github"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, ok := vault.Get(alice, "github"); !ok {
+		t.Errorf("deleting bob's token removed alice's")
+	}
+}
+
+func TestVaultExpiresOnlyAnonymousSessions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vault.json")
+	vault, err := NewTokenVault(path, testVaultKey)
+	if err != nil {
+		t.Fatalf("NewTokenVault: %v", err)
+	}
+	owners := []string{UserOwner("alice"), UserOwner("service-account"), "session-a", "session-b"}
+	for _, owner := range owners {
+		if err := vault.Put(owner, "confluence", StoredToken{AccessToken: owner}); err != nil {
+			t.Fatalf("Put(%s): %v", owner, err)
+		}
+	}
+
+	// Every entry was last written long ago; session-b has been used since
+	stale := time.Now().Add(-vaultSessionTTL - time.Hour)
+	for _, owner := range owners {
+		vault.entries[sessionKey(owner)].Updated = stale
+	}
+	if _, ok := vault.Get("session-b", "confluence"); !ok {
+		t.Fatalf("session-b's token is missing")
+	}
+	if err := vault.Put("session-c", "github", StoredToken{AccessToken: "new"}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	reopened, err := NewTokenVault(path, testVaultKey)
+	if err != nil {
+		t.Fatalf("reopening the vault: %v", err)
+	}
+	for _, owner := range owners {
+		_, ok := reopened.Get(owner, "confluence")
+		if want := owner != "session-a"; ok != want {
+			t.Errorf("%s kept its token: %t, want %t", owner, ok, want)
+		}
+	}
+}
+
+func TestConversationsIsolateUsers(t *testing.T) {
+	store, err := NewConversationStore(filepath.Join(t.TempDir(), "history.json"))
+	if err != nil {
+		t.Fatalf("NewConversationStore: %v", err)
+	}
+	id, err := store.Append("acme", "alice", "", ConversationMessage{Role: "user", Content: "What's our salary band?"})
+	if err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+
+	others := []struct{ tenant, user string }{
+		{"acme", "bob"},
+		{"globex", "alice"}, // Same user ID in another tenant
+	}
+	for _, other := range others {
+		who := other.tenant + "/" + other.user
+		if list := store.List(other.tenant, other.user); len(list) != 0 {
+			t.Errorf("%s lists alice's conversations %+v", who, list)
+		}
+		if conversation, ok := store.Get(other.tenant, other.user, id); ok {
+			t.Errorf("%s read alice's conversation %+v", who, conversation)
+		}
+		if _, err := store.Append(other.tenant, other.user, id, ConversationMessage{Role: "user", Content: "hijack"}); err != ErrConversationNotFound {
+			t.Errorf("%s appended to alice's conversation: %v", who, err)
+		}
+		if err := store.Delete(other.tenant, other.user, id); err != ErrConversationNotFound {
+			t.Errorf("%s deleted alice's conversation: %v", who, err)
+		}
+		if err := store.DeleteUser(other.tenant, other.user); err != nil {
+			t.Fatalf("DeleteUser: %v", err)
+		}
+	}
+
+	conversation, ok := store.Get("acme", "alice", id)
+	if !ok || len(conversation.Messages) != 1 || strings.Contains(conversation.Messages[0].Content, "hijack") {
+		t.Errorf("alice's conversation = %+v, %t", conversation, ok)
+	}
+}
@@ -1,40 +1,84 @@
-import React, { useState } from 'react';
+import React, { useEffect, useState } from 'react';
 import Chat from './components/Chat';
 import Settings from './components/Settings';
-import { ApiKeys } from './types';
+import Login from './components/Login';
+import { Connections, Me } from './types';
 import './App.css';
 
 
 const App: React.FC = () => {
-  const [apiKeys, setApiKeys] = useState<ApiKeys>({
-    confluence: sessionStorage.getItem('confluence_token') || '',
-    slack: sessionStorage.getItem('slack_token') || '',
-    gmail: sessionStorage.getItem('gmail_token') || ''
+  // undefined while loading
+  const [me, setMe] = useState<Me | undefined>(undefined);
+  const needsLogin = !!me && me.auth_mode !== 'none' && !me.user;
+  const [connections, setConnections] = useState<Connections>({
+    confluence: false,
+    slack: false,
+    gmail: false,
+    github: false,
+    drive: false,
+    notion: false,
+    microsoft: false
   });
 
-  const updateApiKeys = (keys: Partial<ApiKeys>) => {
-    const newKeys = { ...apiKeys, ...keys };
-    setApiKeys(newKeys);
-    
-    Object.entries(keys).forEach(([key, value]) => {
-      if (value) {
-        sessionStorage.setItem(`${key}_token`, value);
-      } else {
-        sessionStorage.removeItem(`${key}_token`);
-      }
-    });
+  // The session cookie identifies us; find out who we are
+  useEffect(() => {
+    fetch('/api/me')
+      .then(response => response.json())
+      .then(data => setMe(data))
+      .catch(error => console.error('Failed to load user:', error));
+  }, []);
+
+  // ...and what's connected, once signed in
+  useEffect(() => {
+    if (!me || needsLogin) return;
+    fetch('/api/auth/connections')
+      .then(response => response.json())
+      .then(data => setConnections(prev => ({ ...prev, ...data.connected })))
+      .catch(error => console.error('Failed to load connections:', error));
+  }, [me, needsLogin]);
+
+  const updateConnections = (changes: Partial<Connections>) => {
+    setConnections(prev => ({ ...prev, ...changes }));
+  };
+
+  const disconnect = async (source: keyof Connections) => {
+    try {
+      await fetch(`/api/auth/connections?provider=${source}`, { method: 'DELETE' });
+      updateConnections({ [source]: false });
+    } catch (error) {
+      console.error('Failed to disconnect:', error);
+    }
+  };
+
+  const logout = async () => {
+    try {
+      await fetch('/api/auth/logout', { method: 'POST' });
+    } catch (error) {
+      console.error('Failed to sign out:', error);
+    }
+    setMe(prev => prev && { auth_mode: prev.auth_mode });
   };
 
   return (
     <div className="app">
       <header className="app-header">
         <h1>RAG Chatbot</h1>
+        {me?.user && (
+          <div className="app-user">
+            <span>{me.user.name || me.user.email}</span>
+            <button onClick={logout} className="logout-button">Sign out</button>
+          </div>
+        )}
       </header>
       
-      <main className="app-main">
-        <Settings apiKeys={apiKeys} onUpdateKeys={updateApiKeys} />
-        <Chat apiKeys={apiKeys} />
-      </main>
+      {needsLogin ? (
+        <Login authMode={me!.auth_mode} onLogin={setMe} />
+      ) : me && (
+        <main className="app-main">
+          <Settings connections={connections} onUpdateConnections={updateConnections} onDisconnect={disconnect} />
+          <Chat key={me.user?.id} connections={connections} />
+        </main>
+      )}
     </div>
   );
 };
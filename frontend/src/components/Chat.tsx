@@ -1,16 +1,18 @@
 import React, { useState, useRef, useEffect } from 'react';
-import { ApiKeys, ChatMessage, Reference } from '../types';
+import { Connections, ChatMessage, Reference } from '../types';
 import MessageBubble from './MessageBubble';
 import './Chat.css';
 
 interface ChatProps {
-  apiKeys: ApiKeys;
+  connections: Connections;
 }
 
-const Chat: React.FC<ChatProps> = ({ apiKeys }) => {
+const Chat: React.FC<ChatProps> = ({ connections }) => {
   const [messages, setMessages] = useState<ChatMessage[]>([]);
   const [input, setInput] = useState('');
   const [isLoading, setIsLoading] = useState(false);
+  // Set by the server once it records the conversation (signed-in users only)
+  const [conversationId, setConversationId] = useState<string | undefined>(undefined);
   const messagesEndRef = useRef<HTMLDivElement>(null);
   const streamingMessageRef = useRef<HTMLDivElement>(null);
 
@@ -56,17 +58,31 @@ const Chat: React.FC<ChatProps> = ({ apiKeys }) => {
         },
         body: JSON.stringify({
           query: userMessage.content,
-          confluence_token: apiKeys.confluence,
-          slack_token: apiKeys.slack,
-          gmail_token: apiKeys.gmail,
+          conversation_id: conversationId,
           sources: {
-            confluence: apiKeys.confluence ? 'enabled' : 'disabled',
-            slack: apiKeys.slack ? 'enabled' : 'disabled',
-            gmail: apiKeys.gmail ? 'enabled' : 'disabled',
+            confluence: connections.confluence ? 'enabled' : 'disabled',
+            slack: connections.slack ? 'enabled' : 'disabled',
+            gmail: connections.gmail ? 'enabled' : 'disabled',
+            github: connections.github ? 'enabled' : 'disabled',
+            drive: connections.drive ? 'enabled' : 'disabled',
+            notion: connections.notion ? 'enabled' : 'disabled',
+            microsoft: connections.microsoft ? 'enabled' : 'disabled',
           },
         }),
       });
 
+      if (response.status === 429) {
+        // Rate limited or out of quota; the server says why
+        const message = (await response.text()).trim();
+        setMessages(prev =>
+          prev.map(msg =>
+            msg.id === botMessageId
+              ? { ...msg, content: message, isStreaming: false }
+              : msg
+          )
+        );
+        return;
+      }
       if (!response.ok) {
         throw new Error('Failed to get response');
       }
@@ -153,6 +169,8 @@ const Chat: React.FC<ChatProps> = ({ apiKeys }) => {
                 );
                 setIsLoading(false);
                 break;
+              } else if (event.type === 'conversation') {
+                setConversationId(event.id);
               } else if (event.type === 'status') {
                 console.log('Status update:', event.message);
               }
@@ -184,16 +202,16 @@ const Chat: React.FC<ChatProps> = ({ apiKeys }) => {
     }
   };
 
-  const hasActiveConnections = Object.values(apiKeys).some(key => key.length > 0);
+  const hasActiveConnections = Object.values(connections).some(connected => connected);
 
   return (
     <div className="chat">
       <div className="chat-header">
         <h2>Chat</h2>
         <div className="active-sources">
-          {apiKeys.confluence && <span className="source-badge">Confluence</span>}
-          {apiKeys.slack && <span className="source-badge">Slack</span>}
-          {apiKeys.gmail && <span className="source-badge">Gmail</span>}
+          {connections.confluence && <span className="source-badge">Confluence</span>}
+          {connections.slack && <span className="source-badge">Slack</span>}
+          {connections.gmail && <span className="source-badge">Gmail</span>}
           {!hasActiveConnections && <span className="no-sources">No data sources connected</span>}
         </div>
       </div>
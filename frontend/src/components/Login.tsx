@@ -0,0 +1,85 @@
+import React, { useState } from 'react';
+import { Me } from '../types';
+import './Login.css';
+
+interface LoginProps {
+  authMode: string;
+  onLogin: (me: Me) => void;
+}
+
+const Login: React.FC<LoginProps> = ({ authMode, onLogin }) => {
+  const [email, setEmail] = useState('');
+  const [password, setPassword] = useState('');
+  const [error, setError] = useState('');
+  const [isLoading, setIsLoading] = useState(false);
+
+  const handleSubmit = async (e: React.FormEvent) => {
+    e.preventDefault();
+    setIsLoading(true);
+    setError('');
+
+    try {
+      const response = await fetch('/api/auth/login', {
+        method: 'POST',
+        headers: {
+          'Content-Type': 'application/json',
+        },
+        body: JSON.stringify({ email, password }),
+      });
+
+      if (!response.ok) {
+        setError((await response.text()).trim() || 'Sign in failed');
+        return;
+      }
+      onLogin(await response.json());
+    } catch (error) {
+      setError('Sign in failed. Please try again.');
+    } finally {
+      setIsLoading(false);
+    }
+  };
+
+  // The identity provider sends the browser back here once signed in
+  if (authMode === 'oidc') {
+    return (
+      <div className="login">
+        <div className="login-form">
+          <h2>Sign in</h2>
+          <button onClick={() => { window.location.href = '/api/auth/sso'; }}>
+            Sign in with SSO
+          </button>
+        </div>
+      </div>
+    );
+  }
+
+  return (
+    <div className="login">
+      <form className="login-form" onSubmit={handleSubmit}>
+        <h2>Sign in</h2>
+        <input
+          type="email"
+          placeholder="Email"
+          value={email}
+          onChange={(e) => setEmail(e.target.value)}
+          autoComplete="username"
+          required
+        />
+        <input
+          type="password"
+          placeholder="Password"
+          value={password}
+          onChange={(e) => setPassword(e.target.value)}
+          autoComplete="current-password"
+          required
+        />
+        {error && <div className="login-error">{error}</div>}
+        <button type="submit" disabled={isLoading}>
+          {isLoading ? 'Signing in...' : 'Sign in'}
+        </button>
+      </form>
+    </div>
+  );
+};
+
+export default Login;
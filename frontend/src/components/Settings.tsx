@@ -1,24 +1,21 @@
 import React, { useState } from 'react';
-import { ApiKeys } from '../types';
+import { Connections } from '../types';
 import './Settings.css';
 
 interface SettingsProps {
-  apiKeys: ApiKeys;
-  onUpdateKeys: (keys: Partial<ApiKeys>) => void;
+  connections: Connections;
+  onUpdateConnections: (changes: Partial<Connections>) => void;
+  onDisconnect: (source: keyof Connections) => void;
 }
 
-const Settings: React.FC<SettingsProps> = ({ apiKeys, onUpdateKeys }) => {
+const Settings: React.FC<SettingsProps> = ({ connections, onUpdateConnections, onDisconnect }) => {
   const [isExpanded, setIsExpanded] = useState(false);
 
-  const handleKeyChange = (source: keyof ApiKeys, value: string) => {
-    onUpdateKeys({ [source]: value });
+  const getConnectionStatus = (connected: boolean) => {
+    return connected ? 'connected' : 'disconnected';
   };
 
-  const getConnectionStatus = (key: string) => {
-    return key ? 'connected' : 'disconnected';
-  };
-
-  const handleOAuthConnect = async (source: keyof ApiKeys) => {
+  const handleOAuthConnect = async (source: keyof Connections) => {
     try {
       let authUrl = '';
       let successMessageType = '';
@@ -38,6 +35,26 @@ const Settings: React.FC<SettingsProps> = ({ apiKeys, onUpdateKeys }) => {
         const data = await response.json();
         authUrl = data.auth_url;
         successMessageType = 'SLACK_AUTH_SUCCESS';
+      } else if (source === 'github') {
+        const response = await fetch('/api/auth/github');
+        const data = await response.json();
+        authUrl = data.auth_url;
+        successMessageType = 'GITHUB_AUTH_SUCCESS';
+      } else if (source === 'drive') {
+        const response = await fetch('/api/auth/drive');
+        const data = await response.json();
+        authUrl = data.auth_url;
+        successMessageType = 'DRIVE_AUTH_SUCCESS';
+      } else if (source === 'notion') {
+        const response = await fetch('/api/auth/notion');
+        const data = await response.json();
+        authUrl = data.auth_url;
+        successMessageType = 'NOTION_AUTH_SUCCESS';
+      } else if (source === 'microsoft') {
+        const response = await fetch('/api/auth/microsoft');
+        const data = await response.json();
+        authUrl = data.auth_url;
+        successMessageType = 'MICROSOFT_AUTH_SUCCESS';
       }
 
       if (!authUrl) return;
@@ -51,13 +68,14 @@ const Settings: React.FC<SettingsProps> = ({ apiKeys, onUpdateKeys }) => {
 
       // Listen for messages from the popup
       const handleMessage = (event: MessageEvent) => {
-        // Allow messages from localhost (development)
-        if (!event.origin.includes('localhost')) {
+        // Only the popup we opened can report a connection
+        if (event.source !== popup) {
           return;
         }
         
+        // The token itself stays on the server, under our session cookie
         if (event.data.type === successMessageType) {
-          onUpdateKeys({ [source]: event.data.token });
+          onUpdateConnections({ [source]: true });
           window.removeEventListener('message', handleMessage);
           popup?.close();
         }
@@ -91,15 +109,15 @@ const Settings: React.FC<SettingsProps> = ({ apiKeys, onUpdateKeys }) => {
           <div className="source-setting">
             <div className="source-header">
               <span className="source-name">Confluence</span>
-              <span className={`status ${getConnectionStatus(apiKeys.confluence)}`}>
-                {getConnectionStatus(apiKeys.confluence)}
+              <span className={`status ${getConnectionStatus(connections.confluence)}`}>
+                {getConnectionStatus(connections.confluence)}
               </span>
             </div>
-            {apiKeys.confluence ? (
+            {connections.confluence ? (
               <div className="connected-info">
                 <span className="connected-text">✓ Connected to Confluence</span>
                 <button 
-                  onClick={() => handleKeyChange('confluence', '')}
+                  onClick={() => onDisconnect('confluence')}
                   className="disconnect-button"
                 >
                   Disconnect
@@ -118,15 +136,15 @@ const Settings: React.FC<SettingsProps> = ({ apiKeys, onUpdateKeys }) => {
           <div className="source-setting">
             <div className="source-header">
               <span className="source-name">Slack</span>
-              <span className={`status ${getConnectionStatus(apiKeys.slack)}`}>
-                {getConnectionStatus(apiKeys.slack)}
+              <span className={`status ${getConnectionStatus(connections.slack)}`}>
+                {getConnectionStatus(connections.slack)}
               </span>
             </div>
-            {apiKeys.slack ? (
+            {connections.slack ? (
               <div className="connected-info">
                 <span className="connected-text">✓ Connected to Slack</span>
                 <button 
-                  onClick={() => handleKeyChange('slack', '')}
+                  onClick={() => onDisconnect('slack')}
                   className="disconnect-button"
                 >
                   Disconnect
@@ -145,15 +163,15 @@ const Settings: React.FC<SettingsProps> = ({ apiKeys, onUpdateKeys }) => {
           <div className="source-setting">
             <div className="source-header">
               <span className="source-name">Gmail</span>
-              <span className={`status ${getConnectionStatus(apiKeys.gmail)}`}>
-                {getConnectionStatus(apiKeys.gmail)}
+              <span className={`status ${getConnectionStatus(connections.gmail)}`}>
+                {getConnectionStatus(connections.gmail)}
               </span>
             </div>
-            {apiKeys.gmail ? (
+            {connections.gmail ? (
               <div className="connected-info">
                 <span className="connected-text">✓ Connected to Gmail</span>
                 <button 
-                  onClick={() => handleKeyChange('gmail', '')}
+                  onClick={() => onDisconnect('gmail')}
                   className="disconnect-button"
                 >
                   Disconnect
@@ -168,6 +186,110 @@ const Settings: React.FC<SettingsProps> = ({ apiKeys, onUpdateKeys }) => {
               </button>
             )}
           </div>
+          <div className="source-setting">
+            <div className="source-header">
+              <span className="source-name">GitHub</span>
+              <span className={`status ${getConnectionStatus(connections.github)}`}>
+                {getConnectionStatus(connections.github)}
+              </span>
+            </div>
+            {connections.github ? (
+              <div className="connected-info">
+                <span className="connected-text">✓ Connected to GitHub</span>
+                <button 
+                  onClick={() => onDisconnect('github')}
+                  className="disconnect-button"
+                >
+                  Disconnect
+                </button>
+              </div>
+            ) : (
+              <button 
+                onClick={() => handleOAuthConnect('github')}
+                className="connect-button"
+              >
+                Connect to GitHub
+              </button>
+            )}
+          </div>
+          <div className="source-setting">
+            <div className="source-header">
+              <span className="source-name">Google Drive</span>
+              <span className={`status ${getConnectionStatus(connections.drive)}`}>
+                {getConnectionStatus(connections.drive)}
+              </span>
+            </div>
+            {connections.drive ? (
+              <div className="connected-info">
+                <span className="connected-text">✓ Connected to Google Drive</span>
+                <button 
+                  onClick={() => onDisconnect('drive')}
+                  className="disconnect-button"
+                >
+                  Disconnect
+                </button>
+              </div>
+            ) : (
+              <button 
+                onClick={() => handleOAuthConnect('drive')}
+                className="connect-button"
+              >
+                Connect to Google Drive
+              </button>
+            )}
+          </div>
+          <div className="source-setting">
+            <div className="source-header">
+              <span className="source-name">Notion</span>
+              <span className={`status ${getConnectionStatus(connections.notion)}`}>
+                {getConnectionStatus(connections.notion)}
+              </span>
+            </div>
+            {connections.notion ? (
+              <div className="connected-info">
+                <span className="connected-text">✓ Connected to Notion</span>
+                <button 
+                  onClick={() => onDisconnect('notion')}
+                  className="disconnect-button"
+                >
+                  Disconnect
+                </button>
+              </div>
+            ) : (
+              <button 
+                onClick={() => handleOAuthConnect('notion')}
+                className="connect-button"
+              >
+                Connect to Notion
+              </button>
+            )}
+          </div>
+          <div className="source-setting">
+            <div className="source-header">
+              <span className="source-name">Microsoft 365</span>
+              <span className={`status ${getConnectionStatus(connections.microsoft)}`}>
+                {getConnectionStatus(connections.microsoft)}
+              </span>
+            </div>
+            {connections.microsoft ? (
+              <div className="connected-info">
+                <span className="connected-text">✓ Connected to Microsoft 365</span>
+                <button 
+                  onClick={() => onDisconnect('microsoft')}
+                  className="disconnect-button"
+                >
+                  Disconnect
+                </button>
+              </div>
+            ) : (
+              <button 
+                onClick={() => handleOAuthConnect('microsoft')}
+                className="connect-button"
+              >
+                Connect to Microsoft 365
+              </button>
+            )}
+          </div>
         </div>
       )}
     </div>
@@ -1,7 +1,12 @@
-export interface ApiKeys {
-  confluence: string;
-  slack: string;
-  gmail: string;
+// Accounts the browser session has connected; tokens stay on the server
+export interface Connections {
+  confluence: boolean;
+  slack: boolean;
+  gmail: boolean;
+  github: boolean;
+  drive: boolean;
+  notion: boolean;
+  microsoft: boolean;
 }
 
 export interface ChatMessage {
@@ -21,13 +26,28 @@ export interface Reference {
 
 export interface ChatRequest {
   query: string;
-  confluence_token?: string;
-  slack_token?: string;
-  gmail_token?: string;
   sources: Record<string, string>;
+  conversation_id?: string;
 }
 
 export interface ChatResponse {
   response: string;
   references: Reference[];
+  conversation_id?: string;
+}
+
+export interface User {
+  id: string;
+  tenant: string;
+  email?: string; // Missing for service accounts
+  name: string;
+  role: 'admin' | 'member';
+  groups?: string[];
+  service?: boolean;
+}
+
+// What /api/me returns; user is missing when accounts are off or nobody is signed in
+export interface Me {
+  auth_mode: string;
+  user?: User;
 }
\ No newline at end of file